@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accessLogTime is the timestamp layout of the common and combined formats
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// validate parses the trusted proxies
+func (c *AccessLogConfig) validate() error {
+	trusted, err := parsePrefixList(c.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("input.access_log.trusted_proxies: %w", err)
+	}
+	if c.ForwardedFor && len(trusted) == 0 {
+		return fmt.Errorf("input.access_log.trusted_proxies is required with forwarded_for")
+	}
+	c.trusted = trusted
+	return nil
+}
+
+// isAccessLog reports whether a message looks like a common or combined
+// format access log line, for input.format auto
+func isAccessLog(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] != '{' && bytes.Contains(data, []byte(" [")) && bytes.Contains(data, []byte(`] "`))
+}
+
+// splitAccessLog splits a log line into fields: bare words, [bracketed]
+// timestamps and "quoted" strings, unescaping \" \\ and \xHH in the latter
+func splitAccessLog(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+		case '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			fields = append(fields, line[i+1:i+end])
+			i += end + 1
+		case '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] != '\\' || i+1 >= len(line) {
+					b.WriteByte(line[i])
+					continue
+				}
+				i++
+				if line[i] == 'x' && i+2 < len(line) {
+					if v, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+						b.WriteByte(byte(v))
+						i += 2
+						continue
+					}
+				}
+				b.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted field")
+			}
+			fields = append(fields, b.String())
+			i++
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+		}
+	}
+	return fields, nil
+}
+
+// parseAccessLog converts an NGINX or Apache access log line in the common
+// or combined format into an "http" event. Responses of 400 and above are
+// failed; the response size is bytes_out.
+func parseAccessLog(data []byte, cfg *AccessLogConfig) (SecurityEvent, error) {
+	line := strings.TrimSpace(string(data))
+	fields, err := splitAccessLog(line)
+	if err != nil {
+		return SecurityEvent{}, fmt.Errorf("access_log: %w", err)
+	}
+	// host ident user [time] "request" status bytes ["referer" "user agent" ...]
+	if len(fields) < 7 {
+		return SecurityEvent{}, fmt.Errorf("access_log: want at least 7 fields, got %d", len(fields))
+	}
+	ts, err := time.Parse(accessLogTime, fields[3])
+	if err != nil {
+		return SecurityEvent{}, fmt.Errorf("access_log: timestamp: %w", err)
+	}
+	status, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return SecurityEvent{}, fmt.Errorf("access_log: status %q is not a number", fields[5])
+	}
+
+	meta := map[string]string{"status": fields[5], "bytes_out": "0"}
+	if fields[6] != "-" {
+		meta["bytes_out"] = fields[6]
+	}
+	// The request is "METHOD target HTTP/x", where an attack's target may
+	// hold raw spaces. A malformed request line (a probe, or TLS sent to a
+	// plain port) is kept whole as the path.
+	method, path := "", fields[4]
+	first, last := strings.IndexByte(fields[4], ' '), strings.LastIndexByte(fields[4], ' ')
+	if first > 0 && last > first && strings.HasPrefix(fields[4][last+1:], "HTTP/") {
+		method, path = fields[4][:first], strings.TrimSpace(fields[4][first+1:last])
+		meta["method"], meta["protocol"] = method, fields[4][last+1:]
+	}
+	meta["path"] = path
+	if len(fields) > 7 && fields[7] != "-" {
+		meta["referer"] = fields[7]
+	}
+	if len(fields) > 8 && fields[8] != "-" {
+		meta["user_agent"] = fields[8]
+	}
+
+	client := fields[0]
+	if cfg.ForwardedFor && len(fields) > 9 && fields[9] != "-" {
+		meta["forwarded_for"] = fields[9]
+		if real := cfg.clientIP(client, fields[9]); real != client {
+			meta["proxy_ip"] = client
+			client = real
+		}
+	}
+
+	result := "success"
+	if status >= 400 {
+		result = "failed"
+	}
+	user := fields[2]
+	if user == "-" {
+		user = ""
+	}
+	return SecurityEvent{
+		Timestamp: ts,
+		SourceIP:  client,
+		EventType: "http",
+		User:      user,
+		Action:    strings.ToLower(method),
+		Result:    result,
+		RawLog:    line,
+		Metadata:  meta,
+	}, nil
+}
+
+// clientIP finds the real client behind trusted proxies: the rightmost
+// X-Forwarded-For hop that isn't a trusted proxy. Hops further left were
+// written by the client and can't be believed. The peer is the client when
+// it isn't a trusted proxy itself.
+func (c *AccessLogConfig) clientIP(peer, forwardedFor string) string {
+	addr, ok := parseIP(peer)
+	if !ok || !containsAddr(c.trusted, addr) {
+		return peer
+	}
+	hops := strings.Split(forwardedFor, ",")
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		addr, ok := parseIP(hop)
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !containsAddr(c.trusted, addr) {
+			break
+		}
+	}
+	return client
+}
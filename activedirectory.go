@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Windows Security event IDs read by the AD rules
+const (
+	eventTGTRequested   = "4768" // Kerberos authentication ticket (TGT) requested
+	eventTGSRequested   = "4769" // Kerberos service ticket requested
+	eventLogon          = "4624" // successful logon
+	eventObjectAccessed = "4662" // operation performed on a directory object
+)
+
+// replicationRights are the extended rights a DCSync exercises
+// (DS-Replication-Get-Changes, -Get-Changes-All, -Get-Changes-In-Filtered-Set)
+var replicationRights = []string{
+	"1131f6aa-9c07-11d1-f79f-00c04fc2dcd2",
+	"1131f6ad-9c07-11d1-f79f-00c04fc2dcd2",
+	"89e95b76-444d-4c62-991a-0facbeda640c",
+}
+
+// MITRE ATT&CK techniques tagged on the AD alerts
+const (
+	mitreKerberoasting = "T1558.003"
+	mitreGoldenTicket  = "T1558.001"
+	mitreDCSync        = "T1003.006"
+)
+
+// validate checks the AD rule settings and parses the domain controller list
+func (c *ActiveDirectoryConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.EventIDField == "" {
+		return fmt.Errorf("active_directory.event_id_field is required when enabled")
+	}
+
+	if k := &c.Kerberoasting; k.Enabled {
+		if k.Threshold < 1 {
+			return fmt.Errorf("active_directory.kerberoasting.threshold must be at least 1")
+		}
+		if k.Window.Duration <= 0 {
+			return fmt.Errorf("active_directory.kerberoasting.window must be positive")
+		}
+		if severityRank(k.Severity) < 0 {
+			return fmt.Errorf("active_directory.kerberoasting.severity %q is not a severity", k.Severity)
+		}
+		for i, t := range k.EncryptionTypes {
+			k.EncryptionTypes[i] = strings.ToLower(t)
+		}
+	}
+
+	if t := c.TicketAnomaly; t.Enabled {
+		if t.MaxLifetime.Duration <= 0 {
+			return fmt.Errorf("active_directory.ticket_anomaly.max_lifetime must be positive")
+		}
+		if severityRank(t.Severity) < 0 {
+			return fmt.Errorf("active_directory.ticket_anomaly.severity %q is not a severity", t.Severity)
+		}
+	}
+
+	if d := &c.DCSync; d.Enabled {
+		// Without the DC list every legitimate replication would alert
+		if len(d.DomainControllers) == 0 {
+			return fmt.Errorf("active_directory.dcsync.domain_controllers is required when enabled")
+		}
+		var err error
+		if d.domainControllers, err = parsePrefixList(d.DomainControllers); err != nil {
+			return fmt.Errorf("active_directory.dcsync.domain_controllers: %w", err)
+		}
+		if err := validatePatterns(d.AllowedAccounts); err != nil {
+			return fmt.Errorf("active_directory.dcsync.allowed_accounts: %w", err)
+		}
+		if severityRank(d.Severity) < 0 {
+			return fmt.Errorf("active_directory.dcsync.severity %q is not a severity", d.Severity)
+		}
+	}
+	return nil
+}
+
+// isKerberoasting counts the distinct service accounts a host requests
+// weakly encrypted service tickets for, and reports when it reaches the threshold
+func (td *ThreatDetector) isKerberoasting(ctx context.Context, event SecurityEvent, eventID string) (bool, int64, error) {
+	cfg := td.cfg().ActiveDirectory.Kerberoasting
+	if !cfg.Enabled || eventID != eventTGSRequested || event.SourceIP == "" {
+		return false, 0, nil
+	}
+	service := strings.ToLower(event.Metadata["service_name"])
+	encType := strings.ToLower(event.Metadata["ticket_encryption_type"])
+	if service == "" || !containsString(cfg.EncryptionTypes, encType) {
+		return false, 0, nil
+	}
+	// Machine accounts and krbtgt have random keys; roasting them is pointless
+	if strings.HasSuffix(service, "$") || service == "krbtgt" {
+		return false, 0, nil
+	}
+
+	// Only the first request per service counts, so one noisy client
+	// renewing a single ticket doesn't look like a sweep
+	seenKey := stateKey(event, "kerberoast_svc", event.ipKey()+":"+service)
+	first, err := td.state.SetIfAbsent(ctx, seenKey, "1", cfg.Window.Duration)
+	if err != nil {
+		return false, 0, &StateError{Op: "setnx", Key: seenKey, Err: err}
+	}
+	if !first {
+		return false, 0, nil
+	}
+
+	key := stateKey(event, "kerberoast", event.ipKey())
+	count, err := td.countInWindow(ctx, event, "KERBEROASTING", key, cfg.Window.Duration)
+	if err != nil {
+		return false, 0, err
+	}
+	if err := td.recordRawEvent(ctx, event, key, cfg.Window.Duration); err != nil {
+		return false, 0, err
+	}
+	return count >= int64(cfg.Threshold), count, nil
+}
+
+// ticketLifetime reads the ticket lifetime from metadata, as a Go duration
+// ("10h") or in seconds
+func ticketLifetime(event SecurityEvent) (time.Duration, bool) {
+	raw := event.Metadata["ticket_lifetime"]
+	if raw == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return d, true
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	return 0, false
+}
+
+// isTicketAnomaly reports a Kerberos ticket valid for longer than policy allows
+func (td *ThreatDetector) isTicketAnomaly(event SecurityEvent, eventID string) (time.Duration, bool) {
+	cfg := td.cfg().ActiveDirectory.TicketAnomaly
+	if !cfg.Enabled {
+		return 0, false
+	}
+	switch eventID {
+	case eventTGTRequested, eventTGSRequested, eventLogon:
+	default:
+		return 0, false
+	}
+	lifetime, ok := ticketLifetime(event)
+	return lifetime, ok && lifetime > cfg.MaxLifetime.Duration
+}
+
+// isDCSync reports directory replication requested from outside the domain controllers
+func (td *ThreatDetector) isDCSync(event SecurityEvent, eventID string) bool {
+	cfg := td.cfg().ActiveDirectory.DCSync
+	if !cfg.Enabled || eventID != eventObjectAccessed {
+		return false
+	}
+	properties := strings.ToLower(event.Metadata["properties"])
+	replication := false
+	for _, right := range replicationRights {
+		if strings.Contains(properties, right) {
+			replication = true
+			break
+		}
+	}
+	if !replication || matchAny(cfg.AllowedAccounts, event.User) {
+		return false
+	}
+	return event.addr.IsValid() && !containsAddr(cfg.domainControllers, event.addr)
+}
+
+// detectActiveDirectory runs the AD rules and raises their alerts. Events
+// without a Windows event ID are skipped entirely.
+func (td *ThreatDetector) detectActiveDirectory(ctx context.Context, event SecurityEvent) []error {
+	cfg := td.cfg().ActiveDirectory
+	if !cfg.Enabled {
+		return nil
+	}
+	eventID := event.Metadata[cfg.EventIDField]
+	if eventID == "" {
+		return nil
+	}
+
+	var errs []error
+
+	if hit, count, err := td.isKerberoasting(ctx, event, eventID); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("KR-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Kerberoasting.Severity,
+			ThreatType: "KERBEROASTING",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%s requested weakly encrypted service tickets for %d service accounts within %s",
+				event.SourceIP, count, cfg.Kerberoasting.Window),
+			EventCount: int(count),
+			Metadata:   map[string]string{"mitre_technique": mitreKerberoasting},
+			stateKey:   stateKey(event, "kerberoast", event.ipKey()),
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, alert.stateKey); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	if lifetime, hit := td.isTicketAnomaly(event, eventID); hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("KT-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.TicketAnomaly.Severity,
+			ThreatType: "KERBEROS_TICKET_ANOMALY",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Kerberos ticket for %s from %s is valid for %s (policy maximum %s); possible forged ticket",
+				event.User, event.SourceIP, lifetime, cfg.TicketAnomaly.MaxLifetime),
+			Metadata: map[string]string{"mitre_technique": mitreGoldenTicket},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	if td.isDCSync(event, eventID) {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("DC-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.DCSync.Severity,
+			ThreatType: "DCSYNC",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("Directory replication requested by %s from non-DC host %s", event.User, event.SourceIP),
+			Metadata:   map[string]string{"mitre_technique": mitreDCSync},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	return errs
+}
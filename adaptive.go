@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Adaptive threshold scaling functions of the event rate over its baseline
+const (
+	scaleLinear = "linear"
+	scaleSqrt   = "sqrt"
+	scaleLog    = "log"
+)
+
+// adaptiveThreatTypes are the alerts whose count thresholds scale
+var adaptiveThreatTypes = map[string]bool{
+	"BRUTE_FORCE":             true,
+	"POST_BRUTEFORCE_SUCCESS": true,
+	"SUSPICIOUS_USER":         true,
+	"PASSWORD_CHANGE_ANOMALY": true,
+}
+
+// validate checks the baseline and bounds
+func (c *AdaptiveThresholdConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.BaselineRate <= 0 {
+		return fmt.Errorf("adaptive_thresholds.baseline_rate must be positive")
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("adaptive_thresholds.interval must be at least 1s")
+	}
+	switch c.Scaling {
+	case scaleLinear, scaleSqrt, scaleLog:
+	default:
+		return fmt.Errorf(`adaptive_thresholds.scaling must be "linear", "sqrt" or "log", got %q`, c.Scaling)
+	}
+	// An unbounded factor would let a big enough storm switch detection off
+	if c.MinFactor <= 0 || c.MaxFactor < c.MinFactor || math.IsInf(c.MaxFactor, 0) {
+		return fmt.Errorf("adaptive_thresholds: want 0 < min_factor <= max_factor")
+	}
+	return nil
+}
+
+// factor maps an event rate to a threshold factor within the bounds
+func (c *AdaptiveThresholdConfig) factor(rate float64) float64 {
+	ratio := rate / c.BaselineRate
+	f := ratio
+	switch c.Scaling {
+	case scaleSqrt:
+		f = math.Sqrt(ratio)
+	case scaleLog:
+		f = 1
+		if ratio > 1 {
+			f = 1 + math.Log2(ratio)
+		}
+	}
+	return math.Min(math.Max(f, c.MinFactor), c.MaxFactor)
+}
+
+// adaptiveThresholds measures this instance's event rate over fixed
+// intervals and keeps the factor the last complete interval calls for
+type adaptiveThresholds struct {
+	mu      sync.Mutex
+	start   time.Time // of the interval being counted
+	count   int
+	current float64 // 0 until the first interval completes
+}
+
+// observe counts an event, and at the end of an interval rescales
+func (a *adaptiveThresholds) observe(now time.Time, cfg *AdaptiveThresholdConfig, base Thresholds) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Switched off by a reload: back to the configured thresholds
+	if !cfg.Enabled {
+		if a.current != 0 {
+			a.start, a.count, a.current = time.Time{}, 0, 0
+			publishThresholds(base, 1)
+		}
+		return
+	}
+	if a.start.IsZero() {
+		a.start = now
+	}
+	elapsed := now.Sub(a.start)
+	if elapsed < cfg.Interval.Duration {
+		a.count++
+		return
+	}
+
+	rate := float64(a.count) / elapsed.Seconds()
+	previous := a.factorLocked()
+	a.current = cfg.factor(rate)
+	a.start, a.count = now, 1
+
+	eventRate.Set(rate)
+	publishThresholds(base, a.current)
+
+	// Say so whenever detection is desensitized, so it's never silent
+	if a.current != previous && (math.Abs(a.current-previous) >= 0.1 || a.current == cfg.MaxFactor) {
+		log.Printf("Adaptive thresholds: %.1f events/s (baseline %.1f), thresholds scaled by %.2f (was %.2f)",
+			rate, cfg.BaselineRate, a.current, previous)
+	}
+	if a.current == cfg.MaxFactor && previous != cfg.MaxFactor {
+		log.Printf("Adaptive thresholds at max_factor %.2f: the event rate may keep rising, but thresholds won't", cfg.MaxFactor)
+	}
+}
+
+// publishThresholds sets the threshold metrics
+func publishThresholds(base Thresholds, factor float64) {
+	thresholdFactor.Set(factor)
+	scaled := scaleThresholds(base, factor)
+	effectiveThresholds.Set(float64(scaled.BruteForce), "brute_force")
+	effectiveThresholds.Set(float64(scaled.SuspiciousUser), "suspicious_user")
+	effectiveThresholds.Set(float64(scaled.PasswordChange), "password_change")
+}
+
+// factor returns the current threshold factor (1 when off)
+func (a *adaptiveThresholds) factor(cfg *AdaptiveThresholdConfig) float64 {
+	if !cfg.Enabled {
+		return 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.factorLocked()
+}
+
+func (a *adaptiveThresholds) factorLocked() float64 {
+	if a.current == 0 {
+		return 1
+	}
+	return a.current
+}
+
+// scaleThresholds multiplies the count thresholds by factor, rounding up.
+// exfil_bytes is a per-event size, so the event rate doesn't bear on it.
+func scaleThresholds(t Thresholds, factor float64) Thresholds {
+	if factor == 1 {
+		return t
+	}
+	scale := func(n int) int {
+		return max(1, int(math.Ceil(float64(n)*factor)))
+	}
+	t.BruteForce = scale(t.BruteForce)
+	t.SuspiciousUser = scale(t.SuspiciousUser)
+	t.PasswordChange = scale(t.PasswordChange)
+	return t
+}
+
+// thresholdsFor returns a tenant's thresholds, scaled to the event rate
+// when adaptive thresholds are enabled
+func (td *ThreatDetector) thresholdsFor(tenantID string) Thresholds {
+	cfg := td.cfg()
+	return scaleThresholds(cfg.ThresholdsFor(tenantID), td.adaptive.factor(&cfg.AdaptiveThresholds))
+}
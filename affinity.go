@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// dispatched is one input message handed to a worker, parsed once
+type dispatched struct {
+	msg      kafka.Message
+	event    SecurityEvent
+	parseErr error
+}
+
+// jumpHash maps a key to one of n buckets with Lamping and Veach's jump
+// consistent hash, so few keys move if the worker count changes
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// affinityWorker picks the worker that owns an event's source IP. The key is
+// tenant-scoped like the state keys, so tenants sharing an IP don't collide.
+func affinityWorker(event SecurityEvent, numWorkers int) int {
+	h := fnv.New64a()
+	h.Write([]byte(event.TenantID()))
+	h.Write([]byte{0})
+	h.Write([]byte(event.ipKey()))
+	return jumpHash(h.Sum64(), numWorkers)
+}
+
+// runDispatcher reads the input and routes each event to the worker that
+// owns its source IP, until stop is closed. A full queue blocks the
+// dispatcher, so one hot IP slows intake rather than growing memory.
+func (td *ThreatDetector) runDispatcher() {
+	defer td.producers.Done()
+
+	for {
+		msg, err := td.source.Fetch(td.ctx)
+		if err != nil {
+			select {
+			case <-td.stop:
+				log.Println("Dispatcher shutting down")
+				return
+			default:
+			}
+			log.Printf("Dispatcher error reading message: %v", err)
+			continue
+		}
+
+		event, err := parseEvent(msg, &td.cfg().Input)
+		item := dispatched{msg: msg, event: event, parseErr: err}
+
+		select {
+		case td.queues[affinityWorker(event, len(td.queues))] <- item:
+		case <-td.stop:
+			log.Println("Dispatcher shutting down")
+			return
+		}
+	}
+}
+
+// next returns a worker's next message: from its own queue when affinity is
+// on, otherwise straight from the shared input
+func (td *ThreatDetector) next(ctx context.Context, workerID int) (dispatched, error) {
+	if td.queues == nil {
+		msg, err := td.source.Fetch(ctx)
+		if err != nil {
+			return dispatched{}, err
+		}
+		event, err := parseEvent(msg, &td.cfg().Input)
+		return dispatched{msg: msg, event: event, parseErr: err}, nil
+	}
+
+	select {
+	case item := <-td.queues[workerID]:
+		return item, nil
+	case <-td.stop:
+		return dispatched{}, context.Canceled
+	case <-ctx.Done():
+		return dispatched{}, ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// chanSource is an EventSource fed from a slice, for driving the workers
+type chanSource struct {
+	msgs   chan kafka.Message
+	acked  sync.WaitGroup
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newChanSource(msgs []kafka.Message) *chanSource {
+	s := &chanSource{msgs: make(chan kafka.Message, len(msgs)), closed: make(chan struct{})}
+	s.acked.Add(len(msgs))
+	for _, msg := range msgs {
+		s.msgs <- msg
+	}
+	return s
+}
+
+func (s *chanSource) Fetch(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-s.msgs:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case <-s.closed:
+		return kafka.Message{}, errors.New("source closed")
+	}
+}
+
+func (s *chanSource) Ack(ctx context.Context, msg kafka.Message) error {
+	s.acked.Done()
+	return nil
+}
+
+func (s *chanSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// runWorkers processes every message with numWorkers workers, through the
+// affinity dispatcher if affinity is set, and returns the alerts raised
+func runWorkers(td *ThreatDetector, numWorkers int, affinity bool, msgs []kafka.Message) []ThreatAlert {
+	source := newChanSource(msgs)
+	td.source = source
+	td.stop = make(chan struct{})
+	td.queues = nil
+
+	var alerts []ThreatAlert
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for alert := range td.alertChan {
+			alerts = append(alerts, alert)
+		}
+	}()
+
+	if affinity {
+		td.queues = make([]chan dispatched, numWorkers)
+		for i := range td.queues {
+			td.queues[i] = make(chan dispatched, 64)
+		}
+		td.producers.Add(1)
+		go td.runDispatcher()
+	}
+	td.workers = make([]*workerSlot, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		td.startWorker(i)
+	}
+
+	source.acked.Wait()
+	close(td.stop)
+	source.Close()
+	td.producers.Wait()
+	close(td.alertChan)
+	<-collected
+	td.alertChan = make(chan ThreatAlert, cap(td.alertChan))
+	return alerts
+}
+
+// failedLogins is perIP failed logins from each of ips, interleaved
+func failedLogins(ips, perIP int) []kafka.Message {
+	var msgs []kafka.Message
+	for n := 0; n < perIP; n++ {
+		for i := 0; i < ips; i++ {
+			value := fmt.Sprintf(`{"event_type":"authentication","action":"login","result":"failed","source_ip":"203.0.113.%d","user":"alice"}`, i+1)
+			msgs = append(msgs, kafka.Message{Value: []byte(value), Offset: int64(len(msgs))})
+		}
+	}
+	return msgs
+}
+
+func TestAffinityRoutesAnIPToOneWorker(t *testing.T) {
+	event := SecurityEvent{SourceIP: "203.0.113.7"}
+	event.normalize()
+	want := affinityWorker(event, 8)
+	for i := 0; i < 100; i++ {
+		if got := affinityWorker(event, 8); got != want {
+			t.Fatalf("affinityWorker = %d, then %d", want, got)
+		}
+	}
+
+	tenant := event
+	tenant.Metadata = map[string]string{"tenant_id": "acme"}
+	spread := map[int]bool{}
+	for i := 0; i < 64; i++ {
+		e := SecurityEvent{SourceIP: fmt.Sprintf("198.51.100.%d", i), Metadata: tenant.Metadata}
+		e.normalize()
+		spread[affinityWorker(e, 8)] = true
+	}
+	if len(spread) < 4 {
+		t.Errorf("64 IPs hashed to only %d of 8 workers", len(spread))
+	}
+}
+
+func TestThresholdBoundaryUnderConcurrency(t *testing.T) {
+	const ips = 20
+	threshold := DefaultConfig().Thresholds.BruteForce
+
+	for _, affinity := range []bool{false, true} {
+		t.Run(fmt.Sprintf("affinity=%v", affinity), func(t *testing.T) {
+			td := newTestDetector(t, nil)
+			alerts := runWorkers(td, 8, affinity, failedLogins(ips, threshold))
+
+			perIP := map[string]int{}
+			for _, alert := range alerts {
+				if alert.ThreatType == "BRUTE_FORCE" {
+					perIP[alert.SourceIP]++
+				}
+			}
+			if len(perIP) != ips {
+				t.Errorf("BRUTE_FORCE raised for %d IPs, want %d", len(perIP), ips)
+			}
+			for ip, n := range perIP {
+				if n != 1 {
+					t.Errorf("%s: %d BRUTE_FORCE alerts at the threshold, want exactly 1", ip, n)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDispatch compares workers sharing the input with affinity
+// dispatch, on a few hot IPs whose counters every worker would contend for
+func BenchmarkDispatch(b *testing.B) {
+	const hotIPs, workers = 4, 8
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, affinity := range []bool{false, true} {
+		b.Run(map[bool]string{false: "shared", true: "affinity"}[affinity], func(b *testing.B) {
+			td := newTestDetector(b, nil)
+			msgs := failedLogins(hotIPs, (b.N+hotIPs-1)/hotIPs)
+			b.ReportAllocs()
+			b.ResetTimer()
+			runWorkers(td, workers, affinity, msgs)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AlertQuery selects stored alerts. Results are oldest first; After is the
+// cursor from the previous page (unix microseconds of its last alert).
+type AlertQuery struct {
+	From, To   time.Time
+	Severity   string
+	ThreatType string
+	SourceIP   string
+	TenantID   string
+	Limit      int
+	After      int64
+}
+
+// matches applies the query's filters (the time range is left to the store)
+func (q AlertQuery) matches(alert ThreatAlert) bool {
+	return (q.Severity == "" || alert.Severity == q.Severity) &&
+		(q.ThreatType == "" || alert.ThreatType == q.ThreatType) &&
+		(q.SourceIP == "" || alert.SourceIP == q.SourceIP) &&
+		(q.TenantID == "" || alert.TenantID == q.TenantID)
+}
+
+// AlertStore keeps recently published alerts for GET /alerts
+type AlertStore interface {
+	Put(ctx context.Context, alert ThreatAlert) error
+	// Query returns up to q.Limit matching alerts and the cursor for the
+	// next page ("" when there are no more)
+	Query(ctx context.Context, q AlertQuery) ([]ThreatAlert, string, error)
+}
+
+// newAlertStore creates the configured backend
+func newAlertStore(cfg AlertStoreConfig, client *redis.Client) AlertStore {
+	if cfg.Backend == "memory" {
+		return &memoryAlertStore{cfg: cfg}
+	}
+	return &redisAlertStore{cfg: cfg, client: client}
+}
+
+// alertScore orders alerts by time at microsecond precision, which a
+// float64 sorted-set score holds exactly
+func alertScore(alert ThreatAlert) int64 {
+	return alert.Timestamp.UnixMicro()
+}
+
+// pageEnd trims a time-ordered page to the limit, extending it over alerts
+// that share the last one's timestamp so the cursor never splits them
+func pageEnd(alerts []ThreatAlert, limit int) ([]ThreatAlert, string) {
+	if len(alerts) <= limit {
+		return alerts, ""
+	}
+	end := limit
+	last := alertScore(alerts[end-1])
+	for end < len(alerts) && alertScore(alerts[end]) == last {
+		end++
+	}
+	if end == len(alerts) {
+		return alerts, ""
+	}
+	return alerts[:end], strconv.FormatInt(last, 10)
+}
+
+// redisAlertStore keeps alerts in a sorted set scored by alert time
+type redisAlertStore struct {
+	cfg    AlertStoreConfig
+	client *redis.Client
+}
+
+// Put adds the alert and trims everything older than the retention
+func (s *redisAlertStore) Put(ctx context.Context, alert ThreatAlert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.cfg.Retention.Duration).UnixMicro()
+
+	pipe := s.client.Pipeline()
+	pipe.ZAdd(ctx, s.cfg.Key, &redis.Z{Score: float64(alertScore(alert)), Member: data})
+	pipe.ZRemRangeByScore(ctx, s.cfg.Key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	pipe.Expire(ctx, s.cfg.Key, s.cfg.Retention.Duration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return &StateError{Op: "zadd", Key: s.cfg.Key, Err: err}
+	}
+	return nil
+}
+
+// Query scans the range in chunks, filtering as it goes
+func (s *redisAlertStore) Query(ctx context.Context, q AlertQuery) ([]ThreatAlert, string, error) {
+	from := strconv.FormatInt(q.From.UnixMicro(), 10)
+	if q.After > 0 {
+		from = "(" + strconv.FormatInt(q.After, 10)
+	}
+	to := strconv.FormatInt(q.To.UnixMicro(), 10)
+
+	const chunk = 500
+	var alerts []ThreatAlert
+	for offset := int64(0); ; offset += chunk {
+		members, err := s.client.ZRangeByScore(ctx, s.cfg.Key, &redis.ZRangeBy{
+			Min: from, Max: to, Offset: offset, Count: chunk,
+		}).Result()
+		if err != nil {
+			return nil, "", &StateError{Op: "zrangebyscore", Key: s.cfg.Key, Err: err}
+		}
+		for _, m := range members {
+			var alert ThreatAlert
+			if json.Unmarshal([]byte(m), &alert) == nil && q.matches(alert) {
+				alerts = append(alerts, alert)
+			}
+		}
+		// Stop once past the limit with a later timestamp in hand, so pageEnd
+		// knows another page exists and no tie is cut off
+		if len(members) < chunk || len(alerts) > q.Limit && alertScore(alerts[len(alerts)-1]) != alertScore(alerts[q.Limit-1]) {
+			break
+		}
+	}
+	page, next := pageEnd(alerts, q.Limit)
+	return page, next, nil
+}
+
+// memoryAlertStore keeps alerts in process, for single-instance deployments
+type memoryAlertStore struct {
+	cfg AlertStoreConfig
+
+	mu     sync.Mutex
+	alerts []ThreatAlert // ordered by time
+}
+
+// Put inserts the alert in time order and drops expired or excess alerts
+func (s *memoryAlertStore) Put(_ context.Context, alert ThreatAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := sort.Search(len(s.alerts), func(i int) bool {
+		return alertScore(s.alerts[i]) > alertScore(alert)
+	})
+	s.alerts = append(s.alerts, ThreatAlert{})
+	copy(s.alerts[i+1:], s.alerts[i:])
+	s.alerts[i] = alert
+
+	cutoff := time.Now().Add(-s.cfg.Retention.Duration)
+	drop := sort.Search(len(s.alerts), func(i int) bool {
+		return !s.alerts[i].Timestamp.Before(cutoff)
+	})
+	if excess := len(s.alerts) - drop - s.cfg.MaxAlerts; excess > 0 {
+		drop += excess
+	}
+	if drop > 0 {
+		s.alerts = append([]ThreatAlert(nil), s.alerts[drop:]...)
+	}
+	return nil
+}
+
+func (s *memoryAlertStore) Query(_ context.Context, q AlertQuery) ([]ThreatAlert, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := q.From.UnixMicro(), q.To.UnixMicro()
+	var alerts []ThreatAlert
+	for _, alert := range s.alerts {
+		score := alertScore(alert)
+		if score < from || score > to || (q.After > 0 && score <= q.After) || !q.matches(alert) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	page, next := pageEnd(alerts, q.Limit)
+	return page, next, nil
+}
+
+// alertsPage is the GET /alerts response
+type alertsPage struct {
+	Alerts     []ThreatAlert `json:"alerts"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// parseAlertQuery reads GET /alerts parameters. The range defaults to the
+// whole retention period.
+func parseAlertQuery(r *http.Request, retention time.Duration) (AlertQuery, error) {
+	v := r.URL.Query()
+	now := time.Now()
+	q := AlertQuery{
+		From:       now.Add(-retention),
+		To:         now,
+		Severity:   strings.ToUpper(v.Get("severity")),
+		ThreatType: v.Get("threat_type"),
+		TenantID:   v.Get("tenant_id"),
+		Limit:      100,
+	}
+
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if raw := v.Get(t.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return q, fmt.Errorf("%s: want RFC 3339, got %q", t.name, raw)
+			}
+			*t.dst = parsed
+		}
+	}
+	if q.Severity != "" && q.Severity != severityInfo && severityRank(q.Severity) < 0 {
+		return q, fmt.Errorf("unknown severity %q", q.Severity)
+	}
+	if raw := v.Get("source_ip"); raw != "" {
+		addr, ok := parseIP(raw)
+		if !ok {
+			return q, fmt.Errorf("invalid source_ip %q", raw)
+		}
+		q.SourceIP = addr.String()
+	}
+	if raw := v.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 1000 {
+			return q, fmt.Errorf("limit must be between 1 and 1000")
+		}
+		q.Limit = n
+	}
+	if raw := v.Get("cursor"); raw != "" {
+		after, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor %q", raw)
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+// handleAlerts serves GET /alerts
+func (td *ThreatDetector) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	td.serveStore(w, r, td.alertStore, td.cfg().AlertStore.Retention.Duration)
+}
+
+// handleObservations serves GET /observations, with the same parameters
+// and token as GET /alerts
+func (td *ThreatDetector) handleObservations(w http.ResponseWriter, r *http.Request) {
+	td.serveStore(w, r, td.observations, td.cfg().Observations.Retention.Duration)
+}
+
+// serveStore answers a query against an alert or observation store
+func (td *ThreatDetector) serveStore(w http.ResponseWriter, r *http.Request, store AlertStore, retention time.Duration) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := td.cfg().AlertStore.Token; token != "" && !bearerTokenOK(r, token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q, err := parseAlertQuery(r, retention)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alerts, next, err := store.Query(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if alerts == nil {
+		alerts = []ThreatAlert{}
+	}
+	writeJSON(w, http.StatusOK, alertsPage{Alerts: alerts, NextCursor: next})
+}
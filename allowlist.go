@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// allowAllRules is the UserAllowlist key that applies to every rule
+const allowAllRules = "*"
+
+// userAllowlist maps a threat type (or "*") to exact or glob user patterns
+type userAllowlist map[string][]string
+
+// validate checks that every pattern is a valid glob
+func (l userAllowlist) validate() error {
+	for rule, patterns := range l {
+		if err := validatePatterns(patterns); err != nil {
+			return fmt.Errorf("rule %s: %w", rule, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether user is allowlisted for a rule
+func (l userAllowlist) matches(rule, user string) bool {
+	return matchAny(l[rule], user) || matchAny(l[allowAllRules], user)
+}
+
+// validatePatterns checks that every entry is a valid path.Match glob
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether s matches any exact or glob pattern
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns a new allowlist containing both lists' patterns
+func (l userAllowlist) merge(other userAllowlist) userAllowlist {
+	out := make(userAllowlist, len(l)+len(other))
+	for rule, patterns := range l {
+		out[rule] = append(out[rule], patterns...)
+	}
+	for rule, patterns := range other {
+		out[rule] = append(out[rule], patterns...)
+	}
+	return out
+}
+
+// UserAllowlistManager serves the live user allowlist: the rules from the
+// config merged with an optional file that is re-read whenever it changes
+type UserAllowlistManager struct {
+	current atomic.Pointer[userAllowlist]
+
+	mu      sync.Mutex // guards cfg and modTime between Run and Update
+	cfg     UserAllowlistConfig
+	modTime time.Time
+}
+
+// NewUserAllowlistManager loads the initial allowlist
+func NewUserAllowlistManager(cfg UserAllowlistConfig) (*UserAllowlistManager, error) {
+	m := &UserAllowlistManager{cfg: cfg}
+
+	list := userAllowlist(cfg.Rules)
+	if cfg.File != "" {
+		fromFile, modTime, err := loadUserAllowlistFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		list = list.merge(fromFile)
+		m.modTime = modTime
+	}
+	m.current.Store(&list)
+	return m, nil
+}
+
+// Update swaps in a new config (on reload), re-reading its file. On error
+// the previous allowlist stays active.
+func (m *UserAllowlistManager) Update(cfg UserAllowlistConfig) error {
+	list := userAllowlist(cfg.Rules)
+	var modTime time.Time
+	if cfg.File != "" {
+		fromFile, mt, err := loadUserAllowlistFile(cfg.File)
+		if err != nil {
+			return err
+		}
+		list = list.merge(fromFile)
+		modTime = mt
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	m.modTime = modTime
+	m.current.Store(&list)
+	return nil
+}
+
+// Allowed reports whether user is exempt from a rule
+func (m *UserAllowlistManager) Allowed(rule, user string) bool {
+	if user == "" {
+		return false
+	}
+	return (*m.current.Load()).matches(rule, user)
+}
+
+// Run polls the allowlist file and swaps in changes until stop is closed.
+// A file that fails to load is logged and the previous list stays active.
+func (m *UserAllowlistManager) Run(stop <-chan struct{}) {
+	// Keep polling even without a file: a config reload may add one
+	m.mu.Lock()
+	interval := m.cfg.ReloadInterval.Duration
+	m.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged re-reads the allowlist file if it was modified
+func (m *UserAllowlistManager) reloadIfChanged() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cfg.File == "" {
+		return
+	}
+
+	info, err := os.Stat(m.cfg.File)
+	if err != nil || !info.ModTime().After(m.modTime) {
+		return
+	}
+
+	fromFile, modTime, err := loadUserAllowlistFile(m.cfg.File)
+	if err != nil {
+		log.Printf("User allowlist reload failed, keeping previous list: %v", err)
+		m.modTime = info.ModTime() // don't retry the same broken file every tick
+		return
+	}
+
+	list := userAllowlist(m.cfg.Rules).merge(fromFile)
+	m.current.Store(&list)
+	m.modTime = modTime
+	log.Printf("Reloaded user allowlist from %s", m.cfg.File)
+}
+
+// loadUserAllowlistFile reads a JSON object of rule -> patterns
+func loadUserAllowlistFile(file string) (userAllowlist, time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("user allowlist: %w", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("user allowlist: %w", err)
+	}
+
+	var list userAllowlist
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, time.Time{}, fmt.Errorf("user allowlist %s: %w", file, err)
+	}
+	if err := list.validate(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("user allowlist %s: %w", file, err)
+	}
+	return list, info.ModTime(), nil
+}
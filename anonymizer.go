@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// torExitKey is the Redis set holding the current Tor exit node list.
+// It's shared intel, so it is not tenant-scoped.
+const torExitKey = "anonymizer:tor"
+
+// AnonymizerChecker classifies source IPs as Tor exit nodes or known proxies
+type AnonymizerChecker struct {
+	cfg    AnonymizerConfig
+	state  StateStore
+	client *http.Client
+}
+
+// NewAnonymizerChecker creates a checker backed by the shared state store
+func NewAnonymizerChecker(cfg AnonymizerConfig, state StateStore) *AnonymizerChecker {
+	return &AnonymizerChecker{
+		cfg:    cfg,
+		state:  state,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Lookup returns "tor", "proxy", or "" for a source IP
+func (a *AnonymizerChecker) Lookup(ctx context.Context, addr netip.Addr) (string, error) {
+	if !addr.IsValid() {
+		return "", nil
+	}
+
+	if containsAddr(a.cfg.proxies, addr) {
+		return "proxy", nil
+	}
+
+	tor, err := a.state.IsMember(ctx, torExitKey, addr.String())
+	if err != nil {
+		return "", &StateError{Op: "sismember", Key: torExitKey, Err: err}
+	}
+	if tor {
+		return "tor", nil
+	}
+	return "", nil
+}
+
+// Run refreshes the Tor list immediately and then on every interval until stop is closed
+func (a *AnonymizerChecker) Run(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(a.cfg.RefreshInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		// On failure keep serving the previously cached list
+		if err := a.refresh(ctx); err != nil {
+			log.Printf("Tor exit list refresh failed, keeping cached list: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh downloads the Tor exit list and replaces the cached set
+func (a *AnonymizerChecker) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.TorListURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var ips []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Store canonical forms so lookups match however the list spells them
+		if addr, ok := parseIP(line); ok {
+			ips = append(ips, addr.String())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// An empty list almost certainly means a bad response, not zero exit nodes
+	if len(ips) == 0 {
+		return fmt.Errorf("no IPs in response")
+	}
+
+	if err := a.state.ReplaceSet(ctx, torExitKey, ips); err != nil {
+		return &StateError{Op: "replace", Key: torExitKey, Err: err}
+	}
+
+	log.Printf("Loaded %d Tor exit nodes", len(ips))
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AssetEntry assigns a criticality tier to matching assets
+type AssetEntry struct {
+	Match string `json:"match"` // hostname or glob, IP, or CIDR
+	Tier  string `json:"tier"`
+
+	prefix netip.Prefix // set when Match is an IP or CIDR
+}
+
+// compile lowercases a hostname pattern or parses an IP/CIDR match
+func (e *AssetEntry) compile(tiers map[string]int) error {
+	if _, ok := tiers[e.Tier]; !ok {
+		return fmt.Errorf("%s: unknown tier %q", e.Match, e.Tier)
+	}
+	if prefixes, err := parsePrefixList([]string{e.Match}); err == nil {
+		e.prefix = prefixes[0]
+		return nil
+	}
+	e.Match = strings.ToLower(e.Match)
+	return validatePatterns([]string{e.Match})
+}
+
+// matches reports whether the entry covers a target host or IP
+func (e AssetEntry) matches(target string, addr netip.Addr) bool {
+	if e.prefix.IsValid() {
+		return addr.IsValid() && e.prefix.Contains(addr)
+	}
+	return matchAny([]string{e.Match}, target)
+}
+
+// compileAssets validates entries in place
+func compileAssets(entries []AssetEntry, tiers map[string]int) error {
+	for i := range entries {
+		if err := entries[i].compile(tiers); err != nil {
+			return fmt.Errorf("[%d] %w", i, err)
+		}
+	}
+	return nil
+}
+
+// AssetInventory maps event targets to criticality tiers: the config's
+// entries first, then an optional inventory file re-read whenever it changes
+type AssetInventory struct {
+	current atomic.Pointer[assetSnapshot]
+
+	mu      sync.Mutex // guards modTime between Run and Update
+	modTime time.Time
+}
+
+// assetSnapshot is the config and entries a lookup runs against
+type assetSnapshot struct {
+	cfg     AssetConfig
+	entries []AssetEntry
+}
+
+// NewAssetInventory loads the initial inventory
+func NewAssetInventory(cfg AssetConfig) (*AssetInventory, error) {
+	inv := &AssetInventory{}
+	if err := inv.Update(cfg); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Update swaps in a new config (on reload), re-reading its file. On error
+// the previous inventory stays active.
+func (inv *AssetInventory) Update(cfg AssetConfig) error {
+	entries := cfg.Assets
+	var modTime time.Time
+	if cfg.File != "" {
+		fromFile, mt, err := loadAssetFile(cfg.File, cfg.Tiers)
+		if err != nil {
+			return err
+		}
+		entries = append(append([]AssetEntry(nil), entries...), fromFile...)
+		modTime = mt
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.modTime = modTime
+	inv.current.Store(&assetSnapshot{cfg: cfg, entries: entries})
+	return nil
+}
+
+// target returns the asset an event is aimed at
+func (s *assetSnapshot) target(event SecurityEvent) string {
+	if s.cfg.TargetField != "" {
+		if t := event.Metadata[s.cfg.TargetField]; t != "" {
+			return t
+		}
+	}
+	return event.Source
+}
+
+// Lookup returns the event's target asset and its tier (first match wins,
+// unknown assets get the default tier)
+func (inv *AssetInventory) Lookup(event SecurityEvent) (string, string) {
+	snap := inv.current.Load()
+	target := snap.target(event)
+	if target == "" {
+		return "", snap.cfg.DefaultTier
+	}
+	addr, _ := parseIP(target)
+	host := strings.ToLower(target)
+
+	for _, e := range snap.entries {
+		if e.matches(host, addr) {
+			return target, e.Tier
+		}
+	}
+	return target, snap.cfg.DefaultTier
+}
+
+// Run polls the inventory file and swaps in changes until stop is closed.
+// A file that fails to load is logged and the previous inventory stays active.
+func (inv *AssetInventory) Run(stop <-chan struct{}) {
+	// Keep polling even without a file: a config reload may add one
+	interval := inv.current.Load().cfg.ReloadInterval.Duration
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			inv.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged re-reads the inventory file if it was modified
+func (inv *AssetInventory) reloadIfChanged() {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	snap := inv.current.Load()
+	if snap.cfg.File == "" {
+		return
+	}
+
+	info, err := os.Stat(snap.cfg.File)
+	if err != nil || !info.ModTime().After(inv.modTime) {
+		return
+	}
+
+	fromFile, modTime, err := loadAssetFile(snap.cfg.File, snap.cfg.Tiers)
+	if err != nil {
+		log.Printf("Asset inventory reload failed, keeping previous inventory: %v", err)
+		inv.modTime = info.ModTime()
+		return
+	}
+
+	entries := append(append([]AssetEntry(nil), snap.cfg.Assets...), fromFile...)
+	inv.current.Store(&assetSnapshot{cfg: snap.cfg, entries: entries})
+	inv.modTime = modTime
+	log.Printf("Reloaded %d assets from %s", len(fromFile), snap.cfg.File)
+}
+
+// loadAssetFile reads an inventory: a JSON array of entries, or a CSV
+// export (".csv") whose first two columns are the asset and its tier
+func loadAssetFile(file string, tiers map[string]int) ([]AssetEntry, time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("asset inventory: %w", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("asset inventory: %w", err)
+	}
+
+	var entries []AssetEntry
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		r := csv.NewReader(strings.NewReader(string(data)))
+		r.FieldsPerRecord = -1
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("asset inventory %s: %w", file, err)
+		}
+		for i, rec := range records {
+			if len(rec) < 2 {
+				return nil, time.Time{}, fmt.Errorf("asset inventory %s: line %d: want asset,tier", file, i+1)
+			}
+			if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "asset") {
+				continue // header
+			}
+			entries = append(entries, AssetEntry{Match: strings.TrimSpace(rec[0]), Tier: strings.TrimSpace(rec[1])})
+		}
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, time.Time{}, fmt.Errorf("asset inventory %s: %w", file, err)
+	}
+
+	if err := compileAssets(entries, tiers); err != nil {
+		return nil, time.Time{}, fmt.Errorf("asset inventory %s: %w", file, err)
+	}
+	return entries, info.ModTime(), nil
+}
+
+// shiftSeverity moves a severity by delta levels, clamped to LOW..CRITICAL
+func shiftSeverity(severity string, delta int) string {
+	rank := severityRank(severity)
+	if rank < 0 || delta == 0 {
+		return severity
+	}
+	rank += delta
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(severityLevels) {
+		rank = len(severityLevels) - 1
+	}
+	return severityLevels[rank]
+}
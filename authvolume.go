@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// authProfile is a user's authentication volume baseline. Rate is an EWMA
+// of successful logins per active hour; hours without logins aren't folded
+// in, so a user's quiet nights don't make their working day look anomalous.
+type authProfile struct {
+	Started int64   `json:"started"` // unix hour the user was first seen
+	Hour    int64   `json:"hour"`    // unix hour Count belongs to
+	Count   int64   `json:"count"`   // logins in Hour, not yet in Rate
+	Rate    float64 `json:"rate"`
+	Hours   int     `json:"hours"` // active hours folded into Rate
+}
+
+// fold adds the finished hour's count to the rate and starts a new hour
+func (p *authProfile) fold(smoothing float64, hour int64) {
+	if p.Count > 0 {
+		if p.Hours == 0 {
+			p.Rate = float64(p.Count)
+		} else {
+			p.Rate = smoothing*float64(p.Count) + (1-smoothing)*p.Rate
+		}
+		p.Hours++
+	}
+	p.Hour, p.Count = hour, 0
+}
+
+// validate checks the authentication volume settings
+func (c *AuthVolumeConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Factor <= 1 {
+		return fmt.Errorf("auth_volume.factor must be above 1")
+	}
+	if c.MinCount < 2 {
+		return fmt.Errorf("auth_volume.min_count must be at least 2")
+	}
+	if c.Smoothing <= 0 || c.Smoothing > 1 {
+		return fmt.Errorf("auth_volume.smoothing must be above 0 and at most 1")
+	}
+	if c.LearningPeriod.Duration < time.Hour {
+		return fmt.Errorf("auth_volume.learning_period must be at least 1h")
+	}
+	if c.Retention.Duration < c.LearningPeriod.Duration {
+		return fmt.Errorf("auth_volume.retention must be at least learning_period")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("auth_volume.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// authVolumeHit is a user whose logins this hour reached the spike threshold
+type authVolumeHit struct {
+	user      string
+	count     int64
+	threshold int64
+	profile   authProfile
+}
+
+// isAuthVolumeAnomaly counts a user's successful logins per hour and compares
+// the count with their baseline. The first event of an hour folds the last
+// active hour into the baseline, on one worker only. Users still inside the
+// learning period are counted but never flagged.
+func (td *ThreatDetector) isAuthVolumeAnomaly(ctx context.Context, event SecurityEvent) (*authVolumeHit, error) {
+	cfg := td.cfg().AuthVolume
+	user := strings.ToLower(event.User)
+	if !cfg.Enabled || user == "" || event.eventTypeLower != "authentication" || event.Result != "success" {
+		return nil, nil
+	}
+	hour := event.Timestamp.UTC().Truncate(time.Hour)
+	hourID := baselineHour(hour)
+
+	counter := stateKey(event, "auth_volume", user+":"+hourID)
+	count, err := td.state.IncrFixed(ctx, counter, 2*time.Hour)
+	if err != nil {
+		return nil, &StateError{Op: "incr", Key: counter, Err: err}
+	}
+
+	key := stateKey(event, "auth_profile", user)
+	value, err := td.state.Get(ctx, key)
+	if err != nil {
+		return nil, &StateError{Op: "get", Key: key, Err: err}
+	}
+	p := authProfile{Started: hour.Unix(), Hour: hour.Unix()}
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			return nil, fmt.Errorf("auth_volume: profile %s: %w", key, err)
+		}
+	}
+	switch {
+	case p.Hour > hour.Unix():
+		return nil, nil // a late event for an hour already folded
+	case p.Hour < hour.Unix():
+		lock := stateKey(event, "auth_profile_fold", user+":"+hourID)
+		won, err := td.state.SetIfAbsent(ctx, lock, "1", 2*time.Hour)
+		if err != nil {
+			return nil, &StateError{Op: "setnx", Key: lock, Err: err}
+		}
+		if !won {
+			return nil, nil // another worker is folding the hour
+		}
+		p.fold(cfg.Smoothing, hour.Unix())
+	}
+	p.Count = count
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := td.state.Set(ctx, key, string(data), cfg.Retention.Duration); err != nil {
+		return nil, &StateError{Op: "set", Key: key, Err: err}
+	}
+
+	if p.Hours == 0 || hour.Sub(time.Unix(p.Started, 0)) < cfg.LearningPeriod.Duration {
+		return nil, nil
+	}
+	// Fire once per hour, when the count first reaches the threshold
+	threshold := int64(math.Floor(cfg.Factor*p.Rate)) + 1
+	if threshold < int64(cfg.MinCount) {
+		threshold = int64(cfg.MinCount)
+	}
+	if count != threshold {
+		return nil, nil
+	}
+	return &authVolumeHit{user: user, count: count, threshold: threshold, profile: p}, nil
+}
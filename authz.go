@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// validate checks the authorization-probing settings
+func (c *AuthzProbingConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ResourceField == "" {
+		return fmt.Errorf("authz_probing.resource_field is required when enabled")
+	}
+	if len(c.DeniedResults) == 0 {
+		return fmt.Errorf("authz_probing.denied_results is required when enabled")
+	}
+	for i, r := range c.DeniedResults {
+		c.DeniedResults[i] = strings.ToLower(r)
+	}
+	if c.Threshold < 1 {
+		return fmt.Errorf("authz_probing.threshold must be at least 1")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("authz_probing.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("authz_probing.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// authzIdentity is who is probing: the user when known, else the source IP
+func authzIdentity(event SecurityEvent) string {
+	if event.User != "" {
+		return "user:" + strings.ToLower(event.User)
+	}
+	if event.SourceIP != "" {
+		return "ip:" + event.ipKey()
+	}
+	return ""
+}
+
+// isAuthzProbing counts access-denied responses per identity and resource.
+// Failed logins are left to the brute force rule: this is an authenticated
+// (or anonymous) caller being refused the same resource over and over.
+func (td *ThreatDetector) isAuthzProbing(ctx context.Context, event SecurityEvent) (bool, int64, string, error) {
+	cfg := td.cfg().AuthzProbing
+	if !cfg.Enabled || event.eventTypeLower == "authentication" {
+		return false, 0, "", nil
+	}
+	result := strings.ToLower(event.Result)
+	if result == "" || !containsString(cfg.DeniedResults, result) {
+		return false, 0, "", nil
+	}
+	resource := event.Metadata[cfg.ResourceField]
+	identity := authzIdentity(event)
+	if resource == "" || identity == "" {
+		return false, 0, "", nil
+	}
+
+	key := stateKey(event, "authz_denied", identity+":"+resource)
+	count, err := td.countInWindow(ctx, event, "AUTHZ_PROBING", key, cfg.Window.Duration)
+	if err != nil {
+		return false, 0, "", err
+	}
+	if err := td.recordRawEvent(ctx, event, key, cfg.Window.Duration); err != nil {
+		return false, 0, "", err
+	}
+	return count >= int64(cfg.Threshold), count, key, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// loadAWSConfig resolves credentials through the default chain (env, shared
+// config, web identity / IRSA, ECS and EC2 roles). Adaptive retries back off
+// and rate-limit the client when AWS throttles.
+func loadAWSConfig(ctx context.Context, region string, maxAttempts int) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryMode(aws.RetryModeAdaptive),
+		config.WithRetryMaxAttempts(maxAttempts),
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	return config.LoadDefaultConfig(ctx, opts...)
+}
+
+// alertAttributes are the message attributes consumers can filter on
+func alertAttributes(alert ThreatAlert) map[string]string {
+	attrs := map[string]string{
+		"severity":    alert.Severity,
+		"threat_type": alert.ThreatType,
+	}
+	if alert.TenantID != "" {
+		attrs["tenant_id"] = alert.TenantID
+	}
+	return attrs
+}
+
+// fifoIDs returns the group and deduplication IDs for a FIFO queue or topic.
+// Alerts from one source IP stay ordered; the body hash makes redelivery safe.
+func fifoIDs(alert ThreatAlert, body []byte) (*string, *string) {
+	group := alert.SourceIP
+	if group == "" {
+		group = "none"
+	}
+	sum := sha256.Sum256(body)
+	return aws.String(group), aws.String(hex.EncodeToString(sum[:16]))
+}
+
+// SQSSink sends alerts to an SQS queue in batches of up to ten messages
+type SQSSink struct {
+	cfg       SQSConfig
+	transform *AlertTransform
+	client    *sqs.Client
+	fifo      bool
+
+	mu      sync.Mutex
+	pending []sqstypes.SendMessageBatchRequestEntry
+}
+
+// NewSQSSink creates an SQS sink using the default AWS credential chain
+func NewSQSSink(ctx context.Context, cfg SQSConfig, transform *AlertTransform) (*SQSSink, error) {
+	awsCfg, err := loadAWSConfig(ctx, cfg.Region, cfg.MaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("sinks.sqs: %w", err)
+	}
+	client := sqs.NewFromConfig(awsCfg, func(o *sqs.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &SQSSink{cfg: cfg, transform: transform, client: client, fifo: strings.HasSuffix(cfg.QueueURL, ".fifo")}, nil
+}
+
+func (s *SQSSink) Name() string { return "sqs" }
+
+// Send queues the alert for the next batch, sending at once if the batch is full
+func (s *SQSSink) Send(ctx context.Context, alert ThreatAlert) error {
+	if severityRank(alert.Severity) < severityRank(s.cfg.MinSeverity) {
+		return nil
+	}
+
+	body, err := s.transform.encode(alert)
+	if err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: s.cfg.QueueURL, Err: err}
+	}
+
+	entry := sqstypes.SendMessageBatchRequestEntry{
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: make(map[string]sqstypes.MessageAttributeValue),
+	}
+	for k, v := range alertAttributes(alert) {
+		entry.MessageAttributes[k] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	if s.fifo {
+		entry.MessageGroupId, entry.MessageDeduplicationId = fifoIDs(alert, body)
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, entry)
+	var batch []sqstypes.SendMessageBatchRequestEntry
+	if len(s.pending) >= s.cfg.BatchSize {
+		batch, s.pending = s.pending, nil
+	}
+	s.mu.Unlock()
+
+	if batch != nil {
+		s.sendBatch(ctx, batch)
+	}
+	return nil
+}
+
+// Run sends partial batches every batch_wait until stop is closed
+func (s *SQSSink) Run(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.BatchWait.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			s.flush(ctx)
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+// flush sends whatever is pending
+func (s *SQSSink) flush(ctx context.Context) {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) > 0 {
+		s.sendBatch(ctx, batch)
+	}
+}
+
+// sendBatch calls SendMessageBatch, retrying entries that failed on the AWS
+// side. Whole-call throttling is retried by the SDK.
+func (s *SQSSink) sendBatch(ctx context.Context, batch []sqstypes.SendMessageBatchRequestEntry) {
+	for attempt := 1; len(batch) > 0; attempt++ {
+		for i := range batch {
+			batch[i].Id = aws.String(strconv.Itoa(i))
+		}
+
+		out, err := s.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(s.cfg.QueueURL),
+			Entries:  batch,
+		})
+		if err != nil {
+			processingErrors.WithLabelValues("publish").Inc()
+			log.Printf("SQS: dropping batch of %d alerts: %v", len(batch), err)
+			return
+		}
+
+		var retry []sqstypes.SendMessageBatchRequestEntry
+		for _, failed := range out.Failed {
+			i, _ := strconv.Atoi(aws.ToString(failed.Id))
+			if !failed.SenderFault && attempt < s.cfg.MaxAttempts {
+				retry = append(retry, batch[i])
+				continue
+			}
+			processingErrors.WithLabelValues("publish").Inc()
+			log.Printf("SQS: dropping alert: %s: %s", aws.ToString(failed.Code), aws.ToString(failed.Message))
+		}
+
+		batch = retry
+		if len(batch) > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+}
+
+// Close sends any alerts still waiting for a batch
+func (s *SQSSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.flush(ctx)
+	return nil
+}
+
+// SNSSink publishes each alert to an SNS topic
+type SNSSink struct {
+	cfg       SNSConfig
+	transform *AlertTransform
+	client    *sns.Client
+	fifo      bool
+}
+
+// NewSNSSink creates an SNS sink using the default AWS credential chain
+func NewSNSSink(ctx context.Context, cfg SNSConfig, transform *AlertTransform) (*SNSSink, error) {
+	awsCfg, err := loadAWSConfig(ctx, cfg.Region, cfg.MaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("sinks.sns: %w", err)
+	}
+	client := sns.NewFromConfig(awsCfg, func(o *sns.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &SNSSink{cfg: cfg, transform: transform, client: client, fifo: strings.HasSuffix(cfg.TopicARN, ".fifo")}, nil
+}
+
+func (s *SNSSink) Name() string { return "sns" }
+
+// Send publishes the alert with filterable message attributes
+func (s *SNSSink) Send(ctx context.Context, alert ThreatAlert) error {
+	if severityRank(alert.Severity) < severityRank(s.cfg.MinSeverity) {
+		return nil
+	}
+
+	body, err := s.transform.encode(alert)
+	if err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: s.cfg.TopicARN, Err: err}
+	}
+
+	subject := fmt.Sprintf("[%s] %s from %s", alert.Severity, alert.ThreatType, alert.SourceIP)
+	if len(subject) > 100 {
+		subject = subject[:100] // SNS limit
+	}
+
+	input := &sns.PublishInput{
+		TopicArn:          aws.String(s.cfg.TopicARN),
+		Message:           aws.String(string(body)),
+		Subject:           aws.String(subject),
+		MessageAttributes: make(map[string]snstypes.MessageAttributeValue),
+	}
+	for k, v := range alertAttributes(alert) {
+		input.MessageAttributes[k] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	if s.fifo {
+		input.MessageGroupId, input.MessageDeduplicationId = fifoIDs(alert, body)
+	}
+
+	// The SDK has already retried throttling with backoff
+	if _, err := s.client.Publish(ctx, input); err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: s.cfg.TopicARN, Err: err}
+	}
+	return nil
+}
+
+func (s *SNSSink) Close() error { return nil }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// baselineDimensions are the event fields a baseline aggregate can count by
+var baselineDimensions = map[string]func(SecurityEvent) string{
+	"user":       func(e SecurityEvent) string { return strings.ToLower(e.User) },
+	"source":     func(e SecurityEvent) string { return strings.ToLower(e.Source) },
+	"source_ip":  func(e SecurityEvent) string { return e.ipKey() },
+	"event_type": func(e SecurityEvent) string { return e.eventTypeLower },
+	"action":     func(e SecurityEvent) string { return e.actionLower },
+}
+
+// validate checks the baseline settings and lowercases the result list
+func (c *BaselineConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return fmt.Errorf("baseline.sample_rate must be above 0 and at most 1")
+	}
+	if len(c.Aggregates) == 0 {
+		return fmt.Errorf("baseline.aggregates is required when enabled")
+	}
+	for _, agg := range c.Aggregates {
+		for _, dim := range strings.Split(agg, "+") {
+			if baselineDimensions[dim] == nil {
+				return fmt.Errorf("baseline.aggregates: %q: unknown field %q (want user, source, source_ip, event_type or action, joined by +)", agg, dim)
+			}
+		}
+	}
+	if c.Retention.Duration < time.Hour {
+		return fmt.Errorf("baseline.retention must be at least 1h")
+	}
+	for i, r := range c.ExcludeResults {
+		c.ExcludeResults[i] = strings.ToLower(r)
+	}
+	return nil
+}
+
+// baselineHour is the hourly bucket an event is counted in
+func baselineHour(t time.Time) string {
+	return t.UTC().Format("2006010215")
+}
+
+// sampleBaseline counts a sample of benign events into hourly aggregates.
+// It runs after detection, so sampling never skips a rule; events whose
+// result is excluded (failures, denials) are never counted.
+func (td *ThreatDetector) sampleBaseline(ctx context.Context, event SecurityEvent) error {
+	cfg := td.cfg().Baseline
+	if !cfg.Enabled || containsString(cfg.ExcludeResults, strings.ToLower(event.Result)) {
+		return nil
+	}
+	if cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate {
+		return nil
+	}
+
+	hour := baselineHour(event.Timestamp)
+	for _, agg := range cfg.Aggregates {
+		dims := strings.Split(agg, "+")
+		values := make([]string, 0, len(dims))
+		for _, dim := range dims {
+			if v := baselineDimensions[dim](event); v != "" {
+				values = append(values, v)
+			}
+		}
+		// Events missing a field aren't counted in its aggregates
+		if len(values) < len(dims) {
+			continue
+		}
+		key := stateKey(event, "baseline:"+agg, strings.Join(values, "|")+":"+hour)
+		if _, err := td.state.Incr(ctx, key, cfg.Retention.Duration); err != nil {
+			return &StateError{Op: "incr", Key: key, Err: err}
+		}
+	}
+	baselineSamples.Inc()
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// breachLogin is the POST_BRUTEFORCE_SUCCESS login remembered for a user
+type breachLogin struct {
+	AlertID  string    `json:"alert_id"`
+	SourceIP string    `json:"source_ip"`
+	Session  string    `json:"session,omitempty"` // sessionRef, never the token
+	RawLog   string    `json:"raw_log,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// validate checks the confirmed-breach settings
+func (c *ConfirmedBreachConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("confirmed_breach.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("confirmed_breach.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// recordBreachLogin remembers a POST_BRUTEFORCE_SUCCESS login for the
+// user, with the session it opened, for the confirmed-breach window
+func (td *ThreatDetector) recordBreachLogin(ctx context.Context, event SecurityEvent, alert ThreatAlert) error {
+	cfg := td.cfg().ConfirmedBreach
+	if !cfg.Enabled {
+		return nil
+	}
+	login := breachLogin{AlertID: alert.AlertID, SourceIP: event.SourceIP, RawLog: event.RawLog, At: td.now()}
+	if session := event.Metadata[cfg.SessionField]; session != "" {
+		login.Session = sessionRef(session)
+	}
+	value, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	key := stateKey(event, "breach_login", event.User)
+	if err := td.state.Set(ctx, key, string(value), cfg.Window.Duration); err != nil {
+		return &StateError{Op: "set", Key: key, Err: err}
+	}
+	return nil
+}
+
+// isConfirmedBreach links a privilege escalation to a POST_BRUTEFORCE_SUCCESS
+// login by the same user within the window. When both events carry a
+// session ID they must match; otherwise the user alone links them. It
+// returns the login once per breach, nil if there's no link.
+func (td *ThreatDetector) isConfirmedBreach(ctx context.Context, event SecurityEvent) (*breachLogin, string, error) {
+	cfg := td.cfg().ConfirmedBreach
+	if !cfg.Enabled || event.User == "" {
+		return nil, "", nil
+	}
+	key := stateKey(event, "breach_login", event.User)
+	value, err := td.state.Get(ctx, key)
+	if err != nil {
+		return nil, "", &StateError{Op: "get", Key: key, Err: err}
+	}
+	if value == "" {
+		return nil, "", nil
+	}
+	var login breachLogin
+	if err := json.Unmarshal([]byte(value), &login); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", key, err)
+	}
+
+	linkedBy := "user"
+	if session := event.Metadata[cfg.SessionField]; session != "" && login.Session != "" {
+		if sessionRef(session) != login.Session {
+			return nil, "", nil
+		}
+		linkedBy = "session"
+	}
+
+	// One alert per breached login, however many escalations follow
+	alertedKey := stateKey(event, "breach_confirmed", login.AlertID)
+	seen, err := td.dedup.SeenRecently(ctx, alertedKey, cfg.Window.Duration)
+	if err != nil {
+		return nil, "", &StateError{Op: "dedup", Key: alertedKey, Err: err}
+	}
+	if seen {
+		return nil, "", nil
+	}
+	return &login, linkedBy, nil
+}
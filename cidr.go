@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cidrListedIPs bounds the offending IPs listed on an aggregated alert
+const cidrListedIPs = 20
+
+// validate checks the CIDR aggregation settings
+func (c *CIDRAggregationConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.IPv4Prefix < 1 || c.IPv4Prefix > 32 {
+		return fmt.Errorf("cidr_aggregation.ipv4_prefix must be between 1 and 32")
+	}
+	if c.IPv6Prefix < 1 || c.IPv6Prefix > 128 {
+		return fmt.Errorf("cidr_aggregation.ipv6_prefix must be between 1 and 128")
+	}
+	if c.Threshold < 2 {
+		return fmt.Errorf("cidr_aggregation.threshold must be at least 2")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("cidr_aggregation.window must be positive")
+	}
+	return nil
+}
+
+// aggregateByCIDR counts the distinct source IPs in the alert's network that
+// raised its threat type within the window. Below the threshold the alert
+// goes out as it is. The alert that reaches the threshold is replaced by one
+// alert for the whole network, and later alerts from the network are
+// suppressed until the window has passed. It reports whether the alert was
+// replaced or suppressed; a replaced alert is returned in its place.
+func (td *ThreatDetector) aggregateByCIDR(ctx context.Context, event SecurityEvent, alert ThreatAlert) (*ThreatAlert, bool, error) {
+	cfg := td.cfg().CIDRAggregation
+	if !cfg.Enabled || !event.addr.IsValid() {
+		return nil, false, nil
+	}
+	if len(cfg.ThreatTypes) > 0 && !containsString(cfg.ThreatTypes, alert.ThreatType) {
+		return nil, false, nil
+	}
+	network, err := ipPrefix(event.addr, cfg.IPv4Prefix, cfg.IPv6Prefix)
+	if err != nil {
+		return nil, false, nil
+	}
+	window := cfg.Window.Duration
+	id := alert.ThreatType + ":" + ipKeyPart(network.Addr()) + "/" + strconv.Itoa(network.Bits())
+
+	// Each IP is counted once per window, however many alerts it raises
+	countKey := stateKey(event, "cidr_ips", id)
+	listKey := stateKey(event, "cidr_ip_list", id)
+	seenKey := stateKey(event, "cidr_ip", id+":"+event.ipKey())
+	first, err := td.state.SetIfAbsent(ctx, seenKey, "1", window)
+	if err != nil {
+		return nil, false, &StateError{Op: "setnx", Key: seenKey, Err: err}
+	}
+	var count int64
+	if first {
+		if count, err = td.state.Incr(ctx, countKey, window); err != nil {
+			return nil, false, &StateError{Op: "incr", Key: countKey, Err: err}
+		}
+		if err := td.state.AppendList(ctx, listKey, event.SourceIP, cidrListedIPs, window); err != nil {
+			return nil, false, &StateError{Op: "rpush", Key: listKey, Err: err}
+		}
+	} else if count, err = td.counterValue(ctx, countKey); err != nil {
+		return nil, false, err
+	}
+	if count < int64(cfg.Threshold) {
+		return nil, false, nil
+	}
+
+	alertedKey := stateKey(event, "cidr_alerted", id)
+	seen, err := td.dedup.SeenRecently(ctx, alertedKey, window)
+	if err != nil {
+		return nil, false, &StateError{Op: "dedup", Key: alertedKey, Err: err}
+	}
+	if seen {
+		return nil, true, nil
+	}
+
+	ips, err := td.state.ListRange(ctx, listKey)
+	if err != nil {
+		return nil, false, &StateError{Op: "lrange", Key: listKey, Err: err}
+	}
+	// The network alert keeps the context routing depends on: the tenant
+	// picks the alerts topic and the environment picks the sinks. SourceIP
+	// and RawEvents are those of the IP that reached the threshold.
+	return &ThreatAlert{
+		AlertID:     fmt.Sprintf("AG-%d", time.Now().Unix()),
+		Timestamp:   alert.Timestamp,
+		Severity:    alert.Severity,
+		ThreatType:  alert.ThreatType,
+		SourceIP:    alert.SourceIP,
+		TenantID:    alert.TenantID,
+		User:        alert.User,
+		SourceZone:  alert.SourceZone,
+		Environment: alert.Environment,
+		RawEvents:   alert.RawEvents,
+		Details: fmt.Sprintf("%s from %d IPs in %s within %s: %s",
+			alert.ThreatType, count, network, window, strings.Join(ips, ", ")),
+		EventCount: int(count),
+		Metadata: map[string]string{
+			"source_cidr":  network.String(),
+			"distinct_ips": strconv.FormatInt(count, 10),
+			"source_ips":   strings.Join(ips, ","),
+		},
+	}, true, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Clock skew policies for events stamped in the future
+const (
+	skewClamp  = "clamp"  // set the timestamp to the time of arrival
+	skewReject = "reject" // dead-letter the event
+	skewAccept = "accept" // keep the timestamp as sent
+)
+
+// checkTimestamp applies the clock_skew policy to an event. Missing
+// timestamps become the arrival time; past timestamps are left alone.
+func (td *ThreatDetector) checkTimestamp(msg kafka.Message, event *SecurityEvent) error {
+	cfg := td.cfg().ClockSkew
+	now := time.Now()
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now
+		clockSkewEvents.WithLabelValues("missing").Inc()
+		return nil
+	}
+
+	skew := event.Timestamp.Sub(now)
+	if skew <= cfg.Tolerance.Duration {
+		return nil
+	}
+
+	switch cfg.Policy {
+	case skewReject:
+		clockSkewEvents.WithLabelValues("rejected").Inc()
+		return &ParseError{
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			StreamID:  streamID(msg),
+			Err:       &ClockSkewError{Timestamp: event.Timestamp, Skew: skew},
+		}
+	case skewAccept:
+		clockSkewEvents.WithLabelValues("accepted").Inc()
+	default:
+		td.debug.Printf("Clamped timestamp %s from %s (%s in the future)",
+			event.Timestamp.Format(time.RFC3339), event.SourceIP, skew.Round(time.Second))
+		event.Timestamp = now
+		clockSkewEvents.WithLabelValues("clamped").Inc()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	withinTolerance := time.Now().Add(30 * time.Second).Truncate(time.Second)
+
+	tests := []struct {
+		policy    string
+		timestamp time.Time
+		want      string // "kept", "now" or "rejected"
+	}{
+		{skewClamp, past, "kept"},
+		{skewClamp, future, "now"},
+		{skewClamp, withinTolerance, "kept"},
+		{skewClamp, time.Time{}, "now"},
+		{skewReject, past, "kept"},
+		{skewReject, future, "rejected"},
+		{skewReject, withinTolerance, "kept"},
+		{skewReject, time.Time{}, "now"},
+		{skewAccept, past, "kept"},
+		{skewAccept, future, "kept"},
+		{skewAccept, time.Time{}, "now"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.ClockSkew.Policy = tt.policy
+		td := newTestDetector(t, cfg)
+
+		event := SecurityEvent{SourceIP: "203.0.113.7", Timestamp: tt.timestamp}
+		before := time.Now()
+		err := td.checkTimestamp(kafka.Message{Offset: 7}, &event)
+		after := time.Now()
+
+		name := tt.policy + "/" + tt.want
+		if tt.timestamp.IsZero() {
+			name = tt.policy + "/zero"
+		}
+		switch tt.want {
+		case "rejected":
+			var parseErr *ParseError
+			var skewErr *ClockSkewError
+			if !errors.As(err, &parseErr) || !errors.As(err, &skewErr) {
+				t.Errorf("%s: err = %v, want a ParseError wrapping ClockSkewError", name, err)
+			}
+		case "kept":
+			if err != nil || !event.Timestamp.Equal(tt.timestamp) {
+				t.Errorf("%s: timestamp %s, err %v; want %s kept", name, event.Timestamp, err, tt.timestamp)
+			}
+		case "now":
+			if err != nil || event.Timestamp.Before(before) || event.Timestamp.After(after) {
+				t.Errorf("%s: timestamp %s, err %v; want the arrival time", name, event.Timestamp, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MITRE ATT&CK techniques tagged on the CloudTrail alerts
+const (
+	mitreCloudAccounts   = "T1078.004"
+	mitreMFAModification = "T1556.006"
+	mitreCloudRoles      = "T1098.003"
+)
+
+// validate checks the CloudTrail rule settings
+func (c *CloudTrailConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if r := c.RootUsage; r.Enabled {
+		if r.Window.Duration <= 0 {
+			return fmt.Errorf("cloudtrail.root_usage.window must be positive")
+		}
+		if severityRank(r.Severity) < 0 {
+			return fmt.Errorf("cloudtrail.root_usage.severity %q is not a severity", r.Severity)
+		}
+	}
+	if m := c.ConsoleMFA; m.Enabled && severityRank(m.Severity) < 0 {
+		return fmt.Errorf("cloudtrail.console_mfa.severity %q is not a severity", m.Severity)
+	}
+	if m := c.MFADisabled; m.Enabled {
+		if len(m.Events) == 0 {
+			return fmt.Errorf("cloudtrail.mfa_disabled.events is required when enabled")
+		}
+		if severityRank(m.Severity) < 0 {
+			return fmt.Errorf("cloudtrail.mfa_disabled.severity %q is not a severity", m.Severity)
+		}
+	}
+	if p := c.IAMChanges; p.Enabled {
+		if len(p.Events) == 0 {
+			return fmt.Errorf("cloudtrail.iam_changes.events is required when enabled")
+		}
+		if err := validatePatterns(p.Events); err != nil {
+			return fmt.Errorf("cloudtrail.iam_changes.events: %w", err)
+		}
+		if p.Threshold < 1 {
+			return fmt.Errorf("cloudtrail.iam_changes.threshold must be at least 1")
+		}
+		if p.Window.Duration <= 0 {
+			return fmt.Errorf("cloudtrail.iam_changes.window must be positive")
+		}
+		if severityRank(p.Severity) < 0 {
+			return fmt.Errorf("cloudtrail.iam_changes.severity %q is not a severity", p.Severity)
+		}
+	}
+	return nil
+}
+
+// isRootUsage reports the root user acting on its own, once per account per
+// window. Calls AWS services make on root's behalf don't count.
+func (td *ThreatDetector) isRootUsage(ctx context.Context, event SecurityEvent) (bool, error) {
+	cfg := td.cfg().CloudTrail.RootUsage
+	meta := event.Metadata
+	if !cfg.Enabled || meta["user_type"] != "Root" || meta["invoked_by"] != "" || meta["aws_event_type"] == "AwsServiceEvent" {
+		return false, nil
+	}
+	key := stateKey(event, "root_usage", meta["account_id"])
+	seen, err := td.dedup.SeenRecently(ctx, key, cfg.Window.Duration)
+	if err != nil {
+		return false, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	return !seen, nil
+}
+
+// isConsoleLoginWithoutMFA reports a successful console login by an IAM or
+// root user that didn't use MFA. Federated and SSO logins are left out:
+// their MFA happens at the identity provider.
+func (td *ThreatDetector) isConsoleLoginWithoutMFA(event SecurityEvent) bool {
+	meta := event.Metadata
+	if !td.cfg().CloudTrail.ConsoleMFA.Enabled || meta["event_name"] != "ConsoleLogin" || event.Result != "success" {
+		return false
+	}
+	switch meta["user_type"] {
+	case "IAMUser", "Root":
+		return meta["mfa_used"] == "No"
+	}
+	return false
+}
+
+// isMFADisabled reports an MFA device deactivated or deleted
+func (td *ThreatDetector) isMFADisabled(event SecurityEvent) bool {
+	cfg := td.cfg().CloudTrail.MFADisabled
+	return cfg.Enabled && event.Result == "success" && event.Metadata["event_source"] == "iam.amazonaws.com" &&
+		containsString(cfg.Events, event.Metadata["event_name"])
+}
+
+// isIAMPolicyChanges counts one identity's successful IAM policy changes.
+// It returns the count once it reaches the threshold.
+func (td *ThreatDetector) isIAMPolicyChanges(ctx context.Context, event SecurityEvent) (bool, int64, string, error) {
+	cfg := td.cfg().CloudTrail.IAMChanges
+	if !cfg.Enabled || event.Result != "success" || event.User == "" || event.Metadata["event_source"] != "iam.amazonaws.com" ||
+		!matchAny(cfg.Events, event.Metadata["event_name"]) {
+		return false, 0, "", nil
+	}
+	key := stateKey(event, "iam_changes", event.User)
+	count, err := td.countInWindow(ctx, event, "IAM_POLICY_CHANGES", key, cfg.Window.Duration)
+	if err != nil {
+		return false, 0, "", err
+	}
+	if err := td.recordRawEvent(ctx, event, key, cfg.Window.Duration); err != nil {
+		return false, 0, "", err
+	}
+	return count >= int64(cfg.Threshold), count, key, nil
+}
+
+// mfaTarget names the user whose MFA was removed: the userName parameter,
+// else the user in the device's serial number (arn:aws:iam::<acct>:mfa/<user>)
+func mfaTarget(event SecurityEvent) string {
+	if user := event.Metadata["target_user"]; user != "" {
+		return user
+	}
+	if _, user, ok := strings.Cut(event.Metadata["mfa_serial"], ":mfa/"); ok {
+		return user
+	}
+	return event.User
+}
+
+// detectCloudTrail runs the AWS rules and raises their alerts. Events that
+// didn't come from CloudTrail are skipped entirely.
+func (td *ThreatDetector) detectCloudTrail(ctx context.Context, event SecurityEvent) []error {
+	cfg := td.cfg().CloudTrail
+	if !cfg.Enabled || event.Metadata["event_source"] == "" {
+		return nil
+	}
+	account := event.Metadata["account_id"]
+
+	var errs []error
+
+	if hit, err := td.isRootUsage(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("RU-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.RootUsage.Severity,
+			ThreatType: "ROOT_ACCOUNT_USAGE",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Root user of AWS account %s called %s (%s) from %s",
+				account, event.Action, event.Source, event.SourceIP),
+			EventCount: 1,
+			Metadata:   map[string]string{"mitre_technique": mitreCloudAccounts, "account_id": account, "event_name": event.Action},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	if td.isConsoleLoginWithoutMFA(event) {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("NM-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.ConsoleMFA.Severity,
+			ThreatType: "CONSOLE_LOGIN_WITHOUT_MFA",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("%s logged in to the AWS console of account %s from %s without MFA", event.User, account, event.SourceIP),
+			EventCount: 1,
+			Metadata:   map[string]string{"mitre_technique": mitreCloudAccounts, "account_id": account},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	if td.isMFADisabled(event) {
+		target := mfaTarget(event)
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("MD-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.MFADisabled.Severity,
+			ThreatType: "MFA_DISABLED",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("%s removed the MFA device of %s in AWS account %s (%s)", event.User, target, account, event.Action),
+			EventCount: 1,
+			Metadata:   map[string]string{"mitre_technique": mitreMFAModification, "account_id": account, "target_user": target},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	if hit, count, key, err := td.isIAMPolicyChanges(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("IP-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.IAMChanges.Severity,
+			ThreatType: "IAM_POLICY_CHANGES",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%s made %d IAM policy changes in AWS account %s within %s (latest %s)",
+				event.User, count, account, cfg.IAMChanges.Window, strings.TrimSpace(event.Action+" "+event.Metadata["policy"])),
+			EventCount: int(count),
+			Metadata:   map[string]string{"mitre_technique": mitreCloudRoles, "account_id": account},
+			stateKey:   key,
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	return errs
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cloudTrailRecord is one CloudTrail event record, as delivered by a trail,
+// CloudTrail Lake or EventBridge
+type cloudTrailRecord struct {
+	EventVersion    string `json:"eventVersion"`
+	EventTime       string `json:"eventTime"`
+	EventSource     string `json:"eventSource"`
+	EventName       string `json:"eventName"`
+	EventType       string `json:"eventType"`
+	EventID         string `json:"eventID"`
+	AWSRegion       string `json:"awsRegion"`
+	SourceIPAddress string `json:"sourceIPAddress"`
+	UserAgent       string `json:"userAgent"`
+	ErrorCode       string `json:"errorCode"`
+	ErrorMessage    string `json:"errorMessage"`
+	RecipientAcct   string `json:"recipientAccountId"`
+	UserIdentity    struct {
+		Type           string `json:"type"`
+		PrincipalID    string `json:"principalId"`
+		ARN            string `json:"arn"`
+		AccountID      string `json:"accountId"`
+		UserName       string `json:"userName"`
+		InvokedBy      string `json:"invokedBy"`
+		SessionContext struct {
+			Attributes struct {
+				MFAAuthenticated string `json:"mfaAuthenticated"`
+			} `json:"attributes"`
+			SessionIssuer struct {
+				UserName string `json:"userName"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
+	} `json:"userIdentity"`
+	RequestParameters   map[string]interface{} `json:"requestParameters"`
+	ResponseElements    map[string]interface{} `json:"responseElements"`
+	AdditionalEventData map[string]interface{} `json:"additionalEventData"`
+}
+
+// cloudTrailEnvelope is a trail log file ({"Records": [...]}) or an
+// EventBridge event ({"detail": {...}}) around the record
+type cloudTrailEnvelope struct {
+	Records []json.RawMessage `json:"Records"`
+	Detail  json.RawMessage   `json:"detail"`
+}
+
+// cloudTrailEventTypes maps the event names whose type isn't implied by
+// their service onto the analyzer's event types
+var cloudTrailEventTypes = map[string]string{
+	"ConsoleLogin":       "authentication",
+	"CheckMfa":           "authentication",
+	"ChangePassword":     "password_change",
+	"UpdateLoginProfile": "password_reset",
+}
+
+// cloudTrailTargets copies the request parameters naming what an IAM call
+// acts on into metadata, in order (a policy ARN beats a policy name)
+var cloudTrailTargets = [][2]string{
+	{"userName", "target_user"},
+	{"roleName", "target_role"},
+	{"groupName", "group"},
+	{"policyName", "policy"},
+	{"policyArn", "policy"},
+	{"serialNumber", "mfa_serial"},
+}
+
+// hiddenUserName is what CloudTrail logs for a console login to an unknown user
+const hiddenUserName = "HIDDEN_DUE_TO_SECURITY_REASONS"
+
+// isCloudTrail reports whether a JSON message looks like a CloudTrail record,
+// for input.format auto
+func isCloudTrail(data []byte) bool {
+	return bytes.Contains(data, []byte(`"eventSource"`)) && bytes.Contains(data, []byte(`"userIdentity"`))
+}
+
+// parseCloudTrail converts one CloudTrail record into a SecurityEvent:
+// eventName is the action, sourceIPAddress the source IP, the identity's
+// name the user and errorCode the result. A log file must hold one record.
+func parseCloudTrail(data []byte) (SecurityEvent, error) {
+	var env cloudTrailEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return SecurityEvent{}, fmt.Errorf("cloudtrail: %w", err)
+	}
+	record := data
+	switch {
+	case env.Records != nil:
+		if len(env.Records) != 1 {
+			return SecurityEvent{}, fmt.Errorf("cloudtrail: message holds %d records; send one per message", len(env.Records))
+		}
+		record = env.Records[0]
+	case len(env.Detail) > 0:
+		record = env.Detail
+	}
+
+	var r cloudTrailRecord
+	if err := json.Unmarshal(record, &r); err != nil {
+		return SecurityEvent{}, fmt.Errorf("cloudtrail: %w", err)
+	}
+	if r.EventName == "" || r.EventSource == "" {
+		return SecurityEvent{}, fmt.Errorf("cloudtrail: no eventName or eventSource")
+	}
+
+	id := r.UserIdentity
+	account := r.RecipientAcct
+	if account == "" {
+		account = id.AccountID
+	}
+	meta := map[string]string{
+		"event_source": r.EventSource,
+		"event_name":   r.EventName,
+		"user_type":    id.Type,
+	}
+	for key, value := range map[string]string{
+		"cloudtrail_event_id": r.EventID,
+		"aws_event_type":      r.EventType,
+		"aws_region":          r.AWSRegion,
+		"account_id":          account,
+		"user_arn":            id.ARN,
+		"invoked_by":          id.InvokedBy,
+		"user_agent":          r.UserAgent,
+		"error_code":          r.ErrorCode,
+		"error_message":       r.ErrorMessage,
+		"mfa_authenticated":   id.SessionContext.Attributes.MFAAuthenticated,
+	} {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+	if mfa, ok := r.AdditionalEventData["MFAUsed"].(string); ok {
+		meta["mfa_used"] = mfa
+	}
+	for _, target := range cloudTrailTargets {
+		if v, ok := r.RequestParameters[target[0]].(string); ok && v != "" {
+			meta[target[1]] = v
+		}
+	}
+
+	event := SecurityEvent{
+		Source:    r.EventSource,
+		EventType: cloudTrailEventType(r.EventSource, r.EventName),
+		Action:    r.EventName,
+		User:      cloudTrailUser(r),
+		Result:    cloudTrailResult(r),
+		RawLog:    string(bytes.TrimSpace(record)),
+		Metadata:  meta,
+	}
+	// Calls made by AWS services log the service's name instead of an IP
+	if _, ok := parseIP(r.SourceIPAddress); ok {
+		event.SourceIP = r.SourceIPAddress
+	} else if r.SourceIPAddress != "" {
+		meta["source_ip_address"] = r.SourceIPAddress
+	}
+	if t, err := time.Parse(time.RFC3339, r.EventTime); err == nil {
+		event.Timestamp = t
+	}
+	if event.User == hiddenUserName {
+		event.User = ""
+		meta["failure_reason"] = "unknown_user"
+	}
+	return event, nil
+}
+
+// cloudTrailEventType is the event type for a call: by event name where
+// mapped, else "iam" for IAM calls and "aws_api" for the rest
+func cloudTrailEventType(source, name string) string {
+	if t := cloudTrailEventTypes[name]; t != "" {
+		return t
+	}
+	if source == "iam.amazonaws.com" {
+		return "iam"
+	}
+	return "aws_api"
+}
+
+// cloudTrailUser names the identity: "root" for the root user, the IAM user
+// name, or role/session for an assumed role
+func cloudTrailUser(r cloudTrailRecord) string {
+	id := r.UserIdentity
+	switch {
+	case id.Type == "Root":
+		return "root"
+	case id.UserName != "":
+		return id.UserName
+	case id.Type == "AssumedRole" && id.SessionContext.SessionIssuer.UserName != "":
+		session := id.ARN[strings.LastIndex(id.ARN, "/")+1:]
+		return id.SessionContext.SessionIssuer.UserName + "/" + session
+	case id.ARN != "":
+		return id.ARN
+	}
+	return id.PrincipalID
+}
+
+// cloudTrailResult is "success", "access_denied" for authorization errors,
+// or "failed". A failed console login has no errorCode, only a response.
+func cloudTrailResult(r cloudTrailRecord) string {
+	if login, ok := r.ResponseElements["ConsoleLogin"].(string); ok && login == "Failure" {
+		return "failed"
+	}
+	switch {
+	case r.ErrorCode == "":
+		return "success"
+	case strings.Contains(r.ErrorCode, "AccessDenied"), strings.Contains(r.ErrorCode, "Unauthorized"):
+		return "access_denied"
+	}
+	return "failed"
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	metadataAPI "github.com/segmentio/kafka-go/protocol/metadata"
+	produceAPI "github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// wireTransport is a one-broker cluster for a kafka.Writer: it answers
+// metadata requests and keeps each produced record batch exactly as it
+// would go over the wire
+type wireTransport struct {
+	mu      sync.Mutex
+	batches [][]byte
+}
+
+func (t *wireTransport) RoundTrip(ctx context.Context, addr net.Addr, req kafka.Request) (kafka.Response, error) {
+	switch req := req.(type) {
+	case *metadataAPI.Request:
+		res := &metadataAPI.Response{Brokers: []metadataAPI.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}}}
+		for _, topic := range req.TopicNames {
+			res.Topics = append(res.Topics, metadataAPI.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadataAPI.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			})
+		}
+		return res, nil
+	case *produceAPI.Request:
+		res := &produceAPI.Response{}
+		for _, topic := range req.Topics {
+			rt := produceAPI.ResponseTopic{Topic: topic.Topic}
+			for _, p := range topic.Partitions {
+				// Produce v3+ always carries v2 record batches
+				rs := p.RecordSet
+				rs.Version = 2
+				var buf bytes.Buffer
+				if _, err := rs.WriteTo(&buf); err != nil {
+					return nil, err
+				}
+				t.mu.Lock()
+				t.batches = append(t.batches, buf.Bytes())
+				t.mu.Unlock()
+				rt.Partitions = append(rt.Partitions, produceAPI.ResponsePartition{Partition: p.Partition})
+			}
+			res.Topics = append(res.Topics, rt)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", req)
+}
+
+// readBatch decodes a record batch as a consumer would, returning the codec
+// it was compressed with and its record values
+func readBatch(t *testing.T, batch []byte) (kafka.Compression, [][]byte) {
+	t.Helper()
+	var rs protocol.RecordSet
+	if _, err := rs.ReadFrom(bytes.NewReader(batch)); err != nil {
+		t.Fatalf("reading batch: %v", err)
+	}
+
+	var values [][]byte
+	for {
+		record, err := rs.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading batch: %v", err)
+		}
+		value, err := protocol.ReadAll(record.Value)
+		if err != nil {
+			t.Fatalf("reading record: %v", err)
+		}
+		values = append(values, value)
+	}
+	return rs.Attributes.Compression(), values
+}
+
+// rawLines is evidence from a sustained brute force: near-identical lines
+var rawLines = []string{
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51000 ssh2",
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51001 ssh2",
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51002 ssh2",
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51003 ssh2",
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51004 ssh2",
+}
+
+// compressionAlerts is a batch of alerts with repetitive, compressible evidence
+func compressionAlerts() []ThreatAlert {
+	alerts := make([]ThreatAlert, 20)
+	for i := range alerts {
+		alerts[i] = ThreatAlert{
+			AlertID:    fmt.Sprintf("BF-%d", 1704067200+i),
+			Timestamp:  time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			Severity:   "HIGH",
+			ThreatType: "BRUTE_FORCE",
+			SourceIP:   fmt.Sprintf("203.0.113.%d", i+1),
+			Details:    "Brute force attack detected",
+			EventCount: 5,
+			User:       "alice",
+			RawEvents:  rawLines,
+		}
+	}
+	return alerts
+}
+
+func TestKafkaCompressionRoundTrip(t *testing.T) {
+	var plainSize int
+	for _, codec := range []string{"none", "gzip", "snappy", "lz4", "zstd"} {
+		t.Run(codec, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Compression = codec
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("config: %v", err)
+			}
+			transport := &wireTransport{}
+			writer := &kafka.Writer{
+				Addr:         kafka.TCP("localhost:9092"),
+				Balancer:     &kafka.LeastBytes{},
+				Compression:  cfg.KafkaCompression(),
+				BatchSize:    100,
+				BatchTimeout: 5 * time.Millisecond,
+				Transport:    transport,
+			}
+			defer writer.Close()
+			sink := NewKafkaSink(writer, func() *DetectorConfig { return cfg })
+
+			alerts := compressionAlerts()
+			for _, alert := range alerts {
+				if err := sink.Send(context.Background(), alert); err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+			}
+
+			var got []ThreatAlert
+			size := 0
+			for _, batch := range transport.batches {
+				size += len(batch)
+				batchCodec, values := readBatch(t, batch)
+				if batchCodec != cfg.KafkaCompression() {
+					t.Errorf("batch compressed with %v, want %s", batchCodec, codec)
+				}
+				for _, value := range values {
+					var alert ThreatAlert
+					if err := json.Unmarshal(value, &alert); err != nil {
+						t.Fatalf("record is not an alert: %v", err)
+					}
+					got = append(got, alert)
+				}
+			}
+			if len(got) != len(alerts) {
+				t.Fatalf("read back %d alerts, want %d", len(got), len(alerts))
+			}
+			for i := range alerts {
+				want, _ := json.Marshal(alerts[i])
+				back, _ := json.Marshal(got[i])
+				if !bytes.Equal(want, back) {
+					t.Errorf("alert %d = %s, want %s", i, back, want)
+				}
+			}
+
+			if codec == "none" {
+				plainSize = size
+			} else if plainSize > 0 && size >= plainSize {
+				t.Errorf("%s batches are %d bytes, uncompressed %d", codec, size, plainSize)
+			}
+		})
+	}
+}
+
+// Compressed input needs no configuration: a batch from a producer using
+// any codec decodes to events the detector parses and detects on
+func TestCompressedInputIsDetected(t *testing.T) {
+	threshold := DefaultConfig().Thresholds.BruteForce
+	for _, codec := range []kafka.Compression{kafka.Gzip, kafka.Snappy, kafka.Lz4, kafka.Zstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			records := make([]protocol.Record, threshold)
+			for i, msg := range failedLogins(1, threshold) {
+				records[i] = protocol.Record{Offset: int64(i), Value: protocol.NewBytes(msg.Value)}
+			}
+			rs := protocol.RecordSet{
+				Version:    2,
+				Attributes: protocol.Attributes(codec),
+				Records:    protocol.NewRecordReader(records...),
+			}
+			var buf bytes.Buffer
+			if _, err := rs.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			td := newTestDetector(t, nil)
+			input := DefaultConfig().Input
+			_, values := readBatch(t, buf.Bytes())
+			if len(values) != threshold {
+				t.Fatalf("read %d events, want %d", len(values), threshold)
+			}
+			for _, value := range values {
+				event, err := parseEvent(kafka.Message{Value: value}, &input)
+				if err != nil {
+					t.Fatalf("parseEvent: %v", err)
+				}
+				if err := td.detectThreats(context.Background(), event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			found := false
+			for _, alert := range drainAlerts(td) {
+				found = found || alert.ThreatType == "BRUTE_FORCE"
+			}
+			if !found {
+				t.Error("no BRUTE_FORCE alert from compressed input")
+			}
+		})
+	}
+}
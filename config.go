@@ -0,0 +1,2449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Duration is a time.Duration that reads and writes as a string like "5m" in JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Thresholds holds per-rule detection thresholds.
+// A zero value means "inherit" when used as a tenant override.
+type Thresholds struct {
+	BruteForce     int `json:"brute_force"`     // failed auths per IP in 5 min
+	SuspiciousUser int `json:"suspicious_user"` // invalid users per IP in 5 min
+	PasswordChange int `json:"password_change"` // password changes per user in 1 hour
+	ExfilBytes     int `json:"exfil_bytes"`     // bytes_out in a single event
+}
+
+// AdaptiveThresholdConfig scales the count thresholds with this instance's
+// event rate, so a log storm doesn't become an alert storm
+type AdaptiveThresholdConfig struct {
+	Enabled      bool     `json:"enabled"`
+	BaselineRate float64  `json:"baseline_rate"` // normal events per second for one instance
+	Interval     Duration `json:"interval"`      // how often the rate is measured
+	Scaling      string   `json:"scaling"`       // "linear", "sqrt" or "log" of rate / baseline_rate
+	MinFactor    float64  `json:"min_factor"`    // bounds on the factor thresholds are scaled by
+	MaxFactor    float64  `json:"max_factor"`
+}
+
+// TenantConfig overrides detection settings for a single tenant
+type TenantConfig struct {
+	Thresholds   Thresholds `json:"thresholds"`
+	AllowlistIPs []string   `json:"allowlist_ips"`
+	AlertsTopic  string     `json:"alerts_topic"`
+
+	allowlist []netip.Prefix
+}
+
+// AnonymizerConfig controls Tor exit node / proxy enrichment
+type AnonymizerConfig struct {
+	Enabled         bool     `json:"enabled"`
+	TorListURL      string   `json:"tor_list_url"`     // one IP per line, '#' comments allowed
+	RefreshInterval Duration `json:"refresh_interval"` // how often the Tor list is re-fetched
+	ProxyCIDRs      []string `json:"proxy_cidrs"`      // known VPN/proxy ranges
+
+	proxies []netip.Prefix
+}
+
+// SnapshotConfig controls periodic state snapshots to a compacted Kafka topic
+type SnapshotConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Topic    string   `json:"topic"`    // must be created with cleanup.policy=compact
+	Interval Duration `json:"interval"` // time between snapshots
+}
+
+// IPSeenConfig controls first-seen / last-seen tracking per source IP
+type IPSeenConfig struct {
+	Enabled bool     `json:"enabled"`
+	TTL     Duration `json:"ttl"` // how long an idle IP is remembered
+}
+
+// IPHistoryConfig controls the per-IP recent event history attached to alerts
+type IPHistoryConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Length      int      `json:"length"`       // events kept per IP
+	TTL         Duration `json:"ttl"`          // how long an idle IP's history is kept
+	MinSeverity string   `json:"min_severity"` // lowest alert severity that carries the history
+}
+
+// TimelineConfig controls the attack timeline attached to alerts, built
+// from each source IP's and user's recent events
+type TimelineConfig struct {
+	Enabled bool           `json:"enabled"`
+	Length  int            `json:"length"`  // events kept per IP and per user
+	TTL     Duration       `json:"ttl"`     // how long an idle IP's or user's timeline is kept
+	Entries map[string]int `json:"entries"` // timeline entries per alert severity (unlisted: none)
+}
+
+// StateRetentionConfig keeps the Redis state behind an alert (counter, raw
+// events, related sets) for longer than the detection window, by severity
+// or threat type, so serious detections can be investigated
+type StateRetentionConfig struct {
+	Severity    map[string]Duration `json:"severity"`     // e.g. CRITICAL -> 24h
+	ThreatTypes map[string]Duration `json:"threat_types"` // takes precedence over severity
+}
+
+// WatchdogConfig controls restarting workers stuck on a single event
+type WatchdogConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Timeout  Duration `json:"timeout"`  // processing time after which a worker is restarted
+	Interval Duration `json:"interval"` // how often workers are checked
+}
+
+// PoisonConfig dead-letters messages that are redelivered again and again
+// because processing never completes (a crash or a watchdog restart)
+type PoisonConfig struct {
+	Enabled     bool     `json:"enabled"`
+	MaxAttempts int      `json:"max_attempts"` // deliveries before a message is dead-lettered
+	TTL         Duration `json:"ttl"`          // how long a delivery count is kept
+}
+
+// ScanConfig re-evaluates counter state on a schedule, independent of
+// event arrivals, for patterns no single event trips
+type ScanConfig struct {
+	Enabled  bool       `json:"enabled"`
+	Interval Duration   `json:"interval"`
+	Rules    []ScanRule `json:"rules"`
+}
+
+// ScanRule raises an alert from the live counters of one counter rule.
+// In "distributed" mode it fires when many sources each sit at min_count;
+// in "persistent" mode when one source stays at min_count for min_age.
+type ScanRule struct {
+	Name       string   `json:"name"`        // threat type of the alert
+	Counter    string   `json:"counter"`     // counter rule whose state is scanned (built-in or custom)
+	Mode       string   `json:"mode"`        // distributed or persistent
+	MinCount   int64    `json:"min_count"`   // count a key needs to be considered
+	MaxCount   int64    `json:"max_count"`   // keys above it are left to the counter rule (0 = no limit)
+	MinSources int      `json:"min_sources"` // distributed: keys at min_count
+	MinTotal   int64    `json:"min_total"`   // distributed: their combined count
+	MinAge     Duration `json:"min_age"`     // persistent: how long the key must stay at min_count
+	Severity   string   `json:"severity"`
+	Cooldown   Duration `json:"cooldown"` // quiet period after an alert (default 1h)
+}
+
+// AlertStoreConfig controls the recent-alerts store behind GET /alerts
+type AlertStoreConfig struct {
+	Enabled   bool     `json:"enabled"`
+	Backend   string   `json:"backend"`    // redis (shared by all instances) or memory
+	Retention Duration `json:"retention"`  // how long alerts are kept
+	Key       string   `json:"key"`        // Redis sorted set
+	MaxAlerts int      `json:"max_alerts"` // cap for the memory backend
+	Token     string   `json:"token"`      // optional bearer token for GET /alerts (secret)
+	TokenFile string   `json:"token_file"` // read into Token at load
+}
+
+// DedupConfig chooses where rules remember what they've already alerted on
+type DedupConfig struct {
+	Backend    string `json:"backend"`     // redis (shared by all instances) or memory
+	MaxEntries int    `json:"max_entries"` // cap for the memory backend
+}
+
+// ObservationConfig controls the observation tier below alerts, which keeps
+// a trail of detections for tuning and forensics without paging anyone
+type ObservationConfig struct {
+	ThreatTypes map[string]string `json:"threat_types"` // threat type -> "observe" (instead of alerting) or "both"
+	Store       bool              `json:"store"`        // keep them for GET /observations, in alert_store's backend
+	Retention   Duration          `json:"retention"`    // how long the store keeps them
+	Key         string            `json:"key"`          // Redis sorted set for the redis backend
+}
+
+// UIConfig controls the built-in dashboard, which reads the alert store
+type UIConfig struct {
+	Enabled bool   `json:"enabled"`
+	Addr    string `json:"addr"` // own listen address; empty serves it on http_addr
+}
+
+// StandbyConfig is an optional warm standby Redis that mirrors state writes
+// and takes over when the primary fails
+type StandbyConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Addr          string   `json:"addr"`
+	Password      string   `json:"password"`       // secret
+	PasswordFile  string   `json:"password_file"`  // read into Password at load
+	FailoverAfter int      `json:"failover_after"` // consecutive primary errors before switching
+	QueueSize     int      `json:"queue_size"`     // mirrored writes waiting for the standby
+	Timeout       Duration `json:"timeout"`        // per mirrored write
+}
+
+// RedisPoolConfig sizes the Redis connection pool and bounds each command
+type RedisPoolConfig struct {
+	PoolSize     int      `json:"pool_size"`      // 0: 4 per worker, at least 10
+	MinIdleConns int      `json:"min_idle_conns"` // 0: one per worker
+	DialTimeout  Duration `json:"dial_timeout"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	PoolTimeout  Duration `json:"pool_timeout"` // wait for a free connection when all are busy
+}
+
+// ClockSkewConfig decides what happens to events stamped in the future
+type ClockSkewConfig struct {
+	Policy    string   `json:"policy"`    // clamp, reject or accept
+	Tolerance Duration `json:"tolerance"` // future skew always accepted as sent
+}
+
+// DispatchConfig controls how input events are spread across workers
+type DispatchConfig struct {
+	// Affinity routes every event from one source IP to the same worker, so
+	// that IP's state updates never race within an instance
+	Affinity  bool `json:"affinity"`
+	QueueSize int  `json:"queue_size"` // events buffered per worker
+
+	// MaxInFlight caps events processed at once across all workers,
+	// including workers the watchdog abandoned (0 = no cap)
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// TestAlertConfig controls the POST /test-alert endpoint
+type TestAlertConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Token     string `json:"token"`      // bearer token required by the endpoint (secret)
+	TokenFile string `json:"token_file"` // read into Token at load
+}
+
+// FeedbackConfig controls the POST /feedback endpoint, where analysts mark
+// alerts as true or false positives
+type FeedbackConfig struct {
+	Enabled   bool           `json:"enabled"`
+	Token     string         `json:"token"`      // bearer token required by the endpoint (secret)
+	TokenFile string         `json:"token_file"` // read into Token at load
+	AutoMute  AutoMuteConfig `json:"auto_mute"`
+}
+
+// PauseConfig controls POST /alerting/pause and /alerting/resume, which hold
+// every alert back (detection keeps running) during an incident or maintenance
+type PauseConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Token      string `json:"token"`       // bearer token required by the endpoints (secret)
+	TokenFile  string `json:"token_file"`  // read into Token at load
+	BufferSize int    `json:"buffer_size"` // alerts kept for resume; later ones are dropped
+}
+
+// SuppressionAPIConfig controls GET/POST /suppressions and DELETE
+// /suppressions/<id>, where analysts add suppression rules without a config
+// change. Added rules are kept in Redis, shared by every replica.
+type SuppressionAPIConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Token           string   `json:"token"`            // bearer token required by the endpoints (secret)
+	TokenFile       string   `json:"token_file"`       // read into Token at load
+	RefreshInterval Duration `json:"refresh_interval"` // how often rules added on other replicas are picked up
+}
+
+// SimulationConfig controls POST /rules/simulate, which replays a draft
+// custom rule against recently buffered events
+type SimulationConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Token        string   `json:"token"`         // bearer token required by the endpoint (secret)
+	TokenFile    string   `json:"token_file"`    // read into Token at load
+	BufferLength int      `json:"buffer_length"` // events buffered per source IP
+	BufferTTL    Duration `json:"buffer_ttl"`    // how long an idle source's buffer is kept; the longest lookback
+	MaxEvents    int      `json:"max_events"`    // newest events replayed per simulation
+	Timeout      Duration `json:"timeout"`       // cap on a simulation's run time
+}
+
+// AutoMuteConfig controls learning from feedback: a source whose alerts are
+// mostly marked false positive stops alerting for a while
+type AutoMuteConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Window         Duration `json:"window"`          // how long alert and verdict counts are kept
+	MinSamples     int      `json:"min_samples"`     // alerts from a source before it can be muted
+	Ratio          float64  `json:"ratio"`           // share of those alerts marked false positive that mutes
+	Duration       Duration `json:"duration"`        // how long a mute lasts
+	AuditRetention Duration `json:"audit_retention"` // how long a source's mute history is kept
+}
+
+// PagerDutyConfig configures the PagerDuty Events API v2 sink
+type PagerDutyConfig struct {
+	Enabled         bool     `json:"enabled"`
+	RoutingKey      string   `json:"routing_key"`      // integration key (secret)
+	RoutingKeyFile  string   `json:"routing_key_file"` // read into RoutingKey at load
+	MinSeverity     string   `json:"min_severity"`     // lowest severity that pages
+	MinRiskScore    float64  `json:"min_risk_score"`   // if set, only alerts scored this high page
+	EventsURL       string   `json:"events_url"`       // override for testing
+	ResolveInterval Duration `json:"resolve_interval"` // how often expired attacks are resolved
+}
+
+// STIXConfig configures the STIX 2.1 sink, which publishes attacking IPs as
+// indicators to a Kafka topic and/or a TAXII 2.1 collection
+type STIXConfig struct {
+	Enabled        bool                     `json:"enabled"`
+	MinSeverity    string                   `json:"min_severity"`     // lowest severity published
+	Topic          string                   `json:"topic"`            // Kafka topic for STIX bundles
+	TAXIIURL       string                   `json:"taxii_url"`        // collection objects endpoint
+	TAXIIToken     string                   `json:"taxii_token"`      // bearer token (secret)
+	TAXIITokenFile string                   `json:"taxii_token_file"` // read into TAXIIToken at load
+	Identity       string                   `json:"identity"`         // name objects are attributed to
+	ValidFor       Duration                 `json:"valid_for"`        // indicator lifetime from the alert
+	Techniques     map[string]STIXTechnique `json:"techniques"`       // threat type -> ATT&CK, over the built-ins
+}
+
+// SQSConfig configures the AWS SQS sink. Credentials come from the default
+// AWS chain (env, shared config, IAM role).
+type SQSConfig struct {
+	Enabled     bool     `json:"enabled"`
+	QueueURL    string   `json:"queue_url"`    // a ".fifo" queue gets group and dedup IDs
+	Region      string   `json:"region"`       // default: from the AWS environment
+	Endpoint    string   `json:"endpoint"`     // override, e.g. LocalStack
+	MinSeverity string   `json:"min_severity"` // lowest severity sent
+	BatchSize   int      `json:"batch_size"`   // messages per SendMessageBatch (1-10)
+	BatchWait   Duration `json:"batch_wait"`   // longest an alert waits for its batch to fill
+	MaxAttempts int      `json:"max_attempts"` // per call, with backoff on throttling
+}
+
+// SNSConfig configures the AWS SNS sink
+type SNSConfig struct {
+	Enabled     bool   `json:"enabled"`
+	TopicARN    string `json:"topic_arn"`
+	Region      string `json:"region"`
+	Endpoint    string `json:"endpoint"`
+	MinSeverity string `json:"min_severity"`
+	MaxAttempts int    `json:"max_attempts"`
+}
+
+// KafkaSinkConfig batches alerts for the alerts topic
+type KafkaSinkConfig struct {
+	BatchSize int      `json:"batch_size"` // alerts per WriteMessages call
+	BatchWait Duration `json:"batch_wait"` // longest an alert waits for its batch to fill
+}
+
+// SinksConfig configures alert sinks in addition to the alerts topic
+type SinksConfig struct {
+	Kafka     KafkaSinkConfig `json:"kafka"`
+	PagerDuty PagerDutyConfig `json:"pagerduty"`
+	SQS       SQSConfig       `json:"sqs"`
+	SNS       SNSConfig       `json:"sns"`
+	STIX      STIXConfig      `json:"stix"`
+
+	Timeout   Duration `json:"timeout"`    // per delivery attempt
+	QueueSize int      `json:"queue_size"` // alerts buffered per sink before it starts dropping
+	// Critical names sinks (kafka, pagerduty, sqs, sns, stix) whose failure to
+	// deliver an alert shuts the analyzer down; the rest are best-effort
+	Critical []string `json:"critical"`
+
+	// Transforms reshape the alert JSON per sink (kafka, pagerduty, sqs, sns)
+	Transforms map[string]*AlertTransform `json:"transforms"`
+
+	// Filters limit which alerts each sink (by name) receives
+	Filters map[string]SinkFilter `json:"filters"`
+
+	// MaxBytes caps the alert size per sink (by name). A larger alert goes
+	// out trimmed, with the full copy kept as Overflow says.
+	MaxBytes map[string]int `json:"max_bytes"`
+	Overflow OverflowConfig `json:"overflow"`
+}
+
+// OverflowConfig is where full copies of alerts too big for a sink are kept
+type OverflowConfig struct {
+	Retention Duration `json:"retention"`  // how long full copies are kept in Redis
+	URL       string   `json:"url"`        // base URL of GET /overflow/ for references (optional)
+	Token     string   `json:"token"`      // bearer token for GET /overflow/ (optional, secret)
+	TokenFile string   `json:"token_file"` // read into Token at load
+}
+
+// SinkFilter picks the alerts one sink receives. An alert must reach
+// min_severity, be in threat_types if set, and not be in exclude_threat_types.
+type SinkFilter struct {
+	MinSeverity        string   `json:"min_severity"`
+	ThreatTypes        []string `json:"threat_types"`         // empty: every threat type
+	ExcludeThreatTypes []string `json:"exclude_threat_types"` // wins over threat_types
+}
+
+// ChainRule is an ordered sequence of threat types that, seen for the same IP
+// or user within Window, raises an alert of type Name
+type ChainRule struct {
+	Name     string   `json:"name"`
+	Sequence []string `json:"sequence"`
+	Window   Duration `json:"window"`
+	Severity string   `json:"severity"`
+}
+
+// AccountManipulationConfig watches per-account action sequences that signal
+// persistence, e.g. an account disabled and quickly re-enabled
+type AccountManipulationConfig struct {
+	Enabled   bool       `json:"enabled"`
+	Window    Duration   `json:"window"`    // max time from a sequence's first to last action
+	Sequences [][]string `json:"sequences"` // ordered actions; completing any one alerts
+
+	watched map[string]bool // every action named in Sequences (lowercased)
+}
+
+// AccountRole classifies accounts by username pattern (first match wins)
+type AccountRole struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"` // exact usernames or globs
+}
+
+// RoleConfusionConfig flags one identity acting as mutually exclusive account
+// types, e.g. logging in as a normal user and a service account in one window
+type RoleConfusionConfig struct {
+	Enabled              bool          `json:"enabled"`
+	Window               Duration      `json:"window"`
+	IdentityField        string        `json:"identity_field"`        // metadata key identifying the source (default: source IP)
+	Roles                []AccountRole `json:"roles"`                 // checked in order; unmatched users have no role
+	ForbiddenTransitions [][]string    `json:"forbidden_transitions"` // [from, to] role pairs
+	MachineActions       []string      `json:"machine_actions"`       // actions only MachineRoles may perform
+	MachineRoles         []string      `json:"machine_roles"`
+}
+
+// KerberoastingConfig flags one host requesting weakly encrypted service
+// tickets for many distinct service accounts
+type KerberoastingConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Threshold       int      `json:"threshold"` // distinct services within Window
+	Window          Duration `json:"window"`
+	EncryptionTypes []string `json:"encryption_types"` // ticket_encryption_type values counted (RC4)
+	Severity        string   `json:"severity"`
+}
+
+// TicketAnomalyConfig flags Kerberos tickets valid for longer than the domain
+// policy allows, the usual mark of a forged (golden/silver) ticket
+type TicketAnomalyConfig struct {
+	Enabled     bool     `json:"enabled"`
+	MaxLifetime Duration `json:"max_lifetime"` // domain "maximum lifetime for user ticket"
+	Severity    string   `json:"severity"`
+}
+
+// DCSyncConfig flags directory replication requested by a host that isn't a
+// domain controller
+type DCSyncConfig struct {
+	Enabled           bool     `json:"enabled"`
+	DomainControllers []string `json:"domain_controllers"` // IPs or CIDRs allowed to replicate
+	AllowedAccounts   []string `json:"allowed_accounts"`   // e.g. directory sync service accounts (globs)
+	Severity          string   `json:"severity"`
+
+	domainControllers []netip.Prefix
+}
+
+// ActiveDirectoryConfig groups the Windows/AD rules. They read the Windows
+// event ID and fields from event metadata and ignore events without one.
+type ActiveDirectoryConfig struct {
+	Enabled       bool                `json:"enabled"`
+	EventIDField  string              `json:"event_id_field"` // metadata key holding the event ID
+	Kerberoasting KerberoastingConfig `json:"kerberoasting"`
+	TicketAnomaly TicketAnomalyConfig `json:"ticket_anomaly"`
+	DCSync        DCSyncConfig        `json:"dcsync"`
+}
+
+// CloudTrailConfig groups the AWS rules. They read the fields the cloudtrail
+// input format sets and ignore events without an event_source.
+type CloudTrailConfig struct {
+	Enabled     bool                  `json:"enabled"`
+	RootUsage   RootUsageConfig       `json:"root_usage"`
+	ConsoleMFA  ConsoleMFAConfig      `json:"console_mfa"`
+	MFADisabled MFADisabledConfig     `json:"mfa_disabled"`
+	IAMChanges  IAMPolicyChangeConfig `json:"iam_changes"`
+}
+
+// RootUsageConfig flags API calls and console logins by the AWS root user
+type RootUsageConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Window   Duration `json:"window"` // one alert per account per window
+	Severity string   `json:"severity"`
+}
+
+// ConsoleMFAConfig flags successful console logins by IAM or root users
+// without MFA
+type ConsoleMFAConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Severity string `json:"severity"`
+}
+
+// MFADisabledConfig flags an MFA device being deactivated or deleted
+type MFADisabledConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Events   []string `json:"events"` // IAM event names that remove MFA
+	Severity string   `json:"severity"`
+}
+
+// IAMPolicyChangeConfig flags one identity making many IAM policy changes
+type IAMPolicyChangeConfig struct {
+	Enabled   bool     `json:"enabled"`
+	Events    []string `json:"events"`    // IAM event names counted (exact or glob)
+	Threshold int      `json:"threshold"` // changes per identity in the window
+	Window    Duration `json:"window"`
+	Severity  string   `json:"severity"`
+}
+
+// ReconConfig flags post-exploitation reconnaissance: a session running the
+// commands of a signature in quick succession
+type ReconConfig struct {
+	Enabled      bool             `json:"enabled"`
+	Window       Duration         `json:"window"`        // how long commands stay in a session's buffer
+	CommandField string           `json:"command_field"` // metadata key holding the command line
+	SessionField string           `json:"session_field"` // metadata key identifying the session (default: user@source IP)
+	MaxCommands  int              `json:"max_commands"`  // watched commands kept per session
+	Severity     string           `json:"severity"`
+	Signatures   []ReconSignature `json:"signatures"`
+}
+
+// TargetedAccountConfig flags failed logins against one high-value username
+// from many source IPs, a distributed attack that per-IP counters miss
+type TargetedAccountConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Users        []string `json:"users"`     // watched usernames or globs (case-insensitive)
+	Threshold    int      `json:"threshold"` // failures per username in the window
+	Window       Duration `json:"window"`
+	MinSourceIPs int      `json:"min_source_ips"` // distinct IPs needed to call it distributed
+	MaxSourceIPs int      `json:"max_source_ips"` // distinct IPs listed on the alert
+	Severity     string   `json:"severity"`
+}
+
+// AuthzProbingConfig flags an identity repeatedly refused access to the same
+// resource, probing for a misconfigured authorization check
+type AuthzProbingConfig struct {
+	Enabled       bool     `json:"enabled"`
+	ResourceField string   `json:"resource_field"` // metadata field naming the resource
+	DeniedResults []string `json:"denied_results"` // event results that mean access denied (case-insensitive)
+	Threshold     int      `json:"threshold"`      // denials per identity and resource in the window
+	Window        Duration `json:"window"`
+	Severity      string   `json:"severity"`
+}
+
+// ConfirmedBreachConfig flags a privilege escalation shortly after a
+// POST_BRUTEFORCE_SUCCESS login by the same user (and session, if known)
+type ConfirmedBreachConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Window       Duration `json:"window"`        // from the login to the escalation
+	SessionField string   `json:"session_field"` // metadata field holding the session ID
+	Severity     string   `json:"severity"`
+}
+
+// RansomwareConfig flags one process touching an abnormal number of distinct
+// files in a short window, the footprint of ransomware encrypting a disk
+type RansomwareConfig struct {
+	Enabled      bool     `json:"enabled"`
+	FileField    string   `json:"file_field"`    // metadata key holding the file path
+	TargetField  string   `json:"target_field"`  // metadata key holding a rename's new path
+	ProcessField string   `json:"process_field"` // metadata key holding the process image
+	Actions      []string `json:"actions"`       // event actions that count as touching a file (case-insensitive)
+	Threshold    int      `json:"threshold"`     // distinct files per process and user in the window
+	Window       Duration `json:"window"`
+
+	// SuspiciousExtensions are ransomware file extensions; renames to one
+	// are counted too, and RenameThreshold of them alerts (0 disables)
+	SuspiciousExtensions []string `json:"suspicious_extensions"`
+	RenameThreshold      int      `json:"rename_threshold"`
+
+	Severity string `json:"severity"`
+}
+
+// MiddlewareConfig is the preprocessing chain every event passes through
+// before detection (see middleware.go)
+type MiddlewareConfig struct {
+	Steps       []MiddlewareStep `json:"steps"`
+	HashKey     string           `json:"hash_key"`      // HMAC key for hash steps
+	HashKeyFile string           `json:"hash_key_file"` // read into HashKey at load
+
+	chain []EventMiddleware
+}
+
+// MiddlewareStep is one step of the chain. Which fields apply depends on Type.
+type MiddlewareStep struct {
+	Type       string            `json:"type"`       // normalize_user, hash, tag, derive or drop
+	Name       string            `json:"name"`       // label in logs and metrics (default type[index])
+	Condition  string            `json:"condition"`  // tag, drop: expression over the event
+	Fields     []string          `json:"fields"`     // hash: "user" or "metadata.<key>"
+	Set        map[string]string `json:"set"`        // tag: metadata to add
+	Field      string            `json:"field"`      // derive: metadata key to write
+	Expression string            `json:"expression"` // derive: value to write
+}
+
+// EnrichmentConfig looks events up in an external HTTP service (an asset or
+// identity API) and copies fields from the response into their metadata
+type EnrichmentConfig struct {
+	Enabled          bool              `json:"enabled"`
+	URL              string            `json:"url"`               // GET URL; {key} is replaced by the looked-up value
+	KeyField         string            `json:"key_field"`         // user, source_ip, source or metadata.<key>
+	Fields           map[string]string `json:"fields"`            // metadata key -> dotted path in the JSON response
+	Token            string            `json:"token"`             // sent as a bearer token
+	TokenFile        string            `json:"token_file"`        // read into Token at load
+	Timeout          Duration          `json:"timeout"`           // per lookup
+	CacheTTL         Duration          `json:"cache_ttl"`         // how long a found result is reused
+	NegativeTTL      Duration          `json:"negative_ttl"`      // how long a 404 is reused
+	CacheSize        int               `json:"cache_size"`        // max cached keys
+	FailureThreshold int               `json:"failure_threshold"` // failures in a row that open the circuit
+	Cooldown         Duration          `json:"cooldown"`          // how long the circuit stays open
+}
+
+// VulnConfig tags alerts with the CVEs their event shows in play, from a
+// local mapping of services, versions and request signatures to CVEs
+type VulnConfig struct {
+	Enabled             bool     `json:"enabled"`
+	File                string   `json:"file"`                  // JSON array of VulnEntry, hot-reloaded
+	ReloadInterval      Duration `json:"reload_interval"`       // how often File is checked for changes
+	ServiceField        string   `json:"service_field"`         // metadata key naming the product
+	VersionField        string   `json:"version_field"`         // metadata key holding its version
+	PathField           string   `json:"path_field"`            // metadata key holding the requested path
+	KnownExploitedBoost int      `json:"known_exploited_boost"` // severity levels added for known-exploited CVEs
+}
+
+// PrivacyConfig pseudonymizes or masks personal data in every event before
+// detection, so it never reaches Redis, alerts or sinks in clear text
+type PrivacyConfig struct {
+	Enabled  bool              `json:"enabled"`
+	Fields   map[string]string `json:"fields"`    // "user" or "metadata.<key>" -> "hash" or "mask"
+	RawLog   string            `json:"raw_log"`   // "redact" (replace the fields' values), "drop" or "keep"
+	Salt     string            `json:"salt"`      // HMAC key for hashed fields
+	SaltFile string            `json:"salt_file"` // read into Salt at load
+}
+
+// SigningConfig signs every alert so consumers can tell it wasn't altered
+// in transit or in the store
+type SigningConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Algorithm string `json:"algorithm"` // "hmac-sha256" or "ed25519"
+	Key       string `json:"key"`       // HMAC secret, or base64 ed25519 seed or private key
+	KeyFile   string `json:"key_file"`  // read into Key at load
+	KeyID     string `json:"key_id"`    // names the key in each signature, for rotation
+
+	key []byte // decoded Key
+}
+
+// SessionHijackConfig flags one session ID used from several networks, a sign
+// the session token was stolen and replayed
+type SessionHijackConfig struct {
+	Enabled      bool     `json:"enabled"`
+	SessionField string   `json:"session_field"` // metadata field holding the session ID
+	MaxNetworks  int      `json:"max_networks"`  // distinct networks a session may use in the window
+	IPv4Prefix   int      `json:"ipv4_prefix"`   // IPs within one prefix count as one network
+	IPv6Prefix   int      `json:"ipv6_prefix"`
+	Window       Duration `json:"window"`
+	Severity     string   `json:"severity"`
+}
+
+// GeoDistributedConfig flags failed logins for one user, or against one
+// host, from many countries: a botnet spraying credentials
+type GeoDistributedConfig struct {
+	Enabled      bool     `json:"enabled"`
+	CountryField string   `json:"country_field"` // metadata field holding the source IP's country (e.g. from enrichment)
+	GroupBy      []string `json:"group_by"`      // "user" and/or "source" (the target host)
+	Threshold    int      `json:"threshold"`     // distinct countries in the window
+	Window       Duration `json:"window"`
+	Severity     string   `json:"severity"`
+}
+
+// GeofenceConfig flags successful logins from outside the countries an
+// organization allows, a common compliance control
+type GeofenceConfig struct {
+	Enabled      bool             `json:"enabled"`
+	CountryField string           `json:"country_field"` // metadata field holding the source IP's country
+	Allow        []string         `json:"allow"`         // ISO country codes logins may come from (empty: any not denied)
+	Deny         []string         `json:"deny"`          // country codes logins may never come from
+	Groups       []GeofencePolicy `json:"groups"`        // checked in order; the first one the user is in applies
+	Unresolved   string           `json:"unresolved"`    // "allow" or "flag" logins with no country
+	Window       Duration         `json:"window"`        // one alert per user and country per window
+	Severity     string           `json:"severity"`
+}
+
+// GeofencePolicy replaces the global allow and deny lists for the members
+// of a user group
+type GeofencePolicy struct {
+	UserGroup string   `json:"user_group"` // a name from DetectorConfig.UserGroups
+	Allow     []string `json:"allow"`
+	Deny      []string `json:"deny"`
+}
+
+// StaleHostConfig flags logins and access to hosts that are decommissioned
+// or haven't been seen for a long time, where no legitimate traffic goes
+type StaleHostConfig struct {
+	Enabled        bool     `json:"enabled"`
+	TargetField    string   `json:"target_field"`   // metadata key naming the target host (default: event source)
+	Decommissioned []string `json:"decommissioned"` // hostnames or globs, IPs or CIDRs
+	EventTypes     []string `json:"event_types"`    // event types that count as access
+	Inactivity     Duration `json:"inactivity"`     // silence after which a host is stale
+	Retention      Duration `json:"retention"`      // how long a silent host is remembered
+	Window         Duration `json:"window"`         // one alert per decommissioned host per window
+	Severity       string   `json:"severity"`
+
+	decommissioned []netip.Prefix
+	hostPatterns   []string
+}
+
+// ServiceAccountConfig flags interactive logins by service accounts, which
+// should only ever authenticate non-interactively
+type ServiceAccountConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Accounts       []string `json:"accounts"`         // service account names or globs (case-insensitive)
+	UserGroups     []string `json:"user_groups"`      // names from DetectorConfig.UserGroups whose members are service accounts
+	LogonTypeField string   `json:"logon_type_field"` // metadata key holding the logon type
+	Interactive    []string `json:"interactive"`      // logon types that are interactive (case-insensitive)
+	Window         Duration `json:"window"`           // one alert per account and source IP per window
+	Severity       string   `json:"severity"`
+}
+
+// MFAFatigueConfig flags MFA push bombing: a burst of MFA prompts for one
+// user, escalated when denials are followed by an approval
+type MFAFatigueConfig struct {
+	Enabled           bool     `json:"enabled"`
+	EventTypes        []string `json:"event_types"`      // event types of MFA prompts (case-insensitive)
+	DeniedResults     []string `json:"denied_results"`   // results that mean the user denied the prompt
+	ApprovedResults   []string `json:"approved_results"` // results that mean the user approved it
+	Threshold         int      `json:"threshold"`        // prompts per user in the window
+	Denials           int      `json:"denials"`          // denials in the window that make an approval suspect
+	Window            Duration `json:"window"`
+	Severity          string   `json:"severity"`
+	EscalatedSeverity string   `json:"escalated_severity"` // for an approval after the denials
+}
+
+// AuthVolumeConfig flags a user logging in far more often than usual, as
+// when a stolen credential is used by a script
+type AuthVolumeConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Factor         float64  `json:"factor"`          // multiple of the user's baseline that's a spike
+	MinCount       int      `json:"min_count"`       // logins in an hour below which nothing fires
+	Smoothing      float64  `json:"smoothing"`       // EWMA weight of the latest active hour
+	LearningPeriod Duration `json:"learning_period"` // how long a new user is only learned
+	Retention      Duration `json:"retention"`       // how long an inactive user's baseline is kept
+	Severity       string   `json:"severity"`
+}
+
+// CIDRAggregationConfig replaces per-IP alerts with one alert per network
+// once enough distinct IPs in it raise the same threat type
+type CIDRAggregationConfig struct {
+	Enabled     bool     `json:"enabled"`
+	IPv4Prefix  int      `json:"ipv4_prefix"`
+	IPv6Prefix  int      `json:"ipv6_prefix"`
+	Threshold   int      `json:"threshold"` // distinct IPs in one network
+	Window      Duration `json:"window"`
+	ThreatTypes []string `json:"threat_types"` // empty: every rule with a source IP
+}
+
+// RequestFloodConfig flags one source IP sending events faster than a rate,
+// whatever their type or result, as a DoS or an aggressive scraper does
+type RequestFloodConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Rate       float64  `json:"rate"`        // events per second above which a source is flooding
+	Window     Duration `json:"window"`      // fixed window the rate is measured over
+	EventTypes []string `json:"event_types"` // event types counted (empty = all)
+	Cooldown   Duration `json:"cooldown"`    // one alert per source IP per cooldown
+	Severity   string   `json:"severity"`
+}
+
+// ProtocolDowngradeConfig flags authentication that negotiates a weak or
+// deprecated protocol or cipher, which attackers force to enable credential
+// attacks (NTLMv1 relay, RC4 Kerberos cracking)
+type ProtocolDowngradeConfig struct {
+	Enabled    bool                `json:"enabled"`
+	Weak       map[string][]string `json:"weak"`        // metadata field -> weak values or globs (case-insensitive)
+	EventTypes []string            `json:"event_types"` // event types checked (empty = all)
+	Window     Duration            `json:"window"`      // each source IP and protocol alerts once per window
+	Severity   string              `json:"severity"`
+}
+
+// ProcessLineageConfig flags process creation events whose parent -> child
+// lineage matches a signature (e.g. an Office app spawning a shell)
+type ProcessLineageConfig struct {
+	Enabled      bool               `json:"enabled"`
+	ParentField  string             `json:"parent_field"`  // metadata key holding the parent image
+	ImageField   string             `json:"image_field"`   // metadata key holding the new process image
+	CommandField string             `json:"command_field"` // metadata key holding its command line
+	Severity     string             `json:"severity"`
+	Signatures   []LineageSignature `json:"signatures"`
+}
+
+// RemediationConfig controls the consumer of remediation results, which
+// confirms blocks made by an external responder so blocked IPs stop alerting
+type RemediationConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Topic         string   `json:"topic"`
+	ConsumerGroup string   `json:"consumer_group"`
+	BlockTTL      Duration `json:"block_ttl"` // used when a result has no expires_at
+}
+
+// CorrelationConfig controls multi-alert kill-chain correlation
+type CorrelationConfig struct {
+	Enabled bool              `json:"enabled"`
+	Chains  []ChainRule       `json:"chains"`
+	Scoring RiskScoringConfig `json:"scoring"`
+
+	maxWindow time.Duration // buffer TTL: the longest chain window
+}
+
+// RiskScoringConfig sums the weights of the alerts raised for a source IP
+// or user within a window, and escalates once the sum reaches Threshold
+type RiskScoringConfig struct {
+	Enabled         bool               `json:"enabled"`
+	Weights         map[string]float64 `json:"weights"`          // threat type -> weight
+	SeverityWeights map[string]float64 `json:"severity_weights"` // for threat types not in weights
+	Threshold       float64            `json:"threshold"`
+	Window          Duration           `json:"window"`
+	Name            string             `json:"name"` // threat type of the escalation alert
+	Severity        string             `json:"severity"`
+}
+
+// SuppressionConfig silences known-benign alert patterns, for example while
+// an investigation is under way
+type SuppressionConfig struct {
+	Rules []SuppressionRule      `json:"rules"`
+	Log   bool                   `json:"log"` // log every suppressed alert with the rule that matched
+	Audit SuppressionAuditConfig `json:"audit"`
+}
+
+// SuppressionAuditConfig logs a structured entry for each alert that was
+// suppressed or dropped, with the reason code, rule and state key
+type SuppressionAuditConfig struct {
+	Enabled bool     `json:"enabled"`
+	Reasons []string `json:"reasons"` // reason codes to audit (empty: all)
+}
+
+// CooldownConfig limits how often a rule re-alerts for the same source IP
+// (or user, for events without one)
+type CooldownConfig struct {
+	Default Duration            `json:"default"` // every rule (0 = alert on every detection)
+	Rules   map[string]Duration `json:"rules"`   // threat type -> cooldown, overriding Default
+}
+
+// FuzzyDedupConfig collapses near-duplicate alerts: within a window, only
+// the first alert of a group whose fields match and whose details have the
+// same shape goes out
+type FuzzyDedupConfig struct {
+	Enabled bool     `json:"enabled"`
+	Window  Duration `json:"window"`
+	Fields  []string `json:"fields"` // what groups alerts: threat_type, source_ip, user, details
+}
+
+// LearningConfig runs a training period that samples the counter rules'
+// per-source counts and then reports the thresholds that would alert on
+// the given percentile of sources
+type LearningConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Duration    Duration `json:"duration"`     // training period
+	Interval    Duration `json:"interval"`     // how often the live counters are sampled
+	Percentile  float64  `json:"percentile"`   // share of sources (0-100) a threshold should stay above
+	Rules       []string `json:"rules"`        // counter rules to learn (default: all)
+	ObserveOnly bool     `json:"observe_only"` // record alerts as observations while training
+	ReportFile  string   `json:"report_file"`  // where the report is written (optional)
+	ReportTopic string   `json:"report_topic"` // Kafka topic the report is published to (optional)
+}
+
+// LifecycleConfig sends a rule's first detection as a LOW "emerging" alert
+// and escalates the same alert to its full severity only if the attack
+// persists past a grace period
+type LifecycleConfig struct {
+	Enabled  bool                       `json:"enabled"`
+	Interval Duration                   `json:"interval"` // how often grace periods are checked
+	Rules    map[string]LifecyclePolicy `json:"rules"`    // threat type -> policy
+}
+
+// LifecyclePolicy is one rule's grace period and escalation policy
+type LifecyclePolicy struct {
+	Grace            Duration `json:"grace"`
+	EmergingSeverity string   `json:"emerging_severity"` // severity while in grace (default LOW)
+	Escalation       string   `json:"escalation"`        // "active" (default) or "repeat"
+	Repeats          int      `json:"repeats"`           // re-detections needed for "repeat" (default 1)
+}
+
+// SuppressionRule matches alerts on any combination of its fields; empty
+// fields match anything. It stops matching once Expires has passed.
+type SuppressionRule struct {
+	ID         string            `json:"id"`
+	ThreatType string            `json:"threat_type,omitempty"` // exact or glob
+	SourceIP   string            `json:"source_ip,omitempty"`   // address or CIDR
+	User       string            `json:"user,omitempty"`        // exact or glob, case-insensitive
+	Metadata   map[string]string `json:"metadata,omitempty"`    // alert or event metadata key -> exact or glob
+	Reason     string            `json:"reason,omitempty"`
+	Expires    *time.Time        `json:"expires,omitempty"`
+	Created    *time.Time        `json:"created,omitempty"` // set on rules added over the API
+
+	network netip.Prefix // parsed SourceIP
+}
+
+// UserAllowlistConfig exempts users from rules. Keys are threat types (or "*"
+// for every rule); values are exact usernames or path.Match globs.
+type UserAllowlistConfig struct {
+	Rules          map[string][]string `json:"rules"`
+	File           string              `json:"file"`            // same shape as Rules, hot-reloaded
+	ReloadInterval Duration            `json:"reload_interval"` // how often File is checked for changes
+}
+
+// AssetConfig enriches alerts with the criticality of the targeted asset and
+// shifts severity by the tier's adjustment
+type AssetConfig struct {
+	Enabled        bool           `json:"enabled"`
+	TargetField    string         `json:"target_field"`    // metadata key naming the target (default: event source)
+	Tiers          map[string]int `json:"tiers"`           // tier -> severity levels to add (may be negative)
+	DefaultTier    string         `json:"default_tier"`    // tier of assets not in the inventory
+	Assets         []AssetEntry   `json:"assets"`          // checked before File entries
+	File           string         `json:"file"`            // JSON or CSV inventory export, hot-reloaded
+	ReloadInterval Duration       `json:"reload_interval"` // how often File is checked for changes
+}
+
+// SourceZoneConfig tags alerts with whether the source IP is inside the
+// organization's networks, and optionally shifts severity by zone
+type SourceZoneConfig struct {
+	Enabled       bool           `json:"enabled"`
+	InternalCIDRs []string       `json:"internal_cidrs"` // everything else is external
+	Severity      map[string]int `json:"severity"`       // "internal"/"external" -> severity levels to add
+
+	internal []netip.Prefix
+}
+
+// BaselineConfig counts a sample of benign events per hour into Redis, as
+// the baselines volume and off-hours analytics compare against
+type BaselineConfig struct {
+	Enabled        bool     `json:"enabled"`
+	SampleRate     float64  `json:"sample_rate"`     // fraction of benign events counted (0-1]
+	Aggregates     []string `json:"aggregates"`      // fields counted by, e.g. "user" or "user+event_type"
+	Retention      Duration `json:"retention"`       // how long each hourly count is kept
+	ExcludeResults []string `json:"exclude_results"` // results that make an event threat-relevant, never counted
+}
+
+// EnvironmentConfig tags alerts with the environment (prod, staging, dev)
+// their event came from, and applies that environment's policy
+type EnvironmentConfig struct {
+	Enabled  bool                         `json:"enabled"`
+	Field    string                       `json:"field"`   // metadata field naming the environment, checked first
+	Mapping  []EnvironmentMapping         `json:"mapping"` // first match on event.Source wins
+	Default  string                       `json:"default"` // when neither names one
+	Policies map[string]EnvironmentPolicy `json:"policies"`
+}
+
+// EnvironmentMapping assigns events from matching sources to an environment
+type EnvironmentMapping struct {
+	Environment string   `json:"environment"`
+	Sources     []string `json:"sources"` // event source (host) globs
+}
+
+// EnvironmentPolicy is how alerts from one environment are handled
+type EnvironmentPolicy struct {
+	Suppress    bool     `json:"suppress"`     // raise no alerts
+	MaxSeverity string   `json:"max_severity"` // cap on alert severity
+	Sinks       []string `json:"sinks"`        // deliver only to these sinks (empty: all)
+}
+
+// SeverityOverride sets an alert's severity when every condition it lists
+// matches. Empty conditions match anything.
+type SeverityOverride struct {
+	Name        string            `json:"name"`
+	ThreatTypes []string          `json:"threat_types"`
+	Users       []string          `json:"users"`       // exact usernames or globs
+	UserGroups  []string          `json:"user_groups"` // names from DetectorConfig.UserGroups
+	Sources     []string          `json:"sources"`     // event source (host) globs
+	SourceIPs   []string          `json:"source_ips"`  // IPs or CIDRs
+	Metadata    map[string]string `json:"metadata"`    // metadata key -> value glob
+	Severity    string            `json:"severity"`
+
+	sourceIPs []netip.Prefix
+}
+
+// RawEventsConfig bounds the raw log lines attached to alerts
+type RawEventsConfig struct {
+	MaxEvents     int `json:"max_events"`      // lines kept per alert, newest first (0 = none)
+	MaxLineBytes  int `json:"max_line_bytes"`  // longer lines are truncated with a marker
+	MaxAlertBytes int `json:"max_alert_bytes"` // serialized alert limit (0 = unlimited)
+
+	// Storage is how lines are kept in Redis: "list" (plain), "gzip"
+	// (compressed per line) or "dedup" (each distinct line stored once)
+	Storage string `json:"storage"`
+}
+
+// RedisStreamConfig configures the Redis Stream input
+type RedisStreamConfig struct {
+	Stream    string   `json:"stream"`
+	Group     string   `json:"group"`
+	Consumer  string   `json:"consumer"`   // unique per replica; defaults to the hostname
+	Field     string   `json:"field"`      // entry field holding the event JSON
+	StartID   string   `json:"start_id"`   // where a new group starts: "$" (new entries) or "0" (everything)
+	BatchSize int      `json:"batch_size"` // entries per XREADGROUP
+	Block     Duration `json:"block"`      // how long XREADGROUP waits for new entries
+}
+
+// InputConfig selects where security events are consumed from
+type InputConfig struct {
+	Type        string            `json:"type"`   // "kafka", "redis_stream" or "stdin"
+	Format      string            `json:"format"` // "json", "windows_xml", "cloudtrail", "access_log" or "auto"
+	RedisStream RedisStreamConfig `json:"redis_stream"`
+	AccessLog   AccessLogConfig   `json:"access_log"`
+}
+
+// AccessLogConfig controls parsing of NGINX/Apache access log lines
+type AccessLogConfig struct {
+	ForwardedFor   bool     `json:"forwarded_for"`   // a quoted X-Forwarded-For field follows the user agent
+	TrustedProxies []string `json:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-For is believed
+
+	trusted []netip.Prefix
+}
+
+// LogConfig controls debug logging. Error logs are never sampled.
+type LogConfig struct {
+	Debug        bool `json:"debug"`          // log per-event and suppression details
+	SampleRate   int  `json:"sample_rate"`    // write 1 in N debug lines (1 = all)
+	MaxPerSecond int  `json:"max_per_second"` // cap on debug lines per second (0 = no cap)
+}
+
+// DetectorConfig holds all runtime configuration for the threat detector
+type DetectorConfig struct {
+	KafkaBrokers      []string `json:"kafka_brokers"`
+	RedisAddr         string   `json:"redis_addr"`
+	RedisPassword     string   `json:"redis_password"`      // prefer RedisPasswordFile or SBLA_REDIS_PASSWORD_FILE
+	RedisPasswordFile string   `json:"redis_password_file"` // read into RedisPassword at load
+	NumWorkers        int      `json:"num_workers"`
+	EventsTopic       string   `json:"events_topic"`
+	AlertsTopic       string   `json:"alerts_topic"`
+	ConsumerGroup     string   `json:"consumer_group"`
+	HTTPAddr          string   `json:"http_addr"` // operational HTTP API (empty disables it)
+
+	// ShutdownTimeout bounds graceful shutdown. Past it the process logs what
+	// it's stuck on and exits with status 3.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	// RedisPool applies to the primary and the standby Redis
+	RedisPool RedisPoolConfig `json:"redis_pool"`
+
+	// Standby is an optional second Redis for detection state
+	Standby StandbyConfig `json:"standby"`
+
+	// Dedup holds the once-per-window alert keys
+	Dedup DedupConfig `json:"dedup"`
+
+	TestAlert TestAlertConfig `json:"test_alert"`
+	Feedback  FeedbackConfig  `json:"feedback"`
+	Pause     PauseConfig     `json:"pause"`
+
+	SuppressionAPI SuppressionAPIConfig `json:"suppression_api"`
+
+	Simulation SimulationConfig `json:"simulation"`
+
+	AlertStore AlertStoreConfig `json:"alert_store"`
+	UI         UIConfig         `json:"ui"`
+
+	// Input chooses the event source; EventsTopic/ConsumerGroup apply to Kafka
+	Input InputConfig `json:"input"`
+
+	// StartOffset is "earliest" or "latest": where a consumer group with no
+	// committed offset starts reading. Ignored once the group has committed.
+	StartOffset string `json:"start_offset"`
+
+	// Compression is the codec for messages the analyzer produces (alerts,
+	// dead letters, observations, snapshots): none, gzip, snappy, lz4 or zstd.
+	// Compressed input is decoded whatever the codec.
+	Compression string `json:"compression"`
+
+	// DeadLetterTopic receives messages that can't be parsed (disabled if empty)
+	DeadLetterTopic string `json:"dead_letter_topic"`
+
+	ClockSkew ClockSkewConfig `json:"clock_skew"`
+
+	Log       LogConfig       `json:"log"`
+	RawEvents RawEventsConfig `json:"raw_events"`
+
+	Thresholds   Thresholds `json:"thresholds"`
+	AllowlistIPs []string   `json:"allowlist_ips"`
+
+	AdaptiveThresholds AdaptiveThresholdConfig `json:"adaptive_thresholds"`
+
+	// WindowMode is how counter rules age their counts: "sliding" (every
+	// event restarts the window) or "fixed" (the window starts at the first
+	// event). WindowModes overrides it per threat type.
+	WindowMode  string            `json:"window_mode"`
+	WindowModes map[string]string `json:"window_modes"`
+
+	// DistinctRawLogs lists counter rules that count each distinct raw log
+	// line once per window, so a source's retry loop counts as one attempt
+	DistinctRawLogs []string `json:"distinct_raw_logs"`
+
+	StateRetention StateRetentionConfig `json:"state_retention"`
+
+	UserAllowlist UserAllowlistConfig `json:"user_allowlist"`
+
+	Suppression SuppressionConfig `json:"suppression"`
+
+	Cooldown CooldownConfig `json:"cooldown"`
+
+	Lifecycle LifecycleConfig `json:"lifecycle"`
+
+	FuzzyDedup FuzzyDedupConfig `json:"fuzzy_dedup"`
+
+	Learning LearningConfig `json:"learning"`
+
+	Assets AssetConfig `json:"assets"`
+
+	SourceZone SourceZoneConfig `json:"source_zone"`
+
+	Environment EnvironmentConfig `json:"environment"`
+
+	Baseline BaselineConfig `json:"baseline"`
+
+	Anonymizer AnonymizerConfig `json:"anonymizer"`
+	Snapshot   SnapshotConfig   `json:"snapshot"`
+	IPSeen     IPSeenConfig     `json:"ip_seen"`
+	IPHistory  IPHistoryConfig  `json:"ip_history"`
+	Timeline   TimelineConfig   `json:"timeline"`
+	Sinks      SinksConfig      `json:"sinks"`
+	Watchdog   WatchdogConfig   `json:"watchdog"`
+	Poison     PoisonConfig     `json:"poison"`
+	Scan       ScanConfig       `json:"scan"`
+	Dispatch   DispatchConfig   `json:"dispatch"`
+
+	AccountManipulation AccountManipulationConfig `json:"account_manipulation"`
+
+	RoleConfusion RoleConfusionConfig `json:"role_confusion"`
+
+	ActiveDirectory ActiveDirectoryConfig `json:"active_directory"`
+
+	CloudTrail CloudTrailConfig `json:"cloudtrail"`
+
+	Recon ReconConfig `json:"recon"`
+
+	ProcessLineage ProcessLineageConfig `json:"process_lineage"`
+
+	TargetedAccount TargetedAccountConfig `json:"targeted_account"`
+
+	AuthzProbing AuthzProbingConfig `json:"authz_probing"`
+
+	Ransomware RansomwareConfig `json:"ransomware"`
+
+	ConfirmedBreach ConfirmedBreachConfig `json:"confirmed_breach"`
+
+	SessionHijack SessionHijackConfig `json:"session_hijack"`
+
+	GeoDistributed GeoDistributedConfig `json:"geo_distributed"`
+
+	Geofence GeofenceConfig `json:"geofence"`
+
+	AuthVolume AuthVolumeConfig `json:"auth_volume"`
+
+	StaleHosts StaleHostConfig `json:"stale_hosts"`
+
+	ServiceAccounts ServiceAccountConfig `json:"service_accounts"`
+
+	MFAFatigue MFAFatigueConfig `json:"mfa_fatigue"`
+
+	RequestFlood RequestFloodConfig `json:"request_flood"`
+
+	ProtocolDowngrade ProtocolDowngradeConfig `json:"protocol_downgrade"`
+
+	// Middleware preprocesses events (normalization, redaction, tagging)
+	// before any rule sees them
+	Middleware MiddlewareConfig `json:"middleware"`
+
+	Enrichment EnrichmentConfig `json:"enrichment"`
+
+	Vulns VulnConfig `json:"vulns"`
+
+	Privacy PrivacyConfig `json:"privacy"`
+
+	Signing SigningConfig `json:"signing"`
+
+	// Evidence maps a threat type to the evidence needed before it alerts.
+	// Detections short of it go to ObservationsTopic (dropped if empty).
+	Evidence          map[string]EvidenceRequirement `json:"evidence"`
+	ObservationsTopic string                         `json:"observations_topic"`
+
+	// Observations are detections recorded without alerting: those short of
+	// their evidence and the threat types routed here
+	Observations ObservationConfig `json:"observations"`
+
+	// CustomRules are expression-based detections evaluated after the built-in rules
+	CustomRules []CustomRule `json:"custom_rules"`
+
+	Correlation CorrelationConfig `json:"correlation"`
+	Remediation RemediationConfig `json:"remediation"`
+
+	// CIDRAggregation folds alerts from many IPs in one network into one
+	CIDRAggregation CIDRAggregationConfig `json:"cidr_aggregation"`
+
+	// UserGroups names sets of user patterns for use in SeverityOverrides
+	// and geofence policies
+	UserGroups map[string][]string `json:"user_groups"`
+
+	// SeverityOverrides are checked in order; the first match sets the severity
+	SeverityOverrides []SeverityOverride `json:"severity_overrides"`
+
+	// AlertTemplates maps a threat type to a text/template for the alert's
+	// Details (see AlertTemplateData). Unlisted types use the built-in message.
+	AlertTemplates map[string]string `json:"alert_templates"`
+
+	// Tenants maps a tenant ID (event.Metadata["tenant_id"]) to its overrides
+	Tenants map[string]*TenantConfig `json:"tenants"`
+
+	allowlist      []netip.Prefix
+	alertTemplates map[string]*template.Template
+	evidenceWindow time.Duration // signals buffer TTL: longest MinSignals window (0 = unused)
+}
+
+// hostname returns the machine's hostname, or "" if it can't be determined
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// DefaultConfig returns the built-in configuration
+func DefaultConfig() *DetectorConfig {
+	return &DetectorConfig{
+		KafkaBrokers:  []string{"localhost:9092"},
+		RedisAddr:     "localhost:6379",
+		NumWorkers:    5,
+		EventsTopic:   "security-events",
+		AlertsTopic:   "security-alerts",
+		ConsumerGroup: "threat-detector-group",
+		HTTPAddr:      ":8080",
+		StartOffset:   "latest",
+		Compression:   "none",
+		Input: InputConfig{
+			Type:   "kafka",
+			Format: formatJSON,
+			RedisStream: RedisStreamConfig{
+				Stream:    "security-events",
+				Group:     "threat-detector-group",
+				Consumer:  hostname(),
+				Field:     "event",
+				StartID:   "$",
+				BatchSize: 100,
+				Block:     Duration{5 * time.Second},
+			},
+		},
+		// Inside Kubernetes' default 30s terminationGracePeriodSeconds
+		ShutdownTimeout: Duration{25 * time.Second},
+		Log: LogConfig{
+			SampleRate:   1,
+			MaxPerSecond: 100,
+		},
+		RawEvents: RawEventsConfig{
+			MaxEvents:     10,
+			MaxLineBytes:  4096,
+			MaxAlertBytes: 900_000, // under Kafka's 1 MB default message.max.bytes
+			Storage:       "list",
+		},
+		Thresholds: Thresholds{
+			BruteForce:     5,
+			SuspiciousUser: 3,
+			PasswordChange: 3,
+			ExfilBytes:     100 << 20, // 100 MiB
+		},
+		AdaptiveThresholds: AdaptiveThresholdConfig{
+			Interval:  Duration{time.Minute},
+			Scaling:   scaleSqrt,
+			MinFactor: 1,
+			MaxFactor: 4,
+		},
+		WindowMode: windowSliding,
+		UserAllowlist: UserAllowlistConfig{
+			ReloadInterval: Duration{30 * time.Second},
+		},
+		Assets: AssetConfig{
+			Tiers: map[string]int{
+				"crown_jewel": 1,
+				"production":  0,
+				"development": -1,
+			},
+			DefaultTier:    "production",
+			ReloadInterval: Duration{5 * time.Minute},
+		},
+		SourceZone: SourceZoneConfig{
+			Enabled: true,
+			InternalCIDRs: []string{
+				"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", // RFC 1918
+				"127.0.0.0/8", "169.254.0.0/16", // loopback, link-local
+				"fc00::/7", "fe80::/10", "::1/128", // ULA, link-local, loopback
+			},
+		},
+		Environment: EnvironmentConfig{
+			Field: "environment",
+		},
+		Baseline: BaselineConfig{
+			SampleRate:     0.1,
+			Aggregates:     []string{"user", "source"},
+			Retention:      Duration{7 * 24 * time.Hour},
+			ExcludeResults: []string{"failed", "failure", "denied", "access_denied", "forbidden", "error"},
+		},
+		Anonymizer: AnonymizerConfig{
+			TorListURL:      "https://check.torproject.org/torbulkexitlist",
+			RefreshInterval: Duration{time.Hour},
+		},
+		Snapshot: SnapshotConfig{
+			Topic:    "detector-state-snapshots",
+			Interval: Duration{time.Minute},
+		},
+		IPSeen: IPSeenConfig{
+			Enabled: true,
+			TTL:     Duration{30 * 24 * time.Hour},
+		},
+		IPHistory: IPHistoryConfig{
+			Length:      20,
+			TTL:         Duration{time.Hour},
+			MinSeverity: "HIGH",
+		},
+		Timeline: TimelineConfig{
+			Length:  50,
+			TTL:     Duration{time.Hour},
+			Entries: map[string]int{"CRITICAL": 25, "HIGH": 15, "MEDIUM": 5},
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:  true,
+			Timeout:  Duration{2 * time.Minute},
+			Interval: Duration{10 * time.Second},
+		},
+		Poison: PoisonConfig{
+			MaxAttempts: 3,
+			TTL:         Duration{24 * time.Hour},
+		},
+		Scan: ScanConfig{
+			Interval: Duration{time.Minute},
+		},
+		Lifecycle: LifecycleConfig{
+			Interval: Duration{30 * time.Second},
+		},
+		FuzzyDedup: FuzzyDedupConfig{
+			Window: Duration{10 * time.Minute},
+			Fields: []string{"threat_type", "source_ip", "user", "details"},
+		},
+		Learning: LearningConfig{
+			Duration:    Duration{7 * 24 * time.Hour},
+			Interval:    Duration{time.Minute},
+			Percentile:  99,
+			ObserveOnly: true,
+		},
+		Dispatch: DispatchConfig{
+			QueueSize: 64,
+		},
+		RedisPool: RedisPoolConfig{
+			DialTimeout:  Duration{5 * time.Second},
+			ReadTimeout:  Duration{3 * time.Second},
+			WriteTimeout: Duration{3 * time.Second},
+			PoolTimeout:  Duration{4 * time.Second},
+		},
+		Standby: StandbyConfig{
+			FailoverAfter: 3,
+			QueueSize:     10000,
+			Timeout:       Duration{time.Second},
+		},
+		Dedup: DedupConfig{
+			Backend:    dedupRedis,
+			MaxEntries: 100000,
+		},
+		AlertStore: AlertStoreConfig{
+			Backend:   "redis",
+			Retention: Duration{24 * time.Hour},
+			Key:       "alerts",
+			MaxAlerts: 10000,
+		},
+		Observations: ObservationConfig{
+			Retention: Duration{7 * 24 * time.Hour},
+			Key:       "observations",
+		},
+		ClockSkew: ClockSkewConfig{
+			Policy:    "clamp",
+			Tolerance: Duration{time.Minute},
+		},
+		Pause: PauseConfig{
+			BufferSize: 10000,
+		},
+		SuppressionAPI: SuppressionAPIConfig{
+			RefreshInterval: Duration{30 * time.Second},
+		},
+		Simulation: SimulationConfig{
+			BufferLength: 200,
+			BufferTTL:    Duration{time.Hour},
+			MaxEvents:    50000,
+			Timeout:      Duration{10 * time.Second},
+		},
+		Feedback: FeedbackConfig{
+			AutoMute: AutoMuteConfig{
+				Window:         Duration{7 * 24 * time.Hour},
+				MinSamples:     10,
+				Ratio:          0.8,
+				Duration:       Duration{24 * time.Hour},
+				AuditRetention: Duration{90 * 24 * time.Hour},
+			},
+		},
+		Correlation: CorrelationConfig{
+			Enabled: true,
+			Chains: []ChainRule{
+				{
+					Name:     "BREACH_CHAIN",
+					Sequence: []string{"POST_BRUTEFORCE_SUCCESS", "DATA_EXFILTRATION"},
+					Window:   Duration{30 * time.Minute},
+					Severity: "CRITICAL",
+				},
+			},
+			Scoring: RiskScoringConfig{
+				SeverityWeights: map[string]float64{"LOW": 1, "MEDIUM": 3, "HIGH": 5, "CRITICAL": 10},
+				Threshold:       10,
+				Window:          Duration{time.Hour},
+				Name:            "RISK_ESCALATION",
+				Severity:        "CRITICAL",
+			},
+		},
+		AccountManipulation: AccountManipulationConfig{
+			Enabled: true,
+			Window:  Duration{10 * time.Minute},
+			Sequences: [][]string{
+				{"account_disabled", "account_enabled"},
+				{"account_created", "group_added"},
+			},
+		},
+		RoleConfusion: RoleConfusionConfig{
+			Window: Duration{time.Hour},
+			Roles: []AccountRole{
+				{Name: "privileged", Users: []string{"root", "admin", "administrator"}},
+				{Name: "service", Users: []string{"svc-*", "svc_*"}},
+				{Name: "human", Users: []string{"*"}},
+			},
+			ForbiddenTransitions: [][]string{
+				{"human", "service"},
+				{"service", "human"},
+			},
+			MachineActions: []string{"api_token_create", "deploy", "service_start"},
+			MachineRoles:   []string{"service"},
+		},
+		ActiveDirectory: ActiveDirectoryConfig{
+			EventIDField: "event_id",
+			Kerberoasting: KerberoastingConfig{
+				Enabled:         true,
+				Threshold:       10,
+				Window:          Duration{10 * time.Minute},
+				EncryptionTypes: []string{"0x17", "0x18"}, // RC4-HMAC, RC4-HMAC-EXP
+				Severity:        "HIGH",
+			},
+			TicketAnomaly: TicketAnomalyConfig{
+				Enabled:     true,
+				MaxLifetime: Duration{10 * time.Hour}, // AD default
+				Severity:    "CRITICAL",
+			},
+			DCSync: DCSyncConfig{
+				Enabled:  true,
+				Severity: "CRITICAL",
+			},
+		},
+		CloudTrail: CloudTrailConfig{
+			RootUsage: RootUsageConfig{
+				Enabled:  true,
+				Window:   Duration{time.Hour},
+				Severity: "CRITICAL",
+			},
+			ConsoleMFA: ConsoleMFAConfig{
+				Enabled:  true,
+				Severity: "HIGH",
+			},
+			MFADisabled: MFADisabledConfig{
+				Enabled:  true,
+				Events:   []string{"DeactivateMFADevice", "DeleteVirtualMFADevice"},
+				Severity: "HIGH",
+			},
+			IAMChanges: IAMPolicyChangeConfig{
+				Enabled: true,
+				Events: []string{
+					"Put*Policy", "Attach*Policy", "Detach*Policy", "Delete*Policy",
+					"CreatePolicy", "CreatePolicyVersion", "SetDefaultPolicyVersion", "UpdateAssumeRolePolicy",
+				},
+				Threshold: 10,
+				Window:    Duration{10 * time.Minute},
+				Severity:  "HIGH",
+			},
+		},
+		Recon: ReconConfig{
+			Enabled:      true,
+			Window:       Duration{5 * time.Minute},
+			CommandField: "command",
+			SessionField: "session_id",
+			MaxCommands:  50,
+			Severity:     "HIGH",
+			Signatures: []ReconSignature{
+				{
+					Name:       "linux_host_recon",
+					Commands:   []string{"whoami", "id", "uname", "hostname", "cat /etc/passwd", "netstat", "ss", "ifconfig", "ip a*"},
+					MinMatches: 4,
+				},
+				{
+					Name:       "windows_domain_recon",
+					Commands:   []string{"whoami", "net", "nltest", "systeminfo", "ipconfig"},
+					MinMatches: 3,
+				},
+			},
+		},
+		TargetedAccount: TargetedAccountConfig{
+			Enabled:      true,
+			Users:        []string{"administrator", "admin", "root"},
+			Threshold:    20,
+			Window:       Duration{10 * time.Minute},
+			MinSourceIPs: 3,
+			MaxSourceIPs: 50,
+			Severity:     "HIGH",
+		},
+		AuthzProbing: AuthzProbingConfig{
+			Enabled:       true,
+			ResourceField: "resource",
+			DeniedResults: []string{"denied", "access_denied", "permission_denied", "forbidden", "403"},
+			Threshold:     10,
+			Window:        Duration{10 * time.Minute},
+			Severity:      "MEDIUM",
+		},
+		ConfirmedBreach: ConfirmedBreachConfig{
+			Enabled:      true,
+			Window:       Duration{5 * time.Minute},
+			SessionField: "session_id",
+			Severity:     "CRITICAL",
+		},
+		Ransomware: RansomwareConfig{
+			Enabled:      true,
+			FileField:    "file_path",
+			TargetField:  "target_path",
+			ProcessField: "image",
+			Actions:      []string{"read", "write", "modify", "rename"},
+			Threshold:    100,
+			Window:       Duration{time.Minute},
+			SuspiciousExtensions: []string{".locked", ".encrypted", ".enc", ".crypt", ".crypted",
+				".locky", ".wncry", ".ryk", ".conti", ".lockbit"},
+			RenameThreshold: 10,
+			Severity:        "CRITICAL",
+		},
+		Enrichment: EnrichmentConfig{
+			KeyField:         "user",
+			Timeout:          Duration{500 * time.Millisecond},
+			CacheTTL:         Duration{10 * time.Minute},
+			NegativeTTL:      Duration{time.Minute},
+			CacheSize:        10000,
+			FailureThreshold: 5,
+			Cooldown:         Duration{30 * time.Second},
+		},
+		Vulns: VulnConfig{
+			ReloadInterval:      Duration{5 * time.Minute},
+			ServiceField:        "service",
+			VersionField:        "version",
+			PathField:           "path",
+			KnownExploitedBoost: 1,
+		},
+		Privacy: PrivacyConfig{
+			RawLog: "redact",
+		},
+		Signing: SigningConfig{
+			Algorithm: signHMAC,
+		},
+		SessionHijack: SessionHijackConfig{
+			Enabled:      true,
+			SessionField: "session_id",
+			MaxNetworks:  1,
+			IPv4Prefix:   24,
+			IPv6Prefix:   64,
+			Window:       Duration{30 * time.Minute},
+			Severity:     "HIGH",
+		},
+		GeoDistributed: GeoDistributedConfig{
+			Enabled:      true,
+			CountryField: "country",
+			GroupBy:      []string{"user"},
+			Threshold:    5,
+			Window:       Duration{time.Hour},
+			Severity:     "HIGH",
+		},
+		Geofence: GeofenceConfig{
+			CountryField: "country",
+			Unresolved:   geofenceAllow,
+			Window:       Duration{time.Hour},
+			Severity:     "HIGH",
+		},
+		StaleHosts: StaleHostConfig{
+			EventTypes: []string{"authentication", "access"},
+			Inactivity: Duration{30 * 24 * time.Hour},
+			Retention:  Duration{90 * 24 * time.Hour},
+			Window:     Duration{time.Hour},
+			Severity:   "HIGH",
+		},
+		ServiceAccounts: ServiceAccountConfig{
+			LogonTypeField: "logon_type_name",
+			Interactive:    []string{"interactive", "remote_interactive", "cached_interactive", "unlock"},
+			Window:         Duration{time.Hour},
+			Severity:       "HIGH",
+		},
+		MFAFatigue: MFAFatigueConfig{
+			Enabled:           true,
+			EventTypes:        []string{"mfa", "mfa_push", "mfa_challenge"},
+			DeniedResults:     []string{"denied", "rejected", "fraud"},
+			ApprovedResults:   []string{"success", "approved", "allowed"},
+			Threshold:         5,
+			Denials:           3,
+			Window:            Duration{10 * time.Minute},
+			Severity:          "HIGH",
+			EscalatedSeverity: "CRITICAL",
+		},
+		RequestFlood: RequestFloodConfig{
+			Rate:     50,
+			Window:   Duration{10 * time.Second},
+			Cooldown: Duration{5 * time.Minute},
+			Severity: "MEDIUM",
+		},
+		AuthVolume: AuthVolumeConfig{
+			Factor:         10,
+			MinCount:       20,
+			Smoothing:      0.2,
+			LearningPeriod: Duration{7 * 24 * time.Hour},
+			Retention:      Duration{30 * 24 * time.Hour},
+			Severity:       "MEDIUM",
+		},
+		CIDRAggregation: CIDRAggregationConfig{
+			IPv4Prefix: 24,
+			IPv6Prefix: 64,
+			Threshold:  10,
+			Window:     Duration{10 * time.Minute},
+		},
+		ProtocolDowngrade: ProtocolDowngradeConfig{
+			Enabled: true,
+			Weak: map[string][]string{
+				"protocol":        {"sshv1", "ssh-1.*", "ntlmv1", "ntlm v1", "lm", "smbv1", "smb1"},
+				"tls_version":     {"sslv2", "sslv3", "tlsv1", "tlsv1.0", "tls1.0", "tlsv1.1", "tls1.1"},
+				"cipher":          {"*rc4*", "*des-cbc*", "*3des*", "*export*", "null"},
+				"encryption_type": {"0x1", "0x3", "0x17", "0x18", "rc4-hmac*", "des-cbc-*"},
+				"lm_package":      {"ntlm v1", "lm"},
+			},
+			EventTypes: []string{"authentication"},
+			Window:     Duration{time.Hour},
+			Severity:   "MEDIUM",
+		},
+		ProcessLineage: ProcessLineageConfig{
+			Enabled:      true,
+			ParentField:  "parent_image",
+			ImageField:   "image",
+			CommandField: "command_line",
+			Severity:     "HIGH",
+			Signatures: []LineageSignature{
+				{
+					Name:     "office_spawns_shell",
+					Parents:  []string{"winword", "excel", "powerpnt", "outlook", "msaccess", "onenote"},
+					Children: []string{"cmd", "powershell", "pwsh", "wscript", "cscript", "mshta", "rundll32", "regsvr32", "certutil", "bitsadmin"},
+				},
+				{
+					Name:     "web_server_spawns_shell",
+					Parents:  []string{"w3wp", "httpd", "apache2", "nginx", "php-fpm*", "tomcat*", "node"},
+					Children: []string{"sh", "bash", "dash", "zsh", "cmd", "powershell", "pwsh"},
+					Severity: "CRITICAL",
+				},
+				{
+					Name:          "service_created_by_unusual_parent",
+					Children:      []string{"sc"},
+					Commands:      []string{"sc create *", "sc * create *"},
+					ExceptParents: []string{"services", "msiexec", "trustedinstaller", "tiworker"},
+				},
+				{
+					Name:          "scheduled_task_created_by_unusual_parent",
+					Children:      []string{"schtasks"},
+					Commands:      []string{"schtasks /create *", "schtasks * /create *"},
+					ExceptParents: []string{"svchost", "msiexec", "trustedinstaller"},
+				},
+			},
+		},
+		Remediation: RemediationConfig{
+			Topic:         "remediation-results",
+			ConsumerGroup: "threat-detector-remediation",
+			BlockTTL:      Duration{24 * time.Hour},
+		},
+		Sinks: SinksConfig{
+			Timeout:   Duration{10 * time.Second},
+			QueueSize: 1000,
+			Kafka: KafkaSinkConfig{
+				BatchSize: 100,
+				BatchWait: Duration{50 * time.Millisecond},
+			},
+			PagerDuty: PagerDutyConfig{
+				MinSeverity:     "HIGH",
+				EventsURL:       "https://events.pagerduty.com/v2/enqueue",
+				ResolveInterval: Duration{time.Minute},
+			},
+			STIX: STIXConfig{
+				MinSeverity: "HIGH",
+				Identity:    "Security Breach Log Analyzer",
+				ValidFor:    Duration{30 * 24 * time.Hour},
+			},
+			SQS: SQSConfig{
+				MinSeverity: "LOW",
+				BatchSize:   10,
+				BatchWait:   Duration{time.Second},
+				MaxAttempts: 5,
+			},
+			SNS: SNSConfig{
+				MinSeverity: "LOW",
+				MaxAttempts: 5,
+			},
+			Overflow: OverflowConfig{
+				Retention: Duration{7 * 24 * time.Hour},
+			},
+		},
+	}
+}
+
+// LoadConfig reads a JSON config file over the defaults.
+// An empty path returns the defaults.
+func LoadConfig(path string) (*DetectorConfig, error) {
+	cfg := DefaultConfig()
+
+	// Layers: built-in defaults, then the file, then SBLA_* environment variables
+	var fileKeys []string
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+		var top map[string]json.RawMessage
+		if json.Unmarshal(data, &top) == nil {
+			for key := range top {
+				fileKeys = append(fileKeys, key)
+			}
+		}
+	}
+
+	fromEnv, err := applyEnvOverrides(cfg, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	logConfigSources(path, fileKeys, fromEnv)
+	return cfg, nil
+}
+
+// readSecretFiles loads secrets given as file references
+func (c *DetectorConfig) readSecretFiles() error {
+	for _, secret := range []struct {
+		file  string
+		value *string
+	}{
+		{c.RedisPasswordFile, &c.RedisPassword},
+		{c.Standby.PasswordFile, &c.Standby.Password},
+		{c.Sinks.PagerDuty.RoutingKeyFile, &c.Sinks.PagerDuty.RoutingKey},
+		{c.Sinks.STIX.TAXIITokenFile, &c.Sinks.STIX.TAXIIToken},
+		{c.Sinks.Overflow.TokenFile, &c.Sinks.Overflow.Token},
+		{c.TestAlert.TokenFile, &c.TestAlert.Token},
+		{c.Feedback.TokenFile, &c.Feedback.Token},
+		{c.Pause.TokenFile, &c.Pause.Token},
+		{c.SuppressionAPI.TokenFile, &c.SuppressionAPI.Token},
+		{c.Simulation.TokenFile, &c.Simulation.Token},
+		{c.AlertStore.TokenFile, &c.AlertStore.Token},
+		{c.Middleware.HashKeyFile, &c.Middleware.HashKey},
+		{c.Privacy.SaltFile, &c.Privacy.Salt},
+		{c.Enrichment.TokenFile, &c.Enrichment.Token},
+		{c.Signing.KeyFile, &c.Signing.Key},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("reading secret: %w", err)
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// Validate checks the config and prepares derived fields. It reports every
+// problem found, not just the first.
+func (c *DetectorConfig) Validate() error {
+	return errors.Join(c.problems()...)
+}
+
+// problems runs every check and returns what failed. A failed check only
+// ends its own section, so one mistake doesn't hide the next.
+func (c *DetectorConfig) problems() []error {
+	var errs []error
+	switch c.Input.Type {
+	case "kafka":
+	case "redis_stream":
+		rs := c.Input.RedisStream
+		if rs.Stream == "" || rs.Group == "" || rs.Consumer == "" || rs.Field == "" {
+			errs = append(errs, fmt.Errorf("input.redis_stream: stream, group, consumer and field are required"))
+		}
+		if rs.BatchSize < 1 {
+			errs = append(errs, fmt.Errorf("input.redis_stream.batch_size must be at least 1"))
+		}
+		if rs.Block.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("input.redis_stream.block must be positive"))
+		}
+	case inputStdin:
+	default:
+		errs = append(errs, fmt.Errorf("input.type must be \"kafka\", \"redis_stream\" or \"stdin\", got %q", c.Input.Type))
+	}
+	switch c.Input.Format {
+	case formatJSON, formatWindowsXML, formatCloudTrail, formatAccessLog, formatAuto:
+	default:
+		errs = append(errs, fmt.Errorf("input.format must be %q, %q, %q, %q or %q, got %q", formatJSON, formatWindowsXML, formatCloudTrail, formatAccessLog, formatAuto, c.Input.Format))
+	}
+	if err := c.Input.AccessLog.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.KafkaBrokers) == 0 && c.UsesKafka() {
+		errs = append(errs, fmt.Errorf("kafka_brokers must not be empty"))
+	}
+	if c.NumWorkers < 1 {
+		errs = append(errs, fmt.Errorf("num_workers must be at least 1"))
+	}
+	if c.ShutdownTimeout.Duration < time.Second {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be at least 1s"))
+	}
+	if err := c.RedisPool.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	var codec kafka.Compression
+	if err := codec.UnmarshalText([]byte(c.Compression)); err != nil {
+		errs = append(errs, fmt.Errorf("compression: %w", err))
+	}
+
+	if c.StartOffset != "earliest" && c.StartOffset != "latest" {
+		errs = append(errs, fmt.Errorf("start_offset must be \"earliest\" or \"latest\", got %q", c.StartOffset))
+	}
+	if c.Log.SampleRate < 1 {
+		errs = append(errs, fmt.Errorf("log.sample_rate must be at least 1"))
+	}
+	if c.Log.MaxPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("log.max_per_second must not be negative"))
+	}
+	if c.RawEvents.MaxEvents < 0 || c.RawEvents.MaxAlertBytes < 0 {
+		errs = append(errs, fmt.Errorf("raw_events limits must not be negative"))
+	}
+	if c.RawEvents.MaxLineBytes < 64 {
+		errs = append(errs, fmt.Errorf("raw_events.max_line_bytes must be at least 64"))
+	}
+	switch c.RawEvents.Storage {
+	case "list", "gzip", "dedup":
+	default:
+		errs = append(errs, fmt.Errorf("raw_events.storage must be list, gzip or dedup, got %q", c.RawEvents.Storage))
+	}
+	if c.Thresholds.BruteForce < 1 || c.Thresholds.SuspiciousUser < 1 || c.Thresholds.PasswordChange < 1 ||
+		c.Thresholds.ExfilBytes < 1 {
+		errs = append(errs, fmt.Errorf("thresholds must be at least 1"))
+	}
+
+	var err error
+	if c.allowlist, err = parsePrefixList(c.AllowlistIPs); err != nil {
+		errs = append(errs, fmt.Errorf("allowlist_ips: %w", err))
+	}
+
+	if err := c.SourceZone.compile(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Environment.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Baseline.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := userAllowlist(c.UserAllowlist.Rules).validate(); err != nil {
+		errs = append(errs, fmt.Errorf("user_allowlist: %w", err))
+	}
+	if c.UserAllowlist.File != "" && c.UserAllowlist.ReloadInterval.Duration < time.Second {
+		errs = append(errs, fmt.Errorf("user_allowlist.reload_interval must be at least 1s"))
+	}
+
+	if err := c.Suppression.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Cooldown.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Lifecycle.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.FuzzyDedup.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Learning.validate(c.CustomRules); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.AdaptiveThresholds.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if a := &c.Assets; a.Enabled {
+		if _, ok := a.Tiers[a.DefaultTier]; !ok {
+			errs = append(errs, fmt.Errorf("assets.default_tier %q is not in assets.tiers", a.DefaultTier))
+		}
+		if err := compileAssets(a.Assets, a.Tiers); err != nil {
+			errs = append(errs, fmt.Errorf("assets.assets%w", err))
+		}
+		if a.File != "" && a.ReloadInterval.Duration < time.Second {
+			errs = append(errs, fmt.Errorf("assets.reload_interval must be at least 1s"))
+		}
+	}
+
+	if c.Anonymizer.Enabled {
+		if c.Anonymizer.TorListURL == "" {
+			errs = append(errs, fmt.Errorf("anonymizer.tor_list_url is required when enabled"))
+		} else if err := validateURL(c.Anonymizer.TorListURL); err != nil {
+			errs = append(errs, fmt.Errorf("anonymizer.tor_list_url: %w", err))
+		}
+		if c.Anonymizer.RefreshInterval.Duration < time.Minute {
+			errs = append(errs, fmt.Errorf("anonymizer.refresh_interval must be at least 1m"))
+		}
+	}
+	if c.Anonymizer.proxies, err = parsePrefixList(c.Anonymizer.ProxyCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("anonymizer.proxy_cidrs: %w", err))
+	}
+
+	if c.Snapshot.Enabled {
+		if c.Snapshot.Topic == "" {
+			errs = append(errs, fmt.Errorf("snapshot.topic is required when enabled"))
+		}
+		if c.Snapshot.Interval.Duration < time.Second {
+			errs = append(errs, fmt.Errorf("snapshot.interval must be at least 1s"))
+		}
+	}
+
+	if c.IPSeen.Enabled && c.IPSeen.TTL.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("ip_seen.ttl must be positive"))
+	}
+
+	if h := c.IPHistory; h.Enabled {
+		if h.Length < 1 {
+			errs = append(errs, fmt.Errorf("ip_history.length must be at least 1"))
+		}
+		if h.TTL.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("ip_history.ttl must be positive"))
+		}
+		if severityRank(h.MinSeverity) < 0 {
+			errs = append(errs, fmt.Errorf("ip_history.min_severity %q is not a severity", h.MinSeverity))
+		}
+	}
+
+	if w := c.Watchdog; w.Enabled {
+		if w.Timeout.Duration < time.Second {
+			errs = append(errs, fmt.Errorf("watchdog.timeout must be at least 1s"))
+		}
+		if w.Interval.Duration <= 0 || w.Interval.Duration > w.Timeout.Duration {
+			errs = append(errs, fmt.Errorf("watchdog.interval must be positive and no longer than watchdog.timeout"))
+		}
+	}
+
+	if err := c.Poison.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Scan.validate(c.CustomRules); err != nil {
+		errs = append(errs, err)
+	}
+
+	if a := c.AlertStore; a.Enabled {
+		if a.Backend != "redis" && a.Backend != "memory" {
+			errs = append(errs, fmt.Errorf("alert_store.backend must be redis or memory, got %q", a.Backend))
+		}
+		if a.Retention.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("alert_store.retention must be positive"))
+		}
+		if a.Backend == "redis" && a.Key == "" {
+			errs = append(errs, fmt.Errorf("alert_store.key is required for the redis backend"))
+		}
+		if a.Backend == "memory" && a.MaxAlerts < 1 {
+			errs = append(errs, fmt.Errorf("alert_store.max_alerts must be at least 1"))
+		}
+	}
+
+	if err := c.Observations.validate(c.AlertStore); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.UI.Enabled {
+		if !c.AlertStore.Enabled {
+			errs = append(errs, fmt.Errorf("ui needs alert_store.enabled"))
+		}
+		if c.UI.Addr == "" && c.HTTPAddr == "" {
+			errs = append(errs, fmt.Errorf("ui needs ui.addr or http_addr"))
+		}
+	}
+
+	switch c.ClockSkew.Policy {
+	case skewClamp, skewReject, skewAccept:
+	default:
+		errs = append(errs, fmt.Errorf("clock_skew.policy must be clamp, reject or accept, got %q", c.ClockSkew.Policy))
+	}
+	if c.ClockSkew.Tolerance.Duration < 0 {
+		errs = append(errs, fmt.Errorf("clock_skew.tolerance must not be negative"))
+	}
+
+	if err := c.validateWindowModes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.StateRetention.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Dispatch.Affinity && c.Dispatch.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("dispatch.queue_size must be at least 1"))
+	}
+	if c.Dispatch.MaxInFlight < 0 {
+		errs = append(errs, fmt.Errorf("dispatch.max_in_flight must not be negative"))
+	}
+
+	if c.TestAlert.Enabled && c.TestAlert.Token == "" {
+		errs = append(errs, fmt.Errorf("test_alert.token is required when enabled"))
+	}
+
+	if err := c.Pause.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.SuppressionAPI.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Simulation.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if f := c.Feedback; f.Enabled {
+		if f.Token == "" {
+			errs = append(errs, fmt.Errorf("feedback.token is required when enabled"))
+		}
+		if m := f.AutoMute; m.Enabled {
+			if m.Window.Duration <= 0 || m.Duration.Duration <= 0 {
+				errs = append(errs, fmt.Errorf("feedback.auto_mute.window and feedback.auto_mute.duration must be positive"))
+			}
+			if m.AuditRetention.Duration < m.Duration.Duration {
+				errs = append(errs, fmt.Errorf("feedback.auto_mute.audit_retention must be at least feedback.auto_mute.duration"))
+			}
+			if m.MinSamples < 1 {
+				errs = append(errs, fmt.Errorf("feedback.auto_mute.min_samples must be at least 1"))
+			}
+			if m.Ratio <= 0 || m.Ratio > 1 {
+				errs = append(errs, fmt.Errorf("feedback.auto_mute.ratio must be in (0, 1]"))
+			}
+		}
+	}
+
+	if c.Sinks.Timeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("sinks.timeout must be positive"))
+	}
+	if c.Sinks.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("sinks.queue_size must be at least 1"))
+	}
+	if k := c.Sinks.Kafka; k.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("sinks.kafka.batch_size must be at least 1"))
+	} else if k.BatchWait.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("sinks.kafka.batch_wait must be positive"))
+	}
+	for _, name := range c.Sinks.Critical {
+		if !containsString(sinkNames, name) {
+			errs = append(errs, fmt.Errorf("sinks.critical: unknown sink %q (want one of %s)", name, strings.Join(sinkNames, ", ")))
+		}
+	}
+	if err := c.Sinks.validateTransforms(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Sinks.validateFilters(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Sinks.validateOverflow(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if pd := c.Sinks.PagerDuty; pd.Enabled {
+		if pd.RoutingKey == "" {
+			errs = append(errs, fmt.Errorf("sinks.pagerduty.routing_key is required when enabled"))
+		}
+		if err := validateURL(pd.EventsURL); err != nil {
+			errs = append(errs, fmt.Errorf("sinks.pagerduty.events_url: %w", err))
+		}
+		if severityRank(pd.MinSeverity) < 0 {
+			errs = append(errs, fmt.Errorf("sinks.pagerduty.min_severity %q is not a severity", pd.MinSeverity))
+		}
+		if pd.MinRiskScore < 0 {
+			errs = append(errs, fmt.Errorf("sinks.pagerduty.min_risk_score must not be negative"))
+		}
+		if pd.ResolveInterval.Duration < time.Second {
+			errs = append(errs, fmt.Errorf("sinks.pagerduty.resolve_interval must be at least 1s"))
+		}
+	}
+
+	if x := c.Sinks.STIX; x.Enabled {
+		if x.Topic == "" && x.TAXIIURL == "" {
+			errs = append(errs, fmt.Errorf("sinks.stix needs a topic or taxii_url when enabled"))
+		}
+		if x.TAXIIURL != "" {
+			if err := validateURL(x.TAXIIURL); err != nil {
+				errs = append(errs, fmt.Errorf("sinks.stix.taxii_url: %w", err))
+			}
+		}
+		if severityRank(x.MinSeverity) < 0 {
+			errs = append(errs, fmt.Errorf("sinks.stix.min_severity %q is not a severity", x.MinSeverity))
+		}
+		if x.Identity == "" {
+			errs = append(errs, fmt.Errorf("sinks.stix.identity is required when enabled"))
+		}
+		if x.ValidFor.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("sinks.stix.valid_for must be positive"))
+		}
+		for threatType, t := range x.Techniques {
+			if t.ID == "" || t.Name == "" {
+				errs = append(errs, fmt.Errorf("sinks.stix.techniques.%s needs an id and a name", threatType))
+			}
+		}
+	}
+
+	if q := c.Sinks.SQS; q.Enabled {
+		if q.QueueURL == "" {
+			errs = append(errs, fmt.Errorf("sinks.sqs.queue_url is required when enabled"))
+		} else if err := validateURL(q.QueueURL); err != nil {
+			errs = append(errs, fmt.Errorf("sinks.sqs.queue_url: %w", err))
+		}
+		if severityRank(q.MinSeverity) < 0 {
+			errs = append(errs, fmt.Errorf("sinks.sqs.min_severity %q is not a severity", q.MinSeverity))
+		}
+		if q.BatchSize < 1 || q.BatchSize > 10 {
+			errs = append(errs, fmt.Errorf("sinks.sqs.batch_size must be between 1 and 10"))
+		}
+		if q.BatchWait.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("sinks.sqs.batch_wait must be positive"))
+		}
+		if q.MaxAttempts < 1 {
+			errs = append(errs, fmt.Errorf("sinks.sqs.max_attempts must be at least 1"))
+		}
+	}
+
+	if t := c.Sinks.SNS; t.Enabled {
+		if t.TopicARN == "" {
+			errs = append(errs, fmt.Errorf("sinks.sns.topic_arn is required when enabled"))
+		}
+		if severityRank(t.MinSeverity) < 0 {
+			errs = append(errs, fmt.Errorf("sinks.sns.min_severity %q is not a severity", t.MinSeverity))
+		}
+		if t.MaxAttempts < 1 {
+			errs = append(errs, fmt.Errorf("sinks.sns.max_attempts must be at least 1"))
+		}
+	}
+
+	am := &c.AccountManipulation
+	am.watched = make(map[string]bool)
+	for i, seq := range am.Sequences {
+		if len(seq) < 2 {
+			errs = append(errs, fmt.Errorf("account_manipulation.sequences[%d]: needs at least 2 actions", i))
+		}
+		for j, action := range seq {
+			seq[j] = strings.ToLower(action)
+			am.watched[seq[j]] = true
+		}
+	}
+	if am.Enabled && am.Window.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("account_manipulation.window must be positive"))
+	}
+
+	if rc := &c.RoleConfusion; rc.Enabled {
+		if rc.Window.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("role_confusion.window must be positive"))
+		}
+		roles := make(map[string]bool)
+		for i, role := range rc.Roles {
+			if role.Name == "" {
+				errs = append(errs, fmt.Errorf("role_confusion.roles[%d]: name is required", i))
+			}
+			if err := validatePatterns(role.Users); err != nil {
+				errs = append(errs, fmt.Errorf("role_confusion.roles[%d] (%s): %w", i, role.Name, err))
+			}
+			roles[role.Name] = true
+		}
+		for i, t := range rc.ForbiddenTransitions {
+			if len(t) != 2 || !roles[t[0]] || !roles[t[1]] {
+				errs = append(errs, fmt.Errorf("role_confusion.forbidden_transitions[%d]: must be [from, to] of defined roles", i))
+			}
+		}
+		for _, name := range rc.MachineRoles {
+			if !roles[name] {
+				errs = append(errs, fmt.Errorf("role_confusion.machine_roles: unknown role %q", name))
+			}
+		}
+		for i, action := range rc.MachineActions {
+			rc.MachineActions[i] = strings.ToLower(action)
+		}
+	}
+
+	if r := c.Remediation; r.Enabled {
+		if r.Topic == "" || r.ConsumerGroup == "" {
+			errs = append(errs, fmt.Errorf("remediation.topic and remediation.consumer_group are required when enabled"))
+		}
+		if r.BlockTTL.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("remediation.block_ttl must be positive"))
+		}
+	}
+
+	c.Correlation.maxWindow = 0
+	for i, chain := range c.Correlation.Chains {
+		if chain.Name == "" || len(chain.Sequence) < 2 {
+			errs = append(errs, fmt.Errorf("correlation.chains[%d]: needs a name and at least 2 steps", i))
+		}
+		if chain.Window.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("correlation.chains[%d] (%s): window must be positive", i, chain.Name))
+		}
+		if severityRank(chain.Severity) < 0 {
+			errs = append(errs, fmt.Errorf("correlation.chains[%d] (%s): unknown severity %q", i, chain.Name, chain.Severity))
+		}
+		if chain.Window.Duration > c.Correlation.maxWindow {
+			c.Correlation.maxWindow = chain.Window.Duration
+		}
+	}
+	if err := c.Correlation.Scoring.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := userAllowlist(c.UserGroups).validate(); err != nil {
+		errs = append(errs, fmt.Errorf("user_groups: %w", err))
+	}
+	for i := range c.SeverityOverrides {
+		o := &c.SeverityOverrides[i]
+		if severityRank(o.Severity) < 0 {
+			errs = append(errs, fmt.Errorf("severity_overrides[%d] (%s): unknown severity %q", i, o.Name, o.Severity))
+		}
+		for _, group := range o.UserGroups {
+			if _, ok := c.UserGroups[group]; !ok {
+				errs = append(errs, fmt.Errorf("severity_overrides[%d] (%s): unknown user group %q", i, o.Name, group))
+			}
+		}
+		patterns := append(append([]string{}, o.Users...), o.Sources...)
+		for _, v := range o.Metadata {
+			patterns = append(patterns, v)
+		}
+		if err := validatePatterns(patterns); err != nil {
+			errs = append(errs, fmt.Errorf("severity_overrides[%d] (%s): %w", i, o.Name, err))
+		}
+		if o.sourceIPs, err = parsePrefixList(o.SourceIPs); err != nil {
+			errs = append(errs, fmt.Errorf("severity_overrides[%d] (%s) source_ips: %w", i, o.Name, err))
+		}
+	}
+
+	if err := c.Recon.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ProcessLineage.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.TargetedAccount.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.AuthzProbing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Ransomware.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ConfirmedBreach.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.SessionHijack.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.GeoDistributed.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Geofence.validate(c.UserGroups); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.AuthVolume.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.StaleHosts.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ServiceAccounts.validate(c.UserGroups); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.MFAFatigue.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.RequestFlood.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.CIDRAggregation.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ProtocolDowngrade.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Middleware.compile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Enrichment.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Vulns.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Privacy.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Timeline.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Standby.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Dedup.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Signing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.ActiveDirectory.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.CloudTrail.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.validateEvidence(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := compileCustomRules(c.CustomRules); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.alertTemplates, err = compileAlertTemplates(c.AlertTemplates); err != nil {
+		errs = append(errs, err)
+	}
+
+	for id, tenant := range c.Tenants {
+		if tenant == nil {
+			errs = append(errs, fmt.Errorf("tenant %q: empty config", id))
+			continue
+		}
+		if tenant.allowlist, err = parsePrefixList(tenant.AllowlistIPs); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q allowlist_ips: %w", id, err))
+		}
+	}
+	return errs
+}
+
+// UsesKafka reports whether anything configured needs a Kafka broker. With a
+// Redis Stream input and no alerts topic, the detector can run without Kafka.
+func (c *DetectorConfig) UsesKafka() bool {
+	return c.Input.Type == "kafka" || c.AlertsTopic != "" || c.DeadLetterTopic != "" || c.ObservationsTopic != "" ||
+		c.Snapshot.Enabled || c.Remediation.Enabled
+}
+
+// KafkaStartOffset maps StartOffset to the kafka-go constant
+func (c *DetectorConfig) KafkaStartOffset() int64 {
+	if c.StartOffset == "earliest" {
+		return kafka.FirstOffset
+	}
+	return kafka.LastOffset
+}
+
+// KafkaCompression maps Compression to the kafka-go codec (validated at load)
+func (c *DetectorConfig) KafkaCompression() kafka.Compression {
+	var codec kafka.Compression
+	codec.UnmarshalText([]byte(c.Compression))
+	return codec
+}
+
+// ThresholdsFor returns the effective thresholds for a tenant
+func (c *DetectorConfig) ThresholdsFor(tenantID string) Thresholds {
+	t := c.Thresholds
+
+	tenant, ok := c.Tenants[tenantID]
+	if !ok {
+		return t
+	}
+	if tenant.Thresholds.BruteForce > 0 {
+		t.BruteForce = tenant.Thresholds.BruteForce
+	}
+	if tenant.Thresholds.SuspiciousUser > 0 {
+		t.SuspiciousUser = tenant.Thresholds.SuspiciousUser
+	}
+	if tenant.Thresholds.PasswordChange > 0 {
+		t.PasswordChange = tenant.Thresholds.PasswordChange
+	}
+	if tenant.Thresholds.ExfilBytes > 0 {
+		t.ExfilBytes = tenant.Thresholds.ExfilBytes
+	}
+	return t
+}
+
+// IsAllowlisted reports whether an IP is exempt from detection for a tenant.
+// Global entries apply to every tenant.
+func (c *DetectorConfig) IsAllowlisted(tenantID string, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	if containsAddr(c.allowlist, addr) {
+		return true
+	}
+	if tenant, ok := c.Tenants[tenantID]; ok {
+		return containsAddr(tenant.allowlist, addr)
+	}
+	return false
+}
+
+// AlertsTopicFor returns the alerts topic for a tenant
+func (c *DetectorConfig) AlertsTopicFor(tenantID string) string {
+	if tenant, ok := c.Tenants[tenantID]; ok && tenant.AlertsTopic != "" {
+		return tenant.AlertsTopic
+	}
+	return c.AlertsTopic
+}
+
+// validateURL checks that a setting is an absolute http or https URL
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("want an http(s) URL, got %q", raw)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// validate checks the cooldowns aren't negative
+func (c *CooldownConfig) validate() error {
+	if c.Default.Duration < 0 {
+		return fmt.Errorf("cooldown.default must not be negative")
+	}
+	rules := mapKeys(c.Rules)
+	sort.Strings(rules)
+	for _, threatType := range rules {
+		if c.Rules[threatType].Duration < 0 {
+			return fmt.Errorf("cooldown.rules[%s] must not be negative", threatType)
+		}
+	}
+	return nil
+}
+
+// of returns a threat type's cooldown: its own entry, else the default
+func (c *CooldownConfig) of(threatType string) time.Duration {
+	if d, ok := c.Rules[threatType]; ok {
+		return d.Duration
+	}
+	return c.Default.Duration
+}
+
+// inCooldown reports whether the rule already alerted for the event's
+// subject within its cooldown, and starts the cooldown if not
+func (td *ThreatDetector) inCooldown(ctx context.Context, event SecurityEvent, threatType string) (bool, error) {
+	ttl := td.cfg().Cooldown.of(threatType)
+	if ttl <= 0 {
+		return false, nil
+	}
+	key := cooldownKey(event, threatType)
+	seen, err := td.dedup.SeenRecently(ctx, key, ttl)
+	if err != nil {
+		return false, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	return seen, nil
+}
+
+// cooldownKey is the dedup key of a rule's cooldown for the event's subject
+func cooldownKey(event SecurityEvent, threatType string) string {
+	return stateKey(event, "cooldown:"+threatType, evidenceSubject(event))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// correlationMaxEntries bounds each per-IP / per-user correlation buffer
+const correlationMaxEntries = 50
+
+// correlationEntry is one alert remembered in a correlation buffer
+type correlationEntry struct {
+	AlertID    string    `json:"alert_id"`
+	ThreatType string    `json:"threat_type"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// correlate records an alert in the IP and user correlation buffers and
+// returns an alert for every chain the alert completes
+func (td *ThreatDetector) correlate(ctx context.Context, event SecurityEvent, alert ThreatAlert) ([]ThreatAlert, error) {
+	cfg := td.cfg().Correlation
+	if !cfg.Enabled || len(cfg.Chains) == 0 {
+		return nil, nil
+	}
+
+	entry, err := json.Marshal(correlationEntry{
+		AlertID:    alert.AlertID,
+		ThreatType: alert.ThreatType,
+		Timestamp:  alert.Timestamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A chain can be linked by the attacking IP or by the compromised user
+	var keys []string
+	if event.SourceIP != "" {
+		keys = append(keys, stateKey(event, "chain:ip", event.ipKey()))
+	}
+	if event.User != "" {
+		keys = append(keys, stateKey(event, "chain:user", event.User))
+	}
+
+	matched := make(map[string]bool)
+	var chained []ThreatAlert
+
+	for _, key := range keys {
+		if err := td.state.AppendList(ctx, key, string(entry), correlationMaxEntries, cfg.maxWindow); err != nil {
+			return chained, &StateError{Op: "rpush", Key: key, Err: err}
+		}
+
+		raw, err := td.state.ListRange(ctx, key)
+		if err != nil {
+			return chained, &StateError{Op: "lrange", Key: key, Err: err}
+		}
+		history := make([]correlationEntry, 0, len(raw))
+		for _, r := range raw {
+			var e correlationEntry
+			if json.Unmarshal([]byte(r), &e) == nil {
+				history = append(history, e)
+			}
+		}
+
+		for _, chain := range cfg.Chains {
+			if matched[chain.Name] {
+				continue
+			}
+			ids := matchChain(chain, history, alert)
+			if ids == nil {
+				continue
+			}
+			matched[chain.Name] = true
+
+			chained = append(chained, ThreatAlert{
+				AlertID:    fmt.Sprintf("CH-%d", time.Now().Unix()),
+				Timestamp:  time.Now(),
+				Severity:   chain.Severity,
+				ThreatType: chain.Name,
+				SourceIP:   event.SourceIP,
+				Details: fmt.Sprintf("Attack chain %s completed (%s) for %s from %s",
+					chain.Name, strings.Join(chain.Sequence, " → "), event.User, event.SourceIP),
+				EventCount:    len(ids),
+				RelatedAlerts: ids,
+			})
+		}
+	}
+	return chained, nil
+}
+
+// matchChain checks whether the current alert completes a chain: it must be the
+// chain's last step, and every earlier step must appear in order within the window.
+// It returns the constituent alert IDs in chain order, or nil.
+func matchChain(chain ChainRule, history []correlationEntry, current ThreatAlert) []string {
+	steps := chain.Sequence
+	if len(steps) == 0 || steps[len(steps)-1] != current.ThreatType {
+		return nil
+	}
+
+	cutoff := current.Timestamp.Add(-chain.Window.Duration)
+	ids := make([]string, len(steps))
+	ids[len(steps)-1] = current.AlertID
+
+	// Walk backwards from the newest entry, matching steps from last to first.
+	// The newest entry is the current alert itself, so skip it.
+	step := len(steps) - 2
+	for i := len(history) - 2; i >= 0 && step >= 0; i-- {
+		entry := history[i]
+		if entry.Timestamp.Before(cutoff) {
+			break
+		}
+		if entry.ThreatType == steps[step] {
+			ids[step] = entry.AlertID
+			step--
+		}
+	}
+
+	if step >= 0 {
+		return nil
+	}
+	return ids
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers keys for a while, so a rule alerts once per key per
+// window. It is kept apart from the StateStore so single-instance
+// deployments can keep it in memory.
+type DedupStore interface {
+	// SeenRecently records key for ttl and reports whether it was already
+	// recorded and hasn't expired. A hit doesn't extend the key's ttl.
+	SeenRecently(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// Dedup backends
+const (
+	dedupRedis  = "redis"
+	dedupMemory = "memory"
+)
+
+// validate checks the dedup settings
+func (c *DedupConfig) validate() error {
+	switch c.Backend {
+	case dedupRedis:
+	case dedupMemory:
+		if c.MaxEntries < 1 {
+			return fmt.Errorf("dedup.max_entries must be at least 1")
+		}
+	default:
+		return fmt.Errorf("dedup.backend must be redis or memory, got %q", c.Backend)
+	}
+	return nil
+}
+
+// newDedupStore creates the configured backend. The redis backend shares
+// the state store's Redis (and standby); clock drives the memory backend's
+// expiry.
+func newDedupStore(cfg DedupConfig, state StateStore, clock func() time.Time) DedupStore {
+	if cfg.Backend == dedupMemory {
+		return newMemoryDedup(cfg.MaxEntries, clock)
+	}
+	return stateDedup{state: state}
+}
+
+// stateDedup keeps dedup keys in the StateStore with SET NX, shared by
+// every replica
+type stateDedup struct {
+	state StateStore
+}
+
+func (d stateDedup) SeenRecently(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	first, err := d.state.SetIfAbsent(ctx, key, "1", ttl)
+	return !first, err
+}
+
+// memoryDedup is a bounded in-process LRU of dedup keys. Each replica has
+// its own, so it only suits single-instance deployments.
+type memoryDedup struct {
+	mu      sync.Mutex
+	max     int
+	clock   func() time.Time
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+// memoryDedupEntry is one remembered key
+type memoryDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newMemoryDedup(max int, clock func() time.Time) *memoryDedup {
+	return &memoryDedup{max: max, clock: clock, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (d *memoryDedup) SeenRecently(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	now := d.clock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.entries[key]; ok {
+		d.order.MoveToFront(el)
+		entry := el.Value.(*memoryDedupEntry)
+		if now.Before(entry.expires) {
+			return true, nil
+		}
+		entry.expires = now.Add(ttl)
+		return false, nil
+	}
+
+	d.entries[key] = d.order.PushFront(&memoryDedupEntry{key: key, expires: now.Add(ttl)})
+	// Past the cap the least recently used key is forgotten, which can let
+	// its rule alert again early
+	for d.order.Len() > d.max {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*memoryDedupEntry).key)
+		dedupEvictions.Inc()
+	}
+	return false, nil
+}
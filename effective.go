@@ -0,0 +1,221 @@
+package main
+
+// redactedValue replaces secrets in the effective config
+const redactedValue = "REDACTED"
+
+// RuleSummary describes one detection rule as it is currently configured
+type RuleSummary struct {
+	ThreatType string `json:"threat_type"`
+	Enabled    bool   `json:"enabled"`
+	Threshold  int    `json:"threshold,omitempty"`
+	Window     string `json:"window,omitempty"`
+	Severity   string `json:"severity"`
+}
+
+// EffectiveConfig is the fully-resolved configuration, as served by
+// GET /config/effective and printed by -print-config
+type EffectiveConfig struct {
+	Config *DetectorConfig `json:"config"`
+	Rules  []RuleSummary   `json:"rules"`
+}
+
+// Effective returns the resolved configuration with secrets redacted
+func (c *DetectorConfig) Effective() EffectiveConfig {
+	return EffectiveConfig{
+		Config: c.Redacted(),
+		Rules:  c.RuleSummaries(),
+	}
+}
+
+// Redacted returns a copy of the config with secret values replaced
+func (c *DetectorConfig) Redacted() *DetectorConfig {
+	out := *c
+	if out.RedisPassword != "" {
+		out.RedisPassword = redactedValue
+	}
+	if out.Standby.Password != "" {
+		out.Standby.Password = redactedValue
+	}
+	if out.Sinks.PagerDuty.RoutingKey != "" {
+		out.Sinks.PagerDuty.RoutingKey = redactedValue
+	}
+	if out.Sinks.STIX.TAXIIToken != "" {
+		out.Sinks.STIX.TAXIIToken = redactedValue
+	}
+	if out.Sinks.Overflow.Token != "" {
+		out.Sinks.Overflow.Token = redactedValue
+	}
+	if out.TestAlert.Token != "" {
+		out.TestAlert.Token = redactedValue
+	}
+	if out.Feedback.Token != "" {
+		out.Feedback.Token = redactedValue
+	}
+	if out.Pause.Token != "" {
+		out.Pause.Token = redactedValue
+	}
+	if out.SuppressionAPI.Token != "" {
+		out.SuppressionAPI.Token = redactedValue
+	}
+	if out.Simulation.Token != "" {
+		out.Simulation.Token = redactedValue
+	}
+	if out.AlertStore.Token != "" {
+		out.AlertStore.Token = redactedValue
+	}
+	if out.Middleware.HashKey != "" {
+		out.Middleware.HashKey = redactedValue
+	}
+	if out.Privacy.Salt != "" {
+		out.Privacy.Salt = redactedValue
+	}
+	if out.Enrichment.Token != "" {
+		out.Enrichment.Token = redactedValue
+	}
+	if out.Signing.Key != "" {
+		out.Signing.Key = redactedValue
+	}
+	return &out
+}
+
+// RuleSummaries lists every built-in rule with its global (non-tenant) settings
+func (c *DetectorConfig) RuleSummaries() []RuleSummary {
+	t := c.Thresholds
+	rules := []RuleSummary{
+		{ThreatType: "BRUTE_FORCE", Enabled: true, Threshold: t.BruteForce, Window: "5m", Severity: "HIGH"},
+		{ThreatType: "PRIVILEGE_ESCALATION", Enabled: true, Threshold: 1, Severity: "MEDIUM"},
+		{ThreatType: "SUSPICIOUS_USER", Enabled: true, Threshold: t.SuspiciousUser, Window: "5m", Severity: "HIGH"},
+		{ThreatType: "PASSWORD_CHANGE_ANOMALY", Enabled: true, Threshold: t.PasswordChange, Window: "1h", Severity: "MEDIUM"},
+		{ThreatType: "ANONYMIZER_ACCESS", Enabled: c.Anonymizer.Enabled, Threshold: 1, Severity: "HIGH"},
+		{ThreatType: "DATA_EXFILTRATION", Enabled: true, Threshold: t.ExfilBytes, Severity: "HIGH"},
+		{ThreatType: "POST_BRUTEFORCE_SUCCESS", Enabled: true, Threshold: t.BruteForce, Window: "5m", Severity: "HIGH"},
+		{ThreatType: "ACCOUNT_MANIPULATION", Enabled: c.AccountManipulation.Enabled, Threshold: 2,
+			Window: c.AccountManipulation.Window.String(), Severity: "HIGH"},
+		{ThreatType: "ROLE_CONFUSION", Enabled: c.RoleConfusion.Enabled, Threshold: 2,
+			Window: c.RoleConfusion.Window.String(), Severity: "HIGH"},
+	}
+
+	for _, sig := range c.Recon.Signatures {
+		rules = append(rules, RuleSummary{ThreatType: "RECON_ACTIVITY", Enabled: c.Recon.Enabled,
+			Threshold: sig.MinMatches, Window: c.Recon.Window.String(), Severity: c.Recon.Severity})
+	}
+
+	ta := c.TargetedAccount
+	rules = append(rules, RuleSummary{ThreatType: "TARGETED_ACCOUNT_ATTACK", Enabled: ta.Enabled,
+		Threshold: ta.Threshold, Window: ta.Window.String(), Severity: ta.Severity})
+
+	az := c.AuthzProbing
+	rules = append(rules, RuleSummary{ThreatType: "AUTHZ_PROBING", Enabled: az.Enabled,
+		Threshold: az.Threshold, Window: az.Window.String(), Severity: az.Severity})
+
+	rw := c.Ransomware
+	rules = append(rules, RuleSummary{ThreatType: "RANSOMWARE_BEHAVIOR", Enabled: rw.Enabled,
+		Threshold: rw.Threshold, Window: rw.Window.String(), Severity: rw.Severity})
+
+	cb := c.ConfirmedBreach
+	rules = append(rules, RuleSummary{ThreatType: "CONFIRMED_BREACH", Enabled: cb.Enabled,
+		Threshold: 2, Window: cb.Window.String(), Severity: cb.Severity})
+
+	sh := c.SessionHijack
+	rules = append(rules, RuleSummary{ThreatType: "SESSION_HIJACK", Enabled: sh.Enabled,
+		Threshold: sh.MaxNetworks + 1, Window: sh.Window.String(), Severity: sh.Severity})
+
+	gd := c.GeoDistributed
+	rules = append(rules, RuleSummary{ThreatType: "GEO_DISTRIBUTED_ATTACK", Enabled: gd.Enabled,
+		Threshold: gd.Threshold, Window: gd.Window.String(), Severity: gd.Severity})
+
+	gf := c.Geofence
+	rules = append(rules, RuleSummary{ThreatType: "GEOFENCE_VIOLATION", Enabled: gf.Enabled,
+		Threshold: 1, Window: gf.Window.String(), Severity: gf.Severity})
+
+	av := c.AuthVolume
+	rules = append(rules, RuleSummary{ThreatType: "AUTH_VOLUME_ANOMALY", Enabled: av.Enabled,
+		Threshold: av.MinCount, Window: "1h", Severity: av.Severity})
+
+	st := c.StaleHosts
+	rules = append(rules, RuleSummary{ThreatType: "STALE_HOST_ACCESS", Enabled: st.Enabled,
+		Threshold: 1, Window: st.Inactivity.String(), Severity: st.Severity})
+
+	sa := c.ServiceAccounts
+	rules = append(rules, RuleSummary{ThreatType: "SERVICE_ACCOUNT_MISUSE", Enabled: sa.Enabled,
+		Threshold: 1, Window: sa.Window.String(), Severity: sa.Severity})
+
+	mf := c.MFAFatigue
+	rules = append(rules, RuleSummary{ThreatType: "MFA_FATIGUE", Enabled: mf.Enabled,
+		Threshold: mf.Threshold, Window: mf.Window.String(), Severity: mf.Severity})
+
+	rf := c.RequestFlood
+	rules = append(rules, RuleSummary{ThreatType: "REQUEST_FLOOD", Enabled: rf.Enabled,
+		Threshold: int(rf.threshold()), Window: rf.Window.String(), Severity: rf.Severity})
+
+	pd := c.ProtocolDowngrade
+	rules = append(rules, RuleSummary{ThreatType: "PROTOCOL_DOWNGRADE", Enabled: pd.Enabled,
+		Threshold: 1, Severity: pd.Severity})
+
+	for _, sig := range c.ProcessLineage.Signatures {
+		severity := sig.Severity
+		if severity == "" {
+			severity = c.ProcessLineage.Severity
+		}
+		rules = append(rules, RuleSummary{ThreatType: "SUSPICIOUS_PROCESS", Enabled: c.ProcessLineage.Enabled,
+			Threshold: 1, Severity: severity})
+	}
+
+	ad := c.ActiveDirectory
+	rules = append(rules,
+		RuleSummary{ThreatType: "KERBEROASTING", Enabled: ad.Enabled && ad.Kerberoasting.Enabled,
+			Threshold: ad.Kerberoasting.Threshold, Window: ad.Kerberoasting.Window.String(), Severity: ad.Kerberoasting.Severity},
+		RuleSummary{ThreatType: "KERBEROS_TICKET_ANOMALY", Enabled: ad.Enabled && ad.TicketAnomaly.Enabled,
+			Threshold: 1, Severity: ad.TicketAnomaly.Severity},
+		RuleSummary{ThreatType: "DCSYNC", Enabled: ad.Enabled && ad.DCSync.Enabled,
+			Threshold: 1, Severity: ad.DCSync.Severity},
+	)
+
+	ct := c.CloudTrail
+	rules = append(rules,
+		RuleSummary{ThreatType: "ROOT_ACCOUNT_USAGE", Enabled: ct.Enabled && ct.RootUsage.Enabled,
+			Threshold: 1, Window: ct.RootUsage.Window.String(), Severity: ct.RootUsage.Severity},
+		RuleSummary{ThreatType: "CONSOLE_LOGIN_WITHOUT_MFA", Enabled: ct.Enabled && ct.ConsoleMFA.Enabled,
+			Threshold: 1, Severity: ct.ConsoleMFA.Severity},
+		RuleSummary{ThreatType: "MFA_DISABLED", Enabled: ct.Enabled && ct.MFADisabled.Enabled,
+			Threshold: 1, Severity: ct.MFADisabled.Severity},
+		RuleSummary{ThreatType: "IAM_POLICY_CHANGES", Enabled: ct.Enabled && ct.IAMChanges.Enabled,
+			Threshold: ct.IAMChanges.Threshold, Window: ct.IAMChanges.Window.String(), Severity: ct.IAMChanges.Severity},
+	)
+
+	for _, rule := range c.CustomRules {
+		summary := RuleSummary{ThreatType: rule.Name, Enabled: true, Threshold: rule.Threshold, Severity: rule.Severity}
+		if rule.Threshold > 1 {
+			summary.Window = rule.Window.String()
+		}
+		rules = append(rules, summary)
+	}
+
+	for _, rule := range c.Scan.Rules {
+		summary := RuleSummary{ThreatType: rule.Name, Enabled: c.Scan.Enabled, Severity: rule.Severity}
+		if rule.Mode == scanDistributed {
+			summary.Threshold = rule.MinSources
+		}
+		rules = append(rules, summary)
+	}
+
+	for _, chain := range c.Correlation.Chains {
+		rules = append(rules, RuleSummary{
+			ThreatType: chain.Name,
+			Enabled:    c.Correlation.Enabled,
+			Threshold:  len(chain.Sequence),
+			Window:     chain.Window.String(),
+			Severity:   chain.Severity,
+		})
+	}
+	if sc := c.Correlation.Scoring; sc.Enabled {
+		rules = append(rules, RuleSummary{
+			ThreatType: sc.Name,
+			Enabled:    true,
+			Threshold:  int(sc.Threshold),
+			Window:     sc.Window.String(),
+			Severity:   sc.Severity,
+		})
+	}
+	return rules
+}
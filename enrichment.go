@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validate checks the HTTP enrichment settings
+func (c *EnrichmentConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.Contains(c.URL, "{key}") {
+		return fmt.Errorf("enrichment.url must contain {key}")
+	}
+	if err := validateURL(strings.ReplaceAll(c.URL, "{key}", "k")); err != nil {
+		return fmt.Errorf("enrichment.url: %w", err)
+	}
+	switch {
+	case c.KeyField == "user", c.KeyField == "source_ip", c.KeyField == "source":
+	case strings.HasPrefix(c.KeyField, "metadata.") && len(c.KeyField) > len("metadata."):
+	default:
+		return fmt.Errorf(`enrichment.key_field must be "user", "source_ip", "source" or "metadata.<key>"`)
+	}
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("enrichment.fields is required when enabled")
+	}
+	if c.Timeout.Duration <= 0 {
+		return fmt.Errorf("enrichment.timeout must be positive")
+	}
+	if c.CacheTTL.Duration <= 0 || c.NegativeTTL.Duration <= 0 {
+		return fmt.Errorf("enrichment.cache_ttl and negative_ttl must be positive")
+	}
+	if c.CacheSize < 1 {
+		return fmt.Errorf("enrichment.cache_size must be at least 1")
+	}
+	if c.FailureThreshold < 1 {
+		return fmt.Errorf("enrichment.failure_threshold must be at least 1")
+	}
+	if c.Cooldown.Duration <= 0 {
+		return fmt.Errorf("enrichment.cooldown must be positive")
+	}
+	return nil
+}
+
+// enrichmentEntry is a cached lookup result (nil fields: not found)
+type enrichmentEntry struct {
+	fields  map[string]string
+	expires time.Time
+}
+
+// Enricher adds context from an external HTTP service to events, such as
+// an asset's owner or a user's department. Lookups are cached, and after
+// repeated failures a circuit breaker stops calling the service for a
+// cooldown, so a slow or broken service costs at most one timeout per
+// cooldown rather than one per event.
+type Enricher struct {
+	cfg    EnrichmentConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]enrichmentEntry
+
+	// Circuit breaker: open until openUntil after failures in a row; once
+	// it passes, one trial call (probing) decides whether to close again
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewEnricher creates an enricher with an empty cache
+func NewEnricher(cfg EnrichmentConfig) *Enricher {
+	return &Enricher{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration},
+		cache:  make(map[string]enrichmentEntry),
+	}
+}
+
+// key returns the event value looked up
+func (e *Enricher) key(event *SecurityEvent) string {
+	switch e.cfg.KeyField {
+	case "user":
+		return event.User
+	case "source_ip":
+		return event.SourceIP
+	case "source":
+		return event.Source
+	}
+	return event.Metadata[strings.TrimPrefix(e.cfg.KeyField, "metadata.")]
+}
+
+// Enrich sets the mapped response fields in the event's metadata. Any
+// failure leaves them unset; the event goes on to detection regardless.
+func (e *Enricher) Enrich(ctx context.Context, event *SecurityEvent) {
+	key := e.key(event)
+	if key == "" {
+		return
+	}
+
+	fields, ok := e.cached(key)
+	if !ok {
+		if !e.allow() {
+			enrichmentLookups.WithLabelValues("circuit_open").Inc()
+			return
+		}
+		var err error
+		fields, err = e.fetch(ctx, key)
+		e.record(err)
+		if err != nil {
+			enrichmentLookups.WithLabelValues("failure").Inc()
+			return
+		}
+		ttl := e.cfg.CacheTTL.Duration
+		if fields == nil {
+			ttl = e.cfg.NegativeTTL.Duration
+		}
+		e.store(key, fields, ttl)
+	}
+	if fields == nil {
+		return
+	}
+
+	if event.Metadata == nil {
+		event.Metadata = make(map[string]string)
+	}
+	event.enriched = fields
+	for k, v := range fields {
+		event.Metadata[k] = v
+	}
+}
+
+// cached returns a live cache entry
+func (e *Enricher) cached(key string) (map[string]string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry, ok := e.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	enrichmentLookups.WithLabelValues("cached").Inc()
+	return entry.fields, true
+}
+
+// store caches a result, clearing expired entries (or, failing that, an
+// arbitrary one) when the cache is full
+func (e *Enricher) store(key string, fields map[string]string, ttl time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.cache) >= e.cfg.CacheSize {
+		now := time.Now()
+		for k, entry := range e.cache {
+			if now.After(entry.expires) {
+				delete(e.cache, k)
+			}
+		}
+		for k := range e.cache {
+			if len(e.cache) < e.cfg.CacheSize {
+				break
+			}
+			delete(e.cache, k)
+		}
+	}
+	e.cache[key] = enrichmentEntry{fields: fields, expires: time.Now().Add(ttl)}
+}
+
+// allow reports whether a call may go to the service now
+func (e *Enricher) allow() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failures < e.cfg.FailureThreshold {
+		return true
+	}
+	if time.Now().Before(e.openUntil) || e.probing {
+		return false
+	}
+	e.probing = true
+	return true
+}
+
+// record updates the breaker with a call's outcome
+func (e *Enricher) record(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.probing = false
+	if err == nil {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures >= e.cfg.FailureThreshold {
+		e.openUntil = time.Now().Add(e.cfg.Cooldown.Duration)
+		if e.failures == e.cfg.FailureThreshold {
+			enrichmentCircuitOpens.Inc()
+		}
+	}
+}
+
+// fetch calls the service. A 404 means the key is unknown: nil fields and
+// no error, so it is cached briefly and doesn't trip the breaker.
+func (e *Enricher) fetch(ctx context.Context, key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout.Duration)
+	defer cancel()
+
+	u := strings.ReplaceAll(e.cfg.URL, "{key}", url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, resp.Body)
+		enrichmentLookups.WithLabelValues("not_found").Inc()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	enrichmentLookups.WithLabelValues("success").Inc()
+
+	fields := make(map[string]string)
+	for field, path := range e.cfg.Fields {
+		if v, ok := jsonPath(body, path); ok {
+			fields[field] = v
+		}
+	}
+	return fields, nil
+}
+
+// jsonLookup follows a dotted path ("owner.email") through decoded JSON
+func jsonLookup(v interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonPath renders the value at a dotted path in decoded JSON. Objects and
+// arrays are rendered as JSON.
+func jsonPath(v interface{}, path string) (string, bool) {
+	v, ok := jsonLookup(v, path)
+	if !ok {
+		return "", false
+	}
+	return jsonText(v)
+}
+
+// jsonText renders a decoded JSON value as text
+func jsonText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64, bool:
+		return fmt.Sprint(v), true
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
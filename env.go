@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// envPrefix namespaces the environment variables that override config
+const envPrefix = "SBLA_"
+
+// envAliases are shorter names for commonly overridden settings
+var envAliases = map[string]string{
+	"BRUTEFORCE_THRESHOLD":      "THRESHOLDS_BRUTE_FORCE",
+	"SUSPICIOUS_USER_THRESHOLD": "THRESHOLDS_SUSPICIOUS_USER",
+	"PASSWORD_CHANGE_THRESHOLD": "THRESHOLDS_PASSWORD_CHANGE",
+	"EXFIL_BYTES_THRESHOLD":     "THRESHOLDS_EXFIL_BYTES",
+	"PAGERDUTY_ROUTING_KEY":     "SINKS_PAGERDUTY_ROUTING_KEY",
+}
+
+// secretPaths are config values never logged or served in clear text
+var secretPaths = map[string]bool{
+	"redis_password":              true,
+	"standby.password":            true,
+	"sinks.pagerduty.routing_key": true,
+	"sinks.stix.taxii_token":      true,
+	"test_alert.token":            true,
+	"feedback.token":              true,
+	"pause.token":                 true,
+	"alert_store.token":           true,
+	"middleware.hash_key":         true,
+	"privacy.salt":                true,
+	"enrichment.token":            true,
+}
+
+// applyEnvOverrides sets config values from SBLA_* environment variables.
+// The name is the JSON path upper-cased with "_" between levels
+// (SBLA_THRESHOLDS_BRUTE_FORCE -> thresholds.brute_force). A "_FILE" suffix
+// reads the value from a file, for secrets mounted into the container.
+// It returns the config path each variable set, keyed by variable name.
+func applyEnvOverrides(cfg *DetectorConfig, environ []string) (map[string]string, error) {
+	applied := make(map[string]string)
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, envPrefix)
+		if alias, ok := envAliases[key]; ok {
+			key = alias
+		}
+
+		field, path, ok := lookupEnvField(reflect.ValueOf(cfg).Elem(), key)
+		if !ok && strings.HasSuffix(key, "_FILE") {
+			// No field of that name: treat it as a file reference
+			if field, path, ok = lookupEnvField(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(key, "_FILE")); ok {
+				data, err := os.ReadFile(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				value = strings.TrimSpace(string(data))
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s does not match any config setting", name)
+		}
+
+		if err := setFromEnv(field, value); err != nil {
+			return nil, fmt.Errorf("%s (%s): %w", name, path, err)
+		}
+		applied[name] = path
+	}
+	return applied, nil
+}
+
+// lookupEnvField finds the field an upper-cased, "_"-joined path names
+func lookupEnvField(v reflect.Value, key string) (reflect.Value, string, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		upper := strings.ToUpper(tag)
+
+		if key == upper {
+			return v.Field(i), tag, true
+		}
+		if strings.HasPrefix(key, upper+"_") && sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(Duration{}) {
+			if field, path, ok := lookupEnvField(v.Field(i), strings.TrimPrefix(key, upper+"_")); ok {
+				return field, tag + "." + path, true
+			}
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// setFromEnv decodes an environment value into a field. Values are read as
+// JSON where possible (numbers, bools, arrays, objects); otherwise as a
+// string, and a comma-separated list for string slices.
+func setFromEnv(field reflect.Value, value string) error {
+	target := field.Addr().Interface()
+
+	if json.Unmarshal([]byte(value), target) == nil {
+		return nil
+	}
+
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+		parts := strings.Split(value, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		field.Set(reflect.ValueOf(parts))
+		return nil
+	}
+
+	quoted, _ := json.Marshal(value)
+	return json.Unmarshal(quoted, target)
+}
+
+// logConfigSources logs where the config came from, without secret values
+func logConfigSources(path string, fileKeys []string, env map[string]string) {
+	if path == "" {
+		log.Println("Config: built-in defaults")
+	} else {
+		sort.Strings(fileKeys)
+		log.Printf("Config: defaults, overridden by %s for %s", path, strings.Join(fileKeys, ", "))
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		note := ""
+		if secretPaths[env[name]] {
+			note = " (secret)"
+		}
+		log.Printf("Config: %s from env %s%s", env[name], name, note)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validate checks the environment mapping and policies, and lowercases names
+func (c *EnvironmentConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	c.Default = strings.ToLower(c.Default)
+	for i := range c.Mapping {
+		m := &c.Mapping[i]
+		if m.Environment == "" || len(m.Sources) == 0 {
+			return fmt.Errorf("environment.mapping[%d]: needs an environment and sources", i)
+		}
+		m.Environment = strings.ToLower(m.Environment)
+		if err := validatePatterns(m.Sources); err != nil {
+			return fmt.Errorf("environment.mapping[%d].sources: %w", i, err)
+		}
+	}
+	policies := make(map[string]EnvironmentPolicy, len(c.Policies))
+	for name, p := range c.Policies {
+		if p.MaxSeverity != "" && severityRank(p.MaxSeverity) < 0 {
+			return fmt.Errorf("environment.policies.%s.max_severity %q is not a severity", name, p.MaxSeverity)
+		}
+		for _, sink := range p.Sinks {
+			if !containsString(sinkNames, sink) {
+				return fmt.Errorf("environment.policies.%s.sinks: unknown sink %q (want one of %s)", name, sink, strings.Join(sinkNames, ", "))
+			}
+		}
+		policies[strings.ToLower(name)] = p
+	}
+	c.Policies = policies
+	return nil
+}
+
+// of names the event's environment: the field if the event sets it, else
+// the first mapping whose sources match event.Source, else the default
+func (c *EnvironmentConfig) of(event SecurityEvent) string {
+	if !c.Enabled {
+		return ""
+	}
+	if env := event.Metadata[c.Field]; c.Field != "" && env != "" {
+		return strings.ToLower(env)
+	}
+	for _, m := range c.Mapping {
+		if matchAny(m.Sources, event.Source) {
+			return m.Environment
+		}
+	}
+	return c.Default
+}
+
+// policy returns the environment's policy (the zero policy if it has none)
+func (c *EnvironmentConfig) policy(env string) EnvironmentPolicy {
+	if !c.Enabled || env == "" {
+		return EnvironmentPolicy{}
+	}
+	return c.Policies[env]
+}
+
+// capSeverity lowers a severity to the environment's max_severity
+func (p EnvironmentPolicy) capSeverity(severity string) string {
+	if p.MaxSeverity != "" && severityRank(severity) > severityRank(p.MaxSeverity) {
+		return p.MaxSeverity
+	}
+	return severity
+}
+
+// routesTo reports whether the alert's environment lets a sink receive it
+func (c *EnvironmentConfig) routesTo(alert ThreatAlert, sink string) bool {
+	p := c.policy(alert.Environment)
+	return len(p.Sinks) == 0 || containsString(p.Sinks, sink)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseError is returned when a message can't be decoded into a SecurityEvent
+type ParseError struct {
+	Partition int
+	Offset    int64
+	StreamID  string // set instead of Partition/Offset for Redis Stream input
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	if e.StreamID != "" {
+		return fmt.Sprintf("parse event (stream id %s): %v", e.StreamID, e.Err)
+	}
+	return fmt.Sprintf("parse event (partition %d, offset %d): %v", e.Partition, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ClockSkewError is returned when an event's timestamp is further in the
+// future than the clock skew tolerance allows
+type ClockSkewError struct {
+	Timestamp time.Time
+	Skew      time.Duration
+}
+
+func (e *ClockSkewError) Error() string {
+	return fmt.Sprintf("timestamp %s is %s in the future", e.Timestamp.Format(time.RFC3339), e.Skew.Round(time.Second))
+}
+
+// StateError is returned when a StateStore operation fails
+type StateError struct {
+	Op  string // e.g. "incr", "get"
+	Key string
+	Err error
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("state %s %s: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *StateError) Unwrap() error { return e.Err }
+
+// PublishError is returned when an alert can't be serialized or written
+type PublishError struct {
+	AlertID   string
+	Topic     string
+	Err       error
+	Retryable bool // false for errors a retry can't fix (e.g. marshaling)
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("publish alert %s to %s: %v", e.AlertID, e.Topic, e.Err)
+}
+
+func (e *PublishError) Unwrap() error { return e.Err }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvidenceRequirement holds a rule's alerts back until enough evidence has
+// accumulated for the same source within Window
+type EvidenceRequirement struct {
+	MinEvents  int      `json:"min_events"`  // detections by this rule
+	MinSignals int      `json:"min_signals"` // distinct threat types, this one included
+	Window     Duration `json:"window"`
+}
+
+// evidenceSubject identifies whose evidence is pooled: the source IP, or the
+// user for events without one
+func evidenceSubject(event SecurityEvent) string {
+	if event.SourceIP != "" {
+		return event.ipKey()
+	}
+	return "user:" + event.User
+}
+
+// recordSignal notes that a threat type was detected for the event's subject
+func (td *ThreatDetector) recordSignal(ctx context.Context, event SecurityEvent, threatType string) error {
+	if td.cfg().evidenceWindow == 0 {
+		return nil
+	}
+	key := stateKey(event, "signals", evidenceSubject(event))
+	entry := strconv.FormatInt(td.now().Unix(), 10) + ":" + threatType
+	if err := td.state.AppendList(ctx, key, entry, correlationMaxEntries, td.cfg().evidenceWindow); err != nil {
+		return &StateError{Op: "rpush", Key: key, Err: err}
+	}
+	return nil
+}
+
+// meetsEvidence reports whether an alert has the evidence its rule requires.
+// Rules without a requirement always pass.
+func (td *ThreatDetector) meetsEvidence(ctx context.Context, event SecurityEvent, alert ThreatAlert) (bool, error) {
+	req, ok := td.cfg().Evidence[alert.ThreatType]
+	if !ok {
+		return true, nil
+	}
+	subject := evidenceSubject(event)
+
+	if req.MinEvents > 1 {
+		key := stateKey(event, "evidence:"+alert.ThreatType, subject)
+		count, err := td.state.Incr(ctx, key, req.Window.Duration)
+		if err != nil {
+			return true, &StateError{Op: "incr", Key: key, Err: err}
+		}
+		if count < int64(req.MinEvents) {
+			return false, nil
+		}
+	}
+
+	if req.MinSignals > 1 {
+		key := stateKey(event, "signals", subject)
+		raw, err := td.state.ListRange(ctx, key)
+		if err != nil {
+			return true, &StateError{Op: "lrange", Key: key, Err: err}
+		}
+		cutoff := td.now().Add(-req.Window.Duration).Unix()
+		types := make(map[string]bool)
+		for _, r := range raw {
+			ts, threatType, ok := strings.Cut(r, ":")
+			if !ok {
+				continue
+			}
+			if unix, err := strconv.ParseInt(ts, 10, 64); err == nil && unix >= cutoff {
+				types[threatType] = true
+			}
+		}
+		if len(types) < req.MinSignals {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// validateEvidence checks every requirement and sizes the signals buffer
+func (c *DetectorConfig) validateEvidence() error {
+	c.evidenceWindow = 0
+	for threatType, req := range c.Evidence {
+		if req.MinEvents < 0 || req.MinSignals < 0 {
+			return fmt.Errorf("evidence.%s: minimums must not be negative", threatType)
+		}
+		if req.Window.Duration <= 0 {
+			return fmt.Errorf("evidence.%s: window must be positive", threatType)
+		}
+		if req.MinSignals > 1 && req.Window.Duration > c.evidenceWindow {
+			c.evidenceWindow = req.Window.Duration
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feedback verdicts accepted by POST /feedback
+const (
+	verdictFalsePositive = "false_positive"
+	verdictTruePositive  = "true_positive"
+)
+
+// muteHistoryLength bounds the audit records kept per source
+const muteHistoryLength = 50
+
+// errUnknownAlert is returned for feedback on an alert the analyzer has no
+// record of, or whose record has expired
+var errUnknownAlert = errors.New("unknown or expired alert")
+
+// FeedbackRequest is an analyst's verdict on one alert. The source it counts
+// against is the one the alert was raised for, not anything the client says.
+type FeedbackRequest struct {
+	AlertID  string `json:"alert_id"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Verdict  string `json:"verdict"` // false_positive or true_positive
+	Analyst  string `json:"analyst,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// MuteRecord is stored while a source is muted, and appended to the source's
+// mute history as its audit entry
+type MuteRecord struct {
+	SourceIP       string    `json:"source_ip"`
+	TenantID       string    `json:"tenant_id,omitempty"`
+	MutedAt        time.Time `json:"muted_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Alerts         int64     `json:"alerts"`
+	FalsePositives int64     `json:"false_positives"`
+	Ratio          float64   `json:"ratio"`
+	LastAlertID    string    `json:"last_alert_id,omitempty"`
+	Analyst        string    `json:"analyst,omitempty"`
+}
+
+// feedbackEvent builds the event whose tenant and IP scope the feedback keys
+func feedbackEvent(sourceIP, tenantID string) SecurityEvent {
+	event := SecurityEvent{SourceIP: sourceIP}
+	if tenantID != "" {
+		event.Metadata = map[string]string{"tenant_id": tenantID}
+	}
+	event.normalize()
+	return event
+}
+
+// isAutoMuted reports whether the event's source is muted for false positives
+func (td *ThreatDetector) isAutoMuted(ctx context.Context, event SecurityEvent) (bool, error) {
+	if !td.cfg().Feedback.AutoMute.Enabled || event.SourceIP == "" {
+		return false, nil
+	}
+	key := stateKey(event, "fp_muted", event.ipKey())
+	muted, err := td.state.Exists(ctx, key)
+	if err != nil {
+		return false, &StateError{Op: "exists", Key: key, Err: err}
+	}
+	return muted, nil
+}
+
+// countAlertForFeedback counts an alert against its source, the denominator
+// of the false-positive ratio, and remembers which source the alert was
+// raised for so verdicts on it count against that source
+func (td *ThreatDetector) countAlertForFeedback(ctx context.Context, event SecurityEvent, alert ThreatAlert) error {
+	cfg := td.cfg().Feedback.AutoMute
+	if !cfg.Enabled || event.SourceIP == "" {
+		return nil
+	}
+	key := stateKey(event, "fp_alerts", event.ipKey())
+	if _, err := td.state.Incr(ctx, key, cfg.Window.Duration); err != nil {
+		return &StateError{Op: "incr", Key: key, Err: err}
+	}
+	alertKey := stateKey(event, "fp_alert", alert.AlertID)
+	if err := td.state.Set(ctx, alertKey, event.SourceIP, cfg.Window.Duration); err != nil {
+		return &StateError{Op: "set", Key: alertKey, Err: err}
+	}
+	return nil
+}
+
+// recordFeedback counts a false-positive verdict against the source the alert
+// was raised for and mutes the source once enough of its alerts were false
+// positives. Only the first verdict on an alert counts. It returns the new
+// mute, if any, or errUnknownAlert if the alert isn't known.
+func (td *ThreatDetector) recordFeedback(ctx context.Context, req FeedbackRequest) (*MuteRecord, error) {
+	cfg := td.cfg().Feedback.AutoMute
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	scope := feedbackEvent("", req.TenantID)
+	alertKey := stateKey(scope, "fp_alert", req.AlertID)
+	sourceIP, err := td.state.Get(ctx, alertKey)
+	if err != nil {
+		return nil, &StateError{Op: "get", Key: alertKey, Err: err}
+	}
+	if sourceIP == "" {
+		return nil, errUnknownAlert
+	}
+
+	verdictKey := stateKey(scope, "fp_verdict", req.AlertID)
+	first, err := td.state.SetIfAbsent(ctx, verdictKey, req.Verdict, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "setnx", Key: verdictKey, Err: err}
+	}
+	if !first || req.Verdict != verdictFalsePositive {
+		return nil, nil
+	}
+	event := feedbackEvent(sourceIP, req.TenantID)
+
+	fpKey := stateKey(event, "fp_marked", event.ipKey())
+	falsePositives, err := td.state.Incr(ctx, fpKey, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "incr", Key: fpKey, Err: err}
+	}
+
+	alertsKey := stateKey(event, "fp_alerts", event.ipKey())
+	raw, err := td.state.Get(ctx, alertsKey)
+	if err != nil {
+		return nil, &StateError{Op: "get", Key: alertsKey, Err: err}
+	}
+	alerts, _ := strconv.ParseInt(raw, 10, 64)
+	if alerts < int64(cfg.MinSamples) {
+		return nil, nil
+	}
+	ratio := float64(falsePositives) / float64(alerts)
+	if ratio < cfg.Ratio {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	record := &MuteRecord{
+		SourceIP:       event.SourceIP,
+		TenantID:       req.TenantID,
+		MutedAt:        now,
+		ExpiresAt:      now.Add(cfg.Duration.Duration),
+		Alerts:         alerts,
+		FalsePositives: falsePositives,
+		Ratio:          ratio,
+		LastAlertID:    req.AlertID,
+		Analyst:        req.Analyst,
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	muteKey := stateKey(event, "fp_muted", event.ipKey())
+	muted, err := td.state.SetIfAbsent(ctx, muteKey, string(data), cfg.Duration.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "setnx", Key: muteKey, Err: err}
+	}
+	if !muted {
+		return nil, nil // already muted
+	}
+
+	// The mute expires with its duration; its audit entry outlives it
+	historyKey := stateKey(event, "fp_mute_history", event.ipKey())
+	if err := td.state.AppendList(ctx, historyKey, string(data), muteHistoryLength, cfg.AuditRetention.Duration); err != nil {
+		log.Printf("Auto-mute: recording the audit entry for %s: %v", event.SourceIP, err)
+	}
+
+	// The source re-learns from scratch when the mute expires
+	for _, key := range []string{fpKey, alertsKey} {
+		if err := td.state.Delete(ctx, key); err != nil {
+			log.Printf("Auto-mute: resetting %s: %v", key, err)
+		}
+	}
+
+	autoMutes.Inc()
+	log.Printf("Auto-muted %s until %s: %d of %d alerts marked false positive (last alert %s)",
+		event.SourceIP, record.ExpiresAt.Format(time.RFC3339), falsePositives, alerts, req.AlertID)
+	return record, nil
+}
+
+// activeMutes lists the sources currently muted, with their audit records
+func (td *ThreatDetector) activeMutes(ctx context.Context) ([]MuteRecord, error) {
+	keys, err := td.state.ScanKeys(ctx, "*fp_muted:*")
+	if err != nil {
+		return nil, &StateError{Op: "scan", Key: "*fp_muted:*", Err: err}
+	}
+	mutes := []MuteRecord{}
+	for _, key := range keys {
+		raw, err := td.state.Get(ctx, key)
+		if err != nil {
+			return nil, &StateError{Op: "get", Key: key, Err: err}
+		}
+		var record MuteRecord
+		if raw == "" || json.Unmarshal([]byte(raw), &record) != nil {
+			continue // expired since the scan, or not ours
+		}
+		mutes = append(mutes, record)
+	}
+	return mutes, nil
+}
+
+// muteHistory returns a source's past and current mutes, oldest first
+func (td *ThreatDetector) muteHistory(ctx context.Context, sourceIP, tenantID string) ([]MuteRecord, error) {
+	event := feedbackEvent(sourceIP, tenantID)
+	key := stateKey(event, "fp_mute_history", event.ipKey())
+	entries, err := td.state.ListRange(ctx, key)
+	if err != nil {
+		return nil, &StateError{Op: "lrange", Key: key, Err: err}
+	}
+	history := []MuteRecord{}
+	for _, entry := range entries {
+		var record MuteRecord
+		if json.Unmarshal([]byte(entry), &record) == nil {
+			history = append(history, record)
+		}
+	}
+	return history, nil
+}
+
+// feedbackResponse acknowledges a verdict
+type feedbackResponse struct {
+	Accepted bool        `json:"accepted"`
+	Muted    *MuteRecord `json:"muted,omitempty"` // set when this verdict muted the source
+}
+
+// handleFeedback serves POST /feedback (record a verdict), GET /feedback
+// (list active auto-mutes) and GET /feedback?source_ip= (a source's mute
+// history)
+func (td *ThreatDetector) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	if !bearerTokenOK(r, td.cfg().Feedback.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var mutes []MuteRecord
+		var err error
+		if sourceIP := r.URL.Query().Get("source_ip"); sourceIP != "" {
+			if _, ok := parseIP(sourceIP); !ok {
+				http.Error(w, fmt.Sprintf("invalid source_ip %q", sourceIP), http.StatusBadRequest)
+				return
+			}
+			mutes, err = td.muteHistory(r.Context(), sourceIP, r.URL.Query().Get("tenant_id"))
+		} else {
+			mutes, err = td.activeMutes(r.Context())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, mutes)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Verdict = strings.ToLower(req.Verdict)
+	if req.Verdict != verdictFalsePositive && req.Verdict != verdictTruePositive {
+		http.Error(w, fmt.Sprintf("verdict must be %q or %q", verdictFalsePositive, verdictTruePositive), http.StatusBadRequest)
+		return
+	}
+	if req.AlertID == "" {
+		http.Error(w, "alert_id is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Feedback: alert %s marked %s by %q", req.AlertID, req.Verdict, req.Analyst)
+
+	muted, err := td.recordFeedback(r.Context(), req)
+	if errors.Is(err, errUnknownAlert) {
+		http.Error(w, fmt.Sprintf("alert %s: %v", req.AlertID, err), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		processingErrors.WithLabelValues("state").Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, feedbackResponse{Accepted: true, Muted: muted})
+}
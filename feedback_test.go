@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFeedbackAutoMute(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Feedback.AutoMute = AutoMuteConfig{
+		Enabled:        true,
+		Window:         Duration{time.Hour},
+		MinSamples:     2,
+		Ratio:          1,
+		Duration:       Duration{time.Hour},
+		AuditRetention: Duration{24 * time.Hour},
+	}
+	td, clock := newClockedDetector(t, cfg)
+	ctx := context.Background()
+
+	noisy := SecurityEvent{SourceIP: "203.0.113.7", User: "alice"}
+	quiet := SecurityEvent{SourceIP: "198.51.100.9", User: "bob"}
+	for _, raised := range []struct {
+		event SecurityEvent
+		id    string
+	}{{noisy, "BF-1"}, {noisy, "BF-2"}, {quiet, "BF-3"}} {
+		td.raiseAlert(ctx, raised.event, ThreatAlert{AlertID: raised.id, ThreatType: "BRUTE_FORCE"})
+	}
+	drainAlerts(td)
+
+	verdict := func(alertID string) (*MuteRecord, error) {
+		return td.recordFeedback(ctx, FeedbackRequest{AlertID: alertID, Verdict: verdictFalsePositive})
+	}
+	if _, err := verdict("BF-9"); !errors.Is(err, errUnknownAlert) {
+		t.Fatalf("verdict on an unknown alert: err %v, want errUnknownAlert", err)
+	}
+
+	// Repeating a verdict doesn't count it twice
+	for i := 0; i < 2; i++ {
+		if muted, err := verdict("BF-1"); err != nil || muted != nil {
+			t.Fatalf("verdict %d on BF-1 = %+v, %v; want no mute", i+1, muted, err)
+		}
+	}
+
+	muted, err := verdict("BF-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if muted == nil || muted.SourceIP != noisy.SourceIP || muted.FalsePositives != 2 {
+		t.Fatalf("second false positive muted %+v, want %s with 2 false positives", muted, noisy.SourceIP)
+	}
+	if mutes, _ := td.activeMutes(ctx); len(mutes) != 1 {
+		t.Fatalf("active mutes %+v, want one", mutes)
+	}
+
+	// The audit record outlives the mute
+	clock.advance(2 * time.Hour)
+	if mutes, _ := td.activeMutes(ctx); len(mutes) != 0 {
+		t.Errorf("mute still active after its duration: %+v", mutes)
+	}
+	history, err := td.muteHistory(ctx, noisy.SourceIP, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].LastAlertID != "BF-2" {
+		t.Errorf("mute history %+v, want the BF-2 mute", history)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fuzzyDedupFields are the alert fields fuzzy dedup can group by
+var fuzzyDedupFields = []string{"threat_type", "source_ip", "user", "details"}
+
+// fuzzyCountRetention is how long a closed group's duplicate count waits
+// for the group's next alert to report it
+const fuzzyCountRetention = 24 * time.Hour
+
+// validate checks the window and grouping fields
+func (c *FuzzyDedupConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("fuzzy_dedup.window must be positive")
+	}
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("fuzzy_dedup.fields is required when enabled")
+	}
+	for _, f := range c.Fields {
+		if !containsString(fuzzyDedupFields, f) {
+			return fmt.Errorf("fuzzy_dedup.fields: unknown field %q (want %s)", f, strings.Join(fuzzyDedupFields, ", "))
+		}
+	}
+	return nil
+}
+
+// variableParts matches what changes between alerts of one attack: long
+// hex IDs and runs of digits (counts, addresses, times)
+var variableParts = regexp.MustCompile(`\b[0-9a-f]{8,}\b|[0-9]+`)
+
+// normalizeDetails reduces alert details to their shape: lowercased, with
+// variable parts as "#" and whitespace collapsed
+func normalizeDetails(details string) string {
+	s := variableParts.ReplaceAllString(strings.ToLower(details), "#")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// fuzzyGroup hashes the alert's grouping fields
+func fuzzyGroup(alert ThreatAlert, fields []string) string {
+	h := fnv.New64a()
+	for _, f := range fields {
+		switch f {
+		case "threat_type":
+			h.Write([]byte(alert.ThreatType))
+		case "source_ip":
+			h.Write([]byte(alert.SourceIP))
+		case "user":
+			h.Write([]byte(strings.ToLower(alert.User)))
+		case "details":
+			h.Write([]byte(normalizeDetails(alert.Details)))
+		}
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// collapseDuplicate checks the alert against its group. The first alert of
+// a window claims the group and goes out; it returns the ID of that first
+// alert for a duplicate, which is counted. The group's next alert after the
+// window reports the count in metadata.duplicates_collapsed.
+func (td *ThreatDetector) collapseDuplicate(ctx context.Context, event SecurityEvent, alert *ThreatAlert) (first, key string, err error) {
+	cfg := td.cfg().FuzzyDedup
+	if !cfg.Enabled {
+		return "", "", nil
+	}
+	group := fuzzyGroup(*alert, cfg.Fields)
+	key = stateKey(event, "fuzzy_dedup", group)
+	countKey := stateKey(event, "fuzzy_dedup_count", group)
+
+	claimed, err := td.state.SetIfAbsent(ctx, key, alert.AlertID, cfg.Window.Duration)
+	if err != nil {
+		return "", "", &StateError{Op: "setnx", Key: key, Err: err}
+	}
+	if !claimed {
+		if first, err = td.state.Get(ctx, key); err != nil {
+			return "", "", &StateError{Op: "get", Key: key, Err: err}
+		}
+		if first == "" {
+			first = "an expired alert"
+		}
+		if _, err := td.state.Incr(ctx, countKey, cfg.Window.Duration+fuzzyCountRetention); err != nil {
+			return "", "", &StateError{Op: "incr", Key: countKey, Err: err}
+		}
+		return first, key, nil
+	}
+
+	collapsed, err := td.state.Get(ctx, countKey)
+	if err != nil {
+		return "", "", &StateError{Op: "get", Key: countKey, Err: err}
+	}
+	if alert.Metadata == nil {
+		alert.Metadata = make(map[string]string)
+	}
+	alert.Metadata["dedup_group"] = group
+	if collapsed != "" {
+		alert.Metadata["duplicates_collapsed"] = collapsed
+		if err := td.state.Delete(ctx, countKey); err != nil {
+			return "", "", &StateError{Op: "del", Key: countKey, Err: err}
+		}
+	}
+	return "", "", nil
+}
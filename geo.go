@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// geoListMax caps the countries remembered per user or host
+const geoListMax = 50
+
+// geoGroupings are the subjects geo_distributed can count countries for
+var geoGroupings = map[string]func(SecurityEvent) string{
+	"user":   func(e SecurityEvent) string { return strings.ToLower(e.User) },
+	"source": func(e SecurityEvent) string { return strings.ToLower(e.Source) },
+}
+
+// validate checks the geo-distributed attack settings
+func (c *GeoDistributedConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CountryField == "" {
+		return fmt.Errorf("geo_distributed.country_field is required when enabled")
+	}
+	if len(c.GroupBy) == 0 {
+		return fmt.Errorf("geo_distributed.group_by is required when enabled")
+	}
+	for _, by := range c.GroupBy {
+		if geoGroupings[by] == nil {
+			return fmt.Errorf("geo_distributed.group_by: unknown field %q (want user or source)", by)
+		}
+	}
+	if c.Threshold < 2 || c.Threshold > geoListMax {
+		return fmt.Errorf("geo_distributed.threshold must be between 2 and %d", geoListMax)
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("geo_distributed.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("geo_distributed.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// geoHit is a user or host whose failed logins came from too many countries
+type geoHit struct {
+	by        string // "user" or "source"
+	subject   string
+	countries []string
+	key       string
+}
+
+// isGeoDistributedAttack records the country of each failed login per user
+// and/or target host. It returns a hit for every subject a new country takes
+// to the threshold or beyond. Events without a country (no GeoIP data for
+// the IP, or enrichment unavailable) are skipped.
+func (td *ThreatDetector) isGeoDistributedAttack(ctx context.Context, event SecurityEvent) ([]geoHit, error) {
+	cfg := td.cfg().GeoDistributed
+	if !cfg.Enabled || event.eventTypeLower != "authentication" || event.Result != "failed" {
+		return nil, nil
+	}
+	country := strings.ToUpper(strings.TrimSpace(event.Metadata[cfg.CountryField]))
+	if country == "" {
+		return nil, nil
+	}
+
+	var hits []geoHit
+	for _, by := range cfg.GroupBy {
+		subject := geoGroupings[by](event)
+		if subject == "" {
+			continue
+		}
+		id := by + ":" + subject
+
+		// Each country is listed once, however often it fails
+		seenKey := stateKey(event, "geo_country", id+":"+country)
+		first, err := td.state.SetIfAbsent(ctx, seenKey, "1", cfg.Window.Duration)
+		if err != nil {
+			return hits, &StateError{Op: "setnx", Key: seenKey, Err: err}
+		}
+		if !first {
+			continue
+		}
+		key := stateKey(event, "geo_countries", id)
+		if err := td.state.AppendList(ctx, key, country, geoListMax, cfg.Window.Duration); err != nil {
+			return hits, &StateError{Op: "rpush", Key: key, Err: err}
+		}
+		countries, err := td.state.ListRange(ctx, key)
+		if err != nil {
+			return hits, &StateError{Op: "lrange", Key: key, Err: err}
+		}
+		if len(countries) >= cfg.Threshold {
+			hits = append(hits, geoHit{by: by, subject: subject, countries: countries, key: key})
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Geofence policies for logins whose country is unknown
+const (
+	geofenceAllow = "allow"
+	geofenceFlag  = "flag"
+)
+
+// validate checks the geofence settings against the configured user groups
+// and uppercases the country codes
+func (c *GeofenceConfig) validate(userGroups map[string][]string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CountryField == "" {
+		return fmt.Errorf("geofence.country_field is required when enabled")
+	}
+	if err := normalizeCountries("geofence.allow", c.Allow); err != nil {
+		return err
+	}
+	if err := normalizeCountries("geofence.deny", c.Deny); err != nil {
+		return err
+	}
+	fenced := len(c.Allow) > 0 || len(c.Deny) > 0
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if _, ok := userGroups[g.UserGroup]; !ok {
+			return fmt.Errorf("geofence.groups[%d]: unknown user group %q", i, g.UserGroup)
+		}
+		if err := normalizeCountries(fmt.Sprintf("geofence.groups[%d].allow", i), g.Allow); err != nil {
+			return err
+		}
+		if err := normalizeCountries(fmt.Sprintf("geofence.groups[%d].deny", i), g.Deny); err != nil {
+			return err
+		}
+		fenced = fenced || len(g.Allow) > 0 || len(g.Deny) > 0
+	}
+	if !fenced {
+		return fmt.Errorf("geofence: set allow or deny, globally or for a group")
+	}
+	if c.Unresolved != geofenceAllow && c.Unresolved != geofenceFlag {
+		return fmt.Errorf("geofence.unresolved must be %q or %q, got %q", geofenceAllow, geofenceFlag, c.Unresolved)
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("geofence.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("geofence.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// normalizeCountries uppercases ISO 3166-1 alpha-2 codes in place
+func normalizeCountries(setting string, codes []string) error {
+	for i, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return fmt.Errorf("%s: %q is not a two-letter country code", setting, codes[i])
+		}
+		codes[i] = code
+	}
+	return nil
+}
+
+// policyFor returns the allow and deny lists that apply to a user and the
+// policy's name: the first group the user belongs to, else "global"
+func (c *GeofenceConfig) policyFor(userGroups map[string][]string, user string) (allow, deny []string, name string) {
+	for _, g := range c.Groups {
+		if matchAny(userGroups[g.UserGroup], user) {
+			return g.Allow, g.Deny, g.UserGroup
+		}
+	}
+	return c.Allow, c.Deny, "global"
+}
+
+// geofenceHit is a login from outside the countries allowed for the user
+type geofenceHit struct {
+	country string // "" when unresolved
+	policy  string
+	allow   []string
+}
+
+// isGeofenceViolation checks a successful login's country against the
+// user's policy. Logins from internal addresses aren't fenced. Each user
+// (or, without one, source IP) alerts once per country per window.
+func (td *ThreatDetector) isGeofenceViolation(ctx context.Context, event SecurityEvent) (*geofenceHit, error) {
+	c := td.cfg()
+	cfg := c.Geofence
+	if !cfg.Enabled || event.eventTypeLower != "authentication" || event.Result != "success" ||
+		!event.addr.IsValid() || c.SourceZone.zone(event.addr) == zoneInternal {
+		return nil, nil
+	}
+
+	allow, deny, policy := cfg.policyFor(c.UserGroups, event.User)
+	country := strings.ToUpper(strings.TrimSpace(event.Metadata[cfg.CountryField]))
+	switch {
+	case country == "":
+		if cfg.Unresolved != geofenceFlag || (len(allow) == 0 && len(deny) == 0) {
+			return nil, nil
+		}
+	case len(allow) > 0 && !containsString(allow, country):
+	case containsString(deny, country):
+	default:
+		return nil, nil
+	}
+
+	subject := strings.ToLower(event.User)
+	if subject == "" {
+		subject = event.ipKey()
+	}
+	where := country
+	if where == "" {
+		where = "unknown"
+	}
+	key := stateKey(event, "geofence", subject+":"+where)
+	seen, err := td.dedup.SeenRecently(ctx, key, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	return &geofenceHit{country: country, policy: policy, allow: allow}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// EventSummary is the compact form of an event kept in an IP's history
+type EventSummary struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventType string    `json:"event_type"`
+	Action    string    `json:"action,omitempty"`
+	Result    string    `json:"result,omitempty"`
+	User      string    `json:"user,omitempty"`
+}
+
+// recordIPHistory appends the event to its source IP's bounded history
+func (td *ThreatDetector) recordIPHistory(ctx context.Context, event SecurityEvent) error {
+	cfg := td.cfg().IPHistory
+	entry, err := json.Marshal(EventSummary{
+		Timestamp: event.Timestamp,
+		EventType: event.EventType,
+		Action:    event.Action,
+		Result:    event.Result,
+		User:      event.User,
+	})
+	if err != nil {
+		return err
+	}
+
+	key := stateKey(event, "ip_history", event.ipKey())
+	if err := td.state.AppendList(ctx, key, string(entry), int64(cfg.Length), cfg.TTL.Duration); err != nil {
+		return &StateError{Op: "rpush", Key: key, Err: err}
+	}
+	return nil
+}
+
+// ipHistory returns the source IP's recent events, oldest first
+func (td *ThreatDetector) ipHistory(ctx context.Context, event SecurityEvent) ([]EventSummary, error) {
+	key := stateKey(event, "ip_history", event.ipKey())
+	raw, err := td.state.ListRange(ctx, key)
+	if err != nil {
+		return nil, &StateError{Op: "lrange", Key: key, Err: err}
+	}
+
+	history := make([]EventSummary, 0, len(raw))
+	for _, r := range raw {
+		var e EventSummary
+		if json.Unmarshal([]byte(r), &e) == nil {
+			history = append(history, e)
+		}
+	}
+	return history, nil
+}
+
+// wantsIPHistory reports whether an alert should carry its IP's history
+func (c *DetectorConfig) wantsIPHistory(alert ThreatAlert) bool {
+	return c.IPHistory.Enabled && alert.SourceIP != "" &&
+		severityRank(alert.Severity) >= severityRank(c.IPHistory.MinSeverity)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// parseIP parses an address in any common spelling and canonicalizes it so
+// every spelling of the same host shares detection state:
+//   - surrounding brackets and a trailing port are accepted ("[2001:db8::1]:22")
+//   - IPv4-mapped IPv6 ("::ffff:192.0.2.1") becomes plain IPv4
+//   - IPv6 zone IDs ("fe80::1%eth0") are dropped
+//
+// String() of the result is the RFC 5952 compressed, lowercase form.
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		addrPort, portErr := netip.ParseAddrPort(s)
+		if portErr != nil {
+			return netip.Addr{}, false
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// ipKeyPart renders an address for use inside a ':'-delimited Redis key.
+// IPv6 is bracketed so its colons can't be confused with key separators.
+func ipKeyPart(addr netip.Addr) string {
+	if addr.Is6() {
+		return "[" + addr.String() + "]"
+	}
+	return addr.String()
+}
+
+// ipPrefix returns the network containing addr, using v4Bits for IPv4 and
+// v6Bits for IPv6 (e.g. /24 and /64) so subnet aggregation works for both families
+func ipPrefix(addr netip.Addr, v4Bits, v6Bits int) (netip.Prefix, error) {
+	bits := v4Bits
+	if addr.Is6() {
+		bits = v6Bits
+	}
+	return addr.Prefix(bits)
+}
+
+// parsePrefixList parses single IPs and CIDR ranges of either family
+func parsePrefixList(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, ok := parseIP(entry)
+			if !ok {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		// An IPv4-mapped prefix (::ffff:10.0.0.0/104) wouldn't match the
+		// unmapped addresses parseIP produces, so convert it to IPv4
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether any prefix contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Source zones
+const (
+	zoneInternal = "internal"
+	zoneExternal = "external"
+)
+
+// compile parses the internal ranges and checks the severity adjustments
+func (c *SourceZoneConfig) compile() error {
+	var err error
+	if c.internal, err = parsePrefixList(c.InternalCIDRs); err != nil {
+		return fmt.Errorf("source_zone.internal_cidrs: %w", err)
+	}
+	for zone, delta := range c.Severity {
+		if zone != zoneInternal && zone != zoneExternal {
+			return fmt.Errorf("source_zone.severity: zone must be %q or %q, got %q", zoneInternal, zoneExternal, zone)
+		}
+		if delta < -3 || delta > 3 {
+			return fmt.Errorf("source_zone.severity.%s must be between -3 and 3", zone)
+		}
+	}
+	return nil
+}
+
+// zone classifies an address as internal or external ("" if it isn't an IP)
+func (c *SourceZoneConfig) zone(addr netip.Addr) string {
+	switch {
+	case !addr.IsValid():
+		return ""
+	case containsAddr(c.internal, addr):
+		return zoneInternal
+	}
+	return zoneExternal
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		in, want string // want "" means invalid
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{" 192.0.2.1 ", "192.0.2.1"},
+		{"192.0.2.1:22", "192.0.2.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"2001:DB8::1", "2001:db8::1"},
+		{"2001:db8:0:0:0:0:0:1", "2001:db8::1"},
+		{"2001:0db8:0000::0001", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:22", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[fe80::1%eth0]:22", "fe80::1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:22", "192.0.2.1"},
+		{"", ""},
+		{"unknown", ""},
+		{"2001:db8::1::2", ""},
+		{"192.0.2.256", ""},
+	}
+	for _, tt := range tests {
+		addr, ok := parseIP(tt.in)
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("parseIP(%q) = %s, want invalid", tt.in, addr)
+		case tt.want != "" && (!ok || addr.String() != tt.want):
+			t.Errorf("parseIP(%q) = %s, %v; want %s", tt.in, addr, ok, tt.want)
+		}
+	}
+}
+
+func TestIPKeySharedAcrossSpellings(t *testing.T) {
+	groups := map[string][]string{
+		"[2001:db8::1]": {"2001:db8::1", "2001:DB8:0:0::1", "[2001:db8::1]:22", "2001:db8:0:0:0:0:0:1"},
+		"[fe80::1]":     {"fe80::1", "fe80::1%eth0", "FE80::1%25en0"},
+		"192.0.2.1":     {"192.0.2.1", "::ffff:192.0.2.1", "192.0.2.1:4242"},
+	}
+	for want, spellings := range groups {
+		for _, spelling := range spellings {
+			event := SecurityEvent{SourceIP: spelling, Metadata: map[string]string{"tenant_id": "acme"}}
+			event.normalize()
+			if got := event.ipKey(); got != want {
+				t.Errorf("ipKey(%q) = %s, want %s", spelling, got, want)
+			}
+			if got := stateKey(event, "failed_auth", event.ipKey()); got != "tenant:acme:failed_auth:"+want {
+				t.Errorf("stateKey(%q) = %s", spelling, got)
+			}
+		}
+	}
+
+	// Not an IP: left as it came, so the event still has a key
+	event := SecurityEvent{SourceIP: "unknown"}
+	event.normalize()
+	if event.ipKey() != "unknown" || event.SourceIP != "unknown" {
+		t.Errorf("non-IP source became %q (key %q)", event.SourceIP, event.ipKey())
+	}
+}
+
+func TestIPPrefix(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"192.0.2.77", "192.0.2.0/24"},
+		{"::ffff:192.0.2.77", "192.0.2.0/24"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+	}
+	for _, tt := range tests {
+		addr, _ := parseIP(tt.in)
+		prefix, err := ipPrefix(addr, 24, 64)
+		if err != nil || prefix.String() != tt.want {
+			t.Errorf("ipPrefix(%s) = %s, %v; want %s", tt.in, prefix, err, tt.want)
+		}
+	}
+}
+
+func TestParsePrefixList(t *testing.T) {
+	prefixes, err := parsePrefixList([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1", "::ffff:172.16.0.0/108"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]bool{
+		"10.1.2.3":          true,
+		"::ffff:10.1.2.3":   true,
+		"2001:DB8:ffff::1":  true,
+		"2001:db9::1":       false,
+		"192.0.2.1":         true,
+		"192.0.2.1:22":      true,
+		"192.0.2.2":         false,
+		"172.16.5.5":        true,
+		"fe80::1%eth0":      false,
+		"[2001:db8::5]:443": true,
+	} {
+		addr, _ := parseIP(in)
+		if got := containsAddr(prefixes, addr); got != want {
+			t.Errorf("containsAddr(%s) = %v, want %v", in, got, want)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "2001:db8::/129"} {
+		if _, err := parsePrefixList([]string{bad}); err == nil {
+			t.Errorf("parsePrefixList(%q) accepted", bad)
+		}
+	}
+}
+
+// Counters written before IPs were canonicalized are renamed to the
+// bracketed form by schema migration 1
+func TestMigrateIPKeys(t *testing.T) {
+	td := newTestDetector(t, nil)
+	ctx := context.Background()
+	old := map[string]string{
+		"failed_auth:2001:DB8::1":               "3",
+		"tenant:acme:invalid_user:fe80::1%eth0": "2",
+		"failed_auth:::ffff:192.0.2.1":          "4",
+		"failed_auth:198.51.100.9":              "1", // already canonical
+		"tenant:acme:failed_auth:[2001:db8::5]": "6", // already canonical
+		"ip_seen:2001:db8:0:0:0:0:0:7":          "1",
+		"failed_auth:[2001:db8::9]":             "5", // live key...
+		"failed_auth:2001:DB8::9":               "2", // ...wins over the old spelling
+	}
+	for key, value := range old {
+		if err := td.state.Set(ctx, key, value, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := migrateIPKeys(ctx, td.state); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"failed_auth:[2001:db8::1]":             "3",
+		"tenant:acme:invalid_user:[fe80::1]":    "2",
+		"failed_auth:192.0.2.1":                 "4",
+		"failed_auth:198.51.100.9":              "1",
+		"tenant:acme:failed_auth:[2001:db8::5]": "6",
+		"ip_seen:[2001:db8::7]":                 "1",
+		"failed_auth:[2001:db8::9]":             "5",
+	}
+	for key, value := range want {
+		if got, err := td.state.Get(ctx, key); err != nil || got != value {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, value)
+		}
+	}
+	for key := range old {
+		if _, kept := want[key]; kept {
+			continue
+		}
+		if ok, _ := td.state.Exists(ctx, key); ok {
+			t.Errorf("old key %s still present", key)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Learning state: when the period started, that it has been reported, and
+// each rule's peak count per source
+const (
+	learningStartedKey = "learning:started"
+	learningDoneKey    = "learning:done"
+	learningPeaksKey   = "learning:peaks:"
+)
+
+// learningRetention is how long the started and done markers outlive the
+// training period, so a restart neither trains nor reports twice
+const learningRetention = 30 * 24 * time.Hour
+
+// validate checks the learning settings
+func (c *LearningConfig) validate(customRules []CustomRule) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("learning.interval must be at least 1s")
+	}
+	if c.Duration.Duration < c.Interval.Duration {
+		return fmt.Errorf("learning.duration must be at least learning.interval")
+	}
+	if c.Percentile <= 0 || c.Percentile > 100 {
+		return fmt.Errorf("learning.percentile must be above 0 and at most 100, got %g", c.Percentile)
+	}
+	for _, rule := range c.Rules {
+		if _, ok := counterFor(rule, customRules); !ok {
+			return fmt.Errorf("learning.rules: %q is not a counter rule", rule)
+		}
+	}
+	return nil
+}
+
+// rules returns the counter rules to learn: the configured ones, or every
+// built-in and custom counter rule
+func (c *LearningConfig) rules(customRules []CustomRule) []string {
+	if len(c.Rules) > 0 {
+		return c.Rules
+	}
+	rules := mapKeys(scanCounters)
+	sort.Strings(rules)
+	for _, rule := range customRules {
+		rules = append(rules, rule.Name)
+	}
+	return rules
+}
+
+// startLearning resumes the learning period recorded in Redis, or begins
+// one, and starts sampling. Once the period has been reported it does
+// nothing, so alerting is back to normal.
+func (td *ThreatDetector) startLearning() {
+	cfg := td.cfg().Learning
+	ctx := td.ctx
+	done, err := td.state.Exists(ctx, learningDoneKey)
+	if err != nil {
+		log.Printf("Learning: can't read state, not training: %v", err)
+		return
+	}
+	if done {
+		log.Printf("Learning: the training period has already been reported; delete %s to train again", learningDoneKey)
+		return
+	}
+	now := td.now()
+	ttl := cfg.Duration.Duration + learningRetention
+	if _, err := td.state.SetIfAbsent(ctx, learningStartedKey, strconv.FormatInt(now.Unix(), 10), ttl); err != nil {
+		log.Printf("Learning: can't record the start, not training: %v", err)
+		return
+	}
+	started, err := td.learningStarted(ctx)
+	if err != nil {
+		log.Printf("Learning: %v; not training", err)
+		return
+	}
+
+	td.training.Store(true)
+	log.Printf("Learning thresholds until %s (observe only: %t)", started.Add(cfg.Duration.Duration).Format(time.RFC3339), cfg.ObserveOnly)
+	td.wg.Add(1)
+	go td.runLearning()
+}
+
+// learningStarted reads when the learning period started
+func (td *ThreatDetector) learningStarted(ctx context.Context) (time.Time, error) {
+	value, err := td.state.Get(ctx, learningStartedKey)
+	if err != nil {
+		return time.Time{}, &StateError{Op: "get", Key: learningStartedKey, Err: err}
+	}
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad %s %q", learningStartedKey, value)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// runLearning samples the counters every learning.interval and reports at
+// the end of the period, until stop is closed
+func (td *ThreatDetector) runLearning() {
+	defer td.wg.Done()
+
+	ticker := time.NewTicker(td.cfg().Learning.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+			finished, err := td.learnStep(td.ctx)
+			if err != nil {
+				processingErrors.WithLabelValues("state").Inc()
+				log.Printf("Learning: %v", err)
+			}
+			if finished {
+				return
+			}
+		}
+	}
+}
+
+// learnStep samples the counters, or reports once the period is over. It
+// reports whether learning has finished.
+func (td *ThreatDetector) learnStep(ctx context.Context) (bool, error) {
+	c := td.cfg()
+	started, err := td.learningStarted(ctx)
+	if err != nil {
+		return false, err
+	}
+	if td.now().Before(started.Add(c.Learning.Duration.Duration)) {
+		return false, td.sampleCounters(ctx)
+	}
+
+	claimed, err := td.state.SetIfAbsent(ctx, learningDoneKey, strconv.FormatInt(td.now().Unix(), 10), c.Learning.Duration.Duration+learningRetention)
+	if err != nil {
+		return false, &StateError{Op: "setnx", Key: learningDoneKey, Err: err}
+	}
+	td.training.Store(false)
+	if !claimed {
+		// Another replica wrote the report
+		log.Printf("Learning finished; alerting resumes")
+		return true, nil
+	}
+	report, err := td.learningReport(ctx, started)
+	if err != nil {
+		return true, err
+	}
+	td.publishLearningReport(ctx, report)
+	for _, rule := range c.Learning.rules(c.CustomRules) {
+		if err := td.state.Delete(ctx, learningPeaksKey+rule); err != nil {
+			log.Printf("Learning: can't clear the samples of %s: %v", rule, err)
+		}
+	}
+	log.Printf("Learning finished; alerting resumes")
+	return true, nil
+}
+
+// sampleCounters records each source's highest count so far for every
+// learned rule, across tenants
+func (td *ThreatDetector) sampleCounters(ctx context.Context) error {
+	c := td.cfg()
+	for _, rule := range c.Learning.rules(c.CustomRules) {
+		counter, _ := counterFor(rule, c.CustomRules)
+		keys, err := td.scanCounterKeys(ctx, counter.prefix, ScanRule{MinCount: 1})
+		if err != nil {
+			return err
+		}
+		peaksKey := learningPeaksKey + rule
+		peaks, err := td.state.HashGetAll(ctx, peaksKey)
+		if err != nil {
+			return &StateError{Op: "hgetall", Key: peaksKey, Err: err}
+		}
+		for _, k := range keys {
+			source := k.tenant + "/" + k.subject
+			if peak, _ := strconv.ParseInt(peaks[source], 10, 64); k.count <= peak {
+				continue
+			}
+			if err := td.state.HashSet(ctx, peaksKey, source, strconv.FormatInt(k.count, 10)); err != nil {
+				return &StateError{Op: "hset", Key: peaksKey, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// learningReport is what a learning period found, and the thresholds it
+// recommends
+type learningReport struct {
+	Started    time.Time                `json:"started"`
+	Ended      time.Time                `json:"ended"`
+	Percentile float64                  `json:"percentile"`
+	Rules      []learningRecommendation `json:"rules"`
+}
+
+// learningRecommendation covers one rule. Counts are each source's peak
+// within a window over the period.
+type learningRecommendation struct {
+	ThreatType           string `json:"threat_type"`
+	Sources              int    `json:"sources"` // sources counted at least once
+	P50                  int64  `json:"p50"`
+	P90                  int64  `json:"p90"`
+	P99                  int64  `json:"p99"`
+	Max                  int64  `json:"max"`
+	CurrentThreshold     int    `json:"current_threshold"`
+	CurrentAlerting      int    `json:"current_alerting_sources"` // sources whose peak reached it
+	RecommendedThreshold int64  `json:"recommended_threshold"`
+	RecommendedAlerting  int    `json:"recommended_alerting_sources"`
+}
+
+// learningReport builds the report from the sampled peaks. The recommended
+// threshold is one above the peak at the percentile, so sources at or below
+// it don't alert. A rule nothing was counted for keeps its threshold.
+func (td *ThreatDetector) learningReport(ctx context.Context, started time.Time) (*learningReport, error) {
+	c := td.cfg()
+	current := make(map[string]int)
+	for _, rule := range c.RuleSummaries() {
+		current[rule.ThreatType] = rule.Threshold
+	}
+
+	report := &learningReport{Started: started, Ended: td.now(), Percentile: c.Learning.Percentile}
+	for _, rule := range c.Learning.rules(c.CustomRules) {
+		peaksKey := learningPeaksKey + rule
+		values, err := td.state.HashGetAll(ctx, peaksKey)
+		if err != nil {
+			return nil, &StateError{Op: "hgetall", Key: peaksKey, Err: err}
+		}
+		peaks := make([]int64, 0, len(values))
+		for _, v := range values {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				peaks = append(peaks, n)
+			}
+		}
+		sort.Slice(peaks, func(i, j int) bool { return peaks[i] < peaks[j] })
+
+		rec := learningRecommendation{
+			ThreatType: rule, Sources: len(peaks), CurrentThreshold: current[rule],
+			RecommendedThreshold: int64(current[rule]),
+		}
+		if len(peaks) > 0 {
+			rec.P50, rec.P90, rec.P99 = percentile(peaks, 50), percentile(peaks, 90), percentile(peaks, 99)
+			rec.Max = peaks[len(peaks)-1]
+			rec.RecommendedThreshold = percentile(peaks, c.Learning.Percentile) + 1
+		}
+		rec.CurrentAlerting = countAtLeast(peaks, int64(rec.CurrentThreshold))
+		rec.RecommendedAlerting = countAtLeast(peaks, rec.RecommendedThreshold)
+		report.Rules = append(report.Rules, rec)
+	}
+	return report, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// countAtLeast counts the sorted values at or above n
+func countAtLeast(sorted []int64, n int64) int {
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= n })
+	return len(sorted) - i
+}
+
+// publishLearningReport logs the recommendations and writes the report to
+// learning.report_file and learning.report_topic
+func (td *ThreatDetector) publishLearningReport(ctx context.Context, report *learningReport) {
+	cfg := td.cfg().Learning
+	for _, rec := range report.Rules {
+		if rec.Sources == 0 {
+			log.Printf("Learning: %s: nothing counted; threshold stays %d", rec.ThreatType, rec.CurrentThreshold)
+			continue
+		}
+		log.Printf("Learning: %s: %d sources, p%g peak %d; threshold %d -> %d (alerting sources %d -> %d)",
+			rec.ThreatType, rec.Sources, report.Percentile, rec.RecommendedThreshold-1,
+			rec.CurrentThreshold, rec.RecommendedThreshold, rec.CurrentAlerting, rec.RecommendedAlerting)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Learning: encoding the report: %v", err)
+		return
+	}
+	if cfg.ReportFile != "" {
+		if err := os.WriteFile(cfg.ReportFile, append(data, '\n'), 0o644); err != nil {
+			log.Printf("Learning: writing the report: %v", err)
+		} else {
+			log.Printf("Learning: report written to %s", cfg.ReportFile)
+		}
+	}
+	if cfg.ReportTopic != "" && td.kafkaWriter != nil {
+		err := td.kafkaWriter.WriteMessages(ctx, kafka.Message{
+			Topic: cfg.ReportTopic,
+			Key:   []byte("learning-report"),
+			Value: data,
+		})
+		if err != nil {
+			processingErrors.WithLabelValues("publish").Inc()
+			log.Printf("Learning: publishing the report: %v", err)
+		}
+	}
+}
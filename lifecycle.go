@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Lifecycle phases, in metadata.lifecycle
+const (
+	lifecycleEmerging  = "emerging"
+	lifecycleEscalated = "escalated"
+	lifecycleResolved  = "resolved"
+)
+
+// Escalation policies
+const (
+	escalateActive = "active" // the detection state is still live when grace ends
+	escalateRepeat = "repeat" // the rule fired again enough times by the end of grace
+)
+
+// lifecycleRetention is how long an entry outlives its grace period
+// without a detection, for the sweep to settle it
+const lifecycleRetention = time.Hour
+
+// lifecycleOpenKey is a hash whose fields are the keys of the lifecycle
+// entries the sweep has yet to settle
+const lifecycleOpenKey = "lifecycle:open"
+
+// validate checks the lifecycle settings and fills in the policies' defaults
+func (c *LifecycleConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("lifecycle.interval must be at least 1s")
+	}
+	for threatType, p := range c.Rules {
+		if p.Grace.Duration <= 0 {
+			return fmt.Errorf("lifecycle.rules.%s.grace must be positive", threatType)
+		}
+		if p.EmergingSeverity == "" {
+			p.EmergingSeverity = "LOW"
+		}
+		if severityRank(p.EmergingSeverity) < 0 {
+			return fmt.Errorf("lifecycle.rules.%s.emerging_severity %q is not a severity", threatType, p.EmergingSeverity)
+		}
+		switch p.Escalation {
+		case "":
+			p.Escalation = escalateActive
+		case escalateActive, escalateRepeat:
+		default:
+			return fmt.Errorf(`lifecycle.rules.%s.escalation must be "active" or "repeat", got %q`, threatType, p.Escalation)
+		}
+		if p.Repeats == 0 {
+			p.Repeats = 1
+		}
+		if p.Repeats < 1 {
+			return fmt.Errorf("lifecycle.rules.%s.repeats must be at least 1", threatType)
+		}
+		c.Rules[threatType] = p
+	}
+	return nil
+}
+
+// policy returns the threat type's lifecycle policy, if it has one
+func (c *LifecycleConfig) policy(threatType string) (LifecyclePolicy, bool) {
+	if !c.Enabled {
+		return LifecyclePolicy{}, false
+	}
+	p, ok := c.Rules[threatType]
+	return p, ok
+}
+
+// lifecycleEntry is one logical alert going through its lifecycle, kept in
+// Redis under lifecycleKey
+type lifecycleEntry struct {
+	ID       string      `json:"id"` // the alert ID every phase is sent under
+	Phase    string      `json:"phase"`
+	Started  time.Time   `json:"started"`
+	LastSeen time.Time   `json:"last_seen"`
+	Hits     int         `json:"hits"`                // detections, the first included
+	Severity string      `json:"severity"`            // the full severity it escalates to
+	StateKey string      `json:"state_key,omitempty"` // detection state that keeps the attack active
+	Alert    ThreatAlert `json:"alert"`               // as first sent
+}
+
+// lifecycleKey keys a rule's lifecycle entry for the event's source
+func lifecycleKey(event SecurityEvent, threatType string) string {
+	return stateKey(event, "lifecycle:"+threatType, evidenceSubject(event))
+}
+
+// satisfied reports whether the attack has persisted by the policy. active
+// is whether the detection state is still live.
+func (p LifecyclePolicy) satisfied(e *lifecycleEntry, active bool) bool {
+	if p.Escalation == escalateRepeat {
+		return e.Hits-1 >= p.Repeats
+	}
+	if e.StateKey == "" {
+		return e.Hits > 1
+	}
+	return active
+}
+
+// applyLifecycle runs an alert through its rule's lifecycle. A first
+// detection goes out as an emerging alert at the policy's severity;
+// detections within the grace period are held back; one after it escalates
+// the same alert if the policy is met. It reports whether the alert goes
+// out. Alerts of rules without a policy, or whose severity is no higher
+// than the emerging one, are left alone.
+func (td *ThreatDetector) applyLifecycle(ctx context.Context, event SecurityEvent, alert *ThreatAlert) (bool, error) {
+	p, ok := td.cfg().Lifecycle.policy(alert.ThreatType)
+	if !ok || severityRank(alert.Severity) <= severityRank(p.EmergingSeverity) {
+		return true, nil
+	}
+	now := td.now()
+	key := lifecycleKey(event, alert.ThreatType)
+	entry, err := td.loadLifecycle(ctx, key)
+	if err != nil {
+		return true, err
+	}
+
+	if entry != nil {
+		entry.Hits++
+		entry.LastSeen = now
+		switch {
+		case entry.Phase == lifecycleEscalated:
+			// Later detections go out as usual, tied to the escalated alert
+			markLifecycle(alert, lifecycleEscalated, entry)
+			return true, td.saveLifecycle(ctx, key, entry, p)
+		case now.Before(entry.Started.Add(p.Grace.Duration)):
+			td.suppressAlert(*alert, reasonLifecycleGrace, "", key)
+			td.debug.Printf("Held back %s from %s: in its grace period", alert.ThreatType, evidenceSubject(event))
+			return false, td.saveLifecycle(ctx, key, entry, p)
+		case p.satisfied(entry, true):
+			entry.Phase = lifecycleEscalated
+			alert.AlertID = entry.ID
+			markLifecycle(alert, lifecycleEscalated, entry)
+			lifecycleTransitions.WithLabelValues(alert.ThreatType, lifecycleEscalated).Inc()
+			return true, td.saveLifecycle(ctx, key, entry, p)
+		}
+		// Grace ran out without the policy being met: that attack is over
+		// and this detection starts a new one
+		if !td.resolveLifecycle(ctx, entry, p) {
+			log.Printf("Alert lifecycle: %s %s not resolved: shutting down", alert.ThreatType, entry.ID)
+		}
+	}
+
+	entry = &lifecycleEntry{
+		ID: alert.AlertID, Phase: lifecycleEmerging, Started: now, LastSeen: now, Hits: 1,
+		Severity: alert.Severity, StateKey: alert.stateKey,
+	}
+	alert.Severity = p.EmergingSeverity
+	markLifecycle(alert, lifecycleEmerging, entry)
+	entry.Alert = *alert
+	lifecycleTransitions.WithLabelValues(alert.ThreatType, lifecycleEmerging).Inc()
+	return true, td.saveLifecycle(ctx, key, entry, p)
+}
+
+// markLifecycle records the alert's phase in its metadata
+func markLifecycle(alert *ThreatAlert, phase string, entry *lifecycleEntry) {
+	metadata := make(map[string]string, len(alert.Metadata)+4)
+	for k, v := range alert.Metadata {
+		metadata[k] = v
+	}
+	metadata["lifecycle"] = phase
+	metadata["lifecycle_id"] = entry.ID
+	metadata["lifecycle_started"] = entry.Started.UTC().Format(time.RFC3339)
+	metadata["rule_severity"] = entry.Severity
+	alert.Metadata = metadata
+}
+
+// loadLifecycle reads a lifecycle entry, or nil if there is none
+func (td *ThreatDetector) loadLifecycle(ctx context.Context, key string) (*lifecycleEntry, error) {
+	data, err := td.state.Get(ctx, key)
+	if err != nil {
+		return nil, &StateError{Op: "get", Key: key, Err: err}
+	}
+	if data == "" {
+		return nil, nil
+	}
+	var entry lifecycleEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, fmt.Errorf("lifecycle entry %s: %w", key, err)
+	}
+	entry.Alert.stateKey = entry.StateKey
+	return &entry, nil
+}
+
+// saveLifecycle stores an entry until lifecycleRetention after its grace
+// period or latest detection, whichever is later, and tracks it for the sweep
+func (td *ThreatDetector) saveLifecycle(ctx context.Context, key string, entry *lifecycleEntry, p LifecyclePolicy) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	until := entry.Started.Add(p.Grace.Duration)
+	if entry.LastSeen.After(until) {
+		until = entry.LastSeen
+	}
+	ttl := until.Add(lifecycleRetention).Sub(td.now())
+	if err := td.state.Set(ctx, key, string(data), ttl); err != nil {
+		return &StateError{Op: "set", Key: key, Err: err}
+	}
+	if err := td.state.HashSet(ctx, lifecycleOpenKey, key, ""); err != nil {
+		return &StateError{Op: "hset", Key: lifecycleOpenKey, Err: err}
+	}
+	return nil
+}
+
+// runLifecycle settles grace periods every lifecycle.interval until stop is
+// closed
+func (td *ThreatDetector) runLifecycle() {
+	defer td.producers.Done()
+
+	ticker := time.NewTicker(td.cfg().Lifecycle.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+			for _, err := range td.sweepLifecycle(td.ctx) {
+				processingErrors.WithLabelValues("state").Inc()
+				log.Printf("Alert lifecycle sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// sweepLifecycle settles the emerging alerts nobody re-detected: once grace
+// is over, each is escalated if the policy is met and resolved if not. An
+// "active" alert whose detection state expires is resolved within grace.
+// Escalated entries are dropped once their detection state expires.
+func (td *ThreatDetector) sweepLifecycle(ctx context.Context) []error {
+	cfg := td.cfg().Lifecycle
+	now := td.now()
+	open, err := td.state.HashGetAll(ctx, lifecycleOpenKey)
+	if err != nil {
+		return []error{&StateError{Op: "hgetall", Key: lifecycleOpenKey, Err: err}}
+	}
+
+	keys := mapKeys(open)
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		entry, err := td.loadLifecycle(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if entry == nil {
+			errs = appendErr(errs, td.untrackLifecycle(ctx, key)) // expired
+			continue
+		}
+		p, ok := cfg.policy(entry.Alert.ThreatType)
+		if !ok {
+			errs = appendErr(errs, td.dropLifecycle(ctx, key))
+			continue
+		}
+
+		active := false
+		if entry.StateKey != "" {
+			if active, err = td.state.Exists(ctx, entry.StateKey); err != nil {
+				errs = append(errs, &StateError{Op: "exists", Key: entry.StateKey, Err: err})
+				continue
+			}
+		}
+
+		if entry.Phase == lifecycleEscalated {
+			if entry.StateKey != "" && !active {
+				errs = appendErr(errs, td.dropLifecycle(ctx, key))
+			}
+			continue
+		}
+
+		inGrace := now.Before(entry.Started.Add(p.Grace.Duration))
+		switch {
+		case inGrace && (p.Escalation != escalateActive || entry.StateKey == "" || active):
+			continue
+		case !inGrace && p.satisfied(entry, active):
+			entry.Phase = lifecycleEscalated
+			alert := entry.Alert
+			alert.Timestamp = now
+			alert.Severity = entry.Severity
+			markLifecycle(&alert, lifecycleEscalated, entry)
+			if !td.sendLifecycleAlert(alert) {
+				return errs
+			}
+			lifecycleTransitions.WithLabelValues(alert.ThreatType, lifecycleEscalated).Inc()
+			errs = appendErr(errs, td.saveLifecycle(ctx, key, entry, p))
+		default:
+			if !td.resolveLifecycle(ctx, entry, p) {
+				return errs
+			}
+			errs = appendErr(errs, td.dropLifecycle(ctx, key))
+		}
+	}
+	return errs
+}
+
+// resolveLifecycle sends the RESOLVED event for an emerging alert whose
+// attack cleared within grace. It reports false if the detector is
+// shutting down.
+func (td *ThreatDetector) resolveLifecycle(ctx context.Context, entry *lifecycleEntry, p LifecyclePolicy) bool {
+	alert := entry.Alert
+	alert.Timestamp = td.now()
+	alert.Details = fmt.Sprintf("Resolved: cleared within the %s grace period. %s", p.Grace, alert.Details)
+	markLifecycle(&alert, lifecycleResolved, entry)
+	if !td.sendLifecycleAlert(alert) {
+		return false
+	}
+	lifecycleTransitions.WithLabelValues(alert.ThreatType, lifecycleResolved).Inc()
+	return true
+}
+
+// sendLifecycleAlert queues an alert raised by the sweep, unless the
+// detector is shutting down. The sweep is an alert producer, so Shutdown
+// keeps alertChan open until it returns; stop only cuts the sweep short.
+func (td *ThreatDetector) sendLifecycleAlert(alert ThreatAlert) bool {
+	select {
+	case <-td.stop:
+		return false
+	case td.alertChan <- alert:
+		alertsRaised.WithLabelValues(alert.ThreatType, alert.Severity).Inc()
+		return true
+	}
+}
+
+// dropLifecycle deletes a settled lifecycle entry
+func (td *ThreatDetector) dropLifecycle(ctx context.Context, key string) error {
+	if err := td.state.Delete(ctx, key); err != nil {
+		return &StateError{Op: "del", Key: key, Err: err}
+	}
+	return td.untrackLifecycle(ctx, key)
+}
+
+// untrackLifecycle removes an entry from the ones the sweep visits
+func (td *ThreatDetector) untrackLifecycle(ctx context.Context, key string) error {
+	if err := td.state.HashDelete(ctx, lifecycleOpenKey, key); err != nil {
+		return &StateError{Op: "hdel", Key: lifecycleOpenKey, Err: err}
+	}
+	return nil
+}
+
+// appendErr appends err to errs unless it is nil
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLifecycleSweepTracksOpenEntries(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Lifecycle.Enabled = true
+	cfg.Lifecycle.Rules = map[string]LifecyclePolicy{"BRUTE_FORCE": {Grace: Duration{10 * time.Minute}}}
+	td, clock := newClockedDetector(t, cfg)
+	ctx := context.Background()
+
+	event := SecurityEvent{SourceIP: "203.0.113.7", User: "alice"}
+	event.normalize()
+	td.raiseAlert(ctx, event, ThreatAlert{AlertID: "BF-1", ThreatType: "BRUTE_FORCE", Severity: "HIGH"})
+	if alerts := drainAlerts(td); len(alerts) != 1 || alerts[0].Metadata["lifecycle"] != lifecycleEmerging {
+		t.Fatalf("first detection raised %+v, want one emerging alert", alerts)
+	}
+	key := lifecycleKey(event, "BRUTE_FORCE")
+	open, _ := td.state.HashGetAll(ctx, lifecycleOpenKey)
+	if _, ok := open[key]; !ok || len(open) != 1 {
+		t.Fatalf("open entries %v, want only %s", open, key)
+	}
+
+	clock.advance(11 * time.Minute)
+	if errs := td.sweepLifecycle(ctx); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if alerts := drainAlerts(td); len(alerts) != 1 || alerts[0].Metadata["lifecycle"] != lifecycleResolved {
+		t.Fatalf("sweep raised %+v, want the alert resolved", alerts)
+	}
+	if open, _ := td.state.HashGetAll(ctx, lifecycleOpenKey); len(open) != 0 {
+		t.Errorf("open entries %v after the sweep settled them", open)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// debugLogger writes debug lines subject to sampling (1 in N) and a
+// per-second cap, so an attack flood can't drown the node in per-event logs.
+// Errors bypass it and go straight to log.Printf.
+type debugLogger struct {
+	enabled      atomic.Bool
+	sampleRate   atomic.Uint64
+	maxPerSecond atomic.Int64
+
+	seen     atomic.Uint64
+	second   atomic.Int64 // unix second of the current rate window
+	inSecond atomic.Int64 // lines written in that second
+}
+
+// newDebugLogger creates a logger from the log config
+func newDebugLogger(cfg LogConfig) *debugLogger {
+	l := &debugLogger{}
+	l.configure(cfg)
+	return l
+}
+
+// configure applies log settings; safe to call while workers are logging
+func (l *debugLogger) configure(cfg LogConfig) {
+	l.enabled.Store(cfg.Debug)
+	l.sampleRate.Store(uint64(cfg.SampleRate))
+	l.maxPerSecond.Store(int64(cfg.MaxPerSecond))
+}
+
+// Printf logs a debug line if it survives sampling and rate limiting
+func (l *debugLogger) Printf(format string, args ...interface{}) {
+	if !l.allow() {
+		return
+	}
+	log.Printf("DEBUG "+format, args...)
+}
+
+// allow decides whether the next line is written. The rate window reset is
+// racy across workers, which at worst lets a few extra lines through.
+func (l *debugLogger) allow() bool {
+	if !l.enabled.Load() {
+		return false
+	}
+
+	if n, rate := l.seen.Add(1), l.sampleRate.Load(); rate > 1 && (n-1)%rate != 0 {
+		debugLinesDropped.Inc()
+		return false
+	}
+
+	if max := l.maxPerSecond.Load(); max > 0 {
+		now := time.Now().Unix()
+		if l.second.Swap(now) != now {
+			l.inSecond.Store(0)
+		}
+		if l.inSecond.Add(1) > max {
+			debugLinesDropped.Inc()
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEntry is one key of a memoryStore. Exactly one of the value kinds
+// is in use, as with a Redis key.
+type memoryEntry struct {
+	str     string
+	set     map[string]bool
+	list    []string
+	hash    map[string]string
+	expires time.Time // zero = no TTL
+}
+
+// memoryStore is an in-process StateStore with the same semantics as the
+// Redis one. Expiry follows its clock rather than the wall clock, so rule
+// tests can step through windows without waiting.
+type memoryStore struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	keys map[string]*memoryEntry
+}
+
+// newMemoryStore creates an empty store on the given clock
+func newMemoryStore(now func() time.Time) *memoryStore {
+	return &memoryStore{now: now, keys: make(map[string]*memoryEntry)}
+}
+
+// get returns a live key, dropping it if it has expired. Callers hold mu.
+func (s *memoryStore) get(key string) *memoryEntry {
+	e, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		delete(s.keys, key)
+		return nil
+	}
+	return e
+}
+
+// getOrCreate returns a live key, creating an empty one. Callers hold mu.
+func (s *memoryStore) getOrCreate(key string) *memoryEntry {
+	if e := s.get(key); e != nil {
+		return e
+	}
+	e := &memoryEntry{}
+	s.keys[key] = e
+	return e
+}
+
+// expire sets a TTL; like EXPIRE, a non-positive TTL deletes the key
+func (s *memoryStore) expire(key string, e *memoryEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(s.keys, key)
+		return
+	}
+	e.expires = s.now().Add(ttl)
+}
+
+func (s *memoryStore) incr(key string) (*memoryEntry, int64) {
+	e := s.getOrCreate(key)
+	n, _ := strconv.ParseInt(e.str, 10, 64)
+	n++
+	e.str = strconv.FormatInt(n, 10)
+	return e, n
+}
+
+func (s *memoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, n := s.incr(key)
+	s.expire(key, e, ttl)
+	return n, nil
+}
+
+func (s *memoryStore) IncrFixed(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, n := s.incr(key)
+	if n == 1 || e.expires.IsZero() {
+		s.expire(key, e, ttl)
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.get(key); e != nil {
+		return e.str, nil
+	}
+	return "", nil
+}
+
+func (s *memoryStore) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &memoryEntry{str: value}
+	s.keys[key] = e
+	if ttl > 0 {
+		s.expire(key, e, ttl)
+	}
+	return nil
+}
+
+func (s *memoryStore) Exists(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key) != nil, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func (s *memoryStore) ReplaceSet(_ context.Context, key string, members []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(members) == 0 {
+		delete(s.keys, key)
+		return nil
+	}
+	set := make(map[string]bool, len(members))
+	for _, m := range members {
+		set[m] = true
+	}
+	s.keys[key] = &memoryEntry{set: set}
+	return nil
+}
+
+func (s *memoryStore) IsMember(_ context.Context, key, member string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.get(key); e != nil {
+		return e.set[member], nil
+	}
+	return false, nil
+}
+
+func (s *memoryStore) ScanKeys(_ context.Context, pattern string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range s.keys {
+		if re.MatchString(key) && s.get(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// globRegexp compiles a Redis glob, where unlike path.Match '*' also
+// matches '/'
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(pattern[i : i+end+1])
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
+
+func (s *memoryStore) GetWithTTL(_ context.Context, key string) (string, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.get(key)
+	if e == nil {
+		return "", 0, nil
+	}
+	if e.expires.IsZero() {
+		return e.str, -1, nil // PTTL of a key without a TTL
+	}
+	return e.str, e.expires.Sub(s.now()), nil
+}
+
+func (s *memoryStore) SetIfAbsent(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.get(key) != nil {
+		return false, nil
+	}
+	e := &memoryEntry{str: value}
+	s.keys[key] = e
+	if ttl > 0 {
+		s.expire(key, e, ttl)
+	}
+	return true, nil
+}
+
+func (s *memoryStore) TouchSeen(_ context.Context, key string, ts int64, ttl time.Duration) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	if e.hash == nil {
+		e.hash = make(map[string]string)
+	}
+	prev, _ := strconv.ParseInt(e.hash["last"], 10, 64)
+	if _, ok := e.hash["first"]; !ok {
+		e.hash["first"] = strconv.FormatInt(ts, 10)
+	}
+	first, _ := strconv.ParseInt(e.hash["first"], 10, 64)
+	e.hash["last"] = strconv.FormatInt(ts, 10)
+	s.expire(key, e, ttl)
+	return first, prev, nil
+}
+
+func (s *memoryStore) ExtendTTL(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.get(key); e != nil && !e.expires.IsZero() && e.expires.Sub(s.now()) < ttl {
+		s.expire(key, e, ttl)
+	}
+	return nil
+}
+
+func (s *memoryStore) AppendList(_ context.Context, key, value string, maxLen int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	e.list = append(e.list, value)
+	if int64(len(e.list)) > maxLen {
+		e.list = append([]string(nil), e.list[int64(len(e.list))-maxLen:]...)
+	}
+	s.expire(key, e, ttl)
+	return nil
+}
+
+func (s *memoryStore) ListRange(_ context.Context, key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.get(key); e != nil {
+		return append([]string(nil), e.list...), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) RenameIfAbsent(_ context.Context, from, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.get(from)
+	if e == nil || s.get(to) != nil {
+		return false, nil
+	}
+	delete(s.keys, from)
+	s.keys[to] = e
+	return true, nil
+}
+
+func (s *memoryStore) HashSet(_ context.Context, key, field, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.getOrCreate(key)
+	if e.hash == nil {
+		e.hash = make(map[string]string)
+	}
+	e.hash[field] = value
+	return nil
+}
+
+func (s *memoryStore) HashGetAll(_ context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]string)
+	if e := s.get(key); e != nil {
+		for k, v := range e.hash {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) HashDelete(_ context.Context, key, field string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.get(key); e != nil {
+		delete(e.hash, field)
+		if len(e.hash) == 0 {
+			delete(s.keys, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// newMemoryDetector builds a detector with no Kafka or Redis, for ruletest
+// and stdin mode: state is kept in memory on the given clock, alerts stay on
+// alertChan and observations in memory for the caller to collect
+func newMemoryDetector(cfg *DetectorConfig, clock func() time.Time) (*ThreatDetector, error) {
+	userAllowlist, err := NewUserAllowlistManager(cfg.UserAllowlist)
+	if err != nil {
+		return nil, err
+	}
+	state := newMemoryStore(clock)
+	td := &ThreatDetector{
+		state:         state,
+		dedup:         newDedupStore(cfg.Dedup, state, clock),
+		userAllowlist: userAllowlist,
+		debug:         newDebugLogger(cfg.Log),
+		ctx:           context.Background(),
+		alertChan:     make(chan ThreatAlert, 1000),
+		stop:          make(chan struct{}),
+		fatal:         make(chan error, 1),
+		clock:         clock,
+		observations:  &memoryAlertStore{cfg: AlertStoreConfig{Retention: Duration{24 * time.Hour}, MaxAlerts: 10000}},
+	}
+	td.config.Store(cfg)
+
+	if cfg.Anonymizer.Enabled {
+		td.anonymizers = NewAnonymizerChecker(cfg.Anonymizer, state)
+	}
+	if cfg.Assets.Enabled {
+		if td.assets, err = NewAssetInventory(cfg.Assets); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Vulns.Enabled {
+		if td.vulns, err = NewVulnDB(cfg.Vulns); err != nil {
+			return nil, err
+		}
+	}
+	return td, nil
+}
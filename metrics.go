@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// counterVec is a minimal Prometheus-style counter keyed by label values.
+// It avoids pulling the full client library in for a handful of counters.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// metric is anything served on /metrics
+type metric interface {
+	write(w io.Writer)
+}
+
+// newCounterVec creates a counter and registers it for /metrics
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registry = append(registry, c)
+	return c
+}
+
+// counter is one labelled series of a counterVec
+type counter struct {
+	vec *counterVec
+	key string
+}
+
+// WithLabelValues selects a series; values must match the declared labels
+func (c *counterVec) WithLabelValues(values ...string) counter {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", c.name, len(values), len(c.labels)))
+	}
+	return counter{vec: c, key: strings.Join(values, "\xff")}
+}
+
+// Inc adds one to a series
+func (c counter) Inc() {
+	c.vec.mu.Lock()
+	c.vec.values[c.key]++
+	c.vec.mu.Unlock()
+}
+
+// Add adds n to a series
+func (c counter) Add(n float64) {
+	c.vec.mu.Lock()
+	c.vec.values[c.key] += n
+	c.vec.mu.Unlock()
+}
+
+// Inc adds one to an unlabelled counter
+func (c *counterVec) Inc() {
+	c.WithLabelValues().Inc()
+}
+
+// write renders the counter in the Prometheus text exposition format
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %g\n", c.name, c.values[""])
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %g\n", c.name, strings.Join(labelPairs(c.labels, k), ","), c.values[k])
+	}
+}
+
+// labelPairs renders a series key as name="value" pairs
+func labelPairs(labels []string, key string) []string {
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = fmt.Sprintf("%s=%q", l, values[i])
+	}
+	return pairs
+}
+
+// histogramVec is a minimal Prometheus-style histogram keyed by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // upper bounds, ascending; +Inf is implied
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// histogramSeries holds one labelled series' observations per bucket
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// newHistogramVec creates a histogram and registers it for /metrics. It
+// needs at least one label.
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registry = append(registry, h)
+	return h
+}
+
+// Observe records v in the series selected by values
+func (h *histogramVec) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", h.name, len(values), len(h.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with bound >= v
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// write renders the histogram in the Prometheus text exposition format
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+		labels := strings.Join(labelPairs(h.labels, k), ",")
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", h.name, labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", h.name, labels, s.sum, h.name, labels, s.count)
+	}
+}
+
+// gauge is an unlabelled value that goes up and down
+type gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// newGauge creates a gauge and registers it for /metrics
+func newGauge(name, help string) *gauge {
+	g := &gauge{name: name, help: help}
+	registry = append(registry, g)
+	return g
+}
+
+// Add moves the gauge by delta
+func (g *gauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+// write renders the gauge in the Prometheus text exposition format
+func (g *gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value.Load())
+}
+
+// gaugeVec is a set of values that go up and down, keyed by label values.
+// Without labels it has a single series.
+type gaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newGaugeVec creates a labelled gauge and registers it for /metrics
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registry = append(registry, g)
+	return g
+}
+
+// Set sets the series selected by values
+func (g *gaugeVec) Set(v float64, values ...string) {
+	if len(values) != len(g.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", g.name, len(values), len(g.labels)))
+	}
+	g.mu.Lock()
+	g.values[strings.Join(values, "\xff")] = v
+	g.mu.Unlock()
+}
+
+// write renders the gauge in the Prometheus text exposition format
+func (g *gaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	keys := make([]string, 0, len(g.values))
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if len(g.labels) == 0 {
+			fmt.Fprintf(w, "%s %g\n", g.name, g.values[k])
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %g\n", g.name, strings.Join(labelPairs(g.labels, k), ","), g.values[k])
+	}
+}
+
+// registry holds every metric served on /metrics, in declaration order
+var registry []metric
+
+// Metrics served on /metrics by the HTTP API
+var (
+	eventsProcessed = newCounterVec("sbla_events_processed_total",
+		"Security events parsed and run through detection.")
+
+	alertsRaised = newCounterVec("sbla_alerts_total",
+		"Alerts queued for publishing, by threat type and severity.",
+		"threat_type", "severity")
+
+	alertsSuppressed = newCounterVec("sbla_alerts_suppressed_total",
+		"Events or alerts suppressed before alerting, by threat type and reason.",
+		"threat_type", "reason")
+
+	suppressionHits = newCounterVec("sbla_suppression_rule_hits_total",
+		"Alerts silenced by each suppression rule.",
+		"rule")
+
+	sinkDeliveries = newCounterVec("sbla_sink_deliveries_total",
+		"Alert deliveries per sink by result (success, failure, dropped, filtered).",
+		"sink", "result")
+	alertOverflows = newCounterVec("sbla_alert_overflows_total",
+		"Alerts over a sink's max_bytes, sent trimmed with the full copy stored.",
+		"sink")
+
+	processingErrors = newCounterVec("sbla_errors_total",
+		"Processing errors by type (parse, state, publish, sign, other).",
+		"type")
+
+	debugLinesDropped = newCounterVec("sbla_debug_log_lines_dropped_total",
+		"Debug log lines skipped by sampling or the per-second cap.")
+
+	workerRestarts = newCounterVec("sbla_worker_restarts_total",
+		"Workers restarted by the watchdog after getting stuck on an event.")
+
+	poisonMessages = newCounterVec("sbla_poison_messages_total",
+		"Messages dead-lettered after too many deliveries without completing processing.")
+
+	clockSkewEvents = newCounterVec("sbla_clock_skew_events_total",
+		"Events with a missing or future timestamp, by action (missing, clamped, rejected, accepted).",
+		"action")
+
+	standbyWrites = newCounterVec("sbla_standby_writes_total",
+		"State writes mirrored to the standby Redis by result (success, failure, dropped).",
+		"result")
+
+	stateFailovers = newCounterVec("sbla_state_failovers_total",
+		"Switches from the primary to the standby Redis.")
+
+	autoMutes = newCounterVec("sbla_auto_mutes_total",
+		"Sources muted for a high false-positive ratio in analyst feedback.")
+
+	duplicateRawLogs = newCounterVec("sbla_duplicate_raw_logs_total",
+		"Repeated raw log lines not counted by rules in distinct_raw_logs, by threat type.",
+		"threat_type")
+
+	alertsPaused = newCounterVec("sbla_alerts_paused_total",
+		"Alerts raised while alerting was paused, by result (held, dropped).",
+		"result")
+
+	baselineSamples = newCounterVec("sbla_baseline_samples_total",
+		"Benign events sampled into the baseline aggregates.")
+
+	lifecycleTransitions = newCounterVec("sbla_alert_lifecycle_total",
+		"Alert lifecycle transitions (emerging, escalated, resolved), by threat type.",
+		"threat_type", "transition")
+
+	scanRuns = newCounterVec("sbla_scan_runs_total",
+		"Scheduled state scans completed, by scan rule.",
+		"rule")
+
+	eventsDropped = newCounterVec("sbla_events_dropped_total",
+		"Events dropped by a middleware step before detection, by step.",
+		"step")
+
+	enrichmentLookups = newCounterVec("sbla_enrichment_lookups_total",
+		"Enrichment lookups by result (cached, success, not_found, failure, circuit_open).",
+		"result")
+
+	enrichmentCircuitOpens = newCounterVec("sbla_enrichment_circuit_opens_total",
+		"Times repeated enrichment failures opened the circuit breaker.")
+
+	dedupEvictions = newCounterVec("sbla_dedup_evictions_total",
+		"Dedup keys the memory backend forgot early because it was full.")
+
+	observationsRecorded = newCounterVec("sbla_observations_total",
+		"Detections recorded as observations instead of or beside alerts, by threat type and reason.",
+		"threat_type", "reason")
+
+	ruleEvaluations = newCounterVec("sbla_rule_evaluations_total",
+		"Events each detection rule evaluated, by rule.",
+		"rule")
+
+	ruleHits = newCounterVec("sbla_rule_hits_total",
+		"Alerts each detection rule raised, before suppression, by rule.",
+		"rule")
+
+	ruleDuration = newHistogramVec("sbla_rule_duration_seconds",
+		"Time each detection rule took to evaluate one event, by rule.",
+		[]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+		"rule")
+
+	eventsInFlight = newGauge("sbla_events_in_flight",
+		"Events currently being processed, including those abandoned by the watchdog.")
+
+	eventRate = newGaugeVec("sbla_event_rate",
+		"Events per second seen by this instance over the last adaptive threshold interval.")
+	thresholdFactor = newGaugeVec("sbla_adaptive_threshold_factor",
+		"Factor the count thresholds are currently scaled by (1 when adaptive thresholds are off).")
+	effectiveThresholds = newGaugeVec("sbla_effective_threshold",
+		"Count thresholds in effect after adaptive scaling, by rule (default tenant).",
+		"rule")
+)
+
+// handleMetrics serves GET /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, c := range registry {
+		c.write(w)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validate checks the MFA fatigue settings and lowercases the event types
+// and results
+func (c *MFAFatigueConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.EventTypes) == 0 {
+		return fmt.Errorf("mfa_fatigue.event_types is required when enabled")
+	}
+	for _, list := range [][]string{c.EventTypes, c.DeniedResults, c.ApprovedResults} {
+		for i, v := range list {
+			list[i] = strings.ToLower(v)
+		}
+	}
+	if c.Threshold < 1 {
+		return fmt.Errorf("mfa_fatigue.threshold must be at least 1")
+	}
+	if c.Denials < 1 {
+		return fmt.Errorf("mfa_fatigue.denials must be at least 1")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("mfa_fatigue.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("mfa_fatigue.severity %q is not a severity", c.Severity)
+	}
+	if severityRank(c.EscalatedSeverity) < 0 {
+		return fmt.Errorf("mfa_fatigue.escalated_severity %q is not a severity", c.EscalatedSeverity)
+	}
+	return nil
+}
+
+// mfaFatigueHit is a burst of MFA prompts for one user
+type mfaFatigueHit struct {
+	prompts  int64
+	denials  int64
+	approved bool   // an approval followed the denials
+	key      string // the prompt counter
+}
+
+// isMFAFatigue counts MFA prompts and denials per user. Reaching threshold
+// prompts alerts once per window. An approval once the user has denied
+// enough prompts alerts at once, since they likely gave in, and starts the
+// denial count over. Events without a user are skipped.
+func (td *ThreatDetector) isMFAFatigue(ctx context.Context, event SecurityEvent) (*mfaFatigueHit, error) {
+	cfg := td.cfg().MFAFatigue
+	if !cfg.Enabled || event.User == "" || !containsString(cfg.EventTypes, event.eventTypeLower) {
+		return nil, nil
+	}
+	user := strings.ToLower(event.User)
+	key := stateKey(event, "mfa_prompts", user)
+	deniedKey := stateKey(event, "mfa_denied", user)
+
+	prompts, err := td.countInWindow(ctx, event, "MFA_FATIGUE", key, cfg.Window.Duration)
+	if err != nil {
+		return nil, err
+	}
+	if err := td.recordRawEvent(ctx, event, key, cfg.Window.Duration); err != nil {
+		return nil, err
+	}
+
+	result := strings.ToLower(event.Result)
+	var denials int64
+	if containsString(cfg.DeniedResults, result) {
+		if denials, err = td.state.Incr(ctx, deniedKey, cfg.Window.Duration); err != nil {
+			return nil, &StateError{Op: "incr", Key: deniedKey, Err: err}
+		}
+	} else {
+		value, err := td.state.Get(ctx, deniedKey)
+		if err != nil {
+			return nil, &StateError{Op: "get", Key: deniedKey, Err: err}
+		}
+		denials, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	if containsString(cfg.ApprovedResults, result) && denials >= int64(cfg.Denials) {
+		if err := td.state.Delete(ctx, deniedKey); err != nil {
+			return nil, &StateError{Op: "del", Key: deniedKey, Err: err}
+		}
+		return &mfaFatigueHit{prompts: prompts, denials: denials, approved: true, key: key}, nil
+	}
+	if prompts < int64(cfg.Threshold) {
+		return nil, nil
+	}
+	burstKey := stateKey(event, "mfa_fatigue", user)
+	seen, err := td.dedup.SeenRecently(ctx, burstKey, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: burstKey, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	return &mfaFatigueHit{prompts: prompts, denials: denials, key: key}, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// EventMiddleware runs on each parsed event before detection. It may modify
+// the event, and returns false to drop it.
+type EventMiddleware func(event *SecurityEvent) (bool, error)
+
+// middlewareTypes builds each middleware step type from its config. New
+// preprocessing goes here rather than into the detection rules.
+var middlewareTypes = map[string]func(step MiddlewareStep, cfg MiddlewareConfig) (EventMiddleware, error){
+	"normalize_user": newNormalizeUser,
+	"hash":           newHashFields,
+	"tag":            newTag,
+	"derive":         newDerive,
+	"drop":           newDrop,
+}
+
+// compile builds the chain. It runs at load so a bad step rejects the config.
+func (c *MiddlewareConfig) compile() error {
+	c.chain = nil
+	for i := range c.Steps {
+		step := &c.Steps[i]
+		build, ok := middlewareTypes[step.Type]
+		if !ok {
+			return fmt.Errorf("middleware.steps[%d]: unknown type %q", i, step.Type)
+		}
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("%s[%d]", step.Type, i)
+		}
+		mw, err := build(*step, *c)
+		if err != nil {
+			return fmt.Errorf("middleware.steps[%d] (%s): %w", i, step.Name, err)
+		}
+		c.chain = append(c.chain, mw)
+	}
+	return nil
+}
+
+// run passes the event through every step in order. It returns false, and
+// the name of the step, when one drops the event. A step that fails is
+// skipped and the rest still run.
+func (c *MiddlewareConfig) run(event *SecurityEvent) (bool, string, error) {
+	var errs []error
+	for i, mw := range c.chain {
+		keep, err := mw(event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("middleware %s: %w", c.Steps[i].Name, err))
+			continue
+		}
+		if !keep {
+			return false, c.Steps[i].Name, nil
+		}
+	}
+	// Steps may have rewritten the fields the derived ones come from
+	event.normalize()
+	return true, "", errors.Join(errs...)
+}
+
+// compileEventExpr compiles an expression over the SecurityEvent fields, as
+// custom rules do
+func compileEventExpr(name, source string, opts ...expr.Option) (*vm.Program, error) {
+	if source == "" {
+		return nil, fmt.Errorf("%s is required", name)
+	}
+	program, err := expr.Compile(source, append([]expr.Option{expr.Env(SecurityEvent{})}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("%s:\n%w", name, err)
+	}
+	return program, nil
+}
+
+// matchEvent evaluates an optional condition; no condition matches everything
+func matchEvent(condition *vm.Program, event *SecurityEvent) (bool, error) {
+	if condition == nil {
+		return true, nil
+	}
+	out, err := expr.Run(condition, *event)
+	if err != nil {
+		return false, err
+	}
+	matched, _ := out.(bool)
+	return matched, nil
+}
+
+// newNormalizeUser lower-cases usernames and strips a Windows domain
+// ("CORP\alice") or UPN suffix ("alice@corp.example"), so one person's
+// logins count together whichever form the source logs
+func newNormalizeUser(MiddlewareStep, MiddlewareConfig) (EventMiddleware, error) {
+	return func(event *SecurityEvent) (bool, error) {
+		user := event.User
+		if i := strings.LastIndex(user, `\`); i >= 0 {
+			user = user[i+1:]
+		}
+		if i := strings.Index(user, "@"); i > 0 {
+			user = user[:i]
+		}
+		event.User = strings.ToLower(user)
+		return true, nil
+	}, nil
+}
+
+// newHashFields replaces identifying fields with a keyed hash, so usernames
+// never reach Redis or alerts but the same user still hashes the same way.
+// Occurrences in the raw log line are replaced too.
+func newHashFields(step MiddlewareStep, cfg MiddlewareConfig) (EventMiddleware, error) {
+	if len(step.Fields) == 0 {
+		return nil, fmt.Errorf("fields is required")
+	}
+	if cfg.HashKey == "" {
+		return nil, fmt.Errorf("middleware.hash_key is required for hash steps")
+	}
+	for _, f := range step.Fields {
+		if f != "user" && !strings.HasPrefix(f, "metadata.") {
+			return nil, fmt.Errorf(`fields: want "user" or "metadata.<key>", got %q`, f)
+		}
+	}
+	return func(event *SecurityEvent) (bool, error) {
+		for _, f := range step.Fields {
+			var value *string
+			if f == "user" {
+				value = &event.User
+			} else if v, ok := event.Metadata[strings.TrimPrefix(f, "metadata.")]; ok {
+				value = &v
+			}
+			if value == nil || *value == "" {
+				continue
+			}
+			hashed := pseudonymize(cfg.HashKey, *value)
+			event.RawLog = strings.ReplaceAll(event.RawLog, *value, hashed)
+			if f == "user" {
+				event.User = hashed
+			} else {
+				event.Metadata[strings.TrimPrefix(f, "metadata.")] = hashed
+			}
+		}
+		return true, nil
+	}, nil
+}
+
+// newTag sets metadata on events matching the condition (or all events)
+func newTag(step MiddlewareStep, _ MiddlewareConfig) (EventMiddleware, error) {
+	if len(step.Set) == 0 {
+		return nil, fmt.Errorf("set is required")
+	}
+	var condition *vm.Program
+	if step.Condition != "" {
+		var err error
+		if condition, err = compileEventExpr("condition", step.Condition, expr.AsBool()); err != nil {
+			return nil, err
+		}
+	}
+	return func(event *SecurityEvent) (bool, error) {
+		matched, err := matchEvent(condition, event)
+		if err != nil || !matched {
+			return true, err
+		}
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string)
+		}
+		for k, v := range step.Set {
+			event.Metadata[k] = v
+		}
+		return true, nil
+	}, nil
+}
+
+// newDerive writes an expression's result to a metadata field, for rules
+// and templates to use
+func newDerive(step MiddlewareStep, _ MiddlewareConfig) (EventMiddleware, error) {
+	if step.Field == "" {
+		return nil, fmt.Errorf("field is required")
+	}
+	program, err := compileEventExpr("expression", step.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return func(event *SecurityEvent) (bool, error) {
+		out, err := expr.Run(program, *event)
+		if err != nil {
+			return true, err
+		}
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string)
+		}
+		event.Metadata[step.Field] = fmt.Sprint(out)
+		return true, nil
+	}, nil
+}
+
+// newDrop discards events matching the condition before detection
+func newDrop(step MiddlewareStep, _ MiddlewareConfig) (EventMiddleware, error) {
+	condition, err := compileEventExpr("condition", step.Condition, expr.AsBool())
+	if err != nil {
+		return nil, err
+	}
+	return func(event *SecurityEvent) (bool, error) {
+		matched, err := matchEvent(condition, event)
+		return !matched, err
+	}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// severityInfo marks observations, a tier below LOW that never pages
+const severityInfo = "INFO"
+
+// Modes for observations.threat_types
+const (
+	observeOnly = "observe" // record an observation instead of alerting
+	observeBoth = "both"    // alert and record an observation
+)
+
+// Why a detection was recorded as an observation, in metadata.observation
+const (
+	observedEvidence   = "insufficient_evidence"
+	observedThreatType = "threat_type"
+	observedLearning   = "learning"
+)
+
+// validate checks the observation settings; the store lives in alert_store's
+// backend
+func (c *ObservationConfig) validate(store AlertStoreConfig) error {
+	for threatType, mode := range c.ThreatTypes {
+		if mode != observeOnly && mode != observeBoth {
+			return fmt.Errorf("observations.threat_types.%s must be %q or %q, got %q", threatType, observeOnly, observeBoth, mode)
+		}
+	}
+	if !c.Store {
+		return nil
+	}
+	if !store.Enabled {
+		return fmt.Errorf("observations.store needs alert_store.enabled")
+	}
+	if c.Retention.Duration <= 0 {
+		return fmt.Errorf("observations.retention must be positive")
+	}
+	if store.Backend == "redis" && (c.Key == "" || c.Key == store.Key) {
+		return fmt.Errorf("observations.key is required and must differ from alert_store.key")
+	}
+	return nil
+}
+
+// newObservationStore creates the store behind GET /observations, in
+// alert_store's backend with the observations' own key and retention
+func newObservationStore(cfg *DetectorConfig, client *redis.Client) AlertStore {
+	storeCfg := cfg.AlertStore
+	storeCfg.Key, storeCfg.Retention = cfg.Observations.Key, cfg.Observations.Retention
+	return newAlertStore(storeCfg, client)
+}
+
+// publishObservation records a detection as an observation on the
+// observations topic and store. It keeps the alert's schema with severity
+// INFO and the rule's own severity in metadata.rule_severity. Sinks and
+// paging never see it.
+func (td *ThreatDetector) publishObservation(ctx context.Context, alert ThreatAlert, reason string) {
+	observationsRecorded.WithLabelValues(alert.ThreatType, reason).Inc()
+	topic := td.cfg().ObservationsTopic
+	if topic == "" && td.observations == nil {
+		return
+	}
+
+	// The alert may still go out in "both" mode, so don't share its metadata
+	metadata := make(map[string]string, len(alert.Metadata)+3)
+	for k, v := range alert.Metadata {
+		metadata[k] = v
+	}
+	metadata["observation"] = reason
+	metadata["rule_severity"] = alert.Severity
+	if reason == observedEvidence {
+		metadata["confidence"] = "low"
+	}
+	alert.Metadata = metadata
+	alert.Severity = severityInfo
+	td.cfg().boundRawEvents(&alert)
+	td.signAlert(&alert)
+
+	if topic != "" {
+		data, err := json.Marshal(alert)
+		if err != nil {
+			log.Printf("Error encoding observation: %v", err)
+			return
+		}
+		err = td.kafkaWriter.WriteMessages(ctx, kafka.Message{
+			Topic: topic,
+			Key:   []byte(alert.SourceIP),
+			Value: data,
+		})
+		if err != nil {
+			processingErrors.WithLabelValues("publish").Inc()
+			log.Printf("Error publishing observation: %v", err)
+		}
+	}
+	if td.observations != nil {
+		if err := td.observations.Put(ctx, alert); err != nil {
+			processingErrors.WithLabelValues("state").Inc()
+			log.Printf("Error storing observation %s: %v", alert.AlertID, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// overflowKeyPrefix keys full copies of alerts too big for a sink
+const overflowKeyPrefix = "alert_overflow:"
+
+// validateOverflow checks sinks.max_bytes and sinks.overflow
+func (c *SinksConfig) validateOverflow() error {
+	names := mapKeys(c.MaxBytes)
+	sort.Strings(names)
+	for _, name := range names {
+		if !containsString(sinkNames, name) {
+			return fmt.Errorf("sinks.max_bytes: unknown sink %q (want one of %s)", name, strings.Join(sinkNames, ", "))
+		}
+		if c.MaxBytes[name] < 1024 {
+			return fmt.Errorf("sinks.max_bytes.%s must be at least 1024", name)
+		}
+	}
+	if len(c.MaxBytes) > 0 && c.Overflow.Retention.Duration < time.Minute {
+		return fmt.Errorf("sinks.overflow.retention must be at least 1m")
+	}
+	if c.Overflow.URL != "" {
+		if err := validateURL(c.Overflow.URL); err != nil {
+			return fmt.Errorf("sinks.overflow.url: %w", err)
+		}
+	}
+	return nil
+}
+
+// fitForSink returns the alert as a sink should get it. Over the sink's
+// max_bytes, the raw events, timeline and IP history are stripped and the
+// full alert is kept in the overflow store, referenced from the trimmed
+// alert's metadata. key holds the stored copy's key once stored, so an alert
+// too big for several sinks is stored once.
+func (td *ThreatDetector) fitForSink(ctx context.Context, alert ThreatAlert, sink string, key *string) ThreatAlert {
+	cfg := td.cfg().Sinks
+	limit := cfg.MaxBytes[sink]
+	if limit <= 0 {
+		return alert
+	}
+	data, err := cfg.Transforms[sink].encode(alert)
+	if err != nil || len(data) <= limit {
+		return alert
+	}
+
+	if *key == "" {
+		if stored, err := td.storeOverflow(ctx, alert); err != nil {
+			processingErrors.WithLabelValues("state").Inc()
+			log.Printf("Error storing oversized alert %s: %v", alert.AlertID, err)
+		} else {
+			*key = stored
+		}
+	}
+
+	trimmed := alert
+	trimmed.RawEvents = []string{}
+	trimmed.Timeline = nil
+	trimmed.RecentEvents = nil
+	trimmed.Metadata = make(map[string]string, len(alert.Metadata)+3)
+	for k, v := range alert.Metadata {
+		trimmed.Metadata[k] = v
+	}
+	trimmed.Metadata["overflow_bytes"] = strconv.Itoa(len(data))
+	if *key != "" {
+		trimmed.Metadata["overflow_key"] = *key
+		if cfg.Overflow.URL != "" {
+			trimmed.Metadata["overflow_url"] = cfg.Overflow.URL + strings.TrimPrefix(*key, overflowKeyPrefix)
+		}
+	}
+	// The signature covered the full alert
+	td.signAlert(&trimmed)
+
+	alertOverflows.WithLabelValues(sink).Inc()
+	if data, err := cfg.Transforms[sink].encode(trimmed); err == nil && len(data) > limit {
+		log.Printf("Alert %s: %d bytes still exceeds sinks.max_bytes.%s without raw events", alert.AlertID, len(data), sink)
+	}
+	return trimmed
+}
+
+// storeOverflow keeps the full alert for sinks.overflow.retention and
+// returns its key. A hash of the alert tells apart alerts sharing an ID.
+func (td *ThreatDetector) storeOverflow(ctx context.Context, alert ThreatAlert) (string, error) {
+	full, err := json.Marshal(alert)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(full)
+	key := overflowKeyPrefix + alert.AlertID + "-" + hex.EncodeToString(sum[:4])
+	if err := td.state.Set(ctx, key, string(full), td.cfg().Sinks.Overflow.Retention.Duration); err != nil {
+		return "", &StateError{Op: "set", Key: key, Err: err}
+	}
+	return key, nil
+}
+
+// handleOverflow serves GET /overflow/<id>: the full copy of an alert a
+// sink received trimmed
+func (td *ThreatDetector) handleOverflow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := td.cfg().Sinks.Overflow.Token; token != "" && !bearerTokenOK(r, token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/overflow/")
+	if id == "" || strings.ContainsAny(id, "*?[") {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := overflowKeyPrefix + id
+	full, err := td.state.Get(r.Context(), key)
+	if err != nil {
+		http.Error(w, (&StateError{Op: "get", Key: key, Err: err}).Error(), http.StatusInternalServerError)
+		return
+	}
+	if full == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(full))
+}
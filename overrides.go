@@ -0,0 +1,58 @@
+package main
+
+// matches reports whether every condition of the override holds for an alert
+func (o *SeverityOverride) matches(groups map[string][]string, event SecurityEvent, alert ThreatAlert) bool {
+	if len(o.ThreatTypes) > 0 && !containsString(o.ThreatTypes, alert.ThreatType) {
+		return false
+	}
+	if len(o.Users) > 0 && !matchAny(o.Users, event.User) {
+		return false
+	}
+	if len(o.UserGroups) > 0 {
+		inGroup := false
+		for _, group := range o.UserGroups {
+			if matchAny(groups[group], event.User) {
+				inGroup = true
+				break
+			}
+		}
+		if !inGroup {
+			return false
+		}
+	}
+	if len(o.Sources) > 0 && !matchAny(o.Sources, event.Source) {
+		return false
+	}
+	if len(o.sourceIPs) > 0 && !containsAddr(o.sourceIPs, event.addr) {
+		return false
+	}
+	for key, pattern := range o.Metadata {
+		value, ok := event.Metadata[key]
+		if !ok || !matchAny([]string{pattern}, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// applySeverityOverride returns the severity set by the first matching
+// override and its name, or the alert's own severity and ""
+func (c *DetectorConfig) applySeverityOverride(event SecurityEvent, alert ThreatAlert) (string, string) {
+	for i := range c.SeverityOverrides {
+		o := &c.SeverityOverrides[i]
+		if o.matches(c.UserGroups, event, alert) {
+			return o.Severity, o.Name
+		}
+	}
+	return alert.Severity, ""
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
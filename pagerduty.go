@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pagerDutyOpenKey is a Redis hash of open incidents: dedup_key -> detection state key.
+// Keeping it in Redis lets any replica resolve an incident another replica opened.
+const pagerDutyOpenKey = "pagerduty:open"
+
+// pagerDutySeverity maps alert severities to PagerDuty Events API v2 severities
+var pagerDutySeverity = map[string]string{
+	"CRITICAL": "critical",
+	"HIGH":     "error",
+	"MEDIUM":   "warning",
+	"LOW":      "info",
+}
+
+// PagerDutySink triggers PagerDuty incidents for alerts at or above a minimum severity
+type PagerDutySink struct {
+	cfg       PagerDutyConfig
+	transform *AlertTransform // shapes custom_details
+	state     StateStore
+	client    *http.Client
+}
+
+// NewPagerDutySink creates a PagerDuty Events API v2 sink
+func NewPagerDutySink(cfg PagerDutyConfig, transform *AlertTransform, state StateStore) *PagerDutySink {
+	return &PagerDutySink{
+		cfg:       cfg,
+		transform: transform,
+		state:     state,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *PagerDutySink) Name() string { return "pagerduty" }
+
+// pagerDutyEvent is the Events API v2 request body
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string          `json:"summary"`
+	Source        string          `json:"source"`
+	Severity      string          `json:"severity"`
+	Timestamp     string          `json:"timestamp"`
+	Component     string          `json:"component"`
+	Class         string          `json:"class"`
+	CustomDetails json.RawMessage `json:"custom_details"`
+}
+
+// dedupKey groups a sustained attack into a single incident
+func pagerDutyDedupKey(alert ThreatAlert) string {
+	return fmt.Sprintf("sbla:%s:%s:%s", alert.TenantID, alert.ThreatType, alert.SourceIP)
+}
+
+// Send triggers (or re-triggers, which PagerDuty dedups) an incident for the alert
+func (s *PagerDutySink) Send(ctx context.Context, alert ThreatAlert) error {
+	if severityRank(alert.Severity) < severityRank(s.cfg.MinSeverity) {
+		return nil
+	}
+	if s.cfg.MinRiskScore > 0 {
+		if score, _ := strconv.ParseFloat(alert.Metadata["risk_score"], 64); score < s.cfg.MinRiskScore {
+			return nil
+		}
+	}
+
+	details, err := s.transform.encode(alert)
+	if err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: "pagerduty", Err: err}
+	}
+
+	dedupKey := pagerDutyDedupKey(alert)
+	event := pagerDutyEvent{
+		RoutingKey:  s.cfg.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    dedupKey,
+		Payload: &pagerDutyPayload{
+			Summary:       fmt.Sprintf("[%s] %s: %s", alert.Severity, alert.ThreatType, alert.Details),
+			Source:        alert.SourceIP,
+			Severity:      pagerDutySeverity[alert.Severity],
+			Timestamp:     alert.Timestamp.Format(time.RFC3339),
+			Component:     "security-breach-analyzer",
+			Class:         alert.ThreatType,
+			CustomDetails: details,
+		},
+	}
+	if err := s.post(ctx, alert.AlertID, event); err != nil {
+		return err
+	}
+
+	// Remember which state key keeps this incident open
+	if alert.stateKey != "" {
+		if err := s.state.HashSet(ctx, pagerDutyOpenKey, dedupKey, alert.stateKey); err != nil {
+			log.Printf("PagerDuty: can't track incident %s for auto-resolve: %v", dedupKey, err)
+		}
+	}
+	return nil
+}
+
+// Run resolves incidents whose detection state has expired, until stop is closed
+func (s *PagerDutySink) Run(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.ResolveInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.resolveExpired(ctx)
+		}
+	}
+}
+
+// resolveExpired sends a resolve for every open incident whose state key is gone
+func (s *PagerDutySink) resolveExpired(ctx context.Context) {
+	open, err := s.state.HashGetAll(ctx, pagerDutyOpenKey)
+	if err != nil {
+		log.Printf("PagerDuty: reading open incidents: %v", err)
+		return
+	}
+
+	for dedupKey, stateKey := range open {
+		active, err := s.state.Exists(ctx, stateKey)
+		if err != nil || active {
+			continue
+		}
+
+		event := pagerDutyEvent{RoutingKey: s.cfg.RoutingKey, EventAction: "resolve", DedupKey: dedupKey}
+		if err := s.post(ctx, dedupKey, event); err != nil {
+			// Stays in the hash, so the next tick retries
+			log.Printf("PagerDuty: resolving %s: %v", dedupKey, err)
+			continue
+		}
+
+		if err := s.state.HashDelete(ctx, pagerDutyOpenKey, dedupKey); err != nil {
+			log.Printf("PagerDuty: untracking %s: %v", dedupKey, err)
+		}
+		log.Printf("PagerDuty: resolved %s (attack subsided)", dedupKey)
+	}
+}
+
+// post sends an event to the Events API. 429 and 5xx responses are retryable.
+func (s *PagerDutySink) post(ctx context.Context, id string, event pagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.EventsURL, bytes.NewReader(body))
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err, Retryable: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return &PublishError{
+		AlertID:   id,
+		Topic:     s.Name(),
+		Err:       fmt.Errorf("events API returned %s", resp.Status),
+		Retryable: retryable,
+	}
+}
+
+func (s *PagerDutySink) Close() error { return nil }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Alerting pause state, shared by every replica
+const (
+	pausedKey      = "alerting:paused"        // JSON pauseState while paused
+	pauseBufferKey = "alerting:paused_alerts" // alerts held for resume (oldest first)
+	pauseCountKey  = "alerting:paused_count"  // alerts held or dropped during this pause
+)
+
+// pauseBufferTTL bounds how long a forgotten pause keeps its buffer
+const pauseBufferTTL = 30 * 24 * time.Hour
+
+// pauseState is stored under pausedKey and served by the endpoints
+type pauseState struct {
+	Paused  bool       `json:"paused"`
+	Since   *time.Time `json:"since,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Held    int64      `json:"held"`    // alerts buffered for resume
+	Dropped int64      `json:"dropped"` // alerts beyond pause.buffer_size
+}
+
+// pausedAlert is a buffered alert with the state key sinks use for auto-resolve
+type pausedAlert struct {
+	Alert    ThreatAlert `json:"alert"`
+	StateKey string      `json:"state_key,omitempty"`
+}
+
+// validate checks the pause settings
+func (c *PauseConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Token == "" {
+		return fmt.Errorf("pause.token is required when enabled")
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("pause.buffer_size must not be negative")
+	}
+	return nil
+}
+
+// pauseStatus reads the shared pause state
+func (td *ThreatDetector) pauseStatus(ctx context.Context) (pauseState, error) {
+	var st pauseState
+	value, err := td.state.Get(ctx, pausedKey)
+	if err != nil {
+		return st, &StateError{Op: "get", Key: pausedKey, Err: err}
+	}
+	if value == "" {
+		return st, nil
+	}
+	if err := json.Unmarshal([]byte(value), &st); err != nil {
+		return st, fmt.Errorf("%s: %w", pausedKey, err)
+	}
+	count, err := td.state.Get(ctx, pauseCountKey)
+	if err != nil {
+		return st, &StateError{Op: "get", Key: pauseCountKey, Err: err}
+	}
+	n, _ := strconv.ParseInt(count, 10, 64)
+	st.Held = min(n, int64(td.cfg().Pause.BufferSize))
+	st.Dropped = n - st.Held
+	return st, nil
+}
+
+// holdIfPaused buffers an alert instead of publishing it while alerting is
+// paused, and reports whether it did. Past pause.buffer_size the alert is
+// dropped and counted. If Redis can't be reached the alert is published.
+func (td *ThreatDetector) holdIfPaused(ctx context.Context, alert ThreatAlert) bool {
+	if !td.cfg().Pause.Enabled {
+		return false
+	}
+	paused, err := td.state.Exists(ctx, pausedKey)
+	if err != nil {
+		log.Printf("Pause check failed, publishing alert %s: %v", alert.AlertID, &StateError{Op: "exists", Key: pausedKey, Err: err})
+		return false
+	}
+	if !paused {
+		return false
+	}
+
+	n, err := td.state.Incr(ctx, pauseCountKey, pauseBufferTTL)
+	if err != nil {
+		log.Printf("Pause buffer failed, publishing alert %s: %v", alert.AlertID, &StateError{Op: "incr", Key: pauseCountKey, Err: err})
+		return false
+	}
+	if n > int64(td.cfg().Pause.BufferSize) {
+		alertsPaused.WithLabelValues("dropped").Inc()
+		td.auditSuppression(alert, reasonPausedDropped, "", pauseCountKey)
+		td.debug.Printf("Alerting paused and buffer full, dropping alert %s", alert.AlertID)
+		return true
+	}
+
+	entry, err := json.Marshal(pausedAlert{Alert: alert, StateKey: alert.stateKey})
+	if err != nil {
+		log.Printf("Pause buffer failed, publishing alert %s: %v", alert.AlertID, err)
+		return false
+	}
+	if err := td.state.AppendList(ctx, pauseBufferKey, string(entry), int64(td.cfg().Pause.BufferSize), pauseBufferTTL); err != nil {
+		log.Printf("Pause buffer failed, publishing alert %s: %v", alert.AlertID, &StateError{Op: "rpush", Key: pauseBufferKey, Err: err})
+		return false
+	}
+	alertsPaused.WithLabelValues("held").Inc()
+	td.auditSuppression(alert, reasonPausedHeld, "", pauseBufferKey)
+	return true
+}
+
+// pauseAlerting sets the shared pause flag. It has no TTL: a pause lasts
+// until resumed, across restarts.
+func (td *ThreatDetector) pauseAlerting(ctx context.Context, reason string) (pauseState, error) {
+	st, err := td.pauseStatus(ctx)
+	if err != nil || st.Paused {
+		return st, err
+	}
+	now := time.Now().UTC()
+	st = pauseState{Paused: true, Since: &now, Reason: reason}
+	value, err := json.Marshal(st)
+	if err != nil {
+		return st, err
+	}
+	if err := td.state.Set(ctx, pausedKey, string(value), 0); err != nil {
+		return st, &StateError{Op: "set", Key: pausedKey, Err: err}
+	}
+	return st, nil
+}
+
+// resumeAlerting clears the pause flag and queues the buffered alerts for
+// publishing, oldest first. It returns the state the pause ended with.
+func (td *ThreatDetector) resumeAlerting(ctx context.Context) (pauseState, error) {
+	st, err := td.pauseStatus(ctx)
+	if err != nil || !st.Paused {
+		return st, err
+	}
+
+	// Take the buffer before clearing the flag, so a replica resuming at
+	// the same time finds nothing left to publish
+	entries, err := td.drainPauseBuffer(ctx)
+	if err != nil {
+		return st, err
+	}
+	if err := td.state.Delete(ctx, pausedKey); err != nil {
+		return st, &StateError{Op: "del", Key: pausedKey, Err: err}
+	}
+	// Workers that saw the flag just before it was cleared may have held
+	// alerts since
+	late, err := td.drainPauseBuffer(ctx)
+	if err != nil {
+		log.Printf("Resume: alerts held while resuming stay buffered: %v", err)
+	}
+	entries = append(entries, late...)
+	if err := td.state.Delete(ctx, pauseCountKey); err != nil {
+		return st, &StateError{Op: "del", Key: pauseCountKey, Err: err}
+	}
+	st.Held = int64(len(entries))
+
+	for _, entry := range entries {
+		var held pausedAlert
+		if err := json.Unmarshal([]byte(entry), &held); err != nil {
+			log.Printf("Skipping unreadable paused alert: %v", err)
+			continue
+		}
+		held.Alert.stateKey = held.StateKey
+		select {
+		case <-td.stop:
+			return st, fmt.Errorf("shutting down with %d paused alerts unsent", len(entries))
+		case td.alertChan <- held.Alert:
+		}
+	}
+	return st, nil
+}
+
+// drainPauseBuffer moves the held alerts to a key of this resume's own and
+// returns them. The move is atomic, so each held alert is drained once.
+func (td *ThreatDetector) drainPauseBuffer(ctx context.Context) ([]string, error) {
+	drainKey := fmt.Sprintf("%s:resume:%d", pauseBufferKey, time.Now().UnixNano())
+	moved, err := td.state.RenameIfAbsent(ctx, pauseBufferKey, drainKey)
+	if err != nil {
+		return nil, &StateError{Op: "renamenx", Key: pauseBufferKey, Err: err}
+	}
+	if !moved {
+		return nil, nil
+	}
+	entries, err := td.state.ListRange(ctx, drainKey)
+	if err != nil {
+		return nil, &StateError{Op: "lrange", Key: drainKey, Err: err}
+	}
+	if err := td.state.Delete(ctx, drainKey); err != nil {
+		log.Printf("Resume: removing %s: %v", drainKey, err)
+	}
+	return entries, nil
+}
+
+// pauseRequest optionally explains POST /alerting/pause
+type pauseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handlePause serves POST /alerting/pause, POST /alerting/resume and
+// GET /alerting/status
+func (td *ThreatDetector) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !bearerTokenOK(r, td.cfg().Pause.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/alerting/status":
+		st, err := td.pauseStatus(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	case r.Method == http.MethodPost && r.URL.Path == "/alerting/pause":
+		var req pauseRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		st, err := td.pauseAlerting(r.Context(), req.Reason)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Alerting paused from %s: %s", r.RemoteAddr, st.Reason)
+		writeJSON(w, http.StatusOK, st)
+	case r.Method == http.MethodPost && r.URL.Path == "/alerting/resume":
+		st, err := td.resumeAlerting(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if st.Paused {
+			log.Printf("Alerting resumed from %s after %s: %d alerts flushed, %d dropped",
+				r.RemoteAddr, time.Since(*st.Since).Truncate(time.Second), st.Held, st.Dropped)
+		}
+		st.Paused = false
+		writeJSON(w, http.StatusOK, st)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// logPauseState warns at startup when alerting was left paused
+func (td *ThreatDetector) logPauseState() {
+	if !td.cfg().Pause.Enabled {
+		return
+	}
+	st, err := td.pauseStatus(td.ctx)
+	if err != nil {
+		log.Printf("Pause check failed: %v", err)
+		return
+	}
+	if st.Paused {
+		log.Printf("Alerting is paused since %s (%s); %d alerts held. POST /alerting/resume to resume.",
+			st.Since.Format(time.RFC3339), st.Reason, st.Held)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentResumesPublishEachAlertOnce(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Pause.Enabled = true
+	cfg.Pause.Token = "secret"
+	cfg.Pause.BufferSize = 10
+	td := newTestDetector(t, cfg)
+	ctx := context.Background()
+
+	if _, err := td.pauseAlerting(ctx, "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if !td.holdIfPaused(ctx, ThreatAlert{AlertID: fmt.Sprintf("BF-%d", i), ThreatType: "BRUTE_FORCE"}) {
+			t.Fatalf("alert %d published while paused", i)
+		}
+	}
+
+	// Two replicas resuming at once
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := td.resumeAlerting(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	alerts := drainAlerts(td)
+	if len(alerts) != 3 {
+		t.Fatalf("resume published %d alerts, want the 3 held", len(alerts))
+	}
+	for i, alert := range alerts {
+		if want := fmt.Sprintf("BF-%d", i); alert.AlertID != want {
+			t.Errorf("alert %d = %s, want %s", i, alert.AlertID, want)
+		}
+	}
+	if st, _ := td.pauseStatus(ctx); st.Paused {
+		t.Error("still paused after resume")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// validate checks the poison message settings
+func (c *PoisonConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("poison.max_attempts must be at least 1")
+	}
+	if c.TTL.Duration < time.Minute {
+		return fmt.Errorf("poison.ttl must be at least 1m")
+	}
+	return nil
+}
+
+// attemptKey identifies a message across redeliveries: its stream entry ID,
+// or its topic, partition and offset
+func attemptKey(msg kafka.Message) string {
+	if id := streamID(msg); id != "" {
+		return fmt.Sprintf("poison:%s/%s", msg.Topic, id)
+	}
+	return fmt.Sprintf("poison:%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// isPoison counts a delivery of msg and reports whether it has now been
+// delivered more than poison.max_attempts times without being acked. Such a
+// message is dead-lettered; the caller acks it instead of processing it.
+// If Redis can't be reached the message is processed as usual.
+func (td *ThreatDetector) isPoison(ctx context.Context, workerID int, msg kafka.Message) bool {
+	cfg := td.cfg().Poison
+	if !cfg.Enabled {
+		return false
+	}
+	attempts, err := td.state.Incr(ctx, attemptKey(msg), cfg.TTL.Duration)
+	if err != nil {
+		log.Printf("Worker %d poison check: %v", workerID, &StateError{Op: "incr", Key: attemptKey(msg), Err: err})
+		return false
+	}
+	if attempts <= int64(cfg.MaxAttempts) {
+		return false
+	}
+
+	poisonMessages.Inc()
+	cause := fmt.Errorf("message delivered %d times without completing processing", attempts-1)
+	log.Printf("Worker %d skipping poison message %s: %v", workerID, attemptKey(msg), cause)
+	td.deadLetter(ctx, msg, cause)
+	return true
+}
+
+// clearAttempts forgets a message's delivery count once it is acked
+func (td *ThreatDetector) clearAttempts(ctx context.Context, msg kafka.Message) {
+	if !td.cfg().Poison.Enabled {
+		return
+	}
+	if err := td.state.Delete(ctx, attemptKey(msg)); err != nil {
+		log.Printf("Error clearing delivery count of %s: %v", attemptKey(msg), err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrivacyBeforeDetection(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Privacy = PrivacyConfig{
+		Enabled: true,
+		Fields:  map[string]string{"user": "hash", "metadata.host": "mask"},
+		RawLog:  "redact",
+		Salt:    "test-salt",
+	}
+	td := newTestDetector(t, cfg)
+	ctx := context.Background()
+
+	for i := 0; i < cfg.Thresholds.BruteForce; i++ {
+		event := SecurityEvent{
+			EventType: "authentication", Action: "login", Result: "failed",
+			SourceIP: "203.0.113.7", User: "alice",
+			RawLog:   "Failed password for alice from 203.0.113.7 port 22 ssh2",
+			Metadata: map[string]string{"host": "web-1"},
+		}
+		event.normalize()
+		cfg.Privacy.apply(&event)
+		if event.User == "alice" || event.Metadata["host"] != maskedValue || strings.Contains(event.RawLog, "alice") {
+			t.Fatalf("personal data left in %+v", event)
+		}
+		if err := td.detectThreats(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Hashing is stable, so the per-user state still adds up and the alert
+	// names the pseudonym an analyst can look up with the same salt
+	alerts := drainAlerts(td)
+	if len(alerts) != 1 || alerts[0].ThreatType != "BRUTE_FORCE" {
+		t.Fatalf("alerts = %+v, want one BRUTE_FORCE", alerts)
+	}
+	if want := pseudonymize("test-salt", "alice"); alerts[0].User != want {
+		t.Errorf("alert user %q, want the pseudonym %s", alerts[0].User, want)
+	}
+	if len(alerts[0].RawEvents) == 0 {
+		t.Error("alert carries no raw events")
+	}
+	for _, line := range alerts[0].RawEvents {
+		if strings.Contains(line, "alice") {
+			t.Errorf("raw event %q names the user", line)
+		}
+	}
+}
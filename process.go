@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// LineageSignature is a parent -> child process pair that legitimate
+// software rarely produces
+type LineageSignature struct {
+	Name     string   `json:"name"`
+	Parents  []string `json:"parents"`  // parent image names or globs (empty: any parent)
+	Children []string `json:"children"` // child image names or globs
+	// Commands optionally narrows the child to command lines matching one of
+	// these globs (e.g. "schtasks /create *")
+	Commands []string `json:"commands"`
+	// ExceptParents are the expected parents; any other parent matches.
+	// Use it for actions that are normal from a few installers only.
+	ExceptParents []string `json:"except_parents"`
+	Severity      string   `json:"severity"` // default: process_lineage.severity
+}
+
+// imageName reduces an executable path to its lowercase base name without
+// ".exe", so "C:\Program Files\Microsoft Office\WINWORD.EXE" reads as "winword"
+func imageName(image string) string {
+	image = strings.ToLower(strings.TrimSpace(image))
+	if i := strings.LastIndexAny(image, `/\`); i >= 0 {
+		image = image[i+1:]
+	}
+	return strings.TrimSuffix(image, ".exe")
+}
+
+// matchCommandLine reports whether a command line matches any glob. Unlike
+// path.Match alone, "*" also spans "/" so switches and paths match.
+func matchCommandLine(patterns []string, cmd string) bool {
+	cmd = strings.ReplaceAll(cmd, "/", "\x00")
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), cmd); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a parent/child pair fits the signature
+func (s *LineageSignature) matches(parent, child, cmd string) bool {
+	if !matchAny(s.Children, child) {
+		return false
+	}
+	if len(s.Parents) > 0 && !matchAny(s.Parents, parent) {
+		return false
+	}
+	if matchAny(s.ExceptParents, parent) {
+		return false
+	}
+	return len(s.Commands) == 0 || matchCommandLine(s.Commands, cmd)
+}
+
+// validate checks the lineage settings and lowercases the signatures
+func (c *ProcessLineageConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.ParentField == "" || c.ImageField == "" {
+		return fmt.Errorf("process_lineage.parent_field and process_lineage.image_field are required when enabled")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("process_lineage.severity %q is not a severity", c.Severity)
+	}
+
+	for i := range c.Signatures {
+		sig := &c.Signatures[i]
+		if sig.Name == "" {
+			return fmt.Errorf("process_lineage.signatures[%d]: name is required", i)
+		}
+		if len(sig.Children) == 0 {
+			return fmt.Errorf("process_lineage.signatures[%d] (%s): children is required", i, sig.Name)
+		}
+		if sig.Severity != "" && severityRank(sig.Severity) < 0 {
+			return fmt.Errorf("process_lineage.signatures[%d] (%s): severity %q is not a severity", i, sig.Name, sig.Severity)
+		}
+		for _, list := range [][]string{sig.Parents, sig.Children, sig.Commands, sig.ExceptParents} {
+			for j, p := range list {
+				list[j] = strings.ToLower(p)
+			}
+			if err := validatePatterns(list); err != nil {
+				return fmt.Errorf("process_lineage.signatures[%d] (%s): %w", i, sig.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isSuspiciousProcess matches a process creation event against the lineage
+// signatures. Events without both parent and child image are skipped.
+func (td *ThreatDetector) isSuspiciousProcess(event SecurityEvent) (*LineageSignature, string, string) {
+	cfg := &td.cfg().ProcessLineage
+	if !cfg.Enabled || event.eventTypeLower != "process" {
+		return nil, "", ""
+	}
+	parent := imageName(event.Metadata[cfg.ParentField])
+	child := imageName(event.Metadata[cfg.ImageField])
+	if parent == "" || child == "" {
+		return nil, "", ""
+	}
+	cmd := normalizeCommand(event.Metadata[cfg.CommandField])
+
+	for i := range cfg.Signatures {
+		if sig := &cfg.Signatures[i]; sig.matches(parent, child, cmd) {
+			return sig, parent, child
+		}
+	}
+	return nil, "", ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validate checks the protocol-downgrade settings
+func (c *ProtocolDowngradeConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Weak) == 0 {
+		return fmt.Errorf("protocol_downgrade.weak is required when enabled")
+	}
+	for field, patterns := range c.Weak {
+		for i, p := range patterns {
+			patterns[i] = strings.ToLower(p)
+		}
+		if err := validatePatterns(patterns); err != nil {
+			return fmt.Errorf("protocol_downgrade.weak[%s]: %w", field, err)
+		}
+	}
+	for i, t := range c.EventTypes {
+		c.EventTypes[i] = strings.ToLower(t)
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("protocol_downgrade.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("protocol_downgrade.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// weakProtocols returns the event's metadata values that match the weak
+// list, as sorted "field=value" pairs
+func (c *ProtocolDowngradeConfig) weakProtocols(event SecurityEvent) []string {
+	var weak []string
+	for field, patterns := range c.Weak {
+		value := strings.ToLower(strings.TrimSpace(event.Metadata[field]))
+		if value != "" && matchAny(patterns, value) {
+			weak = append(weak, field+"="+value)
+		}
+	}
+	sort.Strings(weak)
+	return weak
+}
+
+// isProtocolDowngrade reports weak protocols or ciphers negotiated by an
+// authentication event. Each source IP and protocol alerts once per window,
+// so a legacy client logging in all day raises one alert, not hundreds.
+func (td *ThreatDetector) isProtocolDowngrade(ctx context.Context, event SecurityEvent) ([]string, error) {
+	cfg := td.cfg().ProtocolDowngrade
+	if !cfg.Enabled || len(event.Metadata) == 0 {
+		return nil, nil
+	}
+	if len(cfg.EventTypes) > 0 && !containsString(cfg.EventTypes, event.eventTypeLower) {
+		return nil, nil
+	}
+	weak := cfg.weakProtocols(event)
+	if len(weak) == 0 {
+		return nil, nil
+	}
+
+	key := stateKey(event, "downgrade", event.ipKey()+":"+strings.Join(weak, ","))
+	seen, err := td.dedup.SeenRecently(ctx, key, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	return weak, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validate checks the ransomware settings and lowercases the lists
+func (c *RansomwareConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.FileField == "" || c.ProcessField == "" {
+		return fmt.Errorf("ransomware.file_field and process_field are required when enabled")
+	}
+	if len(c.Actions) == 0 {
+		return fmt.Errorf("ransomware.actions is required when enabled")
+	}
+	for i, a := range c.Actions {
+		c.Actions[i] = strings.ToLower(a)
+	}
+	for i, ext := range c.SuspiciousExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("ransomware.suspicious_extensions: %q must start with a dot", ext)
+		}
+		c.SuspiciousExtensions[i] = strings.ToLower(ext)
+	}
+	if c.Threshold < 1 {
+		return fmt.Errorf("ransomware.threshold must be at least 1")
+	}
+	if c.RenameThreshold < 0 {
+		return fmt.Errorf("ransomware.rename_threshold must not be negative")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("ransomware.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("ransomware.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// ransomwareHit is what tripped the rule
+type ransomwareHit struct {
+	identity string // process image name and user
+	files    int64  // distinct files touched in the window
+	renames  int64  // distinct renames to a suspicious extension
+	key      string // distinct-file counter
+}
+
+// fileHash keeps per-file keys short whatever the path length
+func fileHash(path string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(path)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// suspiciousExtension returns the configured extension a path ends with
+func (c *RansomwareConfig) suspiciousExtension(path string) string {
+	path = strings.ToLower(path)
+	for _, ext := range c.SuspiciousExtensions {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// isRansomwareBehavior counts the distinct files one process, run by one
+// user, touches within the window, and separately its renames to a known
+// ransomware extension. It fires once per window when either count reaches
+// its threshold. Events without a file path are ignored.
+func (td *ThreatDetector) isRansomwareBehavior(ctx context.Context, event SecurityEvent) (*ransomwareHit, error) {
+	cfg := td.cfg().Ransomware
+	if !cfg.Enabled || !containsString(cfg.Actions, event.actionLower) {
+		return nil, nil
+	}
+	file := event.Metadata[cfg.FileField]
+	process := imageName(event.Metadata[cfg.ProcessField])
+	if file == "" || process == "" {
+		return nil, nil
+	}
+	hit := &ransomwareHit{identity: process + ":" + strings.ToLower(event.User)}
+	window := cfg.Window.Duration
+
+	// Touching the same file again doesn't count
+	hit.key = stateKey(event, "ransomware_files", hit.identity)
+	seenKey := stateKey(event, "ransomware_file", hit.identity+":"+fileHash(file))
+	first, err := td.state.SetIfAbsent(ctx, seenKey, "1", window)
+	if err != nil {
+		return nil, &StateError{Op: "setnx", Key: seenKey, Err: err}
+	}
+	if first {
+		if hit.files, err = td.countInWindow(ctx, event, "RANSOMWARE_BEHAVIOR", hit.key, window); err != nil {
+			return nil, err
+		}
+		if err := td.recordRawEvent(ctx, event, hit.key, window); err != nil {
+			return nil, err
+		}
+	} else if hit.files, err = td.counterValue(ctx, hit.key); err != nil {
+		return nil, err
+	}
+
+	renameKey := stateKey(event, "ransomware_renames", hit.identity)
+	target := event.Metadata[cfg.TargetField]
+	if target == "" {
+		target = file
+	}
+	if event.actionLower == "rename" && cfg.suspiciousExtension(target) != "" {
+		seenKey := stateKey(event, "ransomware_rename", hit.identity+":"+fileHash(target))
+		first, err := td.state.SetIfAbsent(ctx, seenKey, "1", window)
+		if err != nil {
+			return nil, &StateError{Op: "setnx", Key: seenKey, Err: err}
+		}
+		if first {
+			if hit.renames, err = td.state.Incr(ctx, renameKey, window); err != nil {
+				return nil, &StateError{Op: "incr", Key: renameKey, Err: err}
+			}
+		}
+	}
+	if hit.renames == 0 {
+		if hit.renames, err = td.counterValue(ctx, renameKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if hit.files < int64(cfg.Threshold) && (cfg.RenameThreshold == 0 || hit.renames < int64(cfg.RenameThreshold)) {
+		return nil, nil
+	}
+
+	// One alert per process and user per window, however many files follow
+	alertedKey := stateKey(event, "ransomware_alerted", hit.identity)
+	seen, err := td.dedup.SeenRecently(ctx, alertedKey, window)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: alertedKey, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	return hit, nil
+}
+
+// counterValue reads a counter, 0 if it doesn't exist
+func (td *ThreatDetector) counterValue(ctx context.Context, key string) (int64, error) {
+	value, err := td.state.Get(ctx, key)
+	if err != nil {
+		return 0, &StateError{Op: "get", Key: key, Err: err}
+	}
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n, nil
+}
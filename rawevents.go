@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+// truncatedMarker is appended to raw log lines cut at MaxLineBytes
+const truncatedMarker = "…[truncated]"
+
+// truncateLine cuts s to at most max bytes (marker included) on a rune boundary
+func truncateLine(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	cut := max - len(truncatedMarker)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncatedMarker, true
+}
+
+// rawEventsKey is the list of recent raw lines behind a counter key
+func rawEventsKey(counterKey string) string {
+	return "raw:" + counterKey
+}
+
+// rawLineKey is where dedup storage keeps a distinct line, by content hash.
+// Identical lines share one copy however many counters reference them.
+func rawLineKey(ref string) string {
+	return "rawline:" + ref
+}
+
+// recordRawEvent remembers the event's raw line next to a counter so an alert
+// raised on that counter can carry the lines that triggered it
+func (td *ThreatDetector) recordRawEvent(ctx context.Context, event SecurityEvent, counterKey string, ttl time.Duration) error {
+	cfg := td.cfg().RawEvents
+	if cfg.MaxEvents == 0 || event.RawLog == "" {
+		return nil
+	}
+	line, _ := truncateLine(event.RawLog, cfg.MaxLineBytes)
+
+	entry, err := td.encodeRawLine(ctx, line, ttl)
+	if err != nil {
+		return err
+	}
+
+	key := rawEventsKey(counterKey)
+	if err := td.state.AppendList(ctx, key, entry, int64(cfg.MaxEvents), ttl); err != nil {
+		return &StateError{Op: "rpush", Key: key, Err: err}
+	}
+	return nil
+}
+
+// encodeRawLine turns a line into the list entry for the configured storage
+func (td *ThreatDetector) encodeRawLine(ctx context.Context, line string, ttl time.Duration) (string, error) {
+	switch td.cfg().RawEvents.Storage {
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(line))
+		zw.Close()
+		return buf.String(), nil
+	case "dedup":
+		sum := sha256.Sum256([]byte(line))
+		ref := hex.EncodeToString(sum[:16])
+		// Rewriting the line refreshes its TTL, so it outlives every list using it
+		key := rawLineKey(ref)
+		if err := td.state.Set(ctx, key, line, ttl); err != nil {
+			return "", &StateError{Op: "set", Key: key, Err: err}
+		}
+		return ref, nil
+	}
+	return line, nil
+}
+
+// decodeRawLine reverses encodeRawLine. ok is false for an entry whose line
+// is gone (an expired dedup copy) or can't be read.
+func (td *ThreatDetector) decodeRawLine(ctx context.Context, entry string) (string, bool, error) {
+	switch td.cfg().RawEvents.Storage {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader([]byte(entry)))
+		if err != nil {
+			return entry, true, nil // stored before storage was switched to gzip
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			return "", false, nil
+		}
+		return string(data), true, nil
+	case "dedup":
+		key := rawLineKey(entry)
+		line, err := td.state.Get(ctx, key)
+		if err != nil {
+			return "", false, &StateError{Op: "get", Key: key, Err: err}
+		}
+		return line, line != "", nil
+	}
+	return entry, true, nil
+}
+
+// rawEventsFor returns the raw lines recorded next to a counter, oldest first
+func (td *ThreatDetector) rawEventsFor(ctx context.Context, counterKey string) ([]string, error) {
+	if td.cfg().RawEvents.MaxEvents == 0 {
+		return nil, nil
+	}
+	key := rawEventsKey(counterKey)
+	entries, err := td.state.ListRange(ctx, key)
+	if err != nil {
+		return nil, &StateError{Op: "lrange", Key: key, Err: err}
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		line, ok, err := td.decodeRawLine(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// boundRawEvents enforces the RawEvents caps on an alert: newest MaxEvents
+// lines, each at most MaxLineBytes, and the whole alert within MaxAlertBytes
+// (dropping IP history, then the oldest lines) so the producer never rejects it
+func (c *DetectorConfig) boundRawEvents(alert *ThreatAlert) {
+	cfg := c.RawEvents
+
+	if len(alert.RawEvents) > cfg.MaxEvents {
+		dropped := len(alert.RawEvents) - cfg.MaxEvents
+		alert.RawEvents = alert.RawEvents[dropped:]
+		log.Printf("Alert %s: dropped %d raw events over max_events", alert.AlertID, dropped)
+	}
+
+	truncated := 0
+	for i, line := range alert.RawEvents {
+		var cut bool
+		if alert.RawEvents[i], cut = truncateLine(line, cfg.MaxLineBytes); cut {
+			truncated++
+		}
+	}
+	if truncated > 0 {
+		log.Printf("Alert %s: truncated %d raw events over max_line_bytes", alert.AlertID, truncated)
+	}
+
+	if cfg.MaxAlertBytes <= 0 {
+		return
+	}
+
+	// Over the limit: shed IP history and timeline first (context), then the
+	// oldest raw lines (evidence), re-measuring after each drop
+	size := alertSize(*alert)
+	droppedHistory, droppedRaw := 0, 0
+	for size > cfg.MaxAlertBytes {
+		switch {
+		case len(alert.RecentEvents) > 0:
+			alert.RecentEvents = alert.RecentEvents[1:]
+			droppedHistory++
+		case len(alert.Timeline) > 0:
+			alert.Timeline = alert.Timeline[1:]
+			droppedHistory++
+		case len(alert.RawEvents) > 0:
+			alert.RawEvents = alert.RawEvents[1:]
+			droppedRaw++
+		default:
+			log.Printf("Alert %s: %d bytes still exceeds max_alert_bytes without raw events", alert.AlertID, size)
+			return
+		}
+		size = alertSize(*alert)
+	}
+	if droppedHistory > 0 || droppedRaw > 0 {
+		log.Printf("Alert %s: dropped %d history and %d raw events to fit max_alert_bytes (%d)",
+			alert.AlertID, droppedHistory, droppedRaw, cfg.MaxAlertBytes)
+	}
+}
+
+// alertSize returns the serialized size of an alert
+func alertSize(alert ThreatAlert) int {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
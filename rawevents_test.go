@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// floodLines are the few distinct lines a sustained brute force repeats
+var floodLines = []string{
+	"sshd[4242]: Failed password for root from 203.0.113.7 port 51000 ssh2",
+	"sshd[4242]: Failed password for invalid user admin from 203.0.113.7 port 51001 ssh2",
+	"sshd[4242]: Failed password for invalid user oracle from 203.0.113.7 port 51002 ssh2",
+	"sshd[4242]: Connection closed by authenticating user root 203.0.113.7 port 51003 [preauth]",
+}
+
+// storedBytes is the memory store's payload: keys plus string and list values
+func storedBytes(s *memoryStore) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, e := range s.keys {
+		n += len(key) + len(e.str)
+		for _, v := range e.list {
+			n += len(v)
+		}
+	}
+	return n
+}
+
+func TestRawEventStorageRoundTrip(t *testing.T) {
+	for _, storage := range []string{"list", "gzip", "dedup"} {
+		t.Run(storage, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RawEvents.Storage = storage
+			td := newTestDetector(t, cfg)
+			ctx := context.Background()
+
+			for _, line := range floodLines {
+				event := SecurityEvent{SourceIP: "203.0.113.7", RawLog: line}
+				if err := td.recordRawEvent(ctx, event, "failed_auth:203.0.113.7", 5*time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := td.rawEventsFor(ctx, "failed_auth:203.0.113.7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(floodLines) {
+				t.Errorf("rawEventsFor = %q, want %q", got, floodLines)
+			}
+		})
+	}
+}
+
+// BenchmarkRawEventStorage floods many counters with a few repeated lines
+// and reports the bytes each storage mode keeps per counter
+func BenchmarkRawEventStorage(b *testing.B) {
+	const counters = 200
+	for _, storage := range []string{"list", "gzip", "dedup"} {
+		b.Run(storage, func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.RawEvents.Storage = storage
+			td := newTestDetector(b, cfg)
+			ctx := context.Background()
+
+			keys := make([]string, counters)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("failed_auth:198.51.100.%d", i)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				event := SecurityEvent{RawLog: floodLines[i%len(floodLines)]}
+				if err := td.recordRawEvent(ctx, event, keys[i%counters], 5*time.Minute); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(storedBytes(td.state.(*memoryStore)))/counters, "stored-B/counter")
+		})
+	}
+}
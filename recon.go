@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReconSignature is a set of commands that together look like
+// post-exploitation reconnaissance
+type ReconSignature struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands"` // command names ("id") or globs over the command line ("cat /etc/passwd*")
+	Ordered  bool     `json:"ordered"`  // commands must run in the listed order
+	// MinMatches is how many distinct commands of an unordered signature
+	// must run within the window (default: all of them)
+	MinMatches int `json:"min_matches"`
+}
+
+// stepMatches reports whether a normalized command line matches one
+// signature command. A bare name matches that program with any arguments.
+func stepMatches(pattern, cmd string) bool {
+	if !strings.ContainsAny(pattern, " *?[") {
+		name, _, _ := strings.Cut(cmd, " ")
+		return name == pattern
+	}
+	ok, _ := path.Match(pattern, cmd)
+	return ok
+}
+
+// normalizeCommand collapses whitespace, lowercases, and strips the
+// directory and ".exe" from the program, so "/usr/bin/id -u" reads as
+// "id -u" and "C:\Windows\System32\whoami.exe /all" as "whoami /all"
+func normalizeCommand(cmd string) string {
+	fields := strings.Fields(strings.ToLower(cmd))
+	if len(fields) == 0 {
+		return ""
+	}
+	program := fields[0]
+	if i := strings.LastIndexAny(program, `/\`); i >= 0 {
+		program = program[i+1:]
+	}
+	fields[0] = strings.TrimSuffix(program, ".exe")
+	return strings.Join(fields, " ")
+}
+
+// watches reports whether cmd matches any command of any signature
+func (c *ReconConfig) watches(cmd string) bool {
+	for _, sig := range c.Signatures {
+		for _, pattern := range sig.Commands {
+			if stepMatches(pattern, cmd) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match returns the commands from history (oldest first, ending with the
+// current command) that complete the signature, or nil
+func (s *ReconSignature) match(history []string) []string {
+	current := history[len(history)-1]
+
+	if s.Ordered {
+		// The current command finishes the sequence; earlier steps appear before it in order
+		if !stepMatches(s.Commands[len(s.Commands)-1], current) {
+			return nil
+		}
+		matched := []string{current}
+		step := len(s.Commands) - 2
+		for i := len(history) - 2; i >= 0 && step >= 0; i-- {
+			if stepMatches(s.Commands[step], history[i]) {
+				matched = append([]string{history[i]}, matched...)
+				step--
+			}
+		}
+		if step >= 0 {
+			return nil
+		}
+		return matched
+	}
+
+	currentHit := false
+	for _, pattern := range s.Commands {
+		if stepMatches(pattern, current) {
+			currentHit = true
+			break
+		}
+	}
+	if !currentHit {
+		return nil
+	}
+
+	// Each signature command counts once, by its most recent match
+	var matched []string
+	for _, pattern := range s.Commands {
+		for i := len(history) - 1; i >= 0; i-- {
+			if stepMatches(pattern, history[i]) {
+				matched = append(matched, history[i])
+				break
+			}
+		}
+	}
+	if len(matched) < s.MinMatches {
+		return nil
+	}
+	return matched
+}
+
+// validate checks the recon settings and fills signature defaults
+func (c *ReconConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("recon.window must be positive")
+	}
+	if c.CommandField == "" {
+		return fmt.Errorf("recon.command_field is required when enabled")
+	}
+	if c.MaxCommands < 2 {
+		return fmt.Errorf("recon.max_commands must be at least 2")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("recon.severity %q is not a severity", c.Severity)
+	}
+
+	for i := range c.Signatures {
+		sig := &c.Signatures[i]
+		if sig.Name == "" {
+			return fmt.Errorf("recon.signatures[%d]: name is required", i)
+		}
+		if len(sig.Commands) < 2 {
+			return fmt.Errorf("recon.signatures[%d] (%s): needs at least 2 commands", i, sig.Name)
+		}
+		for j, cmd := range sig.Commands {
+			sig.Commands[j] = strings.ToLower(cmd)
+		}
+		if err := validatePatterns(sig.Commands); err != nil {
+			return fmt.Errorf("recon.signatures[%d] (%s): %w", i, sig.Name, err)
+		}
+		if sig.MinMatches == 0 || sig.Ordered {
+			sig.MinMatches = len(sig.Commands)
+		}
+		if sig.MinMatches < 2 || sig.MinMatches > len(sig.Commands) {
+			return fmt.Errorf("recon.signatures[%d] (%s): min_matches must be between 2 and %d", i, sig.Name, len(sig.Commands))
+		}
+	}
+	return nil
+}
+
+// reconSession identifies the shell session a command ran in
+func (c *ReconConfig) reconSession(event SecurityEvent) string {
+	if c.SessionField != "" {
+		if session := event.Metadata[c.SessionField]; session != "" {
+			return session
+		}
+	}
+	if event.User == "" {
+		return event.ipKey()
+	}
+	return event.User + "@" + event.ipKey()
+}
+
+// isReconActivity buffers watched commands per session and returns the
+// signature the event completes with the commands that matched it
+func (td *ThreatDetector) isReconActivity(ctx context.Context, event SecurityEvent) (*ReconSignature, []string, error) {
+	cfg := &td.cfg().Recon
+	if !cfg.Enabled {
+		return nil, nil, nil
+	}
+	cmd := normalizeCommand(event.Metadata[cfg.CommandField])
+	if cmd == "" || !cfg.watches(cmd) {
+		return nil, nil, nil
+	}
+
+	session := cfg.reconSession(event)
+	key := stateKey(event, "cmd_seq", session)
+	now := td.now()
+	entry := strconv.FormatInt(now.Unix(), 10) + ":" + cmd
+	if err := td.state.AppendList(ctx, key, entry, int64(cfg.MaxCommands), cfg.Window.Duration); err != nil {
+		return nil, nil, &StateError{Op: "rpush", Key: key, Err: err}
+	}
+
+	raw, err := td.state.ListRange(ctx, key)
+	if err != nil {
+		return nil, nil, &StateError{Op: "lrange", Key: key, Err: err}
+	}
+
+	// Keep the commands still inside the window, oldest first
+	cutoff := now.Add(-cfg.Window.Duration).Unix()
+	var history []string
+	for _, r := range raw {
+		ts, line, ok := strings.Cut(r, ":")
+		if !ok {
+			continue
+		}
+		if unix, err := strconv.ParseInt(ts, 10, 64); err == nil && unix >= cutoff {
+			history = append(history, line)
+		}
+	}
+	if len(history) == 0 {
+		return nil, nil, nil
+	}
+
+	for i := range cfg.Signatures {
+		sig := &cfg.Signatures[i]
+		matched := sig.match(history)
+		if matched == nil {
+			continue
+		}
+
+		// One alert per session and signature per window, not one per extra command
+		alertedKey := stateKey(event, "recon_alerted", session+":"+sig.Name)
+		seen, err := td.dedup.SeenRecently(ctx, alertedKey, cfg.Window.Duration)
+		if err != nil {
+			return nil, nil, &StateError{Op: "dedup", Key: alertedKey, Err: err}
+		}
+		if !seen {
+			return sig, matched, nil
+		}
+	}
+	return nil, nil, nil
+}
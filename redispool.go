@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// validate checks the Redis pool settings
+func (c *RedisPoolConfig) validate() error {
+	if c.PoolSize < 0 || c.MinIdleConns < 0 {
+		return fmt.Errorf("redis_pool.pool_size and min_idle_conns must not be negative")
+	}
+	if c.PoolSize > 0 && c.MinIdleConns > c.PoolSize {
+		return fmt.Errorf("redis_pool.min_idle_conns must not exceed pool_size")
+	}
+	timeouts := []struct {
+		name string
+		d    Duration
+	}{
+		{"dial_timeout", c.DialTimeout}, {"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout}, {"pool_timeout", c.PoolTimeout},
+	}
+	for _, t := range timeouts {
+		if t.d.Duration <= 0 {
+			return fmt.Errorf("redis_pool.%s must be positive", t.name)
+		}
+	}
+	return nil
+}
+
+// redisPoolSize is pool_size, or if unset enough connections for every
+// worker to hold a few at once alongside the sinks and background loops
+func (c *DetectorConfig) redisPoolSize() int {
+	if c.RedisPool.PoolSize > 0 {
+		return c.RedisPool.PoolSize
+	}
+	return max(10, 4*c.NumWorkers)
+}
+
+// redisMinIdleConns is min_idle_conns, or if unset one per worker
+func (c *DetectorConfig) redisMinIdleConns() int {
+	if c.RedisPool.MinIdleConns > 0 {
+		return c.RedisPool.MinIdleConns
+	}
+	return min(c.NumWorkers, c.redisPoolSize())
+}
+
+// newRedisClient connects to addr with the configured pool, logs the pool
+// settings and serves its pool stats on /metrics under the given name
+func newRedisClient(cfg *DetectorConfig, name, addr, password string) *redis.Client {
+	p := cfg.RedisPool
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           0,
+		PoolSize:     cfg.redisPoolSize(),
+		MinIdleConns: cfg.redisMinIdleConns(),
+		DialTimeout:  p.DialTimeout.Duration,
+		ReadTimeout:  p.ReadTimeout.Duration,
+		WriteTimeout: p.WriteTimeout.Duration,
+		PoolTimeout:  p.PoolTimeout.Duration,
+	})
+	log.Printf("Redis %s pool: %d connections (%d idle minimum), dial %s, read %s, write %s, pool wait %s",
+		name, cfg.redisPoolSize(), cfg.redisMinIdleConns(), p.DialTimeout, p.ReadTimeout, p.WriteTimeout, p.PoolTimeout)
+	redisPools.add(name, client)
+	return client
+}
+
+// poolStats serves the connection pool stats of the Redis clients. They are
+// read from the clients at scrape time.
+type poolStats struct {
+	mu      sync.Mutex
+	names   []string
+	clients map[string]*redis.Client
+}
+
+// redisPools holds the primary (and standby) clients
+var redisPools = func() *poolStats {
+	p := &poolStats{clients: make(map[string]*redis.Client)}
+	registry = append(registry, p)
+	return p
+}()
+
+// add registers a client, replacing one of the same name
+func (p *poolStats) add(name string, client *redis.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.clients[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.clients[name] = client
+}
+
+// write renders the pool stats in the Prometheus text exposition format
+func (p *poolStats) write(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]*redis.PoolStats, len(p.names))
+	for i, name := range p.names {
+		stats[i] = p.clients[name].PoolStats()
+	}
+	series := []struct {
+		name, help, kind string
+		value            func(s *redis.PoolStats) uint32
+	}{
+		{"sbla_redis_pool_hits_total", "Redis commands that found an idle pooled connection.", "counter",
+			func(s *redis.PoolStats) uint32 { return s.Hits }},
+		{"sbla_redis_pool_misses_total", "Redis commands that found no idle connection and dialed or waited for one.", "counter",
+			func(s *redis.PoolStats) uint32 { return s.Misses }},
+		{"sbla_redis_pool_timeouts_total", "Redis commands that gave up waiting pool_timeout for a connection.", "counter",
+			func(s *redis.PoolStats) uint32 { return s.Timeouts }},
+		{"sbla_redis_pool_connections", "Open Redis connections.", "gauge",
+			func(s *redis.PoolStats) uint32 { return s.TotalConns }},
+		{"sbla_redis_pool_idle_connections", "Idle Redis connections.", "gauge",
+			func(s *redis.PoolStats) uint32 { return s.IdleConns }},
+	}
+	for _, m := range series {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, name := range p.names {
+			fmt.Fprintf(w, "%s{pool=%q} %d\n", m.name, name, m.value(stats[i]))
+		}
+	}
+}
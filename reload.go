@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartOnlySettings are top-level config keys read once at startup (client
+// connections, goroutines, sinks). A reload keeps their running values.
+var restartOnlySettings = map[string]bool{
+	"kafka_brokers":       true,
+	"redis_addr":          true,
+	"redis_password":      true,
+	"redis_password_file": true,
+	"redis_pool":          true,
+	"standby":             true,
+	"dedup":               true,
+	"num_workers":         true,
+	"events_topic":        true,
+	"consumer_group":      true,
+	"input":               true,
+	"start_offset":        true,
+	"compression":         true,
+	"http_addr":           true,
+	"test_alert":          true,
+	"pause":               true,
+	"suppression_api":     true,
+	"simulation":          true,
+	"anonymizer":          true,
+	"snapshot":            true,
+	"sinks":               true,
+	"watchdog":            true,
+	"dispatch":            true,
+	"alert_store":         true,
+	"remediation":         true,
+	"enrichment":          true,
+	"ui":                  true,
+	"vulns":               true,
+	"learning":            true,
+}
+
+// Reload re-reads the config file and swaps it in without restarting. An
+// invalid file is rejected and the running config stays in place. Events
+// already being processed finish with the config they started with.
+func (td *ThreatDetector) Reload(path string) error {
+	old := td.cfg()
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("config reload: %w", err)
+	}
+
+	for _, name := range keepRestartOnly(old, cfg) {
+		log.Printf("Config reload: %s changed; takes effect after restart", name)
+	}
+	if cfg.Assets.Enabled != old.Assets.Enabled {
+		log.Printf("Config reload: assets.enabled changed; takes effect after restart")
+		cfg.Assets.Enabled = old.Assets.Enabled
+	}
+	if cfg.Feedback.Enabled != old.Feedback.Enabled {
+		log.Printf("Config reload: feedback.enabled changed; takes effect after restart")
+		cfg.Feedback.Enabled = old.Feedback.Enabled
+	}
+	if cfg.Scan.Enabled != old.Scan.Enabled || cfg.Scan.Interval != old.Scan.Interval {
+		log.Printf("Config reload: scan.enabled or scan.interval changed; takes effect after restart")
+		cfg.Scan.Enabled, cfg.Scan.Interval = old.Scan.Enabled, old.Scan.Interval
+	}
+	if o := old.Observations; cfg.Observations.Store != o.Store || cfg.Observations.Key != o.Key || cfg.Observations.Retention != o.Retention {
+		log.Printf("Config reload: observations.store, key or retention changed; takes effect after restart")
+		cfg.Observations.Store, cfg.Observations.Key, cfg.Observations.Retention = o.Store, o.Key, o.Retention
+	}
+
+	if err := td.userAllowlist.Update(cfg.UserAllowlist); err != nil {
+		return fmt.Errorf("config reload: %w", err)
+	}
+	if td.assets != nil {
+		if err := td.assets.Update(cfg.Assets); err != nil {
+			// Put the previous allowlist back so nothing from the rejected file applies
+			if rerr := td.userAllowlist.Update(old.UserAllowlist); rerr != nil {
+				log.Printf("Config reload: restoring user allowlist: %v", rerr)
+			}
+			return fmt.Errorf("config reload: %w", err)
+		}
+	}
+	td.debug.configure(cfg.Log)
+	td.config.Store(cfg)
+
+	changes := configDiff(old.Redacted(), cfg.Redacted())
+	if len(changes) == 0 {
+		log.Printf("Config reloaded from %s: no changes", path)
+		return nil
+	}
+	log.Printf("Config reloaded from %s: %d settings changed", path, len(changes))
+	for _, c := range changes {
+		log.Printf("  %s", c)
+	}
+	return nil
+}
+
+// keepRestartOnly copies restart-only settings from old into cfg and returns
+// the names of those the new file tried to change
+func keepRestartOnly(old, cfg *DetectorConfig) []string {
+	var changed []string
+	oldV := reflect.ValueOf(old).Elem()
+	newV := reflect.ValueOf(cfg).Elem()
+	t := oldV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if !restartOnlySettings[name] {
+			continue
+		}
+		if !reflect.DeepEqual(oldV.Field(i).Interface(), newV.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+		newV.Field(i).Set(oldV.Field(i))
+	}
+	return changed
+}
+
+// configDiff lists the settings that differ as "path: old -> new"
+func configDiff(old, cfg *DetectorConfig) []string {
+	before, after := flattenConfig(old), flattenConfig(cfg)
+
+	var changes []string
+	for k, v := range after {
+		if prev, ok := before[k]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: (unset) -> %s", k, v))
+		} else if prev != v {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, prev, v))
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: %s -> (unset)", k, v))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// flattenConfig maps each leaf setting's dotted path to its JSON value
+func flattenConfig(cfg *DetectorConfig) map[string]string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+
+	out := make(map[string]string)
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				if prefix != "" {
+					k = prefix + "." + k
+				}
+				walk(k, child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(fmt.Sprintf("%s[%d]", prefix, i), child)
+			}
+		default:
+			b, _ := json.Marshal(v)
+			out[prefix] = string(b)
+		}
+	}
+	walk("", tree)
+	return out
+}
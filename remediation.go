@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// RemediationResult is a message on the remediation results topic, sent by
+// whatever acts on our alerts once it has tried to carry out an action
+type RemediationResult struct {
+	Action    string     `json:"action"` // BLOCK_IP or UNBLOCK_IP
+	SourceIP  string     `json:"source_ip"`
+	TenantID  string     `json:"tenant_id,omitempty"`
+	AlertID   string     `json:"alert_id,omitempty"`
+	Success   bool       `json:"success"`
+	Error     string     `json:"error,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // when the block lapses, if known
+}
+
+// blockedKey returns the state key marking an IP as remediated. It takes an
+// event so the key is tenant-scoped exactly like the detection counters.
+func blockedKey(event SecurityEvent) string {
+	return stateKey(event, "blocked", event.ipKey())
+}
+
+// newRemediationReader builds the optional remediation results consumer
+func newRemediationReader(cfg *DetectorConfig) *kafka.Reader {
+	if !cfg.Remediation.Enabled {
+		return nil
+	}
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.KafkaBrokers,
+		Topic:       cfg.Remediation.Topic,
+		GroupID:     cfg.Remediation.ConsumerGroup,
+		StartOffset: kafka.FirstOffset, // results are few and each one matters
+		MaxWait:     time.Second,
+	})
+}
+
+// consumeRemediationResults applies remediation results until stop is closed
+func (td *ThreatDetector) consumeRemediationResults() {
+	defer td.wg.Done()
+
+	log.Printf("Remediation consumer started on %s", td.cfg().Remediation.Topic)
+
+	for {
+		msg, err := td.remediationReader.ReadMessage(td.ctx)
+		if err != nil {
+			select {
+			case <-td.stop:
+				log.Println("Remediation consumer shutting down")
+				return
+			default:
+			}
+			log.Printf("Error reading remediation result: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var result RemediationResult
+		if err := json.Unmarshal(msg.Value, &result); err != nil {
+			processingErrors.WithLabelValues("parse").Inc()
+			log.Printf("Dropping malformed remediation result at offset %d: %v", msg.Offset, err)
+			continue
+		}
+		if err := td.applyRemediationResult(result); err != nil {
+			processingErrors.WithLabelValues("state").Inc()
+			log.Printf("Error applying remediation result: %v", err)
+		}
+	}
+}
+
+// applyRemediationResult records or clears an IP's blocked marker
+func (td *ThreatDetector) applyRemediationResult(result RemediationResult) error {
+	event := SecurityEvent{SourceIP: result.SourceIP}
+	if result.TenantID != "" {
+		event.Metadata = map[string]string{"tenant_id": result.TenantID}
+	}
+	event.normalize()
+	if !event.addr.IsValid() {
+		return fmt.Errorf("remediation result for %q: invalid source_ip", result.SourceIP)
+	}
+
+	// A failed action changes nothing: the IP keeps alerting until a block sticks
+	if !result.Success {
+		log.Printf("Remediation %s failed for %s (alert %s): %s",
+			result.Action, event.SourceIP, result.AlertID, result.Error)
+		return nil
+	}
+
+	key := blockedKey(event)
+
+	switch strings.ToUpper(result.Action) {
+	case "BLOCK_IP":
+		ttl := td.cfg().Remediation.BlockTTL.Duration
+		if result.ExpiresAt != nil {
+			ttl = time.Until(*result.ExpiresAt)
+			if ttl <= 0 {
+				return nil // already lapsed
+			}
+		}
+		if err := td.state.Set(td.ctx, key, result.AlertID, ttl); err != nil {
+			return &StateError{Op: "set", Key: key, Err: err}
+		}
+		log.Printf("Confirmed block of %s for %s (alert %s)", event.SourceIP, ttl, result.AlertID)
+	case "UNBLOCK_IP":
+		if err := td.state.Delete(td.ctx, key); err != nil {
+			return &StateError{Op: "del", Key: key, Err: err}
+		}
+		log.Printf("Confirmed unblock of %s", event.SourceIP)
+	default:
+		log.Printf("Ignoring remediation result with unknown action %q", result.Action)
+	}
+	return nil
+}
+
+// isRemediated reports whether the event's source IP has a confirmed block
+func (td *ThreatDetector) isRemediated(ctx context.Context, event SecurityEvent) (bool, error) {
+	if td.remediationReader == nil || event.SourceIP == "" {
+		return false, nil
+	}
+	key := blockedKey(event)
+	blocked, err := td.state.Exists(ctx, key)
+	if err != nil {
+		return false, &StateError{Op: "exists", Key: key, Err: err}
+	}
+	return blocked, nil
+}
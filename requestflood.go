@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// validate checks the request flood settings
+func (c *RequestFloodConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Window.Duration < time.Second {
+		return fmt.Errorf("request_flood.window must be at least 1s")
+	}
+	if c.Rate <= 0 || c.Rate*c.Window.Seconds() < 2 {
+		return fmt.Errorf("request_flood.rate must allow at least 2 events per window")
+	}
+	for i, t := range c.EventTypes {
+		c.EventTypes[i] = strings.ToLower(t)
+	}
+	if c.Cooldown.Duration < c.Window.Duration {
+		return fmt.Errorf("request_flood.cooldown must be at least window")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("request_flood.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// threshold is the event count in one window that exceeds the rate
+func (c *RequestFloodConfig) threshold() int64 {
+	return int64(math.Floor(c.Rate*c.Window.Seconds())) + 1
+}
+
+// requestFloodHit is a source IP over the rate in the current window
+type requestFloodHit struct {
+	count   int64
+	elapsed time.Duration // into the window when the threshold was crossed
+}
+
+// rate is the observed events per second so far in the window
+func (h *requestFloodHit) rate() float64 {
+	return float64(h.count) / h.elapsed.Seconds()
+}
+
+// isRequestFlood counts each source IP's events, of any result, in fixed
+// windows and reports the event that takes the count past the rate. Each IP
+// alerts once per cooldown however long the flood lasts.
+func (td *ThreatDetector) isRequestFlood(ctx context.Context, event SecurityEvent) (*requestFloodHit, error) {
+	cfg := td.cfg().RequestFlood
+	if !cfg.Enabled || !event.addr.IsValid() {
+		return nil, nil
+	}
+	if len(cfg.EventTypes) > 0 && !containsString(cfg.EventTypes, event.eventTypeLower) {
+		return nil, nil
+	}
+
+	now := td.now()
+	start := now.Truncate(cfg.Window.Duration)
+	key := stateKey(event, "request_rate", fmt.Sprintf("%s:%d", event.ipKey(), start.Unix()))
+	count, err := td.state.IncrFixed(ctx, key, 2*cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "incr", Key: key, Err: err}
+	}
+	if count != cfg.threshold() {
+		return nil, nil
+	}
+
+	alertedKey := stateKey(event, "request_flood_alerted", event.ipKey())
+	seen, err := td.dedup.SeenRecently(ctx, alertedKey, cfg.Cooldown.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: alertedKey, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	// A burst inside the window's first second is rated per second
+	elapsed := now.Sub(start)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	return &requestFloodHit{count: count, elapsed: elapsed}, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// validate checks the retention settings
+func (c *StateRetentionConfig) validate() error {
+	for severity, d := range c.Severity {
+		if severityRank(severity) < 0 {
+			return fmt.Errorf("state_retention.severity: %q is not a severity", severity)
+		}
+		if d.Duration <= 0 {
+			return fmt.Errorf("state_retention.severity.%s must be positive", severity)
+		}
+	}
+	for threatType, d := range c.ThreatTypes {
+		if d.Duration <= 0 {
+			return fmt.Errorf("state_retention.threat_types.%s must be positive", threatType)
+		}
+	}
+	return nil
+}
+
+// For returns how long an alert's state is kept (0 = just its window)
+func (c StateRetentionConfig) For(alert ThreatAlert) time.Duration {
+	if d, ok := c.ThreatTypes[alert.ThreatType]; ok {
+		return d.Duration
+	}
+	return c.Severity[alert.Severity].Duration
+}
+
+// retainState extends the TTL of every key behind the alert's detection to
+// the configured retention. Keys are only ever extended, and a failure just
+// leaves the state to expire with its window.
+func (td *ThreatDetector) retainState(ctx context.Context, alert ThreatAlert) {
+	ttl := td.cfg().StateRetention.For(alert)
+	if ttl <= 0 || alert.stateKey == "" {
+		return
+	}
+
+	rawKey := rawEventsKey(alert.stateKey)
+	keys := append([]string{alert.stateKey, rawKey}, alert.relatedKeys...)
+
+	// Dedup storage keeps each line under its own key; keep those too
+	if td.cfg().RawEvents.Storage == "dedup" {
+		refs, err := td.state.ListRange(ctx, rawKey)
+		if err != nil {
+			log.Printf("State retention for %s: %v", alert.AlertID, &StateError{Op: "lrange", Key: rawKey, Err: err})
+		}
+		for _, ref := range refs {
+			keys = append(keys, rawLineKey(ref))
+		}
+	}
+
+	for _, key := range keys {
+		if err := td.state.ExtendTTL(ctx, key, ttl); err != nil {
+			log.Printf("State retention for %s: %v", alert.AlertID, &StateError{Op: "pexpire", Key: key, Err: err})
+		}
+	}
+}
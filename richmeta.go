@@ -0,0 +1,68 @@
+package main
+
+import "strconv"
+
+// Accessors for SecurityEvent.RichMetadata. Paths are dotted ("file.owner")
+// and walk nested objects. They are methods so custom rule and middleware
+// expressions can call them too: RichStrings("files"), RichNumber("bytes").
+
+// RichValue returns the value at path as decoded from JSON: a string,
+// float64, bool, []interface{} or map[string]interface{}
+func (e SecurityEvent) RichValue(path string) (interface{}, bool) {
+	if e.RichMetadata == nil {
+		return nil, false
+	}
+	return jsonLookup(map[string]interface{}(e.RichMetadata), path)
+}
+
+// RichString returns the value at path as text ("" if missing). Objects and
+// arrays are rendered as JSON.
+func (e SecurityEvent) RichString(path string) string {
+	v, ok := e.RichValue(path)
+	if !ok {
+		return ""
+	}
+	s, _ := jsonText(v)
+	return s
+}
+
+// RichStrings returns the list at path with each element as text. A single
+// value is returned as a one-element list; a missing one as nil.
+func (e SecurityEvent) RichStrings(path string) []string {
+	v, ok := e.RichValue(path)
+	if !ok {
+		return nil
+	}
+	list, isList := v.([]interface{})
+	if !isList {
+		if s, ok := jsonText(v); ok {
+			return []string{s}
+		}
+		return nil
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := jsonText(item); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// RichNumber returns the number at path (0 if missing or not numeric).
+// Numeric strings are parsed.
+func (e SecurityEvent) RichNumber(path string) float64 {
+	v, _ := e.RichValue(path)
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
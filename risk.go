@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// riskMaxEntries bounds each per-IP / per-user risk buffer
+const riskMaxEntries = 200
+
+// riskEntry is one scored alert remembered in a risk buffer
+type riskEntry struct {
+	AlertID    string    `json:"alert_id"`
+	ThreatType string    `json:"threat_type"`
+	Weight     float64   `json:"weight"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// validate checks the risk scoring settings
+func (c *RiskScoringConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Threshold <= 0 {
+		return fmt.Errorf("correlation.scoring.threshold must be positive")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("correlation.scoring.window must be positive")
+	}
+	if c.Name == "" {
+		return fmt.Errorf("correlation.scoring.name is required when enabled")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("correlation.scoring.severity %q is not a severity", c.Severity)
+	}
+	for t, w := range c.Weights {
+		if w < 0 {
+			return fmt.Errorf("correlation.scoring.weights.%s must not be negative", t)
+		}
+	}
+	for sev, w := range c.SeverityWeights {
+		if severityRank(sev) < 0 {
+			return fmt.Errorf("correlation.scoring.severity_weights: %q is not a severity", sev)
+		}
+		if w < 0 {
+			return fmt.Errorf("correlation.scoring.severity_weights.%s must not be negative", sev)
+		}
+	}
+	return nil
+}
+
+// weight is what an alert adds to its risk score: its threat type's weight
+// if listed, otherwise its severity's
+func (c *RiskScoringConfig) weight(alert ThreatAlert) float64 {
+	if w, ok := c.Weights[alert.ThreatType]; ok {
+		return w
+	}
+	return c.SeverityWeights[alert.Severity]
+}
+
+// formatScore writes a score without trailing zeros
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// scoreRisk adds the alert's weight to the risk scores of its source IP and
+// user, and sets the higher of the two as the alert's risk_score. When
+// either score reaches the threshold it returns an escalation alert listing
+// the contributing alerts. A score that stays above the threshold doesn't
+// escalate again until it has dropped below it.
+func (td *ThreatDetector) scoreRisk(ctx context.Context, event SecurityEvent, alert *ThreatAlert) (*ThreatAlert, error) {
+	cfg := td.cfg().Correlation.Scoring
+	if !cfg.Enabled || alert.ThreatType == cfg.Name {
+		return nil, nil
+	}
+	w := cfg.weight(*alert)
+	if w <= 0 {
+		return nil, nil
+	}
+
+	now := td.now()
+	entry, err := json.Marshal(riskEntry{AlertID: alert.AlertID, ThreatType: alert.ThreatType, Weight: w, Timestamp: now})
+	if err != nil {
+		return nil, err
+	}
+
+	type subject struct{ kind, name, key string }
+	var subjects []subject
+	if event.SourceIP != "" {
+		subjects = append(subjects, subject{"source IP", event.SourceIP, stateKey(event, "risk:ip", event.ipKey())})
+	}
+	if event.User != "" {
+		subjects = append(subjects, subject{"user", event.User, stateKey(event, "risk:user", event.User)})
+	}
+
+	var best float64
+	var escalation *ThreatAlert
+	cutoff := now.Add(-cfg.Window.Duration)
+	for _, s := range subjects {
+		if err := td.state.AppendList(ctx, s.key, string(entry), riskMaxEntries, cfg.Window.Duration); err != nil {
+			return nil, &StateError{Op: "rpush", Key: s.key, Err: err}
+		}
+		raw, err := td.state.ListRange(ctx, s.key)
+		if err != nil {
+			return nil, &StateError{Op: "lrange", Key: s.key, Err: err}
+		}
+
+		var score float64
+		var ids []string
+		types := make(map[string]float64)
+		for _, r := range raw {
+			var e riskEntry
+			if json.Unmarshal([]byte(r), &e) != nil || e.Timestamp.Before(cutoff) {
+				continue
+			}
+			score += e.Weight
+			ids = append(ids, e.AlertID)
+			types[e.ThreatType] += e.Weight
+		}
+		best = max(best, score)
+
+		// Escalate on the alert that crosses the threshold, once per alert
+		if escalation != nil || score < cfg.Threshold || score-w >= cfg.Threshold {
+			continue
+		}
+		names := mapKeys(types)
+		sort.Slice(names, func(i, j int) bool {
+			if types[names[i]] != types[names[j]] {
+				return types[names[i]] > types[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		parts := make([]string, len(names))
+		for i, n := range names {
+			parts[i] = n + "=" + formatScore(types[n])
+		}
+		escalation = &ThreatAlert{
+			AlertID:    fmt.Sprintf("RS-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: cfg.Name,
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Risk score for %s %s reached %s (threshold %s) within %s: %s",
+				s.kind, s.name, formatScore(score), formatScore(cfg.Threshold), cfg.Window, strings.Join(parts, ", ")),
+			EventCount:    len(ids),
+			RelatedAlerts: ids,
+			Metadata:      map[string]string{"risk_score": formatScore(score)},
+		}
+	}
+
+	if alert.Metadata == nil {
+		alert.Metadata = make(map[string]string)
+	}
+	alert.Metadata["risk_score"] = formatScore(best)
+	return escalation, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "time"
+
+// ruleMeter times the detection rules run on one event and counts the
+// alerts each raises. detectThreats begins a rule before each step; the
+// previous rule ends when the next begins.
+type ruleMeter struct {
+	rule    string // "" between rules
+	started time.Time
+	hits    int
+}
+
+// begin ends the current rule, if any, and starts timing the next
+func (m *ruleMeter) begin(rule string) {
+	if m == nil {
+		return
+	}
+	m.end()
+	m.rule, m.started, m.hits = rule, time.Now(), 0
+}
+
+// hit counts an alert raised by the current rule
+func (m *ruleMeter) hit() {
+	if m != nil && m.rule != "" {
+		m.hits++
+	}
+}
+
+// end records the current rule's evaluation, time and alerts
+func (m *ruleMeter) end() {
+	if m == nil || m.rule == "" {
+		return
+	}
+	ruleDuration.Observe(time.Since(m.started).Seconds(), m.rule)
+	ruleEvaluations.WithLabelValues(m.rule).Inc()
+	if m.hits > 0 {
+		ruleHits.WithLabelValues(m.rule).Add(float64(m.hits))
+	}
+	m.rule = ""
+}
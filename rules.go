@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// CustomRule is a detection defined entirely in config. Condition is an
+// expression over the SecurityEvent fields (EventType, Result, User,
+// Metadata["key"], ...). With a Threshold above 1, matches are counted per
+// GroupBy value over Window and the rule alerts once the count is reached.
+type CustomRule struct {
+	Name      string   `json:"name"`      // threat type of the alerts
+	Condition string   `json:"condition"` // must evaluate to a bool
+	GroupBy   string   `json:"group_by"`  // expression for the count key (default SourceIP)
+	Threshold int      `json:"threshold"` // matches within Window before alerting (default 1)
+	Window    Duration `json:"window"`    // required when Threshold > 1
+	Severity  string   `json:"severity"`
+
+	condition *vm.Program
+	groupBy   *vm.Program
+}
+
+// compile validates the rule and compiles its expressions against SecurityEvent
+func (r *CustomRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if severityRank(r.Severity) < 0 {
+		return fmt.Errorf("unknown severity %q", r.Severity)
+	}
+	if r.Threshold == 0 {
+		r.Threshold = 1
+	}
+	if r.Threshold < 0 {
+		return fmt.Errorf("threshold must be positive")
+	}
+	if r.Threshold > 1 && r.Window.Duration <= 0 {
+		return fmt.Errorf("window is required when threshold > 1")
+	}
+
+	var err error
+	if r.condition, err = expr.Compile(r.Condition, expr.Env(SecurityEvent{}), expr.AsBool()); err != nil {
+		return fmt.Errorf("condition:\n%w", err)
+	}
+
+	groupBy := r.GroupBy
+	if groupBy == "" {
+		groupBy = "SourceIP"
+	}
+	if r.groupBy, err = expr.Compile(groupBy, expr.Env(SecurityEvent{})); err != nil {
+		return fmt.Errorf("group_by:\n%w", err)
+	}
+	return nil
+}
+
+// compileCustomRules compiles every custom rule, reporting the first error
+func compileCustomRules(rules []CustomRule) error {
+	seen := make(map[string]bool)
+	for i := range rules {
+		r := &rules[i]
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("custom_rules[%d] (%s): %w", i, r.Name, err)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("custom_rules[%d]: duplicate name %s", i, r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return nil
+}
+
+// evaluateCustomRule runs one rule against an event and returns whether it
+// fires, the match count in the window, and the group it counted under
+func (td *ThreatDetector) evaluateCustomRule(ctx context.Context, rule *CustomRule, event SecurityEvent) (bool, int64, string, error) {
+	out, err := expr.Run(rule.condition, event)
+	if err != nil {
+		return false, 0, "", fmt.Errorf("custom rule %s: %w", rule.Name, err)
+	}
+	if matched, _ := out.(bool); !matched {
+		return false, 0, "", nil
+	}
+
+	out, err = expr.Run(rule.groupBy, event)
+	if err != nil {
+		return false, 0, "", fmt.Errorf("custom rule %s group_by: %w", rule.Name, err)
+	}
+	group := fmt.Sprint(out)
+	if group == event.SourceIP {
+		group = event.ipKey()
+	}
+
+	if rule.Threshold <= 1 {
+		return true, 1, group, nil
+	}
+
+	key := stateKey(event, "rule:"+rule.Name, group)
+	count, err := td.countInWindow(ctx, event, rule.Name, key, rule.Window.Duration)
+	if err != nil {
+		return false, 0, group, err
+	}
+	return count >= int64(rule.Threshold), count, group, nil
+}
+
+// detectCustomRules evaluates every custom rule and raises their alerts
+func (td *ThreatDetector) detectCustomRules(ctx context.Context, event SecurityEvent) []error {
+	var errs []error
+	for i := range td.cfg().CustomRules {
+		rule := &td.cfg().CustomRules[i]
+		event.rules.begin("custom:" + rule.Name)
+
+		hit, count, group, err := td.evaluateCustomRule(ctx, rule, event)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !hit {
+			continue
+		}
+
+		td.raiseAlert(ctx, event, rule.alert(event, count, group))
+	}
+	return errs
+}
+
+// alert builds the alert for a rule that fired on an event
+func (r *CustomRule) alert(event SecurityEvent, count int64, group string) ThreatAlert {
+	alert := ThreatAlert{
+		AlertID:    fmt.Sprintf("CR-%d", time.Now().Unix()),
+		Timestamp:  time.Now(),
+		Severity:   r.Severity,
+		ThreatType: r.Name,
+		SourceIP:   event.SourceIP,
+		Details:    fmt.Sprintf("Custom rule %s matched %d time(s) for %s", r.Name, count, group),
+		EventCount: int(count),
+	}
+	if r.Threshold > 1 {
+		alert.stateKey = stateKey(event, "rule:"+r.Name, group)
+	}
+	return alert
+}
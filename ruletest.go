@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleTest is one rule regression test: events fed in order through the
+// detection engine, and the alerts they must produce
+type RuleTest struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"` // merged over the base config
+	Start  time.Time       `json:"start"`  // fake clock at the first event (default 2024-01-01T00:00:00Z)
+	Events []RuleTestEvent `json:"events"`
+	Expect []RuleTestAlert `json:"expect"`
+
+	// AllowOtherAlerts passes alerts no expectation matches; by default
+	// they fail the test
+	AllowOtherAlerts bool `json:"allow_other_alerts"`
+}
+
+// RuleTestEvent is an input message, sent Repeat times. The fake clock moves
+// forward After before the first copy and Every between copies.
+type RuleTestEvent struct {
+	After     Duration        `json:"after"`
+	Every     Duration        `json:"every"`
+	Repeat    int             `json:"repeat"`    // default 1
+	Event     json.RawMessage `json:"event"`     // event object, or a string holding the raw message (e.g. Windows XML)
+	Scan      bool            `json:"scan"`      // run the scheduled state scans instead of sending an event
+	Lifecycle bool            `json:"lifecycle"` // run the alert lifecycle sweep instead of sending an event
+}
+
+// RuleTestAlert matches produced alerts. Empty fields match anything.
+type RuleTestAlert struct {
+	ThreatType  string            `json:"threat_type"`
+	Severity    string            `json:"severity"`
+	SourceIP    string            `json:"source_ip"`
+	User        string            `json:"user"`
+	TenantID    string            `json:"tenant_id"`
+	SourceZone  string            `json:"source_zone"`
+	Environment string            `json:"environment"`
+	Details     string            `json:"details"`     // substring
+	Metadata    map[string]string `json:"metadata"`    // key -> value glob
+	Count       *int              `json:"count"`       // alerts that must match (default 1; 0 asserts none)
+	Observation bool              `json:"observation"` // match observations instead of alerts
+}
+
+// ruleTestStart is the fake clock's default starting point
+var ruleTestStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// matches reports whether an alert satisfies the expectation
+func (want RuleTestAlert) matches(alert ThreatAlert) bool {
+	if want.ThreatType != "" && want.ThreatType != alert.ThreatType ||
+		want.Severity != "" && want.Severity != alert.Severity ||
+		want.SourceIP != "" && want.SourceIP != alert.SourceIP ||
+		want.User != "" && want.User != alert.User ||
+		want.TenantID != "" && want.TenantID != alert.TenantID ||
+		want.SourceZone != "" && want.SourceZone != alert.SourceZone ||
+		want.Environment != "" && want.Environment != alert.Environment ||
+		want.Details != "" && !strings.Contains(alert.Details, want.Details) ||
+		want.Observation != (alert.Metadata["observation"] != "") {
+		return false
+	}
+	for k, pattern := range want.Metadata {
+		v, ok := alert.Metadata[k]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, v); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the expectation for failure messages
+func (want RuleTestAlert) String() string {
+	var parts []string
+	for _, f := range []struct{ name, value string }{
+		{"threat_type", want.ThreatType}, {"severity", want.Severity}, {"source_ip", want.SourceIP},
+		{"user", want.User}, {"tenant_id", want.TenantID}, {"source_zone", want.SourceZone}, {"environment", want.Environment},
+		{"details", want.Details},
+	} {
+		if f.value != "" {
+			parts = append(parts, fmt.Sprintf("%s=%q", f.name, f.value))
+		}
+	}
+	keys := mapKeys(want.Metadata)
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("metadata.%s=%q", k, want.Metadata[k]))
+	}
+	if len(parts) == 0 {
+		return "any alert"
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeAlert is the one-line form of a produced alert
+func describeAlert(a ThreatAlert) string {
+	s := fmt.Sprintf("%s %s source_ip=%s", a.ThreatType, a.Severity, a.SourceIP)
+	if a.User != "" {
+		s += " user=" + a.User
+	}
+	if a.TenantID != "" {
+		s += " tenant_id=" + a.TenantID
+	}
+	if a.SourceZone != "" {
+		s += " source_zone=" + a.SourceZone
+	}
+	if a.Environment != "" {
+		s += " environment=" + a.Environment
+	}
+	return s + ": " + a.Details
+}
+
+// ruleTestConfig layers a test's config over the base config file. Settings
+// that need Kafka or external services are turned off.
+func ruleTestConfig(base []byte, overlay json.RawMessage) (*DetectorConfig, error) {
+	cfg := DefaultConfig()
+	for _, layer := range [][]byte{base, overlay} {
+		if len(layer) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(layer, cfg); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	cfg.DeadLetterTopic = ""
+	cfg.ObservationsTopic = ""
+	cfg.Enrichment.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// run feeds the test's events through a fresh detector and returns every
+// alert raised, then every observation recorded
+func (t RuleTest) run(base []byte) ([]ThreatAlert, error) {
+	cfg, err := ruleTestConfig(base, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	now := t.Start
+	if now.IsZero() {
+		now = ruleTestStart
+	}
+	td, err := newMemoryDetector(cfg, func() time.Time { return now })
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	var alerts []ThreatAlert
+	for i, step := range t.Events {
+		msg := []byte(step.Event)
+		var raw string
+		if json.Unmarshal(step.Event, &raw) == nil {
+			msg = []byte(raw)
+		}
+		now = now.Add(step.After.Duration)
+
+		repeat := step.Repeat
+		if repeat < 1 {
+			repeat = 1
+		}
+		for n := 0; n < repeat; n++ {
+			if n > 0 {
+				now = now.Add(step.Every.Duration)
+			}
+			if step.Scan {
+				if errs := td.scanState(ctx); len(errs) > 0 {
+					return nil, fmt.Errorf("events[%d]: %w", i, errors.Join(errs...))
+				}
+				for len(td.alertChan) > 0 {
+					alerts = append(alerts, <-td.alertChan)
+				}
+				continue
+			}
+			if step.Lifecycle {
+				if errs := td.sweepLifecycle(ctx); len(errs) > 0 {
+					return nil, fmt.Errorf("events[%d]: %w", i, errors.Join(errs...))
+				}
+				for len(td.alertChan) > 0 {
+					alerts = append(alerts, <-td.alertChan)
+				}
+				continue
+			}
+			event, err := parseEvent(kafka.Message{Value: msg}, &cfg.Input)
+			if err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			if event.Timestamp.IsZero() {
+				event.Timestamp = now
+			}
+			keep, _, err := cfg.Middleware.run(&event)
+			if err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			if !keep {
+				continue
+			}
+			cfg.Privacy.apply(&event)
+			if err := td.detectThreats(ctx, event); err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			for len(td.alertChan) > 0 {
+				alerts = append(alerts, <-td.alertChan)
+			}
+		}
+	}
+
+	observed, _, err := td.observations.Query(ctx, AlertQuery{To: time.Now(), Limit: 10000})
+	if err != nil {
+		return nil, err
+	}
+	return append(alerts, observed...), nil
+}
+
+// check compares the alerts with the expectations and lists the failures.
+// Each alert counts toward the first expectation it matches.
+func (t RuleTest) check(alerts []ThreatAlert) []string {
+	var failures []string
+	used := make([]bool, len(alerts))
+	for _, want := range t.Expect {
+		count := 1
+		if want.Count != nil {
+			count = *want.Count
+		}
+		got := 0
+		for i, a := range alerts {
+			if !used[i] && want.matches(a) {
+				used[i] = true
+				got++
+			}
+		}
+		if got != count {
+			failures = append(failures, fmt.Sprintf("expected %d alert(s) with %s, got %d", count, want, got))
+		}
+	}
+	if !t.AllowOtherAlerts {
+		for i, a := range alerts {
+			if !used[i] {
+				failures = append(failures, "unexpected alert "+describeAlert(a))
+			}
+		}
+	}
+	return failures
+}
+
+// isRuleTestFile reports whether a file is a fixture by its extension
+func isRuleTestFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON re-encodes a YAML fixture as JSON, so both formats share the
+// json tags and the config overlay stays a JSON document
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// loadRuleTests reads a fixture file holding one test or a list of them, in
+// YAML (.yaml, .yml) or JSON
+func loadRuleTests(file string) ([]RuleTest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(file); ext == ".yaml" || ext == ".yml" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	var tests []RuleTest
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &tests)
+	} else {
+		var t RuleTest
+		err = json.Unmarshal(data, &t)
+		tests = []RuleTest{t}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for i := range tests {
+		if tests[i].Name == "" {
+			tests[i].Name = fmt.Sprintf("%s[%d]", filepath.Base(file), i)
+		}
+		if len(tests[i].Events) == 0 {
+			return nil, fmt.Errorf("%s: %s: no events", file, tests[i].Name)
+		}
+	}
+	return tests, nil
+}
+
+// ruleTestFiles expands directories into the fixture files below them
+func ruleTestFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && isRuleTestFile(p) {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runRuleTest implements the ruletest subcommand. Exit codes: 0 every test
+// passed, 1 a test failed, 2 the tests or config couldn't be loaded.
+func runRuleTest(args []string) int {
+	fs := flag.NewFlagSet("ruletest", flag.ExitOnError)
+	configPath := fs.String("config", "", "base JSON config the tests' config is merged over (defaults if empty)")
+	verbose := fs.Bool("v", false, "list every alert and show the detector's log")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "ruletest: give one or more test files or directories")
+		return 2
+	}
+	var base []byte
+	if *configPath != "" {
+		var err error
+		if base, err = os.ReadFile(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "ruletest: %v\n", err)
+			return 2
+		}
+	}
+	files, err := ruleTestFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ruletest: %v\n", err)
+		return 2
+	}
+
+	// Rule logging would bury the results
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	passed, failed := 0, 0
+	for _, file := range files {
+		tests, err := loadRuleTests(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ruletest: %v\n", err)
+			return 2
+		}
+		for _, t := range tests {
+			alerts, err := t.run(base)
+			var failures []string
+			if err != nil {
+				failures = []string{err.Error()}
+			} else {
+				failures = t.check(alerts)
+			}
+
+			if len(failures) == 0 {
+				passed++
+				fmt.Printf("PASS %s: %s (%d alerts)\n", file, t.Name, len(alerts))
+			} else {
+				failed++
+				fmt.Printf("FAIL %s: %s\n", file, t.Name)
+				for _, f := range failures {
+					fmt.Printf("    %s\n", f)
+				}
+			}
+			if *verbose {
+				for _, a := range alerts {
+					fmt.Printf("    alert %s\n", describeAlert(a))
+				}
+			}
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRuleTestsFormats(t *testing.T) {
+	fixtures := map[string]string{
+		"a.yaml": `
+- name: brute force
+  config: {thresholds: {brute_force: 3}}
+  events:
+    - repeat: 3
+      every: 10s
+      event: {event_type: authentication, result: failed, source_ip: 203.0.113.7, user: "1001"}
+  expect:
+    - {threat_type: BRUTE_FORCE, count: 1}
+`,
+		"b.yml": `
+name: brute force
+config:
+  thresholds:
+    brute_force: 3
+events:
+  - repeat: 3
+    every: 10s
+    event:
+      event_type: authentication
+      result: failed
+      source_ip: 203.0.113.7
+      user: "1001"
+expect:
+  - threat_type: BRUTE_FORCE
+    count: 1
+`,
+		"c.json": `{
+  "name": "brute force",
+  "config": {"thresholds": {"brute_force": 3}},
+  "events": [{"repeat": 3, "every": "10s", "event": {"event_type": "authentication", "result": "failed", "source_ip": "203.0.113.7", "user": "1001"}}],
+  "expect": [{"threat_type": "BRUTE_FORCE", "count": 1}]
+}`,
+		"notes.txt": "not a fixture",
+	}
+	dir := t.TempDir()
+	for name, content := range fixtures {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ruleTestFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("ruleTestFiles = %v, want the .yaml, .yml and .json fixtures", files)
+	}
+
+	for _, file := range files {
+		tests, err := loadRuleTests(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tests) != 1 || tests[0].Name != "brute force" || len(tests[0].Events) != 1 || tests[0].Events[0].Repeat != 3 {
+			t.Fatalf("%s loads as %+v", file, tests)
+		}
+		// The overlay lowers the threshold to 3, so the test only passes if
+		// config and events both came through
+		alerts, err := tests[0].run(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if failures := tests[0].check(alerts); len(failures) > 0 {
+			t.Errorf("%s: %v", file, failures)
+		}
+	}
+}
+
+// TestRuleTests runs every fixture in ruletests/, so go test covers the same
+// cases as the ruletest subcommand in CI
+func TestRuleTests(t *testing.T) {
+	files, err := ruleTestFiles([]string{"ruletests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures in ruletests/")
+	}
+	for _, file := range files {
+		tests, err := loadRuleTests(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rt := range tests {
+			t.Run(file+"/"+rt.Name, func(t *testing.T) {
+				alerts, err := rt.run(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, failure := range rt.check(alerts) {
+					t.Error(failure)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scan rule modes
+const (
+	scanDistributed = "distributed"
+	scanPersistent  = "persistent"
+)
+
+// scanCounter is where a counter rule keeps its counts, and whether they are
+// kept per user rather than per source IP
+type scanCounter struct {
+	prefix string
+	byUser bool
+}
+
+// scanCounters are the built-in counter rules a scan rule can read
+var scanCounters = map[string]scanCounter{
+	"BRUTE_FORCE":             {prefix: "failed_auth"},
+	"SUSPICIOUS_USER":         {prefix: "invalid_user"},
+	"PASSWORD_CHANGE_ANOMALY": {prefix: "password_change", byUser: true},
+	"TARGETED_ACCOUNT_ATTACK": {prefix: "targeted_account", byUser: true},
+	"KERBEROASTING":           {prefix: "kerberoast"},
+}
+
+// counterFor resolves a scan rule's counter; custom rules count under
+// rule:<name>, grouped by source IP unless they say otherwise
+func counterFor(name string, customRules []CustomRule) (scanCounter, bool) {
+	if c, ok := scanCounters[name]; ok {
+		return c, true
+	}
+	for _, rule := range customRules {
+		if rule.Name == name {
+			return scanCounter{prefix: "rule:" + name}, true
+		}
+	}
+	return scanCounter{}, false
+}
+
+// validate checks the scan settings
+func (c *ScanConfig) validate(customRules []CustomRule) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("scan.interval must be at least 1s")
+	}
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("scan.rules is required when enabled")
+	}
+	seen := make(map[string]bool)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("scan.rules[%d].name is required", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("scan.rules[%d]: duplicate name %q", i, r.Name)
+		}
+		seen[r.Name] = true
+		if _, ok := counterFor(r.Counter, customRules); !ok {
+			return fmt.Errorf("scan.rules[%d].counter %q is not a counter rule", i, r.Counter)
+		}
+		if r.MinCount < 1 {
+			return fmt.Errorf("scan.rules[%d].min_count must be at least 1", i)
+		}
+		if r.MaxCount != 0 && r.MaxCount < r.MinCount {
+			return fmt.Errorf("scan.rules[%d].max_count must be 0 or at least min_count", i)
+		}
+		switch r.Mode {
+		case scanDistributed:
+			if r.MinSources < 2 {
+				return fmt.Errorf("scan.rules[%d].min_sources must be at least 2", i)
+			}
+		case scanPersistent:
+			if r.MinAge.Duration < c.Interval.Duration {
+				return fmt.Errorf("scan.rules[%d].min_age must be at least scan.interval", i)
+			}
+		default:
+			return fmt.Errorf("scan.rules[%d].mode must be distributed or persistent, got %q", i, r.Mode)
+		}
+		if severityRank(r.Severity) < 0 {
+			return fmt.Errorf("scan.rules[%d].severity %q is not a severity", i, r.Severity)
+		}
+		if r.Cooldown.Duration == 0 {
+			r.Cooldown = Duration{time.Hour}
+		}
+		if r.Cooldown.Duration < 0 {
+			return fmt.Errorf("scan.rules[%d].cooldown must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// scanKey is one live counter found by a scan
+type scanKey struct {
+	key     string
+	tenant  string
+	subject string // source IP or user
+	count   int64
+}
+
+// runScans evaluates the scan rules every scan.interval until stop is closed
+func (td *ThreatDetector) runScans() {
+	defer td.producers.Done()
+
+	ticker := time.NewTicker(td.cfg().Scan.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+			for _, err := range td.scanState(td.ctx) {
+				processingErrors.WithLabelValues("state").Inc()
+				log.Printf("State scan failed: %v", err)
+			}
+		}
+	}
+}
+
+// scanState runs every scan rule once
+func (td *ThreatDetector) scanState(ctx context.Context) []error {
+	cfg := td.cfg()
+	var errs []error
+	for _, rule := range cfg.Scan.Rules {
+		counter, _ := counterFor(rule.Counter, cfg.CustomRules)
+		keys, err := td.scanCounterKeys(ctx, counter.prefix, rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		scanRuns.WithLabelValues(rule.Name).Inc()
+
+		var ruleErrs []error
+		if rule.Mode == scanDistributed {
+			ruleErrs = td.scanDistributed(ctx, rule, counter, keys)
+		} else {
+			ruleErrs = td.scanPersistent(ctx, rule, counter, keys)
+		}
+		errs = append(errs, ruleErrs...)
+	}
+	return errs
+}
+
+// scanCounterKeys returns the counter's live keys whose count is within
+// the rule's bounds, in every tenant
+func (td *ThreatDetector) scanCounterKeys(ctx context.Context, prefix string, rule ScanRule) ([]scanKey, error) {
+	var found []scanKey
+	for _, pattern := range []string{prefix + ":*", "tenant:*:" + prefix + ":*"} {
+		keys, err := td.state.ScanKeys(ctx, pattern)
+		if err != nil {
+			return nil, &StateError{Op: "scan", Key: pattern, Err: err}
+		}
+		for _, key := range keys {
+			sk, ok := parseCounterKey(key, prefix)
+			if !ok {
+				continue
+			}
+			value, err := td.state.Get(ctx, key)
+			if err != nil {
+				return nil, &StateError{Op: "get", Key: key, Err: err}
+			}
+			if sk.count, err = strconv.ParseInt(value, 10, 64); err != nil {
+				continue
+			}
+			if sk.count < rule.MinCount || rule.MaxCount > 0 && sk.count > rule.MaxCount {
+				continue
+			}
+			found = append(found, sk)
+		}
+	}
+	return found, nil
+}
+
+// parseCounterKey splits a stateKey back into tenant and subject. The
+// distinct_raw_logs markers stored beside counters are skipped.
+func parseCounterKey(key, prefix string) (scanKey, bool) {
+	sk := scanKey{key: key}
+	rest := key
+	if strings.HasPrefix(rest, "tenant:") {
+		tenant, after, ok := strings.Cut(strings.TrimPrefix(rest, "tenant:"), ":")
+		if !ok {
+			return sk, false
+		}
+		sk.tenant, rest = tenant, after
+	}
+	subject, ok := strings.CutPrefix(rest, prefix+":")
+	if !ok || subject == "" || strings.Contains(subject, ":seen:") {
+		return sk, false
+	}
+	sk.subject = subject
+	return sk, true
+}
+
+// scanDistributed alerts per tenant when enough sources each sit at the
+// rule's count, none of them necessarily tripping the counter rule
+func (td *ThreatDetector) scanDistributed(ctx context.Context, rule ScanRule, counter scanCounter, keys []scanKey) []error {
+	byTenant := make(map[string][]scanKey)
+	for _, k := range keys {
+		byTenant[k.tenant] = append(byTenant[k.tenant], k)
+	}
+
+	var errs []error
+	for tenant, group := range byTenant {
+		var total int64
+		for _, k := range group {
+			total += k.count
+		}
+		if len(group) < rule.MinSources || total < rule.MinTotal {
+			continue
+		}
+
+		event := scanEvent(td.now(), tenant, "", "")
+		fire, err := td.scanCooldown(ctx, event, rule, "*")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !fire {
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool { return group[i].count > group[j].count })
+		subjects := make([]string, 0, len(group))
+		for _, k := range group {
+			if len(subjects) == 20 {
+				break
+			}
+			subjects = append(subjects, k.subject)
+		}
+		kind := "sources"
+		if counter.byUser {
+			kind = "users"
+		}
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("SC-%d", time.Now().Unix()),
+			Timestamp:  event.Timestamp,
+			Severity:   rule.Severity,
+			ThreatType: rule.Name,
+			Details: fmt.Sprintf("%d %s each with %d+ %s counts (%d total): %s",
+				len(group), kind, rule.MinCount, rule.Counter, total, strings.Join(subjects, ", ")),
+			EventCount: int(total),
+			Metadata: map[string]string{
+				"scan_mode": rule.Mode, "counter": rule.Counter,
+				kind: strings.Join(subjects, ","), "distinct_" + kind: strconv.Itoa(len(group)),
+			},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+	return errs
+}
+
+// scanPersistent alerts for a source whose count has stayed at the rule's
+// level for min_age: a low rate that keeps a sliding window from ever
+// closing, but never reaches the counter rule's threshold. A key missing
+// from two scans in a row starts over.
+func (td *ThreatDetector) scanPersistent(ctx context.Context, rule ScanRule, counter scanCounter, keys []scanKey) []error {
+	now := td.now()
+	ttl := 3 * td.cfg().Scan.Interval.Duration
+
+	var errs []error
+	for _, k := range keys {
+		trackKey := "scan:" + rule.Name + ":" + k.key
+		first, _, err := td.state.TouchSeen(ctx, trackKey, now.Unix(), ttl)
+		if err != nil {
+			errs = append(errs, &StateError{Op: "touch", Key: trackKey, Err: err})
+			continue
+		}
+		age := now.Sub(time.Unix(first, 0))
+		if age < rule.MinAge.Duration {
+			continue
+		}
+
+		ip, user := k.subject, ""
+		if counter.byUser {
+			ip, user = "", k.subject
+		}
+		event := scanEvent(now, k.tenant, ip, user)
+		fire, err := td.scanCooldown(ctx, event, rule, k.subject)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !fire {
+			continue
+		}
+
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("SC-%d", time.Now().Unix()),
+			Timestamp:  now,
+			Severity:   rule.Severity,
+			ThreatType: rule.Name,
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%s has held %d+ %s counts for %s (now %d)",
+				k.subject, rule.MinCount, rule.Counter, age.Truncate(time.Second), k.count),
+			EventCount: int(k.count),
+			Metadata: map[string]string{
+				"scan_mode": rule.Mode, "counter": rule.Counter,
+				"persisted_seconds": strconv.FormatInt(int64(age/time.Second), 10),
+			},
+			stateKey: k.key,
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, k.key); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+	return errs
+}
+
+// scanCooldown claims the rule's quiet period for a subject, so replicas
+// scanning the same state raise one alert between them
+func (td *ThreatDetector) scanCooldown(ctx context.Context, event SecurityEvent, rule ScanRule, subject string) (bool, error) {
+	key := stateKey(event, "scan_alerted", rule.Name+":"+subject)
+	seen, err := td.dedup.SeenRecently(ctx, key, rule.Cooldown.Duration)
+	if err != nil {
+		return false, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	return !seen, nil
+}
+
+// scanEvent is the synthetic event a scan alert is raised for
+func scanEvent(now time.Time, tenant, ip, user string) SecurityEvent {
+	event := SecurityEvent{
+		Timestamp: now,
+		SourceIP:  ip,
+		User:      user,
+		EventType: "state_scan",
+		Metadata:  map[string]string{},
+	}
+	if tenant != "" {
+		event.Metadata["tenant_id"] = tenant
+	}
+	event.normalize()
+	return event
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema version bookkeeping in Redis
+const (
+	stateSchemaKey  = "schema:version"   // layout version of the detection state
+	stateSchemaLock = "schema:migrating" // held by the replica running migrations
+)
+
+// stateSchemaLockTTL frees the lock if the migrating replica dies
+const stateSchemaLockTTL = 5 * time.Minute
+
+// stateMigration brings state from the previous layout version up to
+// version. Running it again on migrated state must change nothing.
+type stateMigration struct {
+	version int
+	name    string
+	run     func(ctx context.Context, state StateStore) (migrated, expired int, err error)
+}
+
+// stateMigrations are every key layout change, oldest first. Append a new
+// migration whenever a release changes how existing keys are named or typed.
+var stateMigrations = []stateMigration{
+	{version: 1, name: "canonical, bracketed IPs in IP-keyed state", run: migrateIPKeys},
+}
+
+// stateSchemaVersion is the layout this build reads and writes
+var stateSchemaVersion = stateMigrations[len(stateMigrations)-1].version
+
+// MigrateState upgrades detection state written by older versions to the
+// current key layout, one migration at a time, recording the version after
+// each. One replica migrates while the others wait. Call it before Start.
+func (td *ThreatDetector) MigrateState() error {
+	ctx := td.ctx
+	version, err := td.stateSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if version > stateSchemaVersion {
+		log.Printf("State schema is version %d, newer than this build's %d; leaving it alone", version, stateSchemaVersion)
+		return nil
+	}
+	if version == stateSchemaVersion {
+		return nil
+	}
+
+	locked, err := td.state.SetIfAbsent(ctx, stateSchemaLock, strconv.Itoa(stateSchemaVersion), stateSchemaLockTTL)
+	if err != nil {
+		return &StateError{Op: "setnx", Key: stateSchemaLock, Err: err}
+	}
+	if !locked {
+		return td.awaitStateSchema(ctx)
+	}
+	defer func() {
+		if err := td.state.Delete(ctx, stateSchemaLock); err != nil {
+			log.Printf("State migration lock not released: %v", err)
+		}
+	}()
+
+	for _, m := range stateMigrations {
+		if m.version <= version {
+			continue
+		}
+		start := time.Now()
+		migrated, expired, err := m.run(ctx, td.state)
+		if err != nil {
+			return fmt.Errorf("state migration %d (%s): %w", m.version, m.name, err)
+		}
+		if err := td.state.Set(ctx, stateSchemaKey, strconv.Itoa(m.version), 0); err != nil {
+			return &StateError{Op: "set", Key: stateSchemaKey, Err: err}
+		}
+		log.Printf("State migrated to schema %d (%s): %d keys moved, %d superseded keys removed in %s",
+			m.version, m.name, migrated, expired, time.Since(start).Truncate(time.Millisecond))
+	}
+	return nil
+}
+
+// stateSchema reads the stored layout version (0 before versioning)
+func (td *ThreatDetector) stateSchema(ctx context.Context) (int, error) {
+	value, err := td.state.Get(ctx, stateSchemaKey)
+	if err != nil {
+		return 0, &StateError{Op: "get", Key: stateSchemaKey, Err: err}
+	}
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a version", stateSchemaKey, value)
+	}
+	return version, nil
+}
+
+// awaitStateSchema waits for another replica's migration to finish. If the
+// lock is released or expires first, the migration is left for a restart.
+func (td *ThreatDetector) awaitStateSchema(ctx context.Context) error {
+	log.Printf("Another replica is migrating state to schema %d; waiting", stateSchemaVersion)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		version, err := td.stateSchema(ctx)
+		if err != nil {
+			return err
+		}
+		if version >= stateSchemaVersion {
+			return nil
+		}
+		held, err := td.state.Exists(ctx, stateSchemaLock)
+		if err != nil {
+			return &StateError{Op: "exists", Key: stateSchemaLock, Err: err}
+		}
+		if !held {
+			return fmt.Errorf("state migration by another replica stopped at schema %d", version)
+		}
+	}
+}
+
+// ipKeyPrefixes are the state keyed by a bare source IP before IPs were
+// canonicalized and IPv6 bracketed
+var ipKeyPrefixes = []string{"failed_auth", "invalid_user", "ip_seen", "chain:ip"}
+
+// migrateIPKeys renames IP-keyed state (and its evidence lists and
+// distinct-raw-log markers) whose IP isn't in canonical key form, e.g.
+// failed_auth:2001:DB8::1 to failed_auth:[2001:db8::1]. Where the new key
+// already exists, the old one is deleted: the live key wins.
+func migrateIPKeys(ctx context.Context, state StateStore) (int, int, error) {
+	var migrated, expired int
+	for _, prefix := range ipKeyPrefixes {
+		for _, pattern := range []string{prefix + ":*", "tenant:*:" + prefix + ":*", "raw:" + prefix + ":*", "raw:tenant:*:" + prefix + ":*"} {
+			keys, err := state.ScanKeys(ctx, pattern)
+			if err != nil {
+				return migrated, expired, &StateError{Op: "scan", Key: pattern, Err: err}
+			}
+			for _, key := range keys {
+				to, ok := canonicalIPKey(key, prefix)
+				if !ok {
+					continue
+				}
+				moved, err := state.RenameIfAbsent(ctx, key, to)
+				if err != nil {
+					return migrated, expired, &StateError{Op: "renamenx", Key: key, Err: err}
+				}
+				if moved {
+					migrated++
+					continue
+				}
+				if err := state.Delete(ctx, key); err != nil {
+					return migrated, expired, &StateError{Op: "del", Key: key, Err: err}
+				}
+				expired++
+			}
+		}
+	}
+	return migrated, expired, nil
+}
+
+// canonicalIPKey returns the key an IP-keyed state key should have, and
+// false if it already has it or isn't keyed by an IP
+func canonicalIPKey(key, prefix string) (string, bool) {
+	marker := prefix + ":"
+	i := strings.Index(key, marker)
+	if i < 0 {
+		return "", false
+	}
+	head, subject := key[:i+len(marker)], key[i+len(marker):]
+	subject, seen, hasSeen := strings.Cut(subject, ":seen:")
+	addr, ok := parseIP(subject)
+	if !ok {
+		return "", false
+	}
+	canonical := ipKeyPart(addr)
+	if canonical == subject {
+		return "", false
+	}
+	to := head + canonical
+	if hasSeen {
+		to += ":seen:" + seen
+	}
+	return to, true
+}
@@ -5,19 +5,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/segmentio/kafka-go"
-	"github.com/go-redis/redis/v8"
 )
 
 // SecurityEvent represents a normalized security event
@@ -31,93 +37,458 @@ type SecurityEvent struct {
 	Result     string            `json:"result"`
 	RawLog     string            `json:"raw_log"`
 	Metadata   map[string]string `json:"metadata"`
+
+	// RichMetadata holds structured context (lists, nested objects) that
+	// doesn't fit Metadata's strings; read it with the Rich* accessors
+	RichMetadata map[string]interface{} `json:"rich_metadata,omitempty"`
+
+	// Lowercased copies filled once by normalize() so detectors don't
+	// repeat strings.ToLower on the hot path
+	actionLower    string
+	eventTypeLower string
+	rawLogLower    string
+
+	// addr is the parsed SourceIP (invalid if SourceIP isn't an IP)
+	addr netip.Addr
+
+	// anonymizer is "tor", "proxy" or "" (set by detectThreats for auth events)
+	anonymizer string
+
+	// rules meters the detection rules while detectThreats runs them
+	rules *ruleMeter
+
+	// enriched holds the fields added by the HTTP enrichment lookup
+	enriched map[string]string
+
+	// firstSeen / lastSeen for the source IP (set by detectThreats when tracking is on)
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// normalize precomputes the lowercased fields used by the detectors and
+// canonicalizes SourceIP so every spelling of an address shares state
+func (e *SecurityEvent) normalize() {
+	e.actionLower = strings.ToLower(e.Action)
+	e.eventTypeLower = strings.ToLower(e.EventType)
+	e.rawLogLower = strings.ToLower(e.RawLog)
+
+	if addr, ok := parseIP(e.SourceIP); ok {
+		e.addr = addr
+		e.SourceIP = addr.String()
+	}
+}
+
+// ipKey returns the source IP for use in Redis keys (IPv6 is bracketed)
+func (e SecurityEvent) ipKey() string {
+	if !e.addr.IsValid() {
+		return e.SourceIP
+	}
+	return ipKeyPart(e.addr)
+}
+
+// TenantID returns the tenant the event belongs to (empty for single-tenant use)
+func (e SecurityEvent) TenantID() string {
+	return e.Metadata["tenant_id"]
 }
 
 // ThreatAlert represents a detected security threat
 type ThreatAlert struct {
 	AlertID    string    `json:"alert_id"`
 	Timestamp  time.Time `json:"timestamp"`
-	Severity   string    `json:"severity"` // HIGH, MEDIUM, LOW
+	Severity   string    `json:"severity"` // CRITICAL, HIGH, MEDIUM, LOW
 	ThreatType string    `json:"threat_type"`
 	SourceIP   string    `json:"source_ip"`
 	Details    string    `json:"details"`
 	EventCount int       `json:"event_count"`
 	RawEvents  []string  `json:"raw_events"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	User       string    `json:"user,omitempty"`
+
+	// SourceZone is "internal" or "external" by source_zone.internal_cidrs
+	// (empty when disabled or the source isn't an IP)
+	SourceZone string `json:"source_zone,omitempty"`
+
+	// Environment is where the event came from (prod, staging, dev), by the
+	// environment mapping (empty when disabled or unmapped)
+	Environment string `json:"environment,omitempty"`
+
+	// RecentEvents is the source IP's latest activity across all event types
+	// (oldest first), attached when ip_history is enabled
+	RecentEvents []EventSummary `json:"recent_events,omitempty"`
+
+	// Timeline is the attack's progression from the source IP's and user's
+	// recent events (oldest first), attached when timeline is enabled
+	Timeline []TimelineEntry `json:"timeline,omitempty"`
+
+	// RelatedAlerts lists the constituent alert IDs of a correlated (chain) alert
+	RelatedAlerts []string `json:"related_alerts,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Signature covers the rest of the alert when signing is enabled
+	Signature *AlertSignature `json:"signature,omitempty"`
+
+	// FirstSeen is when the source IP was first observed; LastSeen is its
+	// most recent activity before the triggering event (nil for a new IP)
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+
+	// stateKey is the detection state that keeps this attack "active";
+	// sinks use its expiry to tell when the attack has subsided
+	stateKey string
+
+	// relatedKeys is other state the detection built next to stateKey
+	// (e.g. a set of source IPs), retained along with it
+	relatedKeys []string
+}
+
+// severityLevels orders severities from lowest to highest
+var severityLevels = []string{"LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// severityRank returns a severity's position in severityLevels, or -1 if unknown
+func severityRank(severity string) int {
+	for i, level := range severityLevels {
+		if level == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// boostSeverity raises a severity by one level, capped at CRITICAL
+func boostSeverity(severity string) string {
+	for i, level := range severityLevels {
+		if level == severity && i < len(severityLevels)-1 {
+			return severityLevels[i+1]
+		}
+	}
+	return severity
 }
 
 // ThreatDetector processes security events and detects threats
 type ThreatDetector struct {
-	kafkaReader   *kafka.Reader
-	kafkaWriter   *kafka.Writer
-	redisClient   *redis.Client
-	ctx           context.Context
-	alertChan     chan ThreatAlert
-	wg            sync.WaitGroup
+	source            EventSource
+	kafkaWriter       *kafka.Writer
+	remediationReader *kafka.Reader // nil unless remediation is enabled
+	state             StateStore
+	dedup             DedupStore
+	adaptive          adaptiveThresholds             // event rate and threshold factor
+	config            atomic.Pointer[DetectorConfig] // swapped by Reload; read through cfg()
+	anonymizers       *AnonymizerChecker
+	enricher          *Enricher
+	userAllowlist     *UserAllowlistManager
+	assets            *AssetInventory // nil unless asset enrichment is enabled
+	vulns             *VulnDB         // nil unless vulnerability context is enabled
+	alertStore        AlertStore      // nil unless alert_store is enabled
+	observations      AlertStore      // nil unless observations.store is enabled
+	debug             *debugLogger
+	sinks             []AlertSink
+	httpServer        *http.Server
+	uiServer          *http.Server
+	ctx               context.Context
+	alertChan         chan ThreatAlert
+	stop              chan struct{}
+	fatal             chan error // a critical sink failed; main shuts down
+	wg                sync.WaitGroup
+	producers         sync.WaitGroup // everything that sends on alertChan
+
+	workersMu sync.Mutex
+	workers   []*workerSlot // indexed by worker ID
+
+	// queues feed each worker its share of source IPs (nil unless affinity is on)
+	queues []chan dispatched
+
+	// suppressions are the suppression rules added over the API, refreshed
+	// from Redis (nil until first loaded)
+	suppressions atomic.Pointer[[]SuppressionRule]
+
+	// training is set while a learning period runs
+	training atomic.Bool
+
+	// inFlight holds a token per event being processed (nil when uncapped)
+	inFlight chan struct{}
+
+	// sinkQueues and shutdownAt let a stalled shutdown report what it's
+	// waiting on
+	sinkQueues atomic.Pointer[[]sinkQueue]
+	shutdownAt atomic.Pointer[string]
+
+	// clock replaces the wall clock for time-windowed state (nil in production;
+	// set by ruletest so fixtures can step through windows)
+	clock func() time.Time
+}
+
+// cfg returns the live config. Callers that read several settings for one
+// decision should keep the returned pointer rather than call cfg() again.
+func (td *ThreatDetector) cfg() *DetectorConfig {
+	return td.config.Load()
+}
+
+// now returns the time windowed rules compare against
+func (td *ThreatDetector) now() time.Time {
+	if td.clock != nil {
+		return td.clock()
+	}
+	return time.Now()
 }
 
 // NewThreatDetector creates a new threat detector instance
-func NewThreatDetector(kafkaBrokers []string, redisAddr string) *ThreatDetector {
+func NewThreatDetector(cfg *DetectorConfig) (*ThreatDetector, error) {
 	ctx := context.Background()
 
-	// Kafka consumer (reads security events)
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     kafkaBrokers,
-		Topic:       "security-events",
-		GroupID:     "threat-detector-group",
-		MinBytes:    10e3, // 10KB
-		MaxBytes:    10e6, // 10MB
-		MaxWait:     500 * time.Millisecond,
-	})
+	userAllowlist, err := NewUserAllowlistManager(cfg.UserAllowlist)
+	if err != nil {
+		return nil, err
+	}
 
 	// Kafka producer (publishes alerts)
+	// Topic is set per message so alerts can be routed per tenant
+	// Alerts arrive in batches already assembled by the Kafka sink, so
+	// partial batches are flushed almost at once rather than after 1s
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(kafkaBrokers...),
-		Topic:    "security-alerts",
-		Balancer: &kafka.LeastBytes{},
+		Addr:         kafka.TCP(cfg.KafkaBrokers...),
+		Balancer:     &kafka.LeastBytes{},
+		Compression:  cfg.KafkaCompression(),
+		BatchSize:    cfg.Sinks.Kafka.BatchSize,
+		BatchTimeout: 5 * time.Millisecond,
 	}
 
 	// Redis client (for state management)
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-		DB:   0,
-	})
+	redisClient := newRedisClient(cfg, "primary", cfg.RedisAddr, cfg.RedisPassword)
+
+	state := NewRedisStore(redisClient)
+	if cfg.Standby.Enabled {
+		standbyClient := newRedisClient(cfg, "standby", cfg.Standby.Addr, cfg.Standby.Password)
+		state = NewStandbyStore(state, NewRedisStore(standbyClient), cfg.Standby)
+	}
+
+	// Event input (Kafka consumer group or Redis Stream)
+	source, err := newEventSource(ctx, cfg, redisClient)
+	if err != nil {
+		return nil, err
+	}
+
+	td := &ThreatDetector{
+		source:            source,
+		kafkaWriter:       writer,
+		remediationReader: newRemediationReader(cfg),
+		state:             state,
+		dedup:             newDedupStore(cfg.Dedup, state, time.Now),
+		userAllowlist:     userAllowlist,
+		debug:             newDebugLogger(cfg.Log),
+		ctx:               ctx,
+		alertChan:         make(chan ThreatAlert, 100),
+		stop:              make(chan struct{}),
+		fatal:             make(chan error, 1),
+	}
+
+	td.config.Store(cfg)
+	publishThresholds(cfg.Thresholds, 1)
+
+	if cfg.Anonymizer.Enabled {
+		td.anonymizers = NewAnonymizerChecker(cfg.Anonymizer, state)
+	}
+
+	if cfg.Enrichment.Enabled {
+		td.enricher = NewEnricher(cfg.Enrichment)
+	}
+
+	if cfg.Assets.Enabled {
+		if td.assets, err = NewAssetInventory(cfg.Assets); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Vulns.Enabled {
+		if td.vulns, err = NewVulnDB(cfg.Vulns); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.AlertStore.Enabled {
+		td.alertStore = newAlertStore(cfg.AlertStore, redisClient)
+	}
+	if cfg.Observations.Store {
+		td.observations = newObservationStore(cfg, redisClient)
+	}
 
-	return &ThreatDetector{
-		kafkaReader:   reader,
-		kafkaWriter:   writer,
-		redisClient:   redisClient,
-		ctx:           ctx,
-		alertChan:     make(chan ThreatAlert, 100),
+	// The alerts topic is the first sink (omitted when running without Kafka)
+	if cfg.AlertsTopic != "" {
+		td.sinks = append(td.sinks, NewKafkaSink(writer, td.cfg))
+	}
+	if cfg.Sinks.PagerDuty.Enabled {
+		td.sinks = append(td.sinks, NewPagerDutySink(cfg.Sinks.PagerDuty, cfg.Sinks.Transforms["pagerduty"], state))
+	}
+	if cfg.Sinks.STIX.Enabled {
+		td.sinks = append(td.sinks, NewSTIXSink(cfg.Sinks.STIX, writer))
+	}
+	if cfg.Sinks.SQS.Enabled {
+		sink, err := NewSQSSink(ctx, cfg.Sinks.SQS, cfg.Sinks.Transforms["sqs"])
+		if err != nil {
+			return nil, err
+		}
+		td.sinks = append(td.sinks, sink)
+	}
+	if cfg.Sinks.SNS.Enabled {
+		sink, err := NewSNSSink(ctx, cfg.Sinks.SNS, cfg.Sinks.Transforms["sns"])
+		if err != nil {
+			return nil, err
+		}
+		td.sinks = append(td.sinks, sink)
 	}
+
+	return td, nil
 }
 
 // Start begins processing security events
 func (td *ThreatDetector) Start(numWorkers int) {
 	log.Printf("Starting %d threat detector workers...", numWorkers)
 
+	// With affinity, one dispatcher reads the input and each worker owns a
+	// fixed share of source IPs
+	if td.cfg().Dispatch.Affinity {
+		td.queues = make([]chan dispatched, numWorkers)
+		for i := range td.queues {
+			td.queues[i] = make(chan dispatched, td.cfg().Dispatch.QueueSize)
+		}
+		td.producers.Add(1)
+		go td.runDispatcher()
+	}
+
+	if n := td.cfg().Dispatch.MaxInFlight; n > 0 {
+		td.inFlight = make(chan struct{}, n)
+	}
+
+	// Resume or begin a learning period before any event is detected
+	if td.cfg().Learning.Enabled {
+		td.startLearning()
+	}
+
 	// Start worker goroutines
+	td.workers = make([]*workerSlot, numWorkers)
 	for i := 0; i < numWorkers; i++ {
-		td.wg.Add(1)
-		go td.processEvents(i)
+		td.startWorker(i)
+	}
+
+	// Start worker watchdog
+	if td.cfg().Watchdog.Enabled {
+		td.producers.Add(1)
+		go td.runWatchdog()
 	}
 
 	// Start alert publisher
+	td.logPauseState()
 	td.wg.Add(1)
 	go td.publishAlerts()
 
+	// Start sink background loops (e.g. incident auto-resolve)
+	for _, sink := range td.sinks {
+		if bg, ok := sink.(backgroundSink); ok {
+			td.wg.Add(1)
+			go func() {
+				defer td.wg.Done()
+				bg.Run(td.ctx, td.stop)
+			}()
+		}
+	}
+
+	// Start user allowlist file watcher
+	td.wg.Add(1)
+	go func() {
+		defer td.wg.Done()
+		td.userAllowlist.Run(td.stop)
+	}()
+
+	// Start refreshing suppression rules added over the API
+	if td.cfg().SuppressionAPI.Enabled {
+		if err := td.refreshSuppressions(td.ctx); err != nil {
+			log.Printf("Suppression rules not loaded, retrying every %s: %v", td.cfg().SuppressionAPI.RefreshInterval, err)
+		}
+		td.wg.Add(1)
+		go td.runSuppressionRefresh()
+	}
+
+	// Start asset inventory file watcher
+	if td.assets != nil {
+		td.wg.Add(1)
+		go func() {
+			defer td.wg.Done()
+			td.assets.Run(td.stop)
+		}()
+	}
+
+	// Start vulnerability mapping file watcher
+	if td.vulns != nil {
+		td.wg.Add(1)
+		go func() {
+			defer td.wg.Done()
+			td.vulns.Run(td.stop)
+		}()
+	}
+
+	// Start remediation results consumer
+	if td.remediationReader != nil {
+		td.wg.Add(1)
+		go td.consumeRemediationResults()
+	}
+
+	// Start the operational HTTP API
+	if td.cfg().HTTPAddr != "" {
+		td.startHTTPServer()
+	}
+	if ui := td.cfg().UI; ui.Enabled && ui.Addr != "" {
+		td.startUIServer()
+	}
+
+	// Start state snapshotter
+	if td.cfg().Snapshot.Enabled {
+		td.wg.Add(1)
+		go td.runSnapshots()
+	}
+
+	// Start scheduled state scans
+	if td.cfg().Scan.Enabled {
+		td.producers.Add(1)
+		go td.runScans()
+	}
+
+	// Start the alert lifecycle sweep
+	if td.cfg().Lifecycle.Enabled {
+		td.producers.Add(1)
+		go td.runLifecycle()
+	}
+
+	// Start Tor exit list refresher
+	if td.anonymizers != nil {
+		td.wg.Add(1)
+		go func() {
+			defer td.wg.Done()
+			td.anonymizers.Run(td.ctx, td.stop)
+		}()
+	}
+
 	log.Println("Threat detector started successfully")
 }
 
-// processEvents reads events from Kafka and analyzes them
-func (td *ThreatDetector) processEvents(workerID int) {
-	defer td.wg.Done()
+// processEvents reads events from the input and analyzes them. The worker
+// exits when its slot is cancelled by the watchdog.
+func (td *ThreatDetector) processEvents(slot *workerSlot) {
+	defer td.producers.Done()
+	defer slot.cancel()
 
+	ctx, workerID := slot.ctx, slot.id
 	log.Printf("Worker %d started", workerID)
 
 	for {
-		// Read message from Kafka
-		msg, err := td.kafkaReader.ReadMessage(td.ctx)
+		// Read the next event from the input (or this worker's affinity queue)
+		item, err := td.next(ctx, workerID)
 		if err != nil {
+			select {
+			case <-td.stop:
+				log.Printf("Worker %d shutting down", workerID)
+				return
+			default:
+			}
 			if err == context.Canceled {
 				log.Printf("Worker %d shutting down", workerID)
 				return
@@ -126,22 +497,207 @@ func (td *ThreatDetector) processEvents(workerID int) {
 			continue
 		}
 
-		// Parse event
-		var event SecurityEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Printf("Worker %d error parsing event: %v", workerID, err)
+		// Wait for an in-flight slot. While Redis is slow, workers (and any
+		// the watchdog abandoned) hold their slots and intake stops here.
+		if !td.acquireInFlight(ctx) {
+			log.Printf("Worker %d shutting down", workerID)
+			return
+		}
+		slot.busySince.Store(time.Now().UnixNano())
+
+		msg, event := item.msg, item.event
+
+		// A message that keeps failing to complete is set aside, not retried forever
+		if td.isPoison(ctx, workerID, msg) {
+			td.ack(ctx, workerID, msg)
+			slot.busySince.Store(0)
+			td.releaseInFlight()
+			continue
+		}
+
+		err = item.parseErr
+		if err == nil {
+			err = td.checkTimestamp(msg, &event)
+		}
+		if err != nil {
+			td.handleError(ctx, workerID, msg, err)
+			td.ack(ctx, workerID, msg)
+			slot.busySince.Store(0)
+			td.releaseInFlight()
+			continue
+		}
+
+		// Preprocess; a failed step is logged and skipped, a drop ends here
+		keep, step, err := td.cfg().Middleware.run(&event)
+		if err != nil {
+			td.handleError(ctx, workerID, msg, err)
+		}
+		if !keep {
+			eventsDropped.WithLabelValues(step).Inc()
+			td.debug.Printf("Worker %d event from %s dropped by middleware %s", workerID, event.SourceIP, step)
+			td.ack(ctx, workerID, msg)
+			slot.busySince.Store(0)
+			td.releaseInFlight()
 			continue
 		}
+		if td.enricher != nil {
+			td.enricher.Enrich(ctx, &event)
+		}
+		td.cfg().Privacy.apply(&event)
+
+		td.debug.Printf("Worker %d event %s/%s from %s user=%q result=%s (partition %d offset %d)",
+			workerID, event.EventType, event.Action, event.SourceIP, event.User, event.Result,
+			msg.Partition, msg.Offset)
 
 		// Detect threats
-		td.detectThreats(event)
+		eventsProcessed.Inc()
+		err = td.detectThreats(ctx, event)
+
+		// Restarted by the watchdog: leave the message unacked (a Redis
+		// Stream redelivers it) and let the replacement worker carry on
+		if ctx.Err() != nil {
+			log.Printf("Worker %d abandoned event from %s after restart", workerID, event.SourceIP)
+			td.releaseInFlight()
+			return
+		}
+
+		if err != nil {
+			td.handleError(ctx, workerID, msg, err)
+		}
+		td.ack(ctx, workerID, msg)
+		slot.busySince.Store(0)
+		td.releaseInFlight()
+	}
+}
+
+// ack confirms a message to the input once it has been handled
+func (td *ThreatDetector) ack(ctx context.Context, workerID int, msg kafka.Message) {
+	if err := td.source.Ack(ctx, msg); err != nil {
+		log.Printf("Worker %d error acknowledging message: %v", workerID, err)
+		return
+	}
+	td.clearAttempts(ctx, msg)
+}
+
+// parseEvent decodes and normalizes an input message in the configured format
+func parseEvent(msg kafka.Message, input *InputConfig) (SecurityEvent, error) {
+	var event SecurityEvent
+	var err error
+	switch format := input.Format; {
+	case format == formatWindowsXML || format == formatAuto && bytes.HasPrefix(bytes.TrimSpace(msg.Value), []byte("<")):
+		event, err = parseWindowsXML(msg.Value)
+	case format == formatCloudTrail || format == formatAuto && isCloudTrail(msg.Value):
+		event, err = parseCloudTrail(msg.Value)
+	case format == formatAccessLog || format == formatAuto && isAccessLog(msg.Value):
+		event, err = parseAccessLog(msg.Value, &input.AccessLog)
+	default:
+		err = json.Unmarshal(msg.Value, &event)
+	}
+	if err != nil {
+		return event, &ParseError{Partition: msg.Partition, Offset: msg.Offset, StreamID: streamID(msg), Err: err}
+	}
+	event.normalize()
+	return event, nil
+}
+
+// handleError applies the error policy for a message that failed processing
+func (td *ThreatDetector) handleError(ctx context.Context, workerID int, msg kafka.Message, err error) {
+	var parseErr *ParseError
+	var stateErr *StateError
+
+	switch {
+	case errors.As(err, &parseErr):
+		// Malformed input will never succeed; park it for inspection
+		processingErrors.WithLabelValues("parse").Inc()
+		log.Printf("Worker %d dropping malformed event: %v", workerID, parseErr)
+		td.deadLetter(ctx, msg, parseErr)
+	case errors.As(err, &stateErr):
+		// State is best-effort: the failed rules are skipped, the others already ran
+		processingErrors.WithLabelValues("state").Inc()
+		log.Printf("Worker %d detection state error: %v", workerID, err)
+	default:
+		processingErrors.WithLabelValues("other").Inc()
+		log.Printf("Worker %d error: %v", workerID, err)
+	}
+}
+
+// deadLetter forwards an unprocessable message to the dead-letter topic, if configured
+func (td *ThreatDetector) deadLetter(ctx context.Context, msg kafka.Message, cause error) {
+	if td.cfg().DeadLetterTopic == "" {
+		return
+	}
+
+	err := td.kafkaWriter.WriteMessages(ctx, kafka.Message{
+		Topic: td.cfg().DeadLetterTopic,
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: []kafka.Header{
+			{Key: "error", Value: []byte(cause.Error())},
+		},
+	})
+	if err != nil {
+		log.Printf("Error writing to dead-letter topic: %v", err)
 	}
 }
 
-// detectThreats analyzes an event for potential threats
-func (td *ThreatDetector) detectThreats(event SecurityEvent) {
+// detectThreats analyzes an event for potential threats.
+// A failing rule doesn't stop the others; their errors are joined.
+func (td *ThreatDetector) detectThreats(ctx context.Context, event SecurityEvent) error {
+	// Every event counts toward the rate adaptive thresholds scale with
+	td.adaptive.observe(td.now(), &td.cfg().AdaptiveThresholds, td.cfg().Thresholds)
+
+	// Allowlisted IPs never contribute to detection state
+	if td.cfg().IsAllowlisted(event.TenantID(), event.addr) {
+		return nil
+	}
+
+	var errs []error
+
+	// Track first/last sighting of the source IP for alert enrichment
+	if td.cfg().IPSeen.Enabled && event.SourceIP != "" {
+		if err := td.touchSourceIP(ctx, &event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Keep a short history of everything the IP does for alert context
+	if td.cfg().IPHistory.Enabled && event.SourceIP != "" {
+		if err := td.recordIPHistory(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Buffer the event for replay by rule simulations
+	if td.cfg().Simulation.Enabled {
+		if err := td.bufferForSimulation(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Record the event on its IP's and user's attack timelines
+	if td.cfg().Timeline.Enabled {
+		if err := td.recordTimeline(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Tag auth attempts from Tor/proxies so any resulting alert is boosted
+	if td.anonymizers != nil && event.EventType == "authentication" {
+		label, err := td.anonymizers.Lookup(ctx, event.addr)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		event.anonymizer = label
+	}
+
+	// Each rule's time and alerts are recorded per rule for /metrics
+	event.rules = &ruleMeter{}
+
 	// 1. Check for brute force attacks
-	if td.isBruteForce(event) {
+	event.rules.begin("brute_force")
+	if hit, count, err := td.isBruteForce(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
 		alert := ThreatAlert{
 			AlertID:    fmt.Sprintf("BF-%d", time.Now().Unix()),
 			Timestamp:  time.Now(),
@@ -149,11 +705,17 @@ func (td *ThreatDetector) detectThreats(event SecurityEvent) {
 			ThreatType: "BRUTE_FORCE",
 			SourceIP:   event.SourceIP,
 			Details:    fmt.Sprintf("Brute force attack detected from %s", event.SourceIP),
+			EventCount: int(count),
+			stateKey:   stateKey(event, "failed_auth", event.ipKey()),
 		}
-		td.alertChan <- alert
+		if alert.RawEvents, err = td.rawEventsFor(ctx, alert.stateKey); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
 	}
 
 	// 2. Check for privilege escalation
+	event.rules.begin("privilege_escalation")
 	if td.isPrivilegeEscalation(event) {
 		alert := ThreatAlert{
 			AlertID:    fmt.Sprintf("PE-%d", time.Now().Unix()),
@@ -163,11 +725,38 @@ func (td *ThreatDetector) detectThreats(event SecurityEvent) {
 			SourceIP:   event.SourceIP,
 			Details:    fmt.Sprintf("Privilege escalation attempt by %s", event.User),
 		}
-		td.alertChan <- alert
+		td.raiseAlert(ctx, event, alert)
+
+		// An escalation right after a brute-forced login confirms the breach
+		if login, linkedBy, err := td.isConfirmedBreach(ctx, event); err != nil {
+			errs = append(errs, err)
+		} else if login != nil {
+			breach := ThreatAlert{
+				AlertID:    fmt.Sprintf("CB-%d", time.Now().Unix()),
+				Timestamp:  time.Now(),
+				Severity:   td.cfg().ConfirmedBreach.Severity,
+				ThreatType: "CONFIRMED_BREACH",
+				SourceIP:   event.SourceIP,
+				Details: fmt.Sprintf("%s escalated privileges %s after a brute-forced login from %s",
+					event.User, td.now().Sub(login.At).Truncate(time.Second), login.SourceIP),
+				EventCount:    2,
+				RelatedAlerts: []string{login.AlertID, alert.AlertID},
+				Metadata:      map[string]string{"login_source_ip": login.SourceIP, "linked_by": linkedBy},
+			}
+			for _, raw := range []string{login.RawLog, event.RawLog} {
+				if raw != "" {
+					breach.RawEvents = append(breach.RawEvents, raw)
+				}
+			}
+			td.raiseAlert(ctx, event, breach)
+		}
 	}
 
 	// 3. Check for suspicious user activity
-	if td.isSuspiciousUser(event) {
+	event.rules.begin("suspicious_user")
+	if hit, count, err := td.isSuspiciousUser(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
 		alert := ThreatAlert{
 			AlertID:    fmt.Sprintf("SU-%d", time.Now().Unix()),
 			Timestamp:  time.Now(),
@@ -175,141 +764,1325 @@ func (td *ThreatDetector) detectThreats(event SecurityEvent) {
 			ThreatType: "SUSPICIOUS_USER",
 			SourceIP:   event.SourceIP,
 			Details:    fmt.Sprintf("Invalid user login attempts from %s", event.SourceIP),
+			EventCount: int(count),
+			stateKey:   stateKey(event, "invalid_user", event.ipKey()),
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, alert.stateKey); err != nil {
+			errs = append(errs, err)
 		}
-		td.alertChan <- alert
+		td.raiseAlert(ctx, event, alert)
 	}
 
-}
-
-// isBruteForce detects brute force authentication attacks
-func (td *ThreatDetector) isBruteForce(event SecurityEvent) bool {
-	// Only check failed authentication events
-	if event.EventType != "authentication" || event.Result != "failed" {
-		return false
+	// 4. Check for rapid password changes (account takeover persistence)
+	event.rules.begin("password_change")
+	if anomalous, after, err := td.isPasswordChangeAnomaly(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if anomalous {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("PC-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "MEDIUM",
+			ThreatType: "PASSWORD_CHANGE_ANOMALY",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("Repeated password changes for %s", event.User),
+			stateKey:   stateKey(event, "password_change", event.User),
+		}
+		switch after {
+		case "post_bf_success":
+			alert.Severity = "HIGH"
+			alert.Details = fmt.Sprintf("Password change for %s following a login after failed attempts from %s",
+				event.User, event.SourceIP)
+		case "new_source_login":
+			alert.Severity = "HIGH"
+			alert.Details = fmt.Sprintf("Password change for %s shortly after a login from a new IP or device (%s)",
+				event.User, event.SourceIP)
+		}
+		td.raiseAlert(ctx, event, alert)
 	}
 
-	// Use Redis to track failed attempts per IP
-	key := fmt.Sprintf("failed_auth:%s", event.SourceIP)
-	
-	// Increment counter
-	count, err := td.redisClient.Incr(td.ctx, key).Result()
-	if err != nil {
-		log.Printf("Redis error: %v", err)
-		return false
+	// 5. Check for successful logins through an anonymizer
+	event.rules.begin("anonymizer")
+	if event.anonymizer != "" && event.Result == "success" {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("AA-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "HIGH",
+			ThreatType: "ANONYMIZER_ACCESS",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("Successful login by %s via %s from %s", event.User, event.anonymizer, event.SourceIP),
+		}
+		td.raiseAlert(ctx, event, alert)
 	}
 
-	// Set expiration (5 minute window)
-	td.redisClient.Expire(td.ctx, key, 5*time.Minute)
-
-	// Threshold: 5 failed attempts in 5 minutes
-	return count >= 5
-}
+	// 6. Check for large outbound transfers
+	event.rules.begin("data_exfiltration")
+	if hit, bytesOut := td.isDataExfiltration(event); hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("DE-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "HIGH",
+			ThreatType: "DATA_EXFILTRATION",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("%s transferred %d bytes out from %s", event.User, bytesOut, event.SourceIP),
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
 
+	// 7. Remember successful logins that follow failures so later rules can
+	// correlate, and alert when the failures amounted to a brute force
+	event.rules.begin("brute_force_success")
+	if err := td.recordNewSourceLogin(ctx, event); err != nil {
+		errs = append(errs, err)
+	}
+	if failures, err := td.recordPostBruteForceSuccess(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if failures >= int64(td.thresholdsFor(event.TenantID()).BruteForce) {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("BS-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "HIGH",
+			ThreatType: "POST_BRUTEFORCE_SUCCESS",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("Successful login for %s from %s after %d failed attempts", event.User, event.SourceIP, failures),
+			EventCount: int(failures),
+		}
+		td.raiseAlert(ctx, event, alert)
+		if err := td.recordBreachLogin(ctx, event, alert); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-// isPrivilegeEscalation detects privilege escalation attempts
-func (td *ThreatDetector) isPrivilegeEscalation(event SecurityEvent) bool {
-	// Check for sudo commands or privilege changes
-	if strings.Contains(strings.ToLower(event.Action), "sudo") ||
-	   strings.Contains(strings.ToLower(event.EventType), "privilege") {
-		
-		// Check if targeting sensitive files/commands
-		sensitivePatterns := []string{
-			"/etc/shadow",
-			"/etc/passwd",
-			"/root",
-			"chmod 777",
-			"useradd",
+	// 8. Check for account manipulation sequences (persistence)
+	event.rules.begin("account_manipulation")
+	if sequence, account, err := td.isAccountManipulation(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if sequence != nil {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("AM-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "HIGH",
+			ThreatType: "ACCOUNT_MANIPULATION",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Account %s manipulated: %s by %s from %s",
+				account, strings.Join(sequence, " → "), event.User, event.SourceIP),
+			EventCount: len(sequence),
+			stateKey:   stateKey(event, "acct_seq", account),
 		}
+		td.raiseAlert(ctx, event, alert)
+	}
 
-		for _, pattern := range sensitivePatterns {
-			if strings.Contains(strings.ToLower(event.RawLog), pattern) {
-				return true
-			}
+	// 9. Check for one identity acting as mutually exclusive account types
+	event.rules.begin("role_confusion")
+	if violation, err := td.isRoleConfusion(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if violation != "" {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("RC-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   "HIGH",
+			ThreatType: "ROLE_CONFUSION",
+			SourceIP:   event.SourceIP,
+			Details:    violation,
 		}
+		td.raiseAlert(ctx, event, alert)
 	}
 
-	return false
-}
+	// 10. Check for Kerberos/AD attacks (Windows events only) and AWS account
+	// attacks (CloudTrail events only)
+	event.rules.begin("active_directory")
+	errs = append(errs, td.detectActiveDirectory(ctx, event)...)
+	event.rules.begin("cloudtrail")
+	errs = append(errs, td.detectCloudTrail(ctx, event)...)
 
-// isSuspiciousUser detects suspicious user activity
-func (td *ThreatDetector) isSuspiciousUser(event SecurityEvent) bool {
-	// Check for invalid user login attempts
-	if strings.Contains(strings.ToLower(event.RawLog), "invalid user") {
-		key := fmt.Sprintf("invalid_user:%s", event.SourceIP)
-		
-		count, err := td.redisClient.Incr(td.ctx, key).Result()
-		if err != nil {
-			return false
+	// 11. Check for post-exploitation recon command sequences
+	event.rules.begin("recon")
+	if sig, commands, err := td.isReconActivity(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if sig != nil {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("RA-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().Recon.Severity,
+			ThreatType: "RECON_ACTIVITY",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Recon commands (%s) by %s from %s: %s",
+				sig.Name, event.User, event.SourceIP, strings.Join(commands, " → ")),
+			EventCount: len(commands),
+			Metadata:   map[string]string{"signature": sig.Name},
 		}
-
-		td.redisClient.Expire(td.ctx, key, 5*time.Minute)
-		
-		// Threshold: 3 invalid users in 5 minutes
-		return count >= 3
+		td.raiseAlert(ctx, event, alert)
 	}
 
-	return false
+	// 12. Check for suspicious parent -> child process lineage
+	event.rules.begin("process_lineage")
+	if sig, parent, child := td.isSuspiciousProcess(event); sig != nil {
+		severity := sig.Severity
+		if severity == "" {
+			severity = td.cfg().ProcessLineage.Severity
+		}
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("SP-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   severity,
+			ThreatType: "SUSPICIOUS_PROCESS",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Suspicious process lineage (%s) on %s by %s: %s → %s",
+				sig.Name, event.Source, event.User, parent, child),
+			EventCount: 1,
+			Metadata:   map[string]string{"signature": sig.Name, "lineage": parent + " → " + child},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 13. Check for distributed attacks on one high-value account
+	event.rules.begin("targeted_account")
+	if hit, count, ips, err := td.isTargetedAccountAttack(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		user := strings.ToLower(event.User)
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("TA-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().TargetedAccount.Severity,
+			ThreatType: "TARGETED_ACCOUNT_ATTACK",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%d failed logins for %q from %d source IPs within %s: %s",
+				count, event.User, len(ips), td.cfg().TargetedAccount.Window, strings.Join(ips, ", ")),
+			EventCount: int(count),
+			Metadata:   map[string]string{"source_ips": strings.Join(ips, ","), "distinct_ips": strconv.Itoa(len(ips))},
+			stateKey:   stateKey(event, "targeted_account", user),
+			relatedKeys: []string{
+				stateKey(event, "targeted_account_ips", user),
+			},
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, alert.stateKey); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 14. Check for repeated access-denied responses on one resource
+	event.rules.begin("authz_probing")
+	if hit, count, key, err := td.isAuthzProbing(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		resource := event.Metadata[td.cfg().AuthzProbing.ResourceField]
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("AZ-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().AuthzProbing.Severity,
+			ThreatType: "AUTHZ_PROBING",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%d access-denied responses for %s on %q within %s",
+				count, authzIdentity(event), resource, td.cfg().AuthzProbing.Window),
+			EventCount: int(count),
+			Metadata:   map[string]string{"resource": resource, "identity": authzIdentity(event)},
+			stateKey:   key,
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 15. Check for one session used from several networks
+	event.rules.begin("session_hijack")
+	if hit, id, ips, err := td.isSessionHijack(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("SH-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().SessionHijack.Severity,
+			ThreatType: "SESSION_HIJACK",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Session %s of %s used from %d networks within %s: %s",
+				id, event.User, len(ips), td.cfg().SessionHijack.Window, strings.Join(ips, ", ")),
+			EventCount: len(ips),
+			Metadata:   map[string]string{"session": id, "source_ips": strings.Join(ips, ","), "distinct_networks": strconv.Itoa(len(ips))},
+			stateKey:   stateKey(event, "session_ips", id),
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 16. Check for authentication over weak or deprecated protocols
+	event.rules.begin("protocol_downgrade")
+	if weak, err := td.isProtocolDowngrade(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if len(weak) > 0 {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("PD-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().ProtocolDowngrade.Severity,
+			ThreatType: "PROTOCOL_DOWNGRADE",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Weak authentication protocol for %s from %s on %s: %s",
+				event.User, event.SourceIP, event.Source, strings.Join(weak, ", ")),
+			EventCount: 1,
+			Metadata:   map[string]string{"weak_protocols": strings.Join(weak, ",")},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 17. Check for one process touching a mass of files (ransomware)
+	event.rules.begin("ransomware")
+	if hit, err := td.isRansomwareBehavior(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().Ransomware
+		process := imageName(event.Metadata[cfg.ProcessField])
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("RW-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "RANSOMWARE_BEHAVIOR",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Process %s run by %s touched %d distinct files (%d renamed to a ransomware extension) within %s on %s",
+				process, event.User, hit.files, hit.renames, cfg.Window, event.Source),
+			EventCount: int(hit.files),
+			Metadata: map[string]string{
+				"process": process, "distinct_files": strconv.FormatInt(hit.files, 10),
+				"suspicious_renames": strconv.FormatInt(hit.renames, 10),
+			},
+			stateKey: hit.key,
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, hit.key); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 18. Check for failed logins from many countries (botnet credential attacks)
+	event.rules.begin("geo_distributed")
+	hits, err := td.isGeoDistributedAttack(ctx, event)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, hit := range hits {
+		cfg := td.cfg().GeoDistributed
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("GD-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "GEO_DISTRIBUTED_ATTACK",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Failed logins for %s %q from %d countries within %s: %s",
+				hit.by, hit.subject, len(hit.countries), cfg.Window, strings.Join(hit.countries, ", ")),
+			EventCount: len(hit.countries),
+			Metadata: map[string]string{
+				"group_by": hit.by, "subject": hit.subject,
+				"countries": strings.Join(hit.countries, ","), "distinct_countries": strconv.Itoa(len(hit.countries)),
+			},
+			stateKey: hit.key,
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 19. Check successful logins against the allowed countries
+	event.rules.begin("geofence")
+	if hit, err := td.isGeofenceViolation(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().Geofence
+		country, where := hit.country, "from "+hit.country
+		if country == "" {
+			country, where = "unknown", "from an unresolved location"
+		}
+		details := fmt.Sprintf("%s logged in %s, outside the countries allowed by the %s geofence policy", event.User, where, hit.policy)
+		if len(hit.allow) > 0 {
+			details += " (" + strings.Join(hit.allow, ", ") + ")"
+		}
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("GF-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "GEOFENCE_VIOLATION",
+			SourceIP:   event.SourceIP,
+			Details:    details,
+			EventCount: 1,
+			Metadata:   map[string]string{"country": country, "geofence_policy": hit.policy},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 20. Check for a user logging in far more often than their baseline
+	event.rules.begin("auth_volume")
+	if hit, err := td.isAuthVolumeAnomaly(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().AuthVolume
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("AV-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "AUTH_VOLUME_ANOMALY",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%s logged in %d times this hour, against a usual %.1f per active hour",
+				hit.user, hit.count, hit.profile.Rate),
+			EventCount: int(hit.count),
+			Metadata: map[string]string{
+				"hour_count": strconv.FormatInt(hit.count, 10), "threshold": strconv.FormatInt(hit.threshold, 10),
+				"baseline_rate": strconv.FormatFloat(hit.profile.Rate, 'f', 2, 64), "baseline_hours": strconv.Itoa(hit.profile.Hours),
+			},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 21. Check for access to decommissioned or long-silent hosts
+	event.rules.begin("stale_hosts")
+	if hit, err := td.isStaleHostAccess(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().StaleHosts
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("STH-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "STALE_HOST_ACCESS",
+			SourceIP:   event.SourceIP,
+			Details:    staleHostDetails(event, hit, td.now()),
+			EventCount: 1,
+			Metadata:   map[string]string{"host": hit.host, "reason": hit.reason},
+		}
+		if !hit.lastSeen.IsZero() {
+			alert.Metadata["host_last_seen"] = hit.lastSeen.UTC().Format(time.RFC3339)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 22. Check for one source sending events faster than the flood rate
+	event.rules.begin("request_flood")
+	if hit, err := td.isRequestFlood(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().RequestFlood
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("RF-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "REQUEST_FLOOD",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("%s sent %d events in %s (%.1f/s), over the limit of %g/s",
+				event.SourceIP, hit.count, hit.elapsed.Round(time.Second), hit.rate(), cfg.Rate),
+			EventCount: int(hit.count),
+			Metadata: map[string]string{
+				"rate": strconv.FormatFloat(hit.rate(), 'f', 1, 64), "window_count": strconv.FormatInt(hit.count, 10),
+				"rate_limit": strconv.FormatFloat(cfg.Rate, 'g', -1, 64),
+			},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 23. Check for interactive logins by service accounts
+	event.rules.begin("service_accounts")
+	if hit, err := td.isServiceAccountMisuse(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("SA-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   td.cfg().ServiceAccounts.Severity,
+			ThreatType: "SERVICE_ACCOUNT_MISUSE",
+			SourceIP:   event.SourceIP,
+			Details: fmt.Sprintf("Service account %s logged in interactively (%s) from %s on %s",
+				event.User, hit.logonType, event.SourceIP, event.Source),
+			EventCount: 1,
+			Metadata:   map[string]string{"logon_type": hit.logonType, "service_account_match": hit.matchedBy},
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 24. Check for a burst of MFA prompts (push bombing)
+	event.rules.begin("mfa_fatigue")
+	if hit, err := td.isMFAFatigue(ctx, event); err != nil {
+		errs = append(errs, err)
+	} else if hit != nil {
+		cfg := td.cfg().MFAFatigue
+		alert := ThreatAlert{
+			AlertID:    fmt.Sprintf("MF-%d", time.Now().Unix()),
+			Timestamp:  time.Now(),
+			Severity:   cfg.Severity,
+			ThreatType: "MFA_FATIGUE",
+			SourceIP:   event.SourceIP,
+			Details:    fmt.Sprintf("%d MFA prompts for %s within %s, %d denied", hit.prompts, event.User, cfg.Window, hit.denials),
+			EventCount: int(hit.prompts),
+			Metadata: map[string]string{
+				"prompts": strconv.FormatInt(hit.prompts, 10), "denials": strconv.FormatInt(hit.denials, 10),
+				"approved": strconv.FormatBool(hit.approved),
+			},
+			stateKey: hit.key,
+		}
+		if hit.approved {
+			alert.Severity = cfg.EscalatedSeverity
+			alert.Details = fmt.Sprintf("%s approved an MFA prompt after denying %d within %s (%d prompts): likely a coerced approval",
+				event.User, hit.denials, cfg.Window, hit.prompts)
+		}
+		if alert.RawEvents, err = td.rawEventsFor(ctx, hit.key); err != nil {
+			errs = append(errs, err)
+		}
+		td.raiseAlert(ctx, event, alert)
+	}
+
+	// 25. Evaluate expression-based rules from config
+	errs = append(errs, td.detectCustomRules(ctx, event)...)
+	event.rules.end()
+
+	// Every rule has seen the event; benign ones may feed the baselines
+	if err := td.sampleBaseline(ctx, event); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
+// raiseAlert stamps event context onto an alert and queues it for publishing
+func (td *ThreatDetector) raiseAlert(ctx context.Context, event SecurityEvent, alert ThreatAlert) {
+	event.rules.hit()
+	alert.TenantID = event.TenantID()
+	alert.User = event.User
 
-// publishAlerts publishes detected threats to Kafka
-func (td *ThreatDetector) publishAlerts() {
-	defer td.wg.Done()
+	// Allowlisted users never generate alerts for the rule
+	if td.userAllowlist.Allowed(alert.ThreatType, event.User) {
+		td.suppressAlert(alert, reasonUserAllowlist, "", "")
+		td.debug.Printf("Suppressed %s for allowlisted user %q", alert.ThreatType, event.User)
+		return
+	}
 
-	for alert := range td.alertChan {
-		// Serialize alert
-		alertJSON, err := json.Marshal(alert)
+	// A confirmed block means the IP is already handled; fail open on errors
+	if blocked, err := td.isRemediated(ctx, event); err != nil {
+		log.Printf("Remediation check failed: %v", err)
+	} else if blocked {
+		td.suppressAlert(alert, reasonRemediated, "", blockedKey(event))
+		td.debug.Printf("Suppressed %s for blocked IP %s", alert.ThreatType, event.SourceIP)
+		return
+	}
+
+	// Sources analysts keep marking as false positives are muted for a while
+	if muted, err := td.isAutoMuted(ctx, event); err != nil {
+		log.Printf("Auto-mute check failed: %v", err)
+	} else if muted {
+		td.suppressAlert(alert, reasonAutoMuted, "", stateKey(event, "fp_muted", event.ipKey()))
+		td.debug.Printf("Suppressed %s for auto-muted IP %s", alert.ThreatType, event.SourceIP)
+		return
+	}
+
+	if zones := &td.cfg().SourceZone; zones.Enabled {
+		alert.SourceZone = zones.zone(event.addr)
+	}
+
+	// Attack simulations in dev shouldn't page anyone
+	alert.Environment = td.cfg().Environment.of(event)
+	envPolicy := td.cfg().Environment.policy(alert.Environment)
+	if envPolicy.Suppress {
+		td.suppressAlert(alert, reasonEnvironment, alert.Environment, "")
+		td.debug.Printf("Suppressed %s from environment %s", alert.ThreatType, alert.Environment)
+		return
+	}
+
+	// Known-benign patterns silenced by an analyst
+	if rule := td.suppressedBy(event, alert); rule != nil {
+		td.suppressAlert(alert, reasonSuppressionRule, rule.ID, "")
+		suppressionHits.WithLabelValues(rule.ID).Inc()
+		if td.cfg().Suppression.Log {
+			log.Printf("Suppressed %s from %s (user %q) by suppression rule %s", alert.ThreatType, event.SourceIP, event.User, rule.ID)
+		}
+		return
+	}
+
+	// Single-event rules carry the line that triggered them
+	if len(alert.RawEvents) == 0 && event.RawLog != "" {
+		alert.RawEvents = []string{event.RawLog}
+	}
+
+	// Analysts can see when a storm raised the bar this alert cleared
+	if adaptiveThreatTypes[alert.ThreatType] {
+		if factor := td.adaptive.factor(&td.cfg().AdaptiveThresholds); factor != 1 {
+			if alert.Metadata == nil {
+				alert.Metadata = make(map[string]string)
+			}
+			alert.Metadata["threshold_factor"] = strconv.FormatFloat(factor, 'f', 2, 64)
+		}
+	}
+
+	// Rules with an evidence requirement wait for corroboration; on state
+	// errors the alert goes out rather than being lost
+	if err := td.recordSignal(ctx, event, alert.ThreatType); err != nil {
+		log.Printf("Evidence signal not recorded: %v", err)
+	}
+	if ok, err := td.meetsEvidence(ctx, event, alert); err != nil {
+		log.Printf("Evidence check failed: %v", err)
+	} else if !ok {
+		td.suppressAlert(alert, reasonEvidence, "", "")
+		td.publishObservation(ctx, alert, observedEvidence)
+		return
+	}
+
+	// While thresholds are being learned nothing pages
+	if td.training.Load() && td.cfg().Learning.ObserveOnly {
+		td.suppressAlert(alert, reasonObserved, "learning", "")
+		td.publishObservation(ctx, alert, observedLearning)
+		return
+	}
+
+	// Threat types routed to the observation tier leave a trail without paging
+	switch td.cfg().Observations.ThreatTypes[alert.ThreatType] {
+	case observeOnly:
+		td.suppressAlert(alert, reasonObserved, "", "")
+		td.publishObservation(ctx, alert, observedThreatType)
+		return
+	case observeBoth:
+		td.publishObservation(ctx, alert, observedThreatType)
+	}
+
+	// Each rule re-alerts for a source at its own cadence; fail open on errors
+	if cooling, err := td.inCooldown(ctx, event, alert.ThreatType); err != nil {
+		log.Printf("Cooldown check failed: %v", err)
+	} else if cooling {
+		td.suppressAlert(alert, reasonCooldown, "", cooldownKey(event, alert.ThreatType))
+		td.debug.Printf("Suppressed %s from %s: in cooldown", alert.ThreatType, evidenceSubject(event))
+		return
+	}
+
+	// Near-duplicates of an alert that just went out are collapsed into it
+	if first, key, err := td.collapseDuplicate(ctx, event, &alert); err != nil {
+		log.Printf("Fuzzy dedup failed: %v", err)
+	} else if first != "" {
+		td.suppressAlert(alert, reasonFuzzyDuplicate, first, key)
+		td.debug.Printf("Suppressed %s from %s: near-duplicate of %s", alert.ThreatType, evidenceSubject(event), first)
+		return
+	}
+
+	// Many IPs from one network raise one alert for the network
+	if aggregated, replaced, err := td.aggregateByCIDR(ctx, event, alert); err != nil {
+		log.Printf("CIDR aggregation failed: %v", err)
+	} else if aggregated != nil {
+		alert = *aggregated
+	} else if replaced {
+		td.suppressAlert(alert, reasonCIDRAggregated, "", "")
+		td.debug.Printf("Suppressed %s from %s: aggregated by network", alert.ThreatType, event.SourceIP)
+		return
+	}
+
+	if event.anonymizer != "" {
+		if alert.Metadata == nil {
+			alert.Metadata = make(map[string]string)
+		}
+		alert.Metadata["anonymizer"] = event.anonymizer
+
+		// ANONYMIZER_ACCESS already accounts for the anonymizer in its severity
+		if alert.ThreatType != "ANONYMIZER_ACCESS" {
+			alert.Severity = boostSeverity(alert.Severity)
+		}
+	}
+
+	// Enrichment fields, as left by privacy redaction
+	for k := range event.enriched {
+		if alert.Metadata == nil {
+			alert.Metadata = make(map[string]string)
+		}
+		alert.Metadata[k] = event.Metadata[k]
+	}
+
+	// Attacks on crown-jewel assets outrank the same attack on a dev box
+	if td.assets != nil {
+		target, tier := td.assets.Lookup(event)
+		if alert.Metadata == nil {
+			alert.Metadata = make(map[string]string)
+		}
+		if target != "" {
+			alert.Metadata["asset"] = target
+		}
+		alert.Metadata["asset_tier"] = tier
+		alert.Severity = shiftSeverity(alert.Severity, td.cfg().Assets.Tiers[tier])
+	}
+
+	// A SOC may weigh lateral movement and internet-facing attacks differently
+	if alert.SourceZone != "" {
+		alert.Severity = shiftSeverity(alert.Severity, td.cfg().SourceZone.Severity[alert.SourceZone])
+	}
+
+	// Exploitation of a known-exploited CVE outranks the generic detection
+	if td.vulns != nil {
+		td.vulns.annotate(event, &alert)
+	}
+
+	// Operator overrides have the final say on severity
+	if severity, name := td.cfg().applySeverityOverride(event, alert); name != "" {
+		if alert.Metadata == nil {
+			alert.Metadata = make(map[string]string)
+		}
+		alert.Metadata["severity_override"] = name
+		alert.Severity = severity
+	}
+
+	// A non-prod environment's severity cap holds even over overrides
+	alert.Severity = envPolicy.capSeverity(alert.Severity)
+
+	// Rules with a grace period start as emerging alerts; fail open on errors
+	if publish, err := td.applyLifecycle(ctx, event, &alert); err != nil {
+		log.Printf("Alert lifecycle failed: %v", err)
+	} else if !publish {
+		return
+	}
+
+	if !event.firstSeen.IsZero() {
+		first := event.firstSeen
+		alert.FirstSeen = &first
+	}
+	if !event.lastSeen.IsZero() {
+		last := event.lastSeen
+		alert.LastSeen = &last
+	}
+
+	// Operator templates replace the built-in Details; on error keep the built-in text
+	if details, err := td.cfg().renderDetails(event, alert); err != nil {
+		log.Printf("Alert template for %s failed: %v", alert.ThreatType, err)
+	} else if details != "" {
+		alert.Details = details
+	}
+
+	// Answer "what else did this IP do?" up front for serious alerts
+	if td.cfg().wantsIPHistory(alert) {
+		if history, err := td.ipHistory(ctx, event); err != nil {
+			log.Printf("IP history lookup failed: %v", err)
+		} else {
+			alert.RecentEvents = history
+		}
+	}
+
+	// Tell the story of the attack, at a length that suits the severity
+	if n := td.cfg().Timeline.Entries[alert.Severity]; td.cfg().Timeline.Enabled && n > 0 {
+		if timeline, err := td.timeline(ctx, event, n); err != nil {
+			log.Printf("Timeline lookup failed: %v", err)
+		} else {
+			alert.Timeline = timeline
+		}
+	}
+
+	// Add the alert's weight to its source's risk score
+	escalation, err := td.scoreRisk(ctx, event, &alert)
+	if err != nil {
+		log.Printf("Risk scoring failed: %v", err)
+	}
+
+	// Keep the state behind serious detections around for investigation
+	td.retainState(ctx, alert)
+
+	td.cfg().boundRawEvents(&alert)
+
+	select {
+	case td.alertChan <- alert:
+		alertsRaised.WithLabelValues(alert.ThreatType, alert.Severity).Inc()
+	case <-ctx.Done():
+		log.Printf("Dropping alert %s: worker restarted while the publisher was blocked", alert.AlertID)
+		return
+	}
+
+	if err := td.countAlertForFeedback(ctx, event, alert); err != nil {
+		log.Printf("Alert not counted for feedback: %v", err)
+	}
+
+	// Raise any attack chain this alert completes
+	chained, err := td.correlate(ctx, event, alert)
+	if err != nil {
+		log.Printf("Alert correlation failed: %v", err)
+	}
+	for _, chainAlert := range chained {
+		td.raiseAlert(ctx, event, chainAlert)
+	}
+	if escalation != nil {
+		td.raiseAlert(ctx, event, *escalation)
+	}
+}
+
+// touchSourceIP records the event's sighting of its source IP and fills in
+// the IP's first-seen and previous last-seen times on the event
+func (td *ThreatDetector) touchSourceIP(ctx context.Context, event *SecurityEvent) error {
+	key := stateKey(*event, "ip_seen", event.ipKey())
+
+	first, previous, err := td.state.TouchSeen(ctx, key, td.now().Unix(), td.cfg().IPSeen.TTL.Duration)
+	if err != nil {
+		return &StateError{Op: "touch", Key: key, Err: err}
+	}
+
+	event.firstSeen = time.Unix(first, 0)
+	if previous > 0 {
+		event.lastSeen = time.Unix(previous, 0)
+	}
+	return nil
+}
+
+// stateKey builds a Redis key, namespaced by tenant when the event has one.
+// Plain concatenation is a single allocation, unlike fmt.Sprintf.
+func stateKey(event SecurityEvent, prefix, id string) string {
+	if tenant := event.TenantID(); tenant != "" {
+		return "tenant:" + tenant + ":" + prefix + ":" + id
+	}
+	return prefix + ":" + id
+}
+
+// isBruteForce detects brute force authentication attacks.
+// It also returns the current failure count for the source IP.
+func (td *ThreatDetector) isBruteForce(ctx context.Context, event SecurityEvent) (bool, int64, error) {
+	// Only check failed authentication events
+	if event.EventType != "authentication" || event.Result != "failed" {
+		return false, 0, nil
+	}
+
+	// Allowlisted users don't count toward the IP's failures
+	if td.userAllowlist.Allowed("BRUTE_FORCE", event.User) {
+		alertsSuppressed.WithLabelValues("BRUTE_FORCE", reasonUserAllowlist).Inc()
+		return false, 0, nil
+	}
+
+	// Use Redis to track failed attempts per IP
+	key := stateKey(event, "failed_auth", event.ipKey())
+	
+	// Increment counter (5 minute window)
+	count, err := td.countInWindow(ctx, event, "BRUTE_FORCE", key, 5*time.Minute)
+	if err != nil {
+		return false, 0, err
+	}
+	if err := td.recordRawEvent(ctx, event, key, 5*time.Minute); err != nil {
+		return false, 0, err
+	}
+
+	// Threshold: 5 failed attempts in 5 minutes (by default)
+	return count >= int64(td.thresholdsFor(event.TenantID()).BruteForce), count, nil
+}
+
+
+// sensitivePatterns are files/commands that make a privileged action suspicious
+var sensitivePatterns = []string{
+	"/etc/shadow",
+	"/etc/passwd",
+	"/root",
+	"chmod 777",
+	"useradd",
+	// Windows privileges that amount to full control of the host (4672)
+	"setcbprivilege",
+	"sedebugprivilege",
+	"secreatetokenprivilege",
+	"seloaddriverprivilege",
+}
+
+// isPrivilegeEscalation detects privilege escalation attempts
+func (td *ThreatDetector) isPrivilegeEscalation(event SecurityEvent) bool {
+	// Check for sudo commands or privilege changes
+	if strings.Contains(event.actionLower, "sudo") ||
+	   strings.Contains(event.eventTypeLower, "privilege") {
+		
+		// Check if targeting sensitive files/commands
+		for _, pattern := range sensitivePatterns {
+			if strings.Contains(event.rawLogLower, pattern) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isSuspiciousUser detects suspicious user activity.
+// It also returns the current invalid-user count for the source IP.
+func (td *ThreatDetector) isSuspiciousUser(ctx context.Context, event SecurityEvent) (bool, int64, error) {
+	// Check for invalid user login attempts
+	if strings.Contains(event.rawLogLower, "invalid user") {
+		if td.userAllowlist.Allowed("SUSPICIOUS_USER", event.User) {
+			alertsSuppressed.WithLabelValues("SUSPICIOUS_USER", reasonUserAllowlist).Inc()
+			return false, 0, nil
+		}
+
+		key := stateKey(event, "invalid_user", event.ipKey())
+		
+		count, err := td.countInWindow(ctx, event, "SUSPICIOUS_USER", key, 5*time.Minute)
 		if err != nil {
-			log.Printf("Error marshaling alert: %v", err)
+			return false, 0, err
+		}
+		if err := td.recordRawEvent(ctx, event, key, 5*time.Minute); err != nil {
+			return false, 0, err
+		}
+		
+		// Threshold: 3 invalid users in 5 minutes (by default)
+		return count >= int64(td.thresholdsFor(event.TenantID()).SuspiciousUser), count, nil
+	}
+
+	return false, 0, nil
+}
+
+// isPasswordChangeEvent reports whether the event is a password change or reset
+func isPasswordChangeEvent(event SecurityEvent) bool {
+	eventType := event.eventTypeLower
+	action := event.actionLower
+	if eventType == "password_change" || eventType == "password_reset" {
+		return true
+	}
+	return strings.Contains(action, "password") &&
+		(strings.Contains(action, "change") || strings.Contains(action, "reset"))
+}
+
+// isPasswordChangeAnomaly detects account takeover persistence via password changes.
+// The second return value names the login marker the change follows
+// ("post_bf_success" or "new_source_login"), or is empty for a plain count.
+func (td *ThreatDetector) isPasswordChangeAnomaly(ctx context.Context, event SecurityEvent) (bool, string, error) {
+	if event.User == "" || !isPasswordChangeEvent(event) {
+		return false, "", nil
+	}
+
+	key := stateKey(event, "password_change", event.User)
+
+	// Increment counter (1 hour window)
+	count, err := td.countInWindow(ctx, event, "PASSWORD_CHANGE_ANOMALY", key, time.Hour)
+	if err != nil {
+		return false, "", err
+	}
+
+	// A password change right after a login that followed failed attempts,
+	// or one from a new IP or device, is suspicious on its own, regardless
+	// of the change count
+	for _, marker := range []string{"post_bf_success", "new_source_login"} {
+		markerKey := stateKey(event, marker, event.User)
+		exists, err := td.state.Exists(ctx, markerKey)
+		if err != nil {
+			return false, "", &StateError{Op: "exists", Key: markerKey, Err: err}
+		}
+		if exists {
+			return true, marker, nil
+		}
+	}
+
+	// Threshold: 3 password changes in 1 hour (by default)
+	return count >= int64(td.thresholdsFor(event.TenantID()).PasswordChange), "", nil
+}
+
+// knownSourceTTL is how long a user's login IPs and devices are remembered
+const knownSourceTTL = 30 * 24 * time.Hour
+
+// recordNewSourceLogin marks a user whose successful login came from an IP,
+// or a metadata device_id, they haven't logged in from before. A user's first
+// login only seeds what is known, so new accounts aren't flagged.
+func (td *ThreatDetector) recordNewSourceLogin(ctx context.Context, event SecurityEvent) error {
+	if event.EventType != "authentication" || event.Result != "success" || event.User == "" {
+		return nil
+	}
+
+	userKey := stateKey(event, "login_user", event.User)
+	firstLogin, err := td.state.SetIfAbsent(ctx, userKey, "1", knownSourceTTL)
+	if err != nil {
+		return &StateError{Op: "setnx", Key: userKey, Err: err}
+	}
+
+	sources := []string{"ip:" + event.ipKey()}
+	if device := event.Metadata["device_id"]; device != "" {
+		sources = append(sources, "device:"+device)
+	}
+	newSource := false
+	for _, source := range sources {
+		seenKey := stateKey(event, "login_source", event.User+":"+source)
+		first, err := td.state.SetIfAbsent(ctx, seenKey, "1", knownSourceTTL)
+		if err != nil {
+			return &StateError{Op: "setnx", Key: seenKey, Err: err}
+		}
+		if !first {
+			if err := td.state.ExtendTTL(ctx, seenKey, knownSourceTTL); err != nil {
+				return &StateError{Op: "expire", Key: seenKey, Err: err}
+			}
+		}
+		newSource = newSource || first
+	}
+	if firstLogin || !newSource {
+		return nil
+	}
+
+	// Keep the marker for 15 minutes, like post_bf_success
+	markerKey := stateKey(event, "new_source_login", event.User)
+	if err := td.state.Set(ctx, markerKey, event.SourceIP, 15*time.Minute); err != nil {
+		return &StateError{Op: "set", Key: markerKey, Err: err}
+	}
+	return nil
+}
+
+// recordPostBruteForceSuccess marks a user whose successful login came from an
+// IP with outstanding failed attempts (POST_BRUTEFORCE_SUCCESS state).
+// It returns the number of failures that preceded the login (0 if none).
+func (td *ThreatDetector) recordPostBruteForceSuccess(ctx context.Context, event SecurityEvent) (int64, error) {
+	if event.EventType != "authentication" || event.Result != "success" || event.User == "" {
+		return 0, nil
+	}
+
+	failedKey := stateKey(event, "failed_auth", event.ipKey())
+	value, err := td.state.Get(ctx, failedKey)
+	if err != nil {
+		return 0, &StateError{Op: "get", Key: failedKey, Err: err}
+	}
+	failures, _ := strconv.ParseInt(value, 10, 64)
+	if failures == 0 {
+		return 0, nil
+	}
+
+	// Keep the marker for 15 minutes
+	markerKey := stateKey(event, "post_bf_success", event.User)
+	if err := td.state.Set(ctx, markerKey, event.SourceIP, 15*time.Minute); err != nil {
+		return 0, &StateError{Op: "set", Key: markerKey, Err: err}
+	}
+	return failures, nil
+}
+
+// isDataExfiltration detects a single large outbound transfer, using the
+// bytes_out metadata field. It also returns the transferred byte count.
+func (td *ThreatDetector) isDataExfiltration(event SecurityEvent) (bool, int64) {
+	value, ok := event.Metadata["bytes_out"]
+	if !ok {
+		return false, 0
+	}
+
+	bytesOut, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, 0
+	}
+
+	return bytesOut >= int64(td.cfg().ThresholdsFor(event.TenantID()).ExfilBytes), bytesOut
+}
+
+// accountManipulationMaxEntries bounds each per-account action history
+const accountManipulationMaxEntries = 20
+
+// isAccountManipulation records watched account actions per target account
+// and returns the configured sequence the event completes (nil if none)
+// along with the account it applies to
+func (td *ThreatDetector) isAccountManipulation(ctx context.Context, event SecurityEvent) ([]string, string, error) {
+	cfg := td.cfg().AccountManipulation
+	if !cfg.Enabled {
+		return nil, "", nil
+	}
+
+	action := event.actionLower
+	if !cfg.watched[action] {
+		action = event.eventTypeLower
+		if !cfg.watched[action] {
+			return nil, "", nil
+		}
+	}
+
+	// The manipulated account is the target, which isn't always the actor
+	account := event.Metadata["target_user"]
+	if account == "" {
+		account = event.User
+	}
+	if account == "" {
+		return nil, "", nil
+	}
+
+	key := stateKey(event, "acct_seq", account)
+	now := td.now()
+	entry := strconv.FormatInt(now.Unix(), 10) + ":" + action
+	if err := td.state.AppendList(ctx, key, entry, accountManipulationMaxEntries, cfg.Window.Duration); err != nil {
+		return nil, "", &StateError{Op: "rpush", Key: key, Err: err}
+	}
+
+	raw, err := td.state.ListRange(ctx, key)
+	if err != nil {
+		return nil, "", &StateError{Op: "lrange", Key: key, Err: err}
+	}
+
+	// Keep the actions still inside the window, oldest first
+	cutoff := now.Add(-cfg.Window.Duration).Unix()
+	var history []string
+	for _, r := range raw {
+		ts, name, ok := strings.Cut(r, ":")
+		if !ok {
 			continue
 		}
+		if unix, err := strconv.ParseInt(ts, 10, 64); err == nil && unix >= cutoff {
+			history = append(history, name)
+		}
+	}
+
+	for _, seq := range cfg.Sequences {
+		if endsSequence(history, seq) {
+			return seq, account, nil
+		}
+	}
+	return nil, account, nil
+}
 
-		// Publish to Kafka
-		err = td.kafkaWriter.WriteMessages(td.ctx, kafka.Message{
-			Key:   []byte(alert.SourceIP),
-			Value: alertJSON,
-		})
+// endsSequence reports whether history's last action completes seq, with
+// seq's earlier actions appearing in order before it
+func endsSequence(history, seq []string) bool {
+	if len(history) == 0 || history[len(history)-1] != seq[len(seq)-1] {
+		return false
+	}
+	step := len(seq) - 2
+	for i := len(history) - 2; i >= 0 && step >= 0; i-- {
+		if history[i] == seq[step] {
+			step--
+		}
+	}
+	return step < 0
+}
 
+// accountRole returns the first configured role matching user, or ""
+func (c *RoleConfusionConfig) accountRole(user string) string {
+	for _, role := range c.Roles {
+		if matchAny(role.Users, user) {
+			return role.Name
+		}
+	}
+	return ""
+}
+
+// isRoleConfusion records the roles each identity authenticates as and returns
+// a description of the violation if the event breaks a configured boundary
+func (td *ThreatDetector) isRoleConfusion(ctx context.Context, event SecurityEvent) (string, error) {
+	cfg := &td.cfg().RoleConfusion
+	if !cfg.Enabled || event.User == "" {
+		return "", nil
+	}
+	role := cfg.accountRole(event.User)
+	if role == "" {
+		return "", nil
+	}
+
+	// A machine-only action by an account of any other type
+	if containsString(cfg.MachineActions, event.actionLower) && !containsString(cfg.MachineRoles, role) {
+		return fmt.Sprintf("%s account %s performed machine-only action %s from %s",
+			role, event.User, event.Action, event.SourceIP), nil
+	}
+
+	if event.EventType != "authentication" || event.Result != "success" {
+		return "", nil
+	}
+
+	identity := event.ipKey()
+	if cfg.IdentityField != "" {
+		identity = event.Metadata[cfg.IdentityField]
+	}
+	if identity == "" {
+		return "", nil
+	}
+
+	// Look for an earlier login as a role this one may not follow
+	violation := ""
+	for _, t := range cfg.ForbiddenTransitions {
+		if t[1] != role {
+			continue
+		}
+		key := stateKey(event, "role_seen", identity+":"+t[0])
+		prevUser, err := td.state.Get(ctx, key)
 		if err != nil {
-			log.Printf("Error publishing alert: %v", err)
+			return "", &StateError{Op: "get", Key: key, Err: err}
+		}
+		if prevUser != "" && prevUser != event.User {
+			violation = fmt.Sprintf("%s authenticated as %s account %s and then %s account %s within %s",
+				identity, t[0], prevUser, role, event.User, cfg.Window)
+			break
+		}
+	}
+
+	key := stateKey(event, "role_seen", identity+":"+role)
+	if err := td.state.Set(ctx, key, event.User, cfg.Window.Duration); err != nil {
+		return violation, &StateError{Op: "set", Key: key, Err: err}
+	}
+	return violation, nil
+}
+
+// publishAlerts fans each detected threat out to the queue of every sink
+// its environment and the sink's filter allow. A full queue drops the alert
+// for that sink only.
+func (td *ThreatDetector) publishAlerts() {
+	defer td.wg.Done()
+
+	var sinksDone sync.WaitGroup
+	queues := make([]sinkQueue, len(td.sinks))
+	for i, sink := range td.sinks {
+		queues[i] = sinkQueue{sink: sink, alerts: make(chan ThreatAlert, td.cfg().Sinks.QueueSize), sending: new(atomic.Int64)}
+		sinksDone.Add(1)
+		go func(q sinkQueue) {
+			defer sinksDone.Done()
+			td.deliver(q)
+		}(queues[i])
+	}
+	td.sinkQueues.Store(&queues)
+
+	for alert := range td.alertChan {
+		// While alerting is paused, alerts wait in Redis for resume
+		if td.holdIfPaused(td.ctx, alert) {
 			continue
 		}
+		// Signed once here so every sink and the store get the same alert
+		td.signAlert(&alert)
+
+		// Stored once, by the first sink the alert is too big for
+		overflowKey := ""
+		for _, q := range queues {
+			if !td.cfg().Environment.routesTo(alert, q.sink.Name()) {
+				td.auditSuppression(alert, reasonSinkRouting, q.sink.Name(), "")
+				continue
+			}
+			if !td.cfg().Sinks.Filters[q.sink.Name()].allows(alert) {
+				sinkDeliveries.WithLabelValues(q.sink.Name(), "filtered").Inc()
+				td.auditSuppression(alert, reasonSinkFiltered, q.sink.Name(), "")
+				continue
+			}
+			select {
+			case q.alerts <- td.fitForSink(td.ctx, alert, q.sink.Name(), &overflowKey):
+			default:
+				sinkDeliveries.WithLabelValues(q.sink.Name(), "dropped").Inc()
+				td.auditSuppression(alert, reasonSinkDropped, q.sink.Name(), "")
+				log.Printf("Sink %s is %d alerts behind, dropping alert %s for it", q.sink.Name(), cap(q.alerts), alert.AlertID)
+				td.sinkFailed(q.sink, fmt.Errorf("queue full"))
+			}
+		}
+
+		// Keep it queryable on GET /alerts
+		if td.alertStore != nil {
+			if err := td.alertStore.Put(td.ctx, alert); err != nil {
+				processingErrors.WithLabelValues("state").Inc()
+				log.Printf("Error storing alert %s: %v", alert.AlertID, err)
+			}
+		}
 
 		log.Printf("🚨 ALERT: %s - %s from %s", 
 			alert.Severity, alert.ThreatType, alert.SourceIP)
 	}
+
+	// Let every sink drain its queue before shutdown closes it
+	for _, q := range queues {
+		close(q.alerts)
+	}
+	sinksDone.Wait()
 }
 
-// Shutdown gracefully shuts down the detector
+// Shutdown gracefully shuts down the detector. If it takes longer than
+// shutdown_timeout, the process logs the stalled step and exits with status 3.
 func (td *ThreatDetector) Shutdown() {
 	log.Println("Shutting down threat detector...")
+	disarm := td.guardShutdown(td.cfg().ShutdownTimeout.Duration)
+	defer disarm()
+
+	td.shutdownStep("stopping the HTTP servers")
+	td.stopHTTPServer()
+	close(td.stop)
+	td.shutdownStep("closing the event source")
+	td.source.Close()
+	if td.remediationReader != nil {
+		td.shutdownStep("closing the remediation results reader")
+		td.remediationReader.Close()
+	}
 
+	// Nothing may send on alertChan once it is closed, so every producer
+	// finishes first
+	td.shutdownStep("waiting for workers, the dispatcher, scans and the lifecycle sweep")
+	td.producers.Wait()
 	close(td.alertChan)
-	td.kafkaReader.Close()
-	td.kafkaWriter.Close()
-	td.redisClient.Close()
 
+	// Sinks drain their queues before they and the writer close
+	td.shutdownStep("waiting for sink queues and background tasks")
 	td.wg.Wait()
+	for _, sink := range td.sinks {
+		td.shutdownStep("closing sink " + sink.Name())
+		sink.Close()
+	}
+	td.shutdownStep("flushing and closing the Kafka writer")
+	td.kafkaWriter.Close()
+	td.shutdownStep("closing the state store")
+	td.state.Close()
 	log.Println("Threat detector shut down successfully")
 }
 
 func main() {
-	// Configuration (normally from env vars or config file)
-	kafkaBrokers := []string{"localhost:9092"}
-	redisAddr := "localhost:6379"
-	numWorkers := 5
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		if err := runSoak(os.Args[2:]); err != nil {
+			log.Fatalf("soak: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ruletest" {
+		os.Exit(runRuleTest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pseudonymize" {
+		if err := runPseudonymize(os.Args[2:]); err != nil {
+			log.Fatalf("pseudonymize: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-alert" {
+		os.Exit(runVerifyAlert(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", "", "path to JSON config file (defaults are used if empty)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration as JSON and exit")
+	stdin := flag.Bool("stdin", false, "read events from stdin and write alerts to stdout as JSON lines (input.type \"stdin\")")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if *printConfig {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg.Effective()); err != nil {
+			log.Fatalf("Error printing config: %v", err)
+		}
+		return
+	}
+
+	// Pipeline mode: no Kafka, Redis or HTTP, just stdin to stdout
+	if *stdin || cfg.Input.Type == inputStdin {
+		os.Exit(runStdin(cfg, os.Stdin, os.Stdout))
+	}
 
 	// Create detector
-	detector := NewThreatDetector(kafkaBrokers, redisAddr)
+	detector, err := NewThreatDetector(cfg)
+	if err != nil {
+		log.Fatalf("Error creating detector: %v", err)
+	}
+
+	// Rebuild recent state from snapshots before consuming events
+	if err := detector.RestoreState(); err != nil {
+		log.Printf("State restore failed, starting with current Redis state: %v", err)
+	}
+
+	// Bring state written by older versions up to the current key layout
+	if err := detector.MigrateState(); err != nil {
+		log.Printf("State migration failed, old-format keys are ignored until it succeeds: %v", err)
+	}
 
 	// Start processing
-	detector.Start(numWorkers)
+	detector.Start(cfg.NumWorkers)
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal; SIGHUP reloads the config file
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	exitCode := 0
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig != syscall.SIGHUP {
+				break wait
+			}
+			if *configPath == "" {
+				log.Println("SIGHUP ignored: no config file to reload")
+				continue
+			}
+			if err := detector.Reload(*configPath); err != nil {
+				log.Printf("%v; keeping the running config", err)
+			}
+		case err := <-detector.fatal:
+			log.Printf("Shutting down: %v", err)
+			exitCode = 1
+			break wait
+		}
+	}
 
 	// Graceful shutdown
 	detector.Shutdown()
+	os.Exit(exitCode)
 }
\ No newline at end of file
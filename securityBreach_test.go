@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is the fake clock a test detector runs on
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward, expiring state as it goes
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestDetector builds an in-memory detector on a fake clock, as ruletest
+// does. Nothing connects to Kafka or Redis; alerts stay on alertChan for the
+// caller to drain.
+func newTestDetector(tb testing.TB, cfg *DetectorConfig) *ThreatDetector {
+	td, _ := newClockedDetector(tb, cfg)
+	return td
+}
+
+// newClockedDetector is newTestDetector, returning the clock too
+func newClockedDetector(tb testing.TB, cfg *DetectorConfig) (*ThreatDetector, *testClock) {
+	tb.Helper()
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	cfg.DeadLetterTopic = ""
+	cfg.ObservationsTopic = ""
+	cfg.Enrichment.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		tb.Fatalf("config: %v", err)
+	}
+	clock := &testClock{now: ruleTestStart}
+	td, err := newMemoryDetector(cfg, clock.Now)
+	if err != nil {
+		tb.Fatalf("detector: %v", err)
+	}
+	return td, clock
+}
+
+// drainAlerts empties alertChan and returns what was on it
+func drainAlerts(td *ThreatDetector) []ThreatAlert {
+	var alerts []ThreatAlert
+	for len(td.alertChan) > 0 {
+		alerts = append(alerts, <-td.alertChan)
+	}
+	return alerts
+}
+
+// benchEvents are representative inputs: a failed login, an invalid user,
+// a sudo on a sensitive file, a password change and an ordinary success
+var benchEvents = []string{
+	`{"timestamp":"2024-01-01T00:00:00Z","source":"sshd","source_ip":"203.0.113.7","event_type":"authentication","user":"alice","action":"login","result":"failed","raw_log":"Failed password for alice from 203.0.113.7 port 22 ssh2","metadata":{"host":"web-1"}}`,
+	`{"timestamp":"2024-01-01T00:00:00Z","source":"sshd","source_ip":"198.51.100.9","event_type":"authentication","user":"oracle","action":"login","result":"failed","raw_log":"Invalid user oracle from 198.51.100.9 port 4242","metadata":{"host":"web-1"}}`,
+	`{"timestamp":"2024-01-01T00:00:00Z","source":"sudo","source_ip":"10.0.0.5","event_type":"privilege","user":"bob","action":"sudo","result":"success","raw_log":"bob : TTY=pts/0 ; COMMAND=/bin/cat /etc/shadow","metadata":{"host":"db-1"}}`,
+	`{"timestamp":"2024-01-01T00:00:00Z","source":"idp","source_ip":"192.0.2.44","event_type":"password_change","user":"carol","action":"password_change","result":"success","raw_log":"password changed for carol","metadata":{"host":"idp-1"}}`,
+	`{"timestamp":"2024-01-01T00:00:00Z","source":"sshd","source_ip":"192.0.2.10","event_type":"authentication","user":"dave","action":"login","result":"success","raw_log":"Accepted publickey for dave from 192.0.2.10 port 51000 ssh2","metadata":{"host":"web-2"}}`,
+}
+
+// parseBenchEvents parses benchEvents as a worker would
+func parseBenchEvents(tb testing.TB) []SecurityEvent {
+	tb.Helper()
+	events := make([]SecurityEvent, len(benchEvents))
+	for i, raw := range benchEvents {
+		if err := json.Unmarshal([]byte(raw), &events[i]); err != nil {
+			tb.Fatalf("benchEvents[%d]: %v", i, err)
+		}
+		events[i].normalize()
+	}
+	return events
+}
+
+func BenchmarkUnmarshalEvent(b *testing.B) {
+	value := []byte(benchEvents[0])
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var event SecurityEvent
+		if err := json.Unmarshal(value, &event); err != nil {
+			b.Fatal(err)
+		}
+		event.normalize()
+	}
+}
+
+func BenchmarkDetectThreats(b *testing.B) {
+	td := newTestDetector(b, nil)
+	events := parseBenchEvents(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := td.detectThreats(ctx, events[i%len(events)]); err != nil {
+			b.Fatal(err)
+		}
+		drainAlerts(td)
+	}
+}
+
+func BenchmarkDetectors(b *testing.B) {
+	td := newTestDetector(b, nil)
+	events := parseBenchEvents(b)
+	failed, invalid, sudo, change, success := events[0], events[1], events[2], events[3], events[4]
+	ctx := context.Background()
+
+	detectors := []struct {
+		name string
+		run  func() error
+	}{
+		{"BruteForce", func() error { _, _, err := td.isBruteForce(ctx, failed); return err }},
+		{"PrivilegeEscalation", func() error { td.isPrivilegeEscalation(sudo); return nil }},
+		{"SuspiciousUser", func() error { _, _, err := td.isSuspiciousUser(ctx, invalid); return err }},
+		{"PasswordChangeAnomaly", func() error { _, _, err := td.isPasswordChangeAnomaly(ctx, change); return err }},
+		{"PostBruteForceSuccess", func() error { _, err := td.recordPostBruteForceSuccess(ctx, success); return err }},
+	}
+	for _, d := range detectors {
+		b.Run(d.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := d.run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStateKey(b *testing.B) {
+	events := parseBenchEvents(b)
+	tenant := events[0]
+	tenant.Metadata = map[string]string{"tenant_id": "acme"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = stateKey(events[0], "failed_auth", events[0].SourceIP)
+		_ = stateKey(tenant, "failed_auth", tenant.SourceIP)
+	}
+}
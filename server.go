@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newHTTPServer builds the operational HTTP API
+func (td *ThreatDetector) newHTTPServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/effective", td.handleEffectiveConfig)
+	mux.HandleFunc("/metrics", handleMetrics)
+	if td.cfg().TestAlert.Enabled {
+		mux.HandleFunc("/test-alert", td.handleTestAlert)
+	}
+	if td.alertStore != nil {
+		mux.HandleFunc("/alerts", td.handleAlerts)
+	}
+	if td.observations != nil {
+		mux.HandleFunc("/observations", td.handleObservations)
+	}
+	if td.cfg().Feedback.Enabled {
+		mux.HandleFunc("/feedback", td.handleFeedback)
+	}
+	if td.cfg().Pause.Enabled {
+		mux.HandleFunc("/alerting/", td.handlePause)
+	}
+	if td.cfg().SuppressionAPI.Enabled {
+		mux.HandleFunc("/suppressions", td.handleSuppressions)
+		mux.HandleFunc("/suppressions/", td.handleSuppressions)
+	}
+	if len(td.cfg().Sinks.MaxBytes) > 0 {
+		mux.HandleFunc("/overflow/", td.handleOverflow)
+	}
+	if td.cfg().Simulation.Enabled {
+		mux.HandleFunc("/rules/simulate", td.handleSimulate)
+	}
+	if ui := td.cfg().UI; ui.Enabled && ui.Addr == "" {
+		mux.Handle("/ui/", uiHandler())
+		mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	}
+
+	return &http.Server{
+		Addr:              td.cfg().HTTPAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// startHTTPServer serves the HTTP API in the background
+func (td *ThreatDetector) startHTTPServer() {
+	td.httpServer = td.newHTTPServer()
+
+	go func() {
+		log.Printf("HTTP API listening on %s", td.cfg().HTTPAddr)
+		if err := td.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+}
+
+// stopHTTPServer gives in-flight requests a few seconds to finish
+func (td *ThreatDetector) stopHTTPServer() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range []*http.Server{td.httpServer, td.uiServer} {
+		if srv != nil {
+			srv.Shutdown(ctx)
+		}
+	}
+}
+
+// handleEffectiveConfig serves GET /config/effective
+func (td *ThreatDetector) handleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, td.cfg().Effective())
+}
+
+// testAlertRequest optionally shapes the synthetic alert sent by /test-alert
+type testAlertRequest struct {
+	Severity string `json:"severity"`
+	SourceIP string `json:"source_ip"`
+	TenantID string `json:"tenant_id"`
+}
+
+// handleTestAlert serves POST /test-alert: it publishes a synthetic alert
+// through every sink so operators can check delivery end to end
+func (td *ThreatDetector) handleTestAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !bearerTokenOK(r, td.cfg().TestAlert.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req := testAlertRequest{Severity: "CRITICAL", SourceIP: "192.0.2.1"} // TEST-NET-1
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if severityRank(req.Severity) < 0 {
+		http.Error(w, fmt.Sprintf("unknown severity %q", req.Severity), http.StatusBadRequest)
+		return
+	}
+
+	alert := ThreatAlert{
+		AlertID:    fmt.Sprintf("TEST-%d", time.Now().Unix()),
+		Timestamp:  time.Now(),
+		Severity:   req.Severity,
+		ThreatType: "TEST_ALERT",
+		SourceIP:   req.SourceIP,
+		Details:    "Synthetic alert from POST /test-alert; no threat was detected",
+		EventCount: 1,
+		TenantID:   req.TenantID,
+		Metadata:   map[string]string{"test": "true"},
+	}
+
+	select {
+	case td.alertChan <- alert:
+	case <-r.Context().Done():
+		return
+	case <-time.After(5 * time.Second):
+		http.Error(w, "alert publisher is busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	alertsRaised.WithLabelValues(alert.ThreatType, alert.Severity).Inc()
+	log.Printf("Test alert %s queued (%s) from %s", alert.AlertID, alert.Severity, r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, alert)
+}
+
+// bearerTokenOK checks the request's bearer token in constant time
+func bearerTokenOK(r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// writeJSON writes v as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
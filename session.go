@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// sessionListMax caps the source IPs remembered per session
+const sessionListMax = 20
+
+// validate checks the session-hijack settings
+func (c *SessionHijackConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.SessionField == "" {
+		return fmt.Errorf("session_hijack.session_field is required when enabled")
+	}
+	if c.MaxNetworks < 1 || c.MaxNetworks >= sessionListMax {
+		return fmt.Errorf("session_hijack.max_networks must be between 1 and %d", sessionListMax-1)
+	}
+	if c.IPv4Prefix < 1 || c.IPv4Prefix > 32 {
+		return fmt.Errorf("session_hijack.ipv4_prefix must be between 1 and 32")
+	}
+	if c.IPv6Prefix < 1 || c.IPv6Prefix > 128 {
+		return fmt.Errorf("session_hijack.ipv6_prefix must be between 1 and 128")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("session_hijack.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("session_hijack.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// sessionRef identifies a session without storing or alerting on the token
+// itself, which would let anyone reading alerts replay it
+func sessionRef(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:8])
+}
+
+// isSessionHijack records the network each session is used from. It returns
+// the session's reference and its source IPs (one per network) when a new
+// network takes the session past max_networks. Addresses within one prefix
+// count as one network, so a phone moving between a carrier's addresses
+// doesn't alert.
+func (td *ThreatDetector) isSessionHijack(ctx context.Context, event SecurityEvent) (bool, string, []string, error) {
+	cfg := td.cfg().SessionHijack
+	session := event.Metadata[cfg.SessionField]
+	if !cfg.Enabled || session == "" || event.SourceIP == "" {
+		return false, "", nil, nil
+	}
+	addr, ok := parseIP(event.SourceIP)
+	if !ok {
+		return false, "", nil, nil
+	}
+	network, err := ipPrefix(addr, cfg.IPv4Prefix, cfg.IPv6Prefix)
+	if err != nil {
+		return false, "", nil, nil
+	}
+
+	id := sessionRef(session)
+	seenKey := stateKey(event, "session_net", id+":"+ipKeyPart(network.Addr()))
+	first, err := td.state.SetIfAbsent(ctx, seenKey, "1", cfg.Window.Duration)
+	if err != nil {
+		return false, "", nil, &StateError{Op: "setnx", Key: seenKey, Err: err}
+	}
+	if !first {
+		return false, "", nil, nil
+	}
+
+	ipsKey := stateKey(event, "session_ips", id)
+	if err := td.state.AppendList(ctx, ipsKey, event.SourceIP, sessionListMax, cfg.Window.Duration); err != nil {
+		return false, "", nil, &StateError{Op: "rpush", Key: ipsKey, Err: err}
+	}
+	ips, err := td.state.ListRange(ctx, ipsKey)
+	if err != nil {
+		return false, "", nil, &StateError{Op: "lrange", Key: ipsKey, Err: err}
+	}
+	return len(ips) > cfg.MaxNetworks, id, ips, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// exitShutdownTimeout is the exit status when graceful shutdown overran
+// shutdown_timeout and the process was stopped by force
+const exitShutdownTimeout = 3
+
+// shutdownStep records the step Shutdown is on, for the timeout report
+func (td *ThreatDetector) shutdownStep(name string) {
+	td.shutdownAt.Store(&name)
+}
+
+// guardShutdown exits the process if shutdown takes longer than timeout,
+// after logging what it was still waiting on. The returned func disarms it.
+func (td *ThreatDetector) guardShutdown(timeout time.Duration) (disarm func()) {
+	timer := time.AfterFunc(timeout, func() {
+		step := "starting"
+		if at := td.shutdownAt.Load(); at != nil {
+			step = *at
+		}
+		log.Printf("Shutdown did not finish within %s, stalled at: %s", timeout, step)
+		for _, line := range td.inFlightReport() {
+			log.Printf("  still in flight: %s", line)
+		}
+		log.Printf("Forcing exit with status %d", exitShutdownTimeout)
+		os.Exit(exitShutdownTimeout)
+	})
+	return func() { timer.Stop() }
+}
+
+// inFlightReport lists the work shutdown is waiting for: busy workers,
+// alerts not yet fanned out, and alerts queued or being sent per sink
+func (td *ThreatDetector) inFlightReport() []string {
+	var lines []string
+
+	td.workersMu.Lock()
+	for _, slot := range td.workers {
+		if since := slot.busySince.Load(); since != 0 {
+			lines = append(lines, fmt.Sprintf("worker %d busy on one event for %s",
+				slot.id, time.Since(time.Unix(0, since)).Round(time.Millisecond)))
+		}
+	}
+	td.workersMu.Unlock()
+
+	if n := len(td.alertChan); n > 0 {
+		lines = append(lines, fmt.Sprintf("%d alerts waiting for the publisher", n))
+	}
+	if queues := td.sinkQueues.Load(); queues != nil {
+		for _, q := range *queues {
+			var parts []string
+			if n := len(q.alerts); n > 0 {
+				parts = append(parts, fmt.Sprintf("%d alerts queued", n))
+			}
+			if since := q.sending.Load(); since != 0 {
+				parts = append(parts, fmt.Sprintf("sending one for %s", time.Since(time.Unix(0, since)).Round(time.Millisecond)))
+			}
+			if len(parts) > 0 {
+				lines = append(lines, fmt.Sprintf("sink %s: %s", q.sink.Name(), strings.Join(parts, ", ")))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no events or alerts; a background task or client close is blocked")
+	}
+	return lines
+}
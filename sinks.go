@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// sinkNames are the Name() of every sink, for sinks.critical
+var sinkNames = []string{"kafka", "pagerduty", "sqs", "sns", "stix"}
+
+// AlertSink delivers alerts to a downstream system.
+// Send returns a *PublishError so the publisher can decide whether to retry.
+type AlertSink interface {
+	Name() string
+	Send(ctx context.Context, alert ThreatAlert) error
+	Close() error
+}
+
+// backgroundSink is implemented by sinks that need a goroutine of their own
+// (e.g. to resolve incidents); Run must return once stop is closed
+type backgroundSink interface {
+	Run(ctx context.Context, stop <-chan struct{})
+}
+
+// validateFilters checks sinks.filters
+func (c *SinksConfig) validateFilters() error {
+	names := mapKeys(c.Filters)
+	sort.Strings(names)
+	for _, name := range names {
+		if !containsString(sinkNames, name) {
+			return fmt.Errorf("sinks.filters: unknown sink %q (want one of %s)", name, strings.Join(sinkNames, ", "))
+		}
+		if f := c.Filters[name]; f.MinSeverity != "" && severityRank(f.MinSeverity) < 0 {
+			return fmt.Errorf("sinks.filters.%s.min_severity %q is not a severity", name, f.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// allows reports whether the filter lets an alert through
+func (f SinkFilter) allows(alert ThreatAlert) bool {
+	if f.MinSeverity != "" && severityRank(alert.Severity) < severityRank(f.MinSeverity) {
+		return false
+	}
+	if len(f.ThreatTypes) > 0 && !containsString(f.ThreatTypes, alert.ThreatType) {
+		return false
+	}
+	return !containsString(f.ExcludeThreatTypes, alert.ThreatType)
+}
+
+// batchSink is implemented by sinks that deliver several alerts per call.
+// SendBatch returns the alerts that weren't delivered along with the error.
+type batchSink interface {
+	SendBatch(ctx context.Context, alerts []ThreatAlert) (failed []ThreatAlert, err error)
+	batchLimits() (size int, wait time.Duration)
+}
+
+// sinkQueue feeds one sink from a goroutine of its own, so a slow or failing
+// sink never holds up the others. Each sink sees alerts in publish order.
+type sinkQueue struct {
+	sink   AlertSink
+	alerts chan ThreatAlert
+
+	// sending is when the alert being sent was taken off the queue (unix
+	// nanos), or 0 while the sink is idle
+	sending *atomic.Int64
+}
+
+// deliver sends queued alerts to the sink until the queue is closed
+func (td *ThreatDetector) deliver(q sinkQueue) {
+	if bs, ok := q.sink.(batchSink); ok {
+		td.deliverBatches(q, bs)
+		return
+	}
+	for alert := range q.alerts {
+		q.sending.Store(time.Now().UnixNano())
+		err := td.publishAlert(q.sink, alert)
+		q.sending.Store(0)
+		if err == nil {
+			sinkDeliveries.WithLabelValues(q.sink.Name(), "success").Inc()
+			continue
+		}
+		sinkDeliveries.WithLabelValues(q.sink.Name(), "failure").Inc()
+		processingErrors.WithLabelValues("publish").Inc()
+		log.Printf("Error publishing alert to %s: %v", q.sink.Name(), err)
+		td.sinkFailed(q.sink, err)
+	}
+}
+
+// deliverBatches collects queued alerts into batches of up to size, sending
+// a partial batch once the oldest alert in it has waited wait. A closed
+// queue sends what was collected before returning.
+func (td *ThreatDetector) deliverBatches(q sinkQueue, bs batchSink) {
+	size, wait := bs.batchLimits()
+	for {
+		alert, ok := <-q.alerts
+		if !ok {
+			return
+		}
+		batch := []ThreatAlert{alert}
+		timer := time.NewTimer(wait)
+	collect:
+		for len(batch) < size {
+			select {
+			case alert, ok = <-q.alerts:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, alert)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		q.sending.Store(time.Now().UnixNano())
+		delivered, err := td.publishBatch(bs, batch)
+		q.sending.Store(0)
+		sinkDeliveries.WithLabelValues(q.sink.Name(), "success").Add(float64(delivered))
+		if err != nil {
+			sinkDeliveries.WithLabelValues(q.sink.Name(), "failure").Add(float64(len(batch) - delivered))
+			processingErrors.WithLabelValues("publish").Inc()
+			log.Printf("Error publishing %d of %d alerts to %s: %v", len(batch)-delivered, len(batch), q.sink.Name(), err)
+			td.sinkFailed(q.sink, err)
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// publishBatch sends a batch, retrying the undelivered alerts on transient
+// failures like publishAlert. It returns how many alerts were delivered.
+func (td *ThreatDetector) publishBatch(bs batchSink, batch []ThreatAlert) (int, error) {
+	timeout := td.cfg().Sinks.Timeout.Duration
+	pending := batch
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(td.ctx, timeout)
+		failed, err := bs.SendBatch(ctx, pending)
+		cancel()
+		if err == nil {
+			return len(batch), nil
+		}
+
+		var pubErr *PublishError
+		if attempt >= publishAttempts || !errors.As(err, &pubErr) || !pubErr.Retryable || len(failed) == 0 {
+			return len(batch) - len(failed), err
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		pending = failed
+	}
+}
+
+// publishAttempts is how many times a retryable publish is tried before dropping the alert
+const publishAttempts = 3
+
+// publishAlert sends an alert to one sink, retrying transient failures
+// with a short linear backoff. Each attempt gets sinks.timeout.
+func (td *ThreatDetector) publishAlert(sink AlertSink, alert ThreatAlert) error {
+	timeout := td.cfg().Sinks.Timeout.Duration
+	send := func() error {
+		ctx, cancel := context.WithTimeout(td.ctx, timeout)
+		defer cancel()
+		return sink.Send(ctx, alert)
+	}
+
+	err := send()
+
+	var pubErr *PublishError
+	for attempt := 1; attempt < publishAttempts && errors.As(err, &pubErr) && pubErr.Retryable; attempt++ {
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		err = send()
+	}
+	return err
+}
+
+// sinkFailed reports a lost alert on a critical sink to main, which shuts down
+func (td *ThreatDetector) sinkFailed(sink AlertSink, err error) {
+	if !containsString(td.cfg().Sinks.Critical, sink.Name()) {
+		return
+	}
+	select {
+	case td.fatal <- fmt.Errorf("critical sink %s failed: %w", sink.Name(), err):
+	default: // already shutting down
+	}
+}
+
+// KafkaSink publishes alerts to the (per-tenant) alerts topic
+type KafkaSink struct {
+	writer *kafka.Writer
+	config func() *DetectorConfig // live config, for per-tenant topics
+}
+
+// NewKafkaSink creates the primary alerts sink. The writer is shared with
+// other producers and is closed by the detector, not the sink.
+func NewKafkaSink(writer *kafka.Writer, config func() *DetectorConfig) *KafkaSink {
+	return &KafkaSink{writer: writer, config: config}
+}
+
+func (s *KafkaSink) Name() string { return "kafka" }
+
+// Send serializes an alert and writes it to the tenant's alerts topic
+func (s *KafkaSink) Send(ctx context.Context, alert ThreatAlert) error {
+	topic := s.config().AlertsTopicFor(alert.TenantID)
+
+	alertJSON, err := s.config().Sinks.Transforms["kafka"].encode(alert)
+	if err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: topic, Err: err}
+	}
+
+	err = s.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(alert.SourceIP),
+		Value: alertJSON,
+	})
+	if err != nil {
+		return &PublishError{AlertID: alert.AlertID, Topic: topic, Err: err, Retryable: true}
+	}
+	return nil
+}
+
+// SendBatch writes the alerts to their alerts topics in one WriteMessages
+// call. On a partial failure only the alerts whose messages failed are
+// returned for retry. An alert that can't be encoded is returned as failed
+// without holding up the rest of the batch.
+func (s *KafkaSink) SendBatch(ctx context.Context, alerts []ThreatAlert) ([]ThreatAlert, error) {
+	cfg := s.config()
+	msgs := make([]kafka.Message, 0, len(alerts))
+	sent := make([]ThreatAlert, 0, len(alerts))
+	var unencoded []ThreatAlert
+	var encodeErr *PublishError
+	for _, alert := range alerts {
+		topic := cfg.AlertsTopicFor(alert.TenantID)
+		alertJSON, err := cfg.Sinks.Transforms["kafka"].encode(alert)
+		if err != nil {
+			if encodeErr == nil {
+				encodeErr = &PublishError{AlertID: alert.AlertID, Topic: topic, Err: err}
+			}
+			unencoded = append(unencoded, alert)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Topic: topic, Key: []byte(alert.SourceIP), Value: alertJSON})
+		sent = append(sent, alert)
+	}
+
+	var failed []ThreatAlert
+	var err error
+	if len(msgs) > 0 {
+		err = s.writer.WriteMessages(ctx, msgs...)
+	}
+	if err != nil {
+		failed = sent
+		var writeErrs kafka.WriteErrors
+		if errors.As(err, &writeErrs) && len(writeErrs) == len(sent) {
+			failed = nil
+			for i, e := range writeErrs {
+				if e != nil {
+					failed = append(failed, sent[i])
+				}
+			}
+		}
+	}
+	if len(failed) == 0 {
+		if encodeErr == nil {
+			return nil, nil
+		}
+		// Encoding fails the same way every time, so these aren't retried
+		encodeErr.Err = fmt.Errorf("%d of %d alerts in the batch: %w", len(unencoded), len(alerts), encodeErr.Err)
+		return unencoded, encodeErr
+	}
+	if encodeErr != nil {
+		err = fmt.Errorf("%w; %d more could not be encoded: %v", err, len(unencoded), encodeErr)
+	}
+	return append(failed, unencoded...), &PublishError{
+		AlertID:   failed[0].AlertID,
+		Topic:     cfg.AlertsTopicFor(failed[0].TenantID),
+		Err:       fmt.Errorf("%d of %d alerts in the batch: %w", len(failed)+len(unencoded), len(alerts), err),
+		Retryable: true,
+	}
+}
+
+// batchLimits reads sinks.kafka from the live config. sinks is restart-only,
+// so the limits only change with a restart.
+func (s *KafkaSink) batchLimits() (int, time.Duration) {
+	batch := s.config().Sinks.Kafka
+	return batch.BatchSize, batch.BatchWait.Duration
+}
+
+func (s *KafkaSink) Close() error { return nil }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaBatchSendsAroundUnencodableAlert(t *testing.T) {
+	cfg := DefaultConfig()
+	transport := &wireTransport{}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP("localhost:9092"),
+		Balancer:     &kafka.LeastBytes{},
+		BatchSize:    100,
+		BatchTimeout: 5 * time.Millisecond,
+		Transport:    transport,
+	}
+	defer writer.Close()
+	sink := NewKafkaSink(writer, func() *DetectorConfig { return cfg })
+
+	// JSON has no timestamps past year 9999
+	alerts := compressionAlerts()[:3]
+	alerts[1].Timestamp = time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	failed, err := sink.SendBatch(context.Background(), alerts)
+	var pubErr *PublishError
+	if !errors.As(err, &pubErr) || pubErr.Retryable || pubErr.AlertID != alerts[1].AlertID {
+		t.Fatalf("SendBatch error %v, want a non-retryable error for %s", err, alerts[1].AlertID)
+	}
+	if len(failed) != 1 || failed[0].AlertID != alerts[1].AlertID {
+		t.Fatalf("SendBatch failed %+v, want only %s", failed, alerts[1].AlertID)
+	}
+
+	var sent []string
+	for _, batch := range transport.batches {
+		_, values := readBatch(t, batch)
+		for _, value := range values {
+			var alert ThreatAlert
+			if err := json.Unmarshal(value, &alert); err != nil {
+				t.Fatalf("record is not an alert: %v", err)
+			}
+			sent = append(sent, alert.AlertID)
+		}
+	}
+	if len(sent) != 2 || sent[0] != alerts[0].AlertID || sent[1] != alerts[2].AlertID {
+		t.Errorf("wrote %v, want %s and %s", sent, alerts[0].AlertID, alerts[2].AlertID)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// snapshotPrefixes are the in-window counters and markers worth restoring.
+// Each is matched both un-prefixed and under any tenant namespace.
+var snapshotPrefixes = []string{
+	"failed_auth",
+	"invalid_user",
+	"password_change",
+	"post_bf_success",
+	"new_source_login",
+	"blocked",
+	"kerberoast",
+}
+
+// stateSnapshot is the value written to the compacted snapshot topic
+type stateSnapshot struct {
+	Value      string    `json:"value"`
+	TTLMillis  int64     `json:"ttl_ms"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+}
+
+// runSnapshots periodically writes detection state to the snapshot topic until stop is closed
+func (td *ThreatDetector) runSnapshots() {
+	defer td.wg.Done()
+
+	ticker := time.NewTicker(td.cfg().Snapshot.Interval.Duration)
+	defer ticker.Stop()
+
+	// Keys written last round, so keys that expired since get a tombstone
+	previous := make(map[string]bool)
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+			current, err := td.snapshotState(previous)
+			if err != nil {
+				log.Printf("State snapshot failed: %v", err)
+				continue
+			}
+			previous = current
+		}
+	}
+}
+
+// snapshotState writes every live counter, plus tombstones for keys that are gone
+func (td *ThreatDetector) snapshotState(previous map[string]bool) (map[string]bool, error) {
+	var keys []string
+	for _, prefix := range snapshotPrefixes {
+		for _, pattern := range []string{prefix + ":*", "tenant:*:" + prefix + ":*"} {
+			found, err := td.state.ScanKeys(td.ctx, pattern)
+			if err != nil {
+				return nil, &StateError{Op: "scan", Key: pattern, Err: err}
+			}
+			keys = append(keys, found...)
+		}
+	}
+
+	now := time.Now()
+	current := make(map[string]bool, len(keys))
+	msgs := make([]kafka.Message, 0, len(keys))
+
+	for _, key := range keys {
+		value, ttl, err := td.state.GetWithTTL(td.ctx, key)
+		if err != nil {
+			return nil, &StateError{Op: "get", Key: key, Err: err}
+		}
+		if value == "" || ttl <= 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(stateSnapshot{Value: value, TTLMillis: ttl.Milliseconds(), SnapshotAt: now})
+		if err != nil {
+			return nil, err
+		}
+		current[key] = true
+		msgs = append(msgs, kafka.Message{Topic: td.cfg().Snapshot.Topic, Key: []byte(key), Value: payload})
+	}
+
+	// A nil value is a tombstone: compaction drops the key entirely
+	for key := range previous {
+		if !current[key] {
+			msgs = append(msgs, kafka.Message{Topic: td.cfg().Snapshot.Topic, Key: []byte(key)})
+		}
+	}
+
+	if len(msgs) == 0 {
+		return current, nil
+	}
+	if err := td.kafkaWriter.WriteMessages(td.ctx, msgs...); err != nil {
+		return nil, &PublishError{Topic: td.cfg().Snapshot.Topic, Err: err, Retryable: true}
+	}
+	return current, nil
+}
+
+// RestoreState rebuilds in-window state from the snapshot topic.
+// Call it before Start so workers see the restored counters.
+// Keys that already exist in Redis are left alone: live state always wins.
+func (td *ThreatDetector) RestoreState() error {
+	if !td.cfg().Snapshot.Enabled {
+		return nil
+	}
+
+	latest, err := td.readSnapshots()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	restored := 0
+	for key, snap := range latest {
+		// Skip entries whose window has closed since the snapshot
+		remaining := time.Duration(snap.TTLMillis)*time.Millisecond - now.Sub(snap.SnapshotAt)
+		if remaining <= 0 {
+			continue
+		}
+
+		ok, err := td.state.SetIfAbsent(td.ctx, key, snap.Value, remaining)
+		if err != nil {
+			return &StateError{Op: "setnx", Key: key, Err: err}
+		}
+		if ok {
+			restored++
+		}
+	}
+
+	log.Printf("Restored %d state keys from %s", restored, td.cfg().Snapshot.Topic)
+	return nil
+}
+
+// readSnapshots reads every partition of the snapshot topic up to its current end
+func (td *ThreatDetector) readSnapshots() (map[string]stateSnapshot, error) {
+	ctx, cancel := context.WithTimeout(td.ctx, time.Minute)
+	defer cancel()
+
+	topic := td.cfg().Snapshot.Topic
+	conn, err := kafka.DialContext(ctx, "tcp", td.cfg().KafkaBrokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("dialing kafka: %w", err)
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading partitions of %s: %w", topic, err)
+	}
+
+	latest := make(map[string]stateSnapshot)
+	for _, p := range partitions {
+		if err := td.readSnapshotPartition(ctx, topic, p.ID, latest); err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// readSnapshotPartition reads one partition from its first to last offset into latest
+func (td *ThreatDetector) readSnapshotPartition(ctx context.Context, topic string, partition int, latest map[string]stateSnapshot) error {
+	leader, err := kafka.DialLeader(ctx, "tcp", td.cfg().KafkaBrokers[0], topic, partition)
+	if err != nil {
+		return fmt.Errorf("dialing leader for %s/%d: %w", topic, partition, err)
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil {
+		return fmt.Errorf("reading offsets for %s/%d: %w", topic, partition, err)
+	}
+	if first >= last {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   td.cfg().KafkaBrokers,
+		Topic:     topic,
+		Partition: partition,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("reading %s/%d: %w", topic, partition, err)
+		}
+
+		key := string(msg.Key)
+		if msg.Value == nil {
+			delete(latest, key)
+		} else {
+			var snap stateSnapshot
+			if err := json.Unmarshal(msg.Value, &snap); err == nil {
+				latest[key] = snap
+			}
+		}
+
+		if msg.Offset >= last-1 {
+			return nil
+		}
+	}
+}
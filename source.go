@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// EventSource delivers raw security events to the workers. Messages are
+// wrapped in kafka.Message whatever the backend, so parsing, error handling
+// and dead-lettering don't care where an event came from.
+type EventSource interface {
+	// Fetch blocks until the next message is available
+	Fetch(ctx context.Context) (kafka.Message, error)
+	// Ack marks a message as fully processed
+	Ack(ctx context.Context, msg kafka.Message) error
+	Close() error
+}
+
+// kafkaSource reads from a Kafka consumer group. Offsets are committed by the
+// reader as messages are fetched, so Ack is a no-op.
+type kafkaSource struct {
+	reader *kafka.Reader
+}
+
+// newKafkaSource creates the consumer for the events topic
+func newKafkaSource(cfg *DetectorConfig) *kafkaSource {
+	return &kafkaSource{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.KafkaBrokers,
+			Topic:       cfg.EventsTopic,
+			GroupID:     cfg.ConsumerGroup,
+			StartOffset: cfg.KafkaStartOffset(),
+			MinBytes:    10e3, // 10KB
+			MaxBytes:    10e6, // 10MB
+			MaxWait:     500 * time.Millisecond,
+		}),
+	}
+}
+
+func (s *kafkaSource) Fetch(ctx context.Context) (kafka.Message, error) {
+	return s.reader.ReadMessage(ctx)
+}
+
+func (s *kafkaSource) Ack(ctx context.Context, msg kafka.Message) error { return nil }
+
+func (s *kafkaSource) Close() error { return s.reader.Close() }
+
+// redisStreamSource reads from a Redis Stream consumer group. Entries are
+// XACKed only after processing, so a crash leaves them pending for redelivery.
+type redisStreamSource struct {
+	client *redis.Client
+	cfg    RedisStreamConfig
+
+	mu      sync.Mutex
+	buf     []redis.XMessage
+	pending string // next ID to re-read from our pending entries; "" once drained
+}
+
+// streamIDHeader carries a Redis Stream entry ID on the wrapped message
+const streamIDHeader = "redis-stream-id"
+
+// streamID returns the Redis Stream entry ID of a message, or ""
+func streamID(msg kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == streamIDHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// newRedisStreamSource joins (creating if needed) the stream's consumer group
+func newRedisStreamSource(ctx context.Context, client *redis.Client, cfg RedisStreamConfig) (*redisStreamSource, error) {
+	start := "$"
+	if cfg.StartID != "" {
+		start = cfg.StartID
+	}
+	err := client.XGroupCreateMkStream(ctx, cfg.Stream, cfg.Group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("redis stream %s: create group %s: %w", cfg.Stream, cfg.Group, err)
+	}
+	return &redisStreamSource{client: client, cfg: cfg, pending: "0"}, nil
+}
+
+// Fetch serves buffered entries, reading a new batch when the buffer is empty.
+// Workers share one buffer, so only one of them waits on Redis at a time.
+func (s *redisStreamSource) Fetch(ctx context.Context) (kafka.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.buf) == 0 {
+		// An explicit ID re-reads entries delivered to this consumer (by a
+		// previous run) but never acked; ">" reads entries new to the group
+		id := ">"
+		block := s.cfg.Block.Duration
+		if s.pending != "" {
+			id, block = s.pending, -1
+		}
+
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.cfg.Group,
+			Consumer: s.cfg.Consumer,
+			Streams:  []string{s.cfg.Stream, id},
+			Count:    int64(s.cfg.BatchSize),
+			Block:    block,
+		}).Result()
+		if err == redis.Nil {
+			continue // block timed out with nothing new
+		}
+		if err != nil {
+			return kafka.Message{}, err
+		}
+
+		for _, stream := range streams {
+			s.buf = append(s.buf, stream.Messages...)
+		}
+		if s.pending != "" {
+			if len(s.buf) == 0 {
+				s.pending = ""
+				continue
+			}
+			log.Printf("Redelivering %d pending entries from %s", len(s.buf), s.cfg.Stream)
+			s.pending = s.buf[len(s.buf)-1].ID
+		}
+	}
+
+	entry := s.buf[0]
+	s.buf = s.buf[1:]
+
+	value, _ := entry.Values[s.cfg.Field].(string)
+	return kafka.Message{
+		Topic:   s.cfg.Stream,
+		Offset:  -1,
+		Value:   []byte(value),
+		Headers: []kafka.Header{{Key: streamIDHeader, Value: []byte(entry.ID)}},
+	}, nil
+}
+
+func (s *redisStreamSource) Ack(ctx context.Context, msg kafka.Message) error {
+	return s.client.XAck(ctx, s.cfg.Stream, s.cfg.Group, streamID(msg)).Err()
+}
+
+// Close leaves the shared Redis client to the state store
+func (s *redisStreamSource) Close() error { return nil }
+
+// newEventSource builds the configured input
+func newEventSource(ctx context.Context, cfg *DetectorConfig, client *redis.Client) (EventSource, error) {
+	if cfg.Input.Type == "redis_stream" {
+		return newRedisStreamSource(ctx, client, cfg.Input.RedisStream)
+	}
+	return newKafkaSource(cfg), nil
+}
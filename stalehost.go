@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Why a host's access was flagged
+const (
+	staleDecommissioned = "decommissioned"
+	staleInactive       = "inactive"
+)
+
+// validate checks the stale host settings, lowercases the host patterns and
+// parses the IP and CIDR entries
+func (c *StaleHostConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	c.decommissioned = nil
+	var patterns []string
+	for _, entry := range c.Decommissioned {
+		if prefixes, err := parsePrefixList([]string{entry}); err == nil {
+			c.decommissioned = append(c.decommissioned, prefixes[0])
+			continue
+		}
+		patterns = append(patterns, strings.ToLower(entry))
+	}
+	if err := validatePatterns(patterns); err != nil {
+		return fmt.Errorf("stale_hosts.decommissioned: %w", err)
+	}
+	c.hostPatterns = patterns
+	if len(c.EventTypes) == 0 {
+		return fmt.Errorf("stale_hosts.event_types is required when enabled")
+	}
+	for i, t := range c.EventTypes {
+		c.EventTypes[i] = strings.ToLower(t)
+	}
+	if c.Inactivity.Duration <= 0 {
+		return fmt.Errorf("stale_hosts.inactivity must be positive")
+	}
+	if c.Retention.Duration <= c.Inactivity.Duration {
+		return fmt.Errorf("stale_hosts.retention must be longer than inactivity")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("stale_hosts.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("stale_hosts.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// isDecommissioned reports whether a host name or address is on the list
+func (c *StaleHostConfig) isDecommissioned(host string) bool {
+	if addr, ok := parseIP(host); ok && containsAddr(c.decommissioned, addr) {
+		return true
+	}
+	return matchAny(c.hostPatterns, host)
+}
+
+// staleHostHit is an access to a decommissioned or long-silent host
+type staleHostHit struct {
+	host     string
+	reason   string
+	lastSeen time.Time // zero for decommissioned hosts
+}
+
+// isStaleHostAccess records when each host was last seen, from events of
+// any type, and flags authentication and access events aimed at a host that
+// is decommissioned or had been silent for longer than the inactivity
+// threshold. A host never seen before is new, not stale.
+func (td *ThreatDetector) isStaleHostAccess(ctx context.Context, event SecurityEvent) (*staleHostHit, error) {
+	cfg := td.cfg().StaleHosts
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	host := event.Source
+	if cfg.TargetField != "" && event.Metadata[cfg.TargetField] != "" {
+		host = event.Metadata[cfg.TargetField]
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return nil, nil
+	}
+	access := containsString(cfg.EventTypes, event.eventTypeLower)
+
+	if cfg.isDecommissioned(host) {
+		if !access {
+			return nil, nil
+		}
+		key := stateKey(event, "stale_host_alerted", host)
+		seen, err := td.dedup.SeenRecently(ctx, key, cfg.Window.Duration)
+		if err != nil {
+			return nil, &StateError{Op: "dedup", Key: key, Err: err}
+		}
+		if seen {
+			return nil, nil
+		}
+		return &staleHostHit{host: host, reason: staleDecommissioned}, nil
+	}
+
+	now := td.now()
+	key := stateKey(event, "host_seen", host)
+	_, previous, err := td.state.TouchSeen(ctx, key, now.Unix(), cfg.Retention.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "touch", Key: key, Err: err}
+	}
+	if !access || previous == 0 {
+		return nil, nil
+	}
+	lastSeen := time.Unix(previous, 0)
+	if now.Sub(lastSeen) < cfg.Inactivity.Duration {
+		return nil, nil
+	}
+	return &staleHostHit{host: host, reason: staleInactive, lastSeen: lastSeen}, nil
+}
+
+// staleHostDetails describes a stale host hit for the alert
+func staleHostDetails(event SecurityEvent, hit *staleHostHit, now time.Time) string {
+	who := event.User
+	if who == "" {
+		who = event.SourceIP
+	}
+	if hit.reason == staleDecommissioned {
+		return fmt.Sprintf("%s %s on decommissioned host %s", who, event.Action, hit.host)
+	}
+	return fmt.Sprintf("%s %s on host %s, which had been silent for %s (last seen %s)",
+		who, event.Action, hit.host, now.Sub(hit.lastSeen).Round(time.Hour), hit.lastSeen.UTC().Format(time.RFC3339))
+}
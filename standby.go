@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mirrorOp is a state write replayed on the standby
+type mirrorOp func(ctx context.Context, s StateStore) error
+
+// standbyStore serves state from the primary Redis and mirrors every write to
+// a warm standby in the background. Once the primary fails enough calls in a
+// row it switches to the standby for good (until restart). Mirroring is
+// best-effort: writes queued or dropped when the primary fails are lost.
+type standbyStore struct {
+	primary, standby StateStore
+	cfg              StandbyConfig
+
+	failures   atomic.Int32
+	failedOver atomic.Bool
+
+	queue chan mirrorOp
+	done  sync.WaitGroup
+}
+
+// NewStandbyStore wraps a primary and standby store and starts mirroring
+func NewStandbyStore(primary, standby StateStore, cfg StandbyConfig) StateStore {
+	s := &standbyStore{
+		primary: primary,
+		standby: standby,
+		cfg:     cfg,
+		queue:   make(chan mirrorOp, cfg.QueueSize),
+	}
+	s.done.Add(1)
+	go s.mirror()
+	return s
+}
+
+// validate checks the standby settings
+func (c *StandbyConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Addr == "" {
+		return fmt.Errorf("standby.addr is required when standby is enabled")
+	}
+	if c.FailoverAfter < 1 {
+		return fmt.Errorf("standby.failover_after must be at least 1")
+	}
+	if c.QueueSize < 1 {
+		return fmt.Errorf("standby.queue_size must be at least 1")
+	}
+	if c.Timeout.Duration <= 0 {
+		return fmt.Errorf("standby.timeout must be positive")
+	}
+	return nil
+}
+
+// mirror replays queued writes on the standby, in order, until Close
+func (s *standbyStore) mirror() {
+	defer s.done.Done()
+	for op := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout.Duration)
+		err := op(ctx, s.standby)
+		cancel()
+		if err != nil {
+			standbyWrites.WithLabelValues("failure").Inc()
+			continue
+		}
+		standbyWrites.WithLabelValues("success").Inc()
+	}
+}
+
+// enqueue mirrors a write that succeeded on the primary. A full queue drops
+// it rather than slowing detection down.
+func (s *standbyStore) enqueue(op mirrorOp) {
+	select {
+	case s.queue <- op:
+	default:
+		standbyWrites.WithLabelValues("dropped").Inc()
+	}
+}
+
+// failed counts a primary error and reports whether the store has now
+// failed over. Errors from a cancelled caller say nothing about Redis.
+func (s *standbyStore) failed(ctx context.Context, err error) bool {
+	if err == nil {
+		s.failures.Store(0)
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	if int(s.failures.Add(1)) < s.cfg.FailoverAfter {
+		return false
+	}
+	if s.failedOver.CompareAndSwap(false, true) {
+		stateFailovers.Inc()
+		log.Printf("State: primary Redis failed %d calls in a row (%v); switching to standby %s until restart",
+			s.cfg.FailoverAfter, err, s.cfg.Addr)
+	}
+	return true
+}
+
+// call runs op on the active store. Writes that succeed on the primary are
+// mirrored; the call that trips the failover is retried on the standby.
+func call[T any](s *standbyStore, ctx context.Context, write bool, op func(context.Context, StateStore) (T, error)) (T, error) {
+	if s.failedOver.Load() {
+		return op(ctx, s.standby)
+	}
+	v, err := op(ctx, s.primary)
+	if s.failed(ctx, err) {
+		return op(ctx, s.standby)
+	}
+	if write && err == nil {
+		s.enqueue(func(ctx context.Context, st StateStore) error {
+			_, err := op(ctx, st)
+			return err
+		})
+	}
+	return v, err
+}
+
+func (s *standbyStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return call(s, ctx, true, func(ctx context.Context, st StateStore) (int64, error) {
+		return st.Incr(ctx, key, ttl)
+	})
+}
+
+func (s *standbyStore) IncrFixed(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return call(s, ctx, true, func(ctx context.Context, st StateStore) (int64, error) {
+		return st.IncrFixed(ctx, key, ttl)
+	})
+}
+
+func (s *standbyStore) Get(ctx context.Context, key string) (string, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) (string, error) {
+		return st.Get(ctx, key)
+	})
+}
+
+func (s *standbyStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.Set(ctx, key, value, ttl)
+	})
+	return err
+}
+
+func (s *standbyStore) Exists(ctx context.Context, key string) (bool, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) (bool, error) {
+		return st.Exists(ctx, key)
+	})
+}
+
+func (s *standbyStore) Delete(ctx context.Context, key string) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.Delete(ctx, key)
+	})
+	return err
+}
+
+func (s *standbyStore) ReplaceSet(ctx context.Context, key string, members []string) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.ReplaceSet(ctx, key, members)
+	})
+	return err
+}
+
+func (s *standbyStore) IsMember(ctx context.Context, key, member string) (bool, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) (bool, error) {
+		return st.IsMember(ctx, key, member)
+	})
+}
+
+func (s *standbyStore) ScanKeys(ctx context.Context, pattern string) ([]string, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) ([]string, error) {
+		return st.ScanKeys(ctx, pattern)
+	})
+}
+
+func (s *standbyStore) GetWithTTL(ctx context.Context, key string) (string, time.Duration, error) {
+	type result struct {
+		value string
+		ttl   time.Duration
+	}
+	r, err := call(s, ctx, false, func(ctx context.Context, st StateStore) (result, error) {
+		value, ttl, err := st.GetWithTTL(ctx, key)
+		return result{value, ttl}, err
+	})
+	return r.value, r.ttl, err
+}
+
+func (s *standbyStore) SetIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return call(s, ctx, true, func(ctx context.Context, st StateStore) (bool, error) {
+		return st.SetIfAbsent(ctx, key, value, ttl)
+	})
+}
+
+func (s *standbyStore) TouchSeen(ctx context.Context, key string, ts int64, ttl time.Duration) (int64, int64, error) {
+	type result struct{ first, previous int64 }
+	r, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (result, error) {
+		first, previous, err := st.TouchSeen(ctx, key, ts, ttl)
+		return result{first, previous}, err
+	})
+	return r.first, r.previous, err
+}
+
+func (s *standbyStore) ExtendTTL(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.ExtendTTL(ctx, key, ttl)
+	})
+	return err
+}
+
+func (s *standbyStore) AppendList(ctx context.Context, key, value string, maxLen int64, ttl time.Duration) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.AppendList(ctx, key, value, maxLen, ttl)
+	})
+	return err
+}
+
+func (s *standbyStore) ListRange(ctx context.Context, key string) ([]string, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) ([]string, error) {
+		return st.ListRange(ctx, key)
+	})
+}
+
+func (s *standbyStore) RenameIfAbsent(ctx context.Context, from, to string) (bool, error) {
+	return call(s, ctx, true, func(ctx context.Context, st StateStore) (bool, error) {
+		return st.RenameIfAbsent(ctx, from, to)
+	})
+}
+
+func (s *standbyStore) HashSet(ctx context.Context, key, field, value string) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.HashSet(ctx, key, field, value)
+	})
+	return err
+}
+
+func (s *standbyStore) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return call(s, ctx, false, func(ctx context.Context, st StateStore) (map[string]string, error) {
+		return st.HashGetAll(ctx, key)
+	})
+}
+
+func (s *standbyStore) HashDelete(ctx context.Context, key, field string) error {
+	_, err := call(s, ctx, true, func(ctx context.Context, st StateStore) (struct{}, error) {
+		return struct{}{}, st.HashDelete(ctx, key, field)
+	})
+	return err
+}
+
+// Close drains the mirror queue, then closes both stores
+func (s *standbyStore) Close() error {
+	close(s.queue)
+	s.done.Wait()
+	err := s.primary.Close()
+	if serr := s.standby.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// StateStore holds detection state (counters and markers) shared across workers
+// and instances. Redis is the production implementation.
+type StateStore interface {
+	// Incr increments a counter and (re)sets its TTL, returning the new value
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrFixed increments a counter, setting its TTL only when the counter
+	// is created, so it expires ttl after the first increment
+	IncrFixed(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get returns a value, or "" if the key does not exist
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores a value with a TTL
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Exists reports whether a key is present
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes a key (no error if it doesn't exist)
+	Delete(ctx context.Context, key string) error
+	// ReplaceSet atomically replaces a set's members
+	ReplaceSet(ctx context.Context, key string, members []string) error
+	// IsMember reports whether a value is in a set
+	IsMember(ctx context.Context, key, member string) (bool, error)
+	// ScanKeys returns all keys matching a glob pattern
+	ScanKeys(ctx context.Context, pattern string) ([]string, error)
+	// GetWithTTL returns a value and its remaining TTL ("" if missing)
+	GetWithTTL(ctx context.Context, key string) (string, time.Duration, error)
+	// SetIfAbsent stores a value only if the key doesn't exist yet
+	SetIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// TouchSeen records a sighting at ts (unix seconds) and returns the first
+	// sighting and the previous last sighting (0 if none)
+	TouchSeen(ctx context.Context, key string, ts int64, ttl time.Duration) (first, previous int64, err error)
+	// ExtendTTL raises a key's TTL to at least ttl; it never shortens a TTL,
+	// and missing keys or keys without a TTL are left alone
+	ExtendTTL(ctx context.Context, key string, ttl time.Duration) error
+	// AppendList appends to a list, trims it to its newest maxLen entries and resets its TTL
+	AppendList(ctx context.Context, key, value string, maxLen int64, ttl time.Duration) error
+	// ListRange returns a list's entries, oldest first
+	ListRange(ctx context.Context, key string) ([]string, error)
+	// RenameIfAbsent moves a key, with its value and TTL, unless to already
+	// exists. A missing from is not an error; it reports false.
+	RenameIfAbsent(ctx context.Context, from, to string) (bool, error)
+	// HashSet, HashGetAll and HashDelete operate on a hash without a TTL
+	HashSet(ctx context.Context, key, field, value string) error
+	HashGetAll(ctx context.Context, key string) (map[string]string, error)
+	HashDelete(ctx context.Context, key, field string) error
+	Close() error
+}
+
+// redisStore implements StateStore on a Redis client
+type redisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore wraps a Redis client as a StateStore
+func NewRedisStore(client *redis.Client) StateStore {
+	return &redisStore{client: client}
+}
+
+func (s *redisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	// INCR and EXPIRE in one round trip
+	pipe := s.client.Pipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// incrFixedScript sets the TTL on the first increment, and on any counter
+// that somehow lost its TTL, so a fixed window can never become permanent
+var incrFixedScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 or redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (s *redisStore) IncrFixed(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrFixedScript.Run(ctx, s.client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) (string, error) {
+	val, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+func (s *redisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *redisStore) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+func (s *redisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+func (s *redisStore) ReplaceSet(ctx context.Context, key string, members []string) error {
+	if len(members) == 0 {
+		return s.client.Del(ctx, key).Err()
+	}
+
+	// Build under a temp key then RENAME so readers never see a partial set
+	tmp := key + ":loading"
+	values := make([]interface{}, len(members))
+	for i, m := range members {
+		values[i] = m
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, tmp)
+	pipe.SAdd(ctx, tmp, values...)
+	pipe.Rename(ctx, tmp, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) IsMember(ctx context.Context, key, member string) (bool, error) {
+	return s.client.SIsMember(ctx, key, member).Result()
+}
+
+func (s *redisStore) ScanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := s.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (s *redisStore) GetWithTTL(ctx context.Context, key string) (string, time.Duration, error) {
+	pipe := s.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", 0, err
+	}
+	if get.Err() == redis.Nil {
+		return "", 0, nil
+	}
+	return get.Val(), ttl.Val(), nil
+}
+
+func (s *redisStore) SetIfAbsent(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *redisStore) TouchSeen(ctx context.Context, key string, ts int64, ttl time.Duration) (int64, int64, error) {
+	// Read the previous last-seen before overwriting it, all in one round trip
+	pipe := s.client.TxPipeline()
+	prev := pipe.HGet(ctx, key, "last")
+	pipe.HSetNX(ctx, key, "first", ts)
+	first := pipe.HGet(ctx, key, "first")
+	pipe.HSet(ctx, key, "last", ts)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+
+	firstTS, err := first.Int64()
+	if err != nil {
+		return 0, 0, err
+	}
+	prevTS, err := prev.Int64()
+	if err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	return firstTS, prevTS, nil
+}
+
+// extendTTLScript raises a TTL without ever lowering it
+var extendTTLScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl >= 0 and ttl < tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return ttl
+`)
+
+func (s *redisStore) ExtendTTL(ctx context.Context, key string, ttl time.Duration) error {
+	return extendTTLScript.Run(ctx, s.client, []string{key}, ttl.Milliseconds()).Err()
+}
+
+func (s *redisStore) AppendList(ctx context.Context, key, value string, maxLen int64, ttl time.Duration) error {
+	pipe := s.client.Pipeline()
+	pipe.RPush(ctx, key, value)
+	pipe.LTrim(ctx, key, -maxLen, -1)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) ListRange(ctx context.Context, key string) ([]string, error) {
+	return s.client.LRange(ctx, key, 0, -1).Result()
+}
+
+func (s *redisStore) RenameIfAbsent(ctx context.Context, from, to string) (bool, error) {
+	ok, err := s.client.RenameNX(ctx, from, to).Result()
+	if err != nil && strings.Contains(err.Error(), "no such key") {
+		return false, nil
+	}
+	return ok, err
+}
+
+func (s *redisStore) HashSet(ctx context.Context, key, field, value string) error {
+	return s.client.HSet(ctx, key, field, value).Err()
+}
+
+func (s *redisStore) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return s.client.HGetAll(ctx, key).Result()
+}
+
+func (s *redisStore) HashDelete(ctx context.Context, key, field string) error {
+	return s.client.HDel(ctx, key, field).Err()
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
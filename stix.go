@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// stixNamespace is the STIX 2.1 namespace for deterministic SCO identifiers
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixID builds a STIX identifier with a UUIDv5 of name, so the same
+// indicator or observable always gets the same ID
+func stixID(objectType, name string) string {
+	h := sha1.New()
+	h.Write(stixNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50 // version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%s--%x-%x-%x-%x-%x", objectType, u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// STIXTechnique is the MITRE ATT&CK technique a threat type maps to
+type STIXTechnique struct {
+	ID   string `json:"id"` // e.g. T1110 or T1110.001
+	Name string `json:"name"`
+}
+
+// url is the technique's page on attack.mitre.org
+func (t STIXTechnique) url() string {
+	return "https://attack.mitre.org/techniques/" + strings.ReplaceAll(t.ID, ".", "/") + "/"
+}
+
+// stixTechniques maps the built-in threat types to ATT&CK; sinks.stix.techniques
+// adds to or overrides it
+var stixTechniques = map[string]STIXTechnique{
+	"BRUTE_FORCE":               {"T1110", "Brute Force"},
+	"SUSPICIOUS_USER":           {"T1110", "Brute Force"},
+	"TARGETED_ACCOUNT_ATTACK":   {"T1110.001", "Password Guessing"},
+	"GEO_DISTRIBUTED_ATTACK":    {"T1110.004", "Credential Stuffing"},
+	"PRIVILEGE_ESCALATION":      {"T1548.003", "Sudo and Sudo Caching"},
+	"POST_BRUTEFORCE_SUCCESS":   {"T1078", "Valid Accounts"},
+	"ROLE_CONFUSION":            {"T1078", "Valid Accounts"},
+	"AUTH_VOLUME_ANOMALY":       {"T1078", "Valid Accounts"},
+	"GEOFENCE_VIOLATION":        {"T1078", "Valid Accounts"},
+	"STALE_HOST_ACCESS":         {"T1021", "Remote Services"},
+	"SERVICE_ACCOUNT_MISUSE":    {"T1078", "Valid Accounts"},
+	"MFA_FATIGUE":               {"T1621", "Multi-Factor Authentication Request Generation"},
+	"REQUEST_FLOOD":             {"T1499.002", "Service Exhaustion Flood"},
+	"SESSION_HIJACK":            {"T1550.004", "Web Session Cookie"},
+	"PROTOCOL_DOWNGRADE":        {"T1562.010", "Downgrade Attack"},
+	"ANONYMIZER_ACCESS":         {"T1090.003", "Multi-hop Proxy"},
+	"DATA_EXFILTRATION":         {"T1041", "Exfiltration Over C2 Channel"},
+	"PASSWORD_CHANGE_ANOMALY":   {"T1098", "Account Manipulation"},
+	"ACCOUNT_MANIPULATION":      {"T1098", "Account Manipulation"},
+	"RECON_ACTIVITY":            {"T1082", "System Information Discovery"},
+	"SUSPICIOUS_PROCESS":        {"T1059", "Command and Scripting Interpreter"},
+	"KERBEROASTING":             {"T1558.003", "Kerberoasting"},
+	"KERBEROS_TICKET_ANOMALY":   {"T1558.001", "Golden Ticket"},
+	"DCSYNC":                    {"T1003.006", "DCSync"},
+	"ROOT_ACCOUNT_USAGE":        {"T1078.004", "Cloud Accounts"},
+	"CONSOLE_LOGIN_WITHOUT_MFA": {"T1078.004", "Cloud Accounts"},
+	"MFA_DISABLED":              {"T1556.006", "Multi-Factor Authentication"},
+	"IAM_POLICY_CHANGES":        {"T1098.003", "Additional Cloud Roles"},
+	"RANSOMWARE_BEHAVIOR":       {"T1486", "Data Encrypted for Impact"},
+	"CONFIRMED_BREACH":          {"T1078", "Valid Accounts"},
+}
+
+// stixObject is any STIX 2.1 object. Fields not used by a type are omitted.
+type stixObject struct {
+	Type         string `json:"type"`
+	SpecVersion  string `json:"spec_version"`
+	ID           string `json:"id"`
+	Created      string `json:"created,omitempty"`
+	Modified     string `json:"modified,omitempty"`
+	CreatedByRef string `json:"created_by_ref,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+
+	// identity
+	IdentityClass string `json:"identity_class,omitempty"`
+
+	// indicator
+	IndicatorTypes []string `json:"indicator_types,omitempty"`
+	Pattern        string   `json:"pattern,omitempty"`
+	PatternType    string   `json:"pattern_type,omitempty"`
+	ValidFrom      string   `json:"valid_from,omitempty"`
+	ValidUntil     string   `json:"valid_until,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+
+	// attack-pattern
+	ExternalReferences []stixExternalReference `json:"external_references,omitempty"`
+
+	// relationship
+	RelationshipType string `json:"relationship_type,omitempty"`
+	SourceRef        string `json:"source_ref,omitempty"`
+	TargetRef        string `json:"target_ref,omitempty"`
+
+	// observed-data
+	FirstObserved  string   `json:"first_observed,omitempty"`
+	LastObserved   string   `json:"last_observed,omitempty"`
+	NumberObserved int      `json:"number_observed,omitempty"`
+	ObjectRefs     []string `json:"object_refs,omitempty"`
+
+	// ipv4-addr, ipv6-addr
+	Value string `json:"value,omitempty"`
+
+	AlertID  string `json:"x_sbla_alert_id,omitempty"`
+	TenantID string `json:"x_sbla_tenant_id,omitempty"`
+}
+
+type stixExternalReference struct {
+	SourceName string `json:"source_name"`
+	ExternalID string `json:"external_id"`
+	URL        string `json:"url"`
+}
+
+// stixBundle is the STIX envelope published to Kafka
+type stixBundle struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id"`
+	Objects []stixObject `json:"objects"`
+}
+
+// STIXFormatter turns alerts into STIX 2.1 indicators and observed data
+type STIXFormatter struct {
+	cfg      STIXConfig
+	identity stixObject
+}
+
+// NewSTIXFormatter creates a formatter whose objects are attributed to the
+// configured identity
+func NewSTIXFormatter(cfg STIXConfig) *STIXFormatter {
+	return &STIXFormatter{
+		cfg: cfg,
+		identity: stixObject{
+			Type:          "identity",
+			SpecVersion:   "2.1",
+			ID:            stixID("identity", cfg.Identity),
+			Created:       stixEpoch,
+			Modified:      stixEpoch,
+			Name:          cfg.Identity,
+			IdentityClass: "system",
+		},
+	}
+}
+
+// stixEpoch dates the identity, which never changes
+const stixEpoch = "2024-01-01T00:00:00.000Z"
+
+// stixTime formats a timestamp the way STIX requires (UTC, milliseconds)
+func stixTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// technique returns the ATT&CK technique for a threat type, if any
+func (f *STIXFormatter) technique(threatType string) (STIXTechnique, bool) {
+	if t, ok := f.cfg.Techniques[threatType]; ok {
+		return t, true
+	}
+	t, ok := stixTechniques[threatType]
+	return t, ok
+}
+
+// Format returns the STIX objects for an alert: an indicator for the source
+// IP, the IP and its observed activity, and the ATT&CK technique the
+// indicator points to. Alerts without a source IP produce nothing.
+func (f *STIXFormatter) Format(alert ThreatAlert) []stixObject {
+	addr, ok := parseIP(alert.SourceIP)
+	if !ok {
+		return nil
+	}
+	scoType := "ipv4-addr"
+	if addr.Is6() {
+		scoType = "ipv6-addr"
+	}
+	ip := addr.String()
+
+	now := stixTime(alert.Timestamp)
+	first := now
+	if alert.FirstSeen != nil {
+		first = stixTime(*alert.FirstSeen)
+	}
+
+	// The indicator ID is stable per tenant, IP and threat type, so a
+	// platform sees repeated alerts as new versions of one indicator
+	indicator := stixObject{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             stixID("indicator", alert.TenantID+"|"+alert.ThreatType+"|"+ip),
+		Created:        first,
+		Modified:       now,
+		CreatedByRef:   f.identity.ID,
+		Name:           fmt.Sprintf("%s from %s", alert.ThreatType, ip),
+		Description:    alert.Details,
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        fmt.Sprintf("[%s:value = '%s']", scoType, ip),
+		PatternType:    "stix",
+		ValidFrom:      now,
+		ValidUntil:     stixTime(alert.Timestamp.Add(f.cfg.ValidFor.Duration)),
+		Labels:         []string{strings.ToLower(alert.Severity)},
+		TenantID:       alert.TenantID,
+	}
+	sco := stixObject{
+		Type:        scoType,
+		SpecVersion: "2.1",
+		ID:          stixID(scoType, `{"value":"`+ip+`"}`),
+		Value:       ip,
+	}
+	count := alert.EventCount
+	if count < 1 {
+		count = 1
+	}
+	observed := stixObject{
+		Type:           "observed-data",
+		SpecVersion:    "2.1",
+		ID:             stixID("observed-data", alert.TenantID+"|"+alert.AlertID),
+		Created:        now,
+		Modified:       now,
+		CreatedByRef:   f.identity.ID,
+		FirstObserved:  first,
+		LastObserved:   now,
+		NumberObserved: count,
+		ObjectRefs:     []string{sco.ID},
+		AlertID:        alert.AlertID,
+		TenantID:       alert.TenantID,
+	}
+	objects := []stixObject{f.identity, indicator, sco, observed}
+
+	if t, ok := f.technique(alert.ThreatType); ok {
+		pattern := stixObject{
+			Type:         "attack-pattern",
+			SpecVersion:  "2.1",
+			ID:           stixID("attack-pattern", t.ID),
+			Created:      stixEpoch,
+			Modified:     stixEpoch,
+			CreatedByRef: f.identity.ID,
+			Name:         t.Name,
+			ExternalReferences: []stixExternalReference{
+				{SourceName: "mitre-attack", ExternalID: t.ID, URL: t.url()},
+			},
+		}
+		objects = append(objects, pattern, stixObject{
+			Type:             "relationship",
+			SpecVersion:      "2.1",
+			ID:               stixID("relationship", indicator.ID+"|indicates|"+pattern.ID),
+			Created:          first,
+			Modified:         now,
+			CreatedByRef:     f.identity.ID,
+			RelationshipType: "indicates",
+			SourceRef:        indicator.ID,
+			TargetRef:        pattern.ID,
+		})
+	}
+	return objects
+}
+
+// STIXSink publishes alerts as STIX 2.1 to a Kafka topic (as a bundle)
+// and/or a TAXII 2.1 collection
+type STIXSink struct {
+	cfg       STIXConfig
+	formatter *STIXFormatter
+	writer    *kafka.Writer
+	client    *http.Client
+}
+
+// NewSTIXSink creates a STIX sink. The writer is shared with other producers
+// and is closed by the detector, not the sink.
+func NewSTIXSink(cfg STIXConfig, writer *kafka.Writer) *STIXSink {
+	return &STIXSink{
+		cfg:       cfg,
+		formatter: NewSTIXFormatter(cfg),
+		writer:    writer,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *STIXSink) Name() string { return "stix" }
+
+// Send formats the alert and publishes it to every configured destination
+func (s *STIXSink) Send(ctx context.Context, alert ThreatAlert) error {
+	if severityRank(alert.Severity) < severityRank(s.cfg.MinSeverity) {
+		return nil
+	}
+	objects := s.formatter.Format(alert)
+	if objects == nil {
+		return nil
+	}
+
+	if s.cfg.Topic != "" {
+		bundle := stixBundle{Type: "bundle", ID: stixID("bundle", alert.TenantID+"|"+alert.AlertID), Objects: objects}
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			return &PublishError{AlertID: alert.AlertID, Topic: s.cfg.Topic, Err: err}
+		}
+		err = s.writer.WriteMessages(ctx, kafka.Message{Topic: s.cfg.Topic, Key: []byte(alert.SourceIP), Value: data})
+		if err != nil {
+			return &PublishError{AlertID: alert.AlertID, Topic: s.cfg.Topic, Err: err, Retryable: true}
+		}
+	}
+	if s.cfg.TAXIIURL != "" {
+		return s.postTAXII(ctx, alert.AlertID, objects)
+	}
+	return nil
+}
+
+// postTAXII adds the objects to the TAXII collection. 429 and 5xx responses
+// are retryable.
+func (s *STIXSink) postTAXII(ctx context.Context, id string, objects []stixObject) error {
+	body, err := json.Marshal(struct {
+		Objects []stixObject `json:"objects"`
+	}{objects})
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TAXIIURL, bytes.NewReader(body))
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err}
+	}
+	req.Header.Set("Content-Type", "application/taxii+json;version=2.1")
+	req.Header.Set("Accept", "application/taxii+json;version=2.1")
+	if s.cfg.TAXIIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.TAXIIToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &PublishError{AlertID: id, Topic: s.Name(), Err: err, Retryable: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return &PublishError{
+		AlertID:   id,
+		Topic:     s.Name(),
+		Err:       fmt.Errorf("TAXII server returned %s", resp.Status),
+		Retryable: retryable,
+	}
+}
+
+func (s *STIXSink) Close() error { return nil }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Reason codes for alerts that didn't go out. The first group is counted in
+// sbla_alerts_suppressed_total; the rest have their own metrics.
+const (
+	reasonUserAllowlist   = "user_allowlist"
+	reasonRemediated      = "remediated"
+	reasonAutoMuted       = "auto_muted"
+	reasonEnvironment     = "environment"
+	reasonSuppressionRule = "suppression_rule"
+	reasonEvidence        = "insufficient_evidence"
+	reasonObserved        = "observed"
+	reasonCooldown        = "cooldown"
+	reasonFuzzyDuplicate  = "fuzzy_duplicate"
+	reasonCIDRAggregated  = "cidr_aggregated"
+	reasonLifecycleGrace  = "lifecycle_grace"
+
+	reasonPausedHeld    = "paused_held"
+	reasonPausedDropped = "paused_dropped"
+	reasonSinkRouting   = "sink_routing"
+	reasonSinkFiltered  = "sink_filtered"
+	reasonSinkDropped   = "sink_dropped"
+)
+
+// suppressionReasons lists every reason code
+var suppressionReasons = []string{
+	reasonUserAllowlist, reasonRemediated, reasonAutoMuted, reasonEnvironment, reasonSuppressionRule,
+	reasonEvidence, reasonObserved, reasonCooldown, reasonFuzzyDuplicate, reasonCIDRAggregated, reasonLifecycleGrace,
+	reasonPausedHeld, reasonPausedDropped, reasonSinkRouting, reasonSinkFiltered, reasonSinkDropped,
+}
+
+// validate checks the audited reasons are known
+func (c *SuppressionAuditConfig) validate() error {
+	for _, reason := range c.Reasons {
+		if !containsString(suppressionReasons, reason) {
+			return fmt.Errorf("suppression.audit.reasons: unknown reason %q", reason)
+		}
+	}
+	return nil
+}
+
+// suppressionAuditEntry is one audit log line: why an alert didn't go out
+type suppressionAuditEntry struct {
+	Time       time.Time `json:"time"`
+	Reason     string    `json:"reason"`
+	Rule       string    `json:"rule,omitempty"` // suppression rule ID, environment or sink
+	Key        string    `json:"key,omitempty"`  // state key behind the decision
+	AlertID    string    `json:"alert_id"`
+	ThreatType string    `json:"threat_type"`
+	Severity   string    `json:"severity"`
+	SourceIP   string    `json:"source_ip,omitempty"`
+	User       string    `json:"user,omitempty"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+}
+
+// suppressAlert counts an alert dropped before publishing and audits why
+func (td *ThreatDetector) suppressAlert(alert ThreatAlert, reason, rule, key string) {
+	alertsSuppressed.WithLabelValues(alert.ThreatType, reason).Inc()
+	td.auditSuppression(alert, reason, rule, key)
+}
+
+// auditSuppression writes the audit entry for an alert that didn't reach
+// some or all of its destinations, when audit is enabled for the reason
+func (td *ThreatDetector) auditSuppression(alert ThreatAlert, reason, rule, key string) {
+	cfg := td.cfg().Suppression.Audit
+	if !cfg.Enabled || len(cfg.Reasons) > 0 && !containsString(cfg.Reasons, reason) {
+		return
+	}
+	entry, err := json.Marshal(suppressionAuditEntry{
+		Time:       td.now(),
+		Reason:     reason,
+		Rule:       rule,
+		Key:        key,
+		AlertID:    alert.AlertID,
+		ThreatType: alert.ThreatType,
+		Severity:   alert.Severity,
+		SourceIP:   alert.SourceIP,
+		User:       alert.User,
+		TenantID:   alert.TenantID,
+	})
+	if err != nil {
+		return
+	}
+	log.Printf("AUDIT suppressed %s", entry)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// suppressionKeyPrefix keys the rules added over the API, one per rule. The
+// key's TTL is the rule's expiry, so Redis removes expired rules itself.
+const suppressionKeyPrefix = "suppression:"
+
+// suppressionIDPattern keeps rule IDs usable in keys, URLs and metric labels
+var suppressionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// validate checks every configured suppression rule
+func (c *SuppressionConfig) validate() error {
+	ids := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("suppression.rules[%d]: %w", i, err)
+		}
+		if ids[r.ID] {
+			return fmt.Errorf("suppression.rules[%d]: duplicate id %q", i, r.ID)
+		}
+		ids[r.ID] = true
+	}
+	return c.Audit.validate()
+}
+
+// validate checks the suppression API settings
+func (c *SuppressionAPIConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Token == "" {
+		return fmt.Errorf("suppression_api.token is required when enabled")
+	}
+	if c.RefreshInterval.Duration < time.Second {
+		return fmt.Errorf("suppression_api.refresh_interval must be at least 1s")
+	}
+	return nil
+}
+
+// validate checks a rule, lowercases its user pattern and parses its source IP
+func (r *SuppressionRule) validate() error {
+	if !suppressionIDPattern.MatchString(r.ID) {
+		return fmt.Errorf("id %q must be 1-64 letters, digits, '_', '.' or '-'", r.ID)
+	}
+	if r.ThreatType == "" && r.SourceIP == "" && r.User == "" && len(r.Metadata) == 0 {
+		return fmt.Errorf("rule %s: needs at least one of threat_type, source_ip, user or metadata", r.ID)
+	}
+	r.User = strings.ToLower(r.User)
+	patterns := []string{r.ThreatType, r.User}
+	for _, p := range r.Metadata {
+		patterns = append(patterns, p)
+	}
+	if err := validatePatterns(patterns); err != nil {
+		return fmt.Errorf("rule %s: %w", r.ID, err)
+	}
+	r.network = netip.Prefix{}
+	if r.SourceIP != "" {
+		if prefix, err := netip.ParsePrefix(r.SourceIP); err == nil {
+			r.network = prefix.Masked()
+		} else if addr, ok := parseIP(r.SourceIP); ok {
+			r.network = netip.PrefixFrom(addr, addr.BitLen())
+		} else {
+			return fmt.Errorf("rule %s: source_ip %q is not an address or CIDR", r.ID, r.SourceIP)
+		}
+	}
+	return nil
+}
+
+// active reports whether the rule hasn't expired
+func (r *SuppressionRule) active(now time.Time) bool {
+	return r.Expires == nil || now.Before(*r.Expires)
+}
+
+// matches reports whether every field the rule sets matches the alert.
+// Metadata is looked up on the alert first, then on the event.
+func (r *SuppressionRule) matches(event SecurityEvent, alert ThreatAlert) bool {
+	if r.ThreatType != "" && !matchAny([]string{r.ThreatType}, alert.ThreatType) {
+		return false
+	}
+	if r.SourceIP != "" && !(event.addr.IsValid() && r.network.Contains(event.addr)) {
+		return false
+	}
+	if r.User != "" && !matchAny([]string{r.User}, strings.ToLower(event.User)) {
+		return false
+	}
+	for key, pattern := range r.Metadata {
+		value, ok := alert.Metadata[key]
+		if !ok {
+			value = event.Metadata[key]
+		}
+		if !matchAny([]string{pattern}, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// suppressedBy returns the first live suppression rule, from the config or
+// added over the API, that matches the alert
+func (td *ThreatDetector) suppressedBy(event SecurityEvent, alert ThreatAlert) *SuppressionRule {
+	now := td.now()
+	rules := td.cfg().Suppression.Rules
+	if added := td.suppressions.Load(); added != nil {
+		rules = append(rules[:len(rules):len(rules)], *added...)
+	}
+	for i := range rules {
+		if r := &rules[i]; r.active(now) && r.matches(event, alert) {
+			return r
+		}
+	}
+	return nil
+}
+
+// refreshSuppressions reloads the rules added over the API from Redis
+func (td *ThreatDetector) refreshSuppressions(ctx context.Context) error {
+	keys, err := td.state.ScanKeys(ctx, suppressionKeyPrefix+"*")
+	if err != nil {
+		return &StateError{Op: "scan", Key: suppressionKeyPrefix + "*", Err: err}
+	}
+	rules := make([]SuppressionRule, 0, len(keys))
+	for _, key := range keys {
+		value, err := td.state.Get(ctx, key)
+		if err != nil {
+			return &StateError{Op: "get", Key: key, Err: err}
+		}
+		if value == "" {
+			continue // expired since the scan
+		}
+		var r SuppressionRule
+		if err := json.Unmarshal([]byte(value), &r); err != nil {
+			log.Printf("Skipping unreadable suppression rule %s: %v", key, err)
+			continue
+		}
+		if err := r.validate(); err != nil {
+			log.Printf("Skipping invalid suppression rule %s: %v", key, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	td.suppressions.Store(&rules)
+	return nil
+}
+
+// runSuppressionRefresh picks up rules added or deleted on other replicas
+func (td *ThreatDetector) runSuppressionRefresh() {
+	defer td.wg.Done()
+
+	ticker := time.NewTicker(td.cfg().SuppressionAPI.RefreshInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+			if err := td.refreshSuppressions(td.ctx); err != nil {
+				log.Printf("Suppression rule refresh failed, keeping previous rules: %v", err)
+			}
+		}
+	}
+}
+
+// suppressionRequest is the body of POST /suppressions. TTL is an
+// alternative to an absolute expiry.
+type suppressionRequest struct {
+	SuppressionRule
+	TTL Duration `json:"ttl"`
+}
+
+// suppressionList is the body of GET /suppressions: live rules by origin
+type suppressionList struct {
+	Config []SuppressionRule `json:"config"`
+	API    []SuppressionRule `json:"api"`
+}
+
+// handleSuppressions serves GET and POST /suppressions and
+// DELETE /suppressions/<id>
+func (td *ThreatDetector) handleSuppressions(w http.ResponseWriter, r *http.Request) {
+	if !bearerTokenOK(r, td.cfg().SuppressionAPI.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+
+	switch id := strings.TrimPrefix(r.URL.Path, "/suppressions/"); {
+	case r.Method == http.MethodGet && r.URL.Path == "/suppressions":
+		if err := td.refreshSuppressions(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		now := td.now()
+		list := suppressionList{Config: []SuppressionRule{}, API: []SuppressionRule{}}
+		for _, rule := range td.cfg().Suppression.Rules {
+			if rule.active(now) {
+				list.Config = append(list.Config, rule)
+			}
+		}
+		for _, rule := range *td.suppressions.Load() {
+			if rule.active(now) {
+				list.API = append(list.API, rule)
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	case r.Method == http.MethodPost && r.URL.Path == "/suppressions":
+		var req suppressionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule, status, err := td.addSuppression(ctx, req)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf("Suppression rule %s added from %s, reason %q", rule.ID, r.RemoteAddr, rule.Reason)
+		writeJSON(w, http.StatusCreated, rule)
+	case r.Method == http.MethodDelete && id != r.URL.Path && id != "":
+		key := suppressionKeyPrefix + id
+		exists, err := td.state.Exists(ctx, key)
+		if err != nil {
+			http.Error(w, (&StateError{Op: "exists", Key: key, Err: err}).Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, fmt.Sprintf("no suppression rule %q was added over the API", id), http.StatusNotFound)
+			return
+		}
+		if err := td.state.Delete(ctx, key); err != nil {
+			http.Error(w, (&StateError{Op: "del", Key: key, Err: err}).Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := td.refreshSuppressions(ctx); err != nil {
+			log.Printf("Suppression rule refresh failed: %v", err)
+		}
+		log.Printf("Suppression rule %s deleted from %s", id, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// addSuppression validates and stores a rule from the API, returning the
+// HTTP status to use on error
+func (td *ThreatDetector) addSuppression(ctx context.Context, req suppressionRequest) (SuppressionRule, int, error) {
+	rule := req.SuppressionRule
+	now := td.now().UTC()
+	if rule.ID == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return rule, http.StatusInternalServerError, err
+		}
+		rule.ID = "s-" + hex.EncodeToString(b)
+	}
+	if req.TTL.Duration > 0 {
+		if rule.Expires != nil {
+			return rule, http.StatusBadRequest, fmt.Errorf("set expires or ttl, not both")
+		}
+		expires := now.Add(req.TTL.Duration)
+		rule.Expires = &expires
+	}
+	if err := rule.validate(); err != nil {
+		return rule, http.StatusBadRequest, err
+	}
+	var ttl time.Duration
+	if rule.Expires != nil {
+		if ttl = rule.Expires.Sub(now); ttl <= 0 {
+			return rule, http.StatusBadRequest, fmt.Errorf("expires is in the past")
+		}
+	}
+	for _, r := range td.cfg().Suppression.Rules {
+		if r.ID == rule.ID {
+			return rule, http.StatusConflict, fmt.Errorf("suppression rule %q is in the config", rule.ID)
+		}
+	}
+	rule.Created = &now
+
+	value, err := json.Marshal(rule)
+	if err != nil {
+		return rule, http.StatusInternalServerError, err
+	}
+	key := suppressionKeyPrefix + rule.ID
+	added, err := td.state.SetIfAbsent(ctx, key, string(value), ttl)
+	if err != nil {
+		return rule, http.StatusInternalServerError, &StateError{Op: "setnx", Key: key, Err: err}
+	}
+	if !added {
+		return rule, http.StatusConflict, fmt.Errorf("suppression rule %q already exists", rule.ID)
+	}
+	if err := td.refreshSuppressions(ctx); err != nil {
+		log.Printf("Suppression rule refresh failed: %v", err)
+	}
+	return rule, 0, nil
+}
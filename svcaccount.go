@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// validate checks the service account settings and lowercases the account
+// patterns and logon types
+func (c *ServiceAccountConfig) validate(userGroups map[string][]string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Accounts) == 0 && len(c.UserGroups) == 0 {
+		return fmt.Errorf("service_accounts.accounts or user_groups is required when enabled")
+	}
+	for i, a := range c.Accounts {
+		c.Accounts[i] = strings.ToLower(a)
+	}
+	if err := validatePatterns(c.Accounts); err != nil {
+		return fmt.Errorf("service_accounts.accounts: %w", err)
+	}
+	for _, group := range c.UserGroups {
+		if _, ok := userGroups[group]; !ok {
+			return fmt.Errorf("service_accounts.user_groups: unknown user group %q", group)
+		}
+	}
+	if c.LogonTypeField == "" {
+		return fmt.Errorf("service_accounts.logon_type_field is required when enabled")
+	}
+	if len(c.Interactive) == 0 {
+		return fmt.Errorf("service_accounts.interactive is required when enabled")
+	}
+	for i, t := range c.Interactive {
+		c.Interactive[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("service_accounts.window must be positive")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("service_accounts.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// classify returns what makes the user a service account: "accounts" or
+// the user group it is in, or "" for any other user
+func (c *ServiceAccountConfig) classify(userGroups map[string][]string, user string) string {
+	if matchAny(c.Accounts, strings.ToLower(user)) {
+		return "accounts"
+	}
+	for _, group := range c.UserGroups {
+		if matchAny(userGroups[group], user) {
+			return group
+		}
+	}
+	return ""
+}
+
+// serviceAccountHit is an interactive login by a service account
+type serviceAccountHit struct {
+	logonType string
+	matchedBy string
+}
+
+// isServiceAccountMisuse checks a successful login by a service account
+// against the interactive logon types. Events without a logon type are
+// skipped, since most sources can't tell. Each account and source IP
+// alerts once per window.
+func (td *ThreatDetector) isServiceAccountMisuse(ctx context.Context, event SecurityEvent) (*serviceAccountHit, error) {
+	c := td.cfg()
+	cfg := c.ServiceAccounts
+	if !cfg.Enabled || event.User == "" || event.eventTypeLower != "authentication" || event.Result != "success" {
+		return nil, nil
+	}
+	logonType := strings.ToLower(strings.TrimSpace(event.Metadata[cfg.LogonTypeField]))
+	if logonType == "" || !containsString(cfg.Interactive, logonType) {
+		return nil, nil
+	}
+	matchedBy := cfg.classify(c.UserGroups, event.User)
+	if matchedBy == "" {
+		return nil, nil
+	}
+
+	key := stateKey(event, "svc_interactive", strings.ToLower(event.User)+":"+event.ipKey())
+	seen, err := td.dedup.SeenRecently(ctx, key, cfg.Window.Duration)
+	if err != nil {
+		return nil, &StateError{Op: "dedup", Key: key, Err: err}
+	}
+	if seen {
+		return nil, nil
+	}
+	return &serviceAccountHit{logonType: logonType, matchedBy: matchedBy}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// validate checks the targeted-account settings
+func (c *TargetedAccountConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Users) == 0 {
+		return fmt.Errorf("targeted_account.users is required when enabled")
+	}
+	for i, u := range c.Users {
+		c.Users[i] = strings.ToLower(u)
+	}
+	if err := validatePatterns(c.Users); err != nil {
+		return fmt.Errorf("targeted_account.users: %w", err)
+	}
+	if c.Threshold < 1 {
+		return fmt.Errorf("targeted_account.threshold must be at least 1")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("targeted_account.window must be positive")
+	}
+	if c.MinSourceIPs < 1 || c.MinSourceIPs > c.MaxSourceIPs {
+		return fmt.Errorf("targeted_account.min_source_ips must be between 1 and max_source_ips")
+	}
+	if severityRank(c.Severity) < 0 {
+		return fmt.Errorf("targeted_account.severity %q is not a severity", c.Severity)
+	}
+	return nil
+}
+
+// isTargetedAccountAttack counts failed logins against a watched username
+// from every source IP. It returns the failure count and the distinct
+// source IPs once both thresholds are reached.
+func (td *ThreatDetector) isTargetedAccountAttack(ctx context.Context, event SecurityEvent) (bool, int64, []string, error) {
+	cfg := td.cfg().TargetedAccount
+	if !cfg.Enabled || event.eventTypeLower != "authentication" || event.Result != "failed" || event.SourceIP == "" {
+		return false, 0, nil, nil
+	}
+	user := strings.ToLower(event.User)
+	if user == "" || !matchAny(cfg.Users, user) {
+		return false, 0, nil, nil
+	}
+
+	key := stateKey(event, "targeted_account", user)
+	count, err := td.countInWindow(ctx, event, "TARGETED_ACCOUNT_ATTACK", key, cfg.Window.Duration)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if err := td.recordRawEvent(ctx, event, key, cfg.Window.Duration); err != nil {
+		return false, 0, nil, err
+	}
+
+	// Each source IP is listed once, however often it fails
+	ipsKey := stateKey(event, "targeted_account_ips", user)
+	seenKey := stateKey(event, "targeted_account_ip", user+":"+event.ipKey())
+	first, err := td.state.SetIfAbsent(ctx, seenKey, "1", cfg.Window.Duration)
+	if err != nil {
+		return false, 0, nil, &StateError{Op: "setnx", Key: seenKey, Err: err}
+	}
+	if first {
+		if err := td.state.AppendList(ctx, ipsKey, event.SourceIP, int64(cfg.MaxSourceIPs), cfg.Window.Duration); err != nil {
+			return false, 0, nil, &StateError{Op: "rpush", Key: ipsKey, Err: err}
+		}
+	}
+
+	if count < int64(cfg.Threshold) {
+		return false, count, nil, nil
+	}
+	ips, err := td.state.ListRange(ctx, ipsKey)
+	if err != nil {
+		return false, 0, nil, &StateError{Op: "lrange", Key: ipsKey, Err: err}
+	}
+	return len(ips) >= cfg.MinSourceIPs, count, ips, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// AlertTemplateData is the data available to alert Details templates, e.g.
+//
+//	"{{.Count}} failed logins for {{.Event.User}} from {{.Alert.SourceIP}} ({{index .Metadata \"host\"}})"
+type AlertTemplateData struct {
+	Event    SecurityEvent
+	Alert    ThreatAlert
+	Count    int               // events that contributed to the alert, if known
+	Metadata map[string]string // the triggering event's metadata
+	Geo      AlertTemplateGeo  // where the source IP is, if enrichment says
+}
+
+// AlertTemplateGeo is the source IP's location, read from the event's
+// metadata. Fields enrichment didn't fill are empty.
+type AlertTemplateGeo struct {
+	Country string // from the geofence (or geo_distributed) country_field
+	City    string // metadata "city"
+	ASN     string // metadata "asn"
+}
+
+// templateGeo reads the location fields for an alert template. An alert
+// that recorded its own country (geofence) is used when the event has none.
+func (c *DetectorConfig) templateGeo(event SecurityEvent, alert ThreatAlert) AlertTemplateGeo {
+	countryField := "country"
+	if c.Geofence.CountryField != "" {
+		countryField = c.Geofence.CountryField
+	} else if c.GeoDistributed.CountryField != "" {
+		countryField = c.GeoDistributed.CountryField
+	}
+	geo := AlertTemplateGeo{
+		Country: event.Metadata[countryField],
+		City:    event.Metadata["city"],
+		ASN:     event.Metadata["asn"],
+	}
+	if geo.Country == "" {
+		geo.Country = alert.Metadata["country"]
+	}
+	return geo
+}
+
+// compileAlertTemplates parses the configured per-threat-type templates and
+// test-renders each one so bad field references fail at startup, not on the first alert
+func compileAlertTemplates(sources map[string]string) (map[string]*template.Template, error) {
+	compiled := make(map[string]*template.Template, len(sources))
+
+	sample := AlertTemplateData{
+		Event:    SecurityEvent{Metadata: map[string]string{}},
+		Metadata: map[string]string{},
+	}
+
+	for threatType, src := range sources {
+		tmpl, err := template.New(threatType).Option("missingkey=zero").Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("alert_templates[%s]: %w", threatType, err)
+		}
+		if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+			return nil, fmt.Errorf("alert_templates[%s]: %w", threatType, err)
+		}
+		compiled[threatType] = tmpl
+	}
+	return compiled, nil
+}
+
+// renderDetails returns the templated Details for an alert, or "" if no
+// template is configured for its threat type or rendering fails
+func (c *DetectorConfig) renderDetails(event SecurityEvent, alert ThreatAlert) (string, error) {
+	tmpl, ok := c.alertTemplates[alert.ThreatType]
+	if !ok {
+		return "", nil
+	}
+
+	var b strings.Builder
+	err := tmpl.Execute(&b, AlertTemplateData{
+		Event:    event,
+		Alert:    alert,
+		Count:    alert.EventCount,
+		Metadata: event.Metadata,
+		Geo:      c.templateGeo(event, alert),
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
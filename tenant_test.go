@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// tenantConfig has two tenants sharing one source IP: acme with a lower
+// brute-force threshold and its own alerts topic, globex allowlisting it
+func tenantConfig(t *testing.T) *DetectorConfig {
+	t.Helper()
+	cfg := DefaultConfig()
+	err := json.Unmarshal([]byte(`{"tenants": {
+		"acme":   {"thresholds": {"brute_force": 3}, "alerts_topic": "acme-alerts"},
+		"globex": {"allowlist_ips": ["203.0.113.0/24"]}
+	}}`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	return cfg
+}
+
+// tenantEvent is a failed login from the shared IP for a tenant
+func tenantEvent(tenant string) SecurityEvent {
+	event := SecurityEvent{EventType: "authentication", Result: "failed", SourceIP: "203.0.113.7", User: "alice"}
+	if tenant != "" {
+		event.Metadata = map[string]string{"tenant_id": tenant}
+	}
+	return event
+}
+
+func TestTenantsKeepSeparateCounters(t *testing.T) {
+	keys := map[string]string{
+		"":       "failed_auth:203.0.113.7",
+		"acme":   "tenant:acme:failed_auth:203.0.113.7",
+		"globex": "tenant:globex:failed_auth:203.0.113.7",
+	}
+	for tenant, want := range keys {
+		event := tenantEvent(tenant)
+		if got := stateKey(event, "failed_auth", event.SourceIP); got != want {
+			t.Errorf("tenant %q: key %s, want %s", tenant, got, want)
+		}
+	}
+}
+
+func TestTenantOverrides(t *testing.T) {
+	cfg := tenantConfig(t)
+
+	if got := cfg.ThresholdsFor("acme").BruteForce; got != 3 {
+		t.Errorf("acme brute_force = %d, want its override 3", got)
+	}
+	for _, tenant := range []string{"", "globex", "unknown"} {
+		if got := cfg.ThresholdsFor(tenant); got != cfg.Thresholds {
+			t.Errorf("tenant %q thresholds = %+v, want the defaults", tenant, got)
+		}
+	}
+
+	addr, _ := parseIP("203.0.113.7")
+	if !cfg.IsAllowlisted("globex", addr) {
+		t.Error("globex's allowlist doesn't cover 203.0.113.7")
+	}
+	for _, tenant := range []string{"", "acme"} {
+		if cfg.IsAllowlisted(tenant, addr) {
+			t.Errorf("globex's allowlist applies to tenant %q", tenant)
+		}
+	}
+
+	if got := cfg.AlertsTopicFor("acme"); got != "acme-alerts" {
+		t.Errorf("acme alerts topic = %s", got)
+	}
+	if got := cfg.AlertsTopicFor("globex"); got != cfg.AlertsTopic {
+		t.Errorf("globex alerts topic = %s, want the default %s", got, cfg.AlertsTopic)
+	}
+}
+
+func TestAlertsCarryTheirTenant(t *testing.T) {
+	td := newTestDetector(t, tenantConfig(t))
+	ctx := context.Background()
+	for _, tenant := range []string{"acme", ""} {
+		td.raiseAlert(ctx, tenantEvent(tenant), ThreatAlert{ThreatType: "BRUTE_FORCE"})
+		if alert := <-td.alertChan; alert.TenantID != tenant {
+			t.Errorf("alert tenant %q, want %q", alert.TenantID, tenant)
+		}
+	}
+
+	// globex allowlists the IP, so its events never reach the detectors
+	td.detectThreats(ctx, tenantEvent("globex"))
+	if len(td.alertChan) != 0 {
+		t.Error("an allowlisted tenant's event raised an alert")
+	}
+}
+
+func TestCrossTenantBruteForce(t *testing.T) {
+	td := newTestDetector(t, tenantConfig(t))
+	ctx := context.Background()
+
+	// acme's threshold is 3 and initech has the default 5. If the tenants
+	// shared a counter, initech's failures would push acme over early.
+	for i := 0; i < 2; i++ {
+		td.detectThreats(ctx, tenantEvent("acme"))
+		td.detectThreats(ctx, tenantEvent("initech"))
+	}
+	if alerts := drainAlerts(td); len(alerts) != 0 {
+		t.Fatalf("4 failures across two tenants raised %+v", alerts)
+	}
+
+	td.detectThreats(ctx, tenantEvent("acme"))
+	alerts := drainAlerts(td)
+	if len(alerts) != 1 || alerts[0].TenantID != "acme" || alerts[0].ThreatType != "BRUTE_FORCE" {
+		t.Fatalf("acme's 3rd failure raised %+v, want one acme BRUTE_FORCE", alerts)
+	}
+
+	for i := 0; i < 2; i++ {
+		td.detectThreats(ctx, tenantEvent("initech"))
+	}
+	if alerts := drainAlerts(td); len(alerts) != 0 {
+		t.Fatalf("initech's 4th failure raised %+v", alerts)
+	}
+	td.detectThreats(ctx, tenantEvent("initech"))
+	alerts = drainAlerts(td)
+	if len(alerts) != 1 || alerts[0].TenantID != "initech" {
+		t.Fatalf("initech's 5th failure raised %+v, want one initech alert", alerts)
+	}
+}
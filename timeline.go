@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimelineEntry is one step of the attack as told on an alert
+type TimelineEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Description string    `json:"description"`
+}
+
+// describeEvent renders an event as a one-line timeline description,
+// e.g. "authentication login failed, user root from 203.0.113.7"
+func describeEvent(event SecurityEvent) string {
+	var what []string
+	for _, part := range []string{event.EventType, event.Action, event.Result} {
+		if part != "" {
+			what = append(what, part)
+		}
+	}
+	desc := strings.Join(what, " ")
+	if event.User != "" {
+		desc += ", user " + event.User
+	}
+	if event.SourceIP != "" {
+		desc += " from " + event.SourceIP
+	}
+	return desc
+}
+
+// validate checks the timeline settings
+func (c *TimelineConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Length < 1 {
+		return fmt.Errorf("timeline.length must be at least 1")
+	}
+	if c.TTL.Duration <= 0 {
+		return fmt.Errorf("timeline.ttl must be positive")
+	}
+	for severity, n := range c.Entries {
+		if severityRank(severity) < 0 {
+			return fmt.Errorf("timeline.entries: %q is not a severity", severity)
+		}
+		if n < 0 || n > c.Length {
+			return fmt.Errorf("timeline.entries.%s must be between 0 and timeline.length", severity)
+		}
+	}
+	return nil
+}
+
+// timelineKeys returns the event's per-IP and per-user timeline lists
+func timelineKeys(event SecurityEvent) []string {
+	var keys []string
+	if event.SourceIP != "" {
+		keys = append(keys, stateKey(event, "timeline_ip", event.ipKey()))
+	}
+	if event.User != "" {
+		keys = append(keys, stateKey(event, "timeline_user", strings.ToLower(event.User)))
+	}
+	return keys
+}
+
+// recordTimeline appends the event to its source IP's and user's timelines
+func (td *ThreatDetector) recordTimeline(ctx context.Context, event SecurityEvent) error {
+	cfg := td.cfg().Timeline
+	entry, err := json.Marshal(TimelineEntry{Timestamp: event.Timestamp, Description: describeEvent(event)})
+	if err != nil {
+		return err
+	}
+	for _, key := range timelineKeys(event) {
+		if err := td.state.AppendList(ctx, key, string(entry), int64(cfg.Length), cfg.TTL.Duration); err != nil {
+			return &StateError{Op: "rpush", Key: key, Err: err}
+		}
+	}
+	return nil
+}
+
+// timeline merges the IP's and user's recent events into one ordered story
+// of at most n entries, newest kept. An event in both lists appears once.
+func (td *ThreatDetector) timeline(ctx context.Context, event SecurityEvent, n int) ([]TimelineEntry, error) {
+	seen := make(map[string]bool)
+	var entries []TimelineEntry
+	for _, key := range timelineKeys(event) {
+		raw, err := td.state.ListRange(ctx, key)
+		if err != nil {
+			return nil, &StateError{Op: "lrange", Key: key, Err: err}
+		}
+		for _, r := range raw {
+			var e TimelineEntry
+			if seen[r] || json.Unmarshal([]byte(r), &e) != nil {
+				continue
+			}
+			seen[r] = true
+			entries = append(entries, e)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
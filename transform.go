@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transformSinks are the sinks whose alert JSON can be reshaped. STIX
+// sends bundles of its own format.
+var transformSinks = []string{"kafka", "pagerduty", "sqs", "sns"}
+
+// AlertTransform reshapes an alert's JSON for one sink. Paths are dotted
+// (e.g. "metadata.cves"); a dotted output path nests the value.
+type AlertTransform struct {
+	Rename map[string]string      `json:"rename"` // alert path -> output path
+	Drop   []string               `json:"drop"`   // alert paths left out
+	Add    map[string]interface{} `json:"add"`    // static values set at output paths
+
+	renames []transformRename // Rename in a fixed order
+}
+
+// transformRename is one compiled Rename entry
+type transformRename struct {
+	from, to []string
+}
+
+// alertFields are the top-level JSON names of ThreatAlert
+var alertFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(ThreatAlert{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// splitPath splits a dotted path, rejecting empty segments
+func splitPath(p string) ([]string, error) {
+	parts := strings.Split(p, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("bad path %q", p)
+		}
+	}
+	return parts, nil
+}
+
+// alertPath splits a path into the native alert, which must start with
+// one of its fields
+func alertPath(p string) ([]string, error) {
+	parts, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if !alertFields[parts[0]] {
+		return nil, fmt.Errorf("%q is not an alert field", parts[0])
+	}
+	return parts, nil
+}
+
+// pathsOverlap reports whether one path is the other or contains it
+func pathsOverlap(a, b []string) bool {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compile checks the spec: paths are well formed, alert paths name alert
+// fields, and no two outputs land on the same path or replace a field that
+// stays on the alert
+func (t *AlertTransform) compile() error {
+	t.renames = nil
+	from := mapKeys(t.Rename)
+	sort.Strings(from)
+
+	// A field renamed or dropped as a whole frees its name for an output
+	moved := make(map[string]bool)
+	for _, p := range append(from, t.Drop...) {
+		moved[p] = true
+	}
+
+	var outputs [][]string
+	var outputNames []string
+	claim := func(name string, path []string) error {
+		if len(path) == 1 && alertFields[path[0]] && !moved[path[0]] {
+			return fmt.Errorf("%q would replace the alert's own %q field", name, path[0])
+		}
+		for i, other := range outputs {
+			if pathsOverlap(path, other) {
+				return fmt.Errorf("%q and %q write overlapping paths", outputNames[i], name)
+			}
+		}
+		outputs = append(outputs, path)
+		outputNames = append(outputNames, name)
+		return nil
+	}
+
+	for _, src := range from {
+		r := transformRename{}
+		var err error
+		if r.from, err = alertPath(src); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		if r.to, err = splitPath(t.Rename[src]); err != nil {
+			return fmt.Errorf("rename %q: %w", src, err)
+		}
+		if err := claim(t.Rename[src], r.to); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		t.renames = append(t.renames, r)
+	}
+	for _, p := range t.Drop {
+		path, err := alertPath(p)
+		if err != nil {
+			return fmt.Errorf("drop: %w", err)
+		}
+		for i, r := range t.renames {
+			if pathsOverlap(path, r.to) {
+				return fmt.Errorf("drop: %q would remove the output of rename %q", p, from[i])
+			}
+		}
+	}
+	added := mapKeys(t.Add)
+	sort.Strings(added)
+	for _, p := range added {
+		path, err := splitPath(p)
+		if err != nil {
+			return fmt.Errorf("add: %w", err)
+		}
+		if err := claim(p, path); err != nil {
+			return fmt.Errorf("add: %w", err)
+		}
+	}
+
+	// An output nested under a field that isn't an object only fails when
+	// applied, so try the spec on a fully populated alert
+	if _, err := t.apply(transformSample); err != nil {
+		return err
+	}
+	return nil
+}
+
+// transformSample has every commonly set alert field filled in
+var transformSample = ThreatAlert{
+	AlertID: "BF-0", Timestamp: time.Unix(0, 0), Severity: "HIGH", ThreatType: "BRUTE_FORCE",
+	SourceIP: "192.0.2.1", Details: "sample", EventCount: 1, RawEvents: []string{"sample"},
+	TenantID: "sample", User: "sample", SourceZone: zoneExternal, Environment: "prod", Metadata: map[string]string{"sample": "sample"},
+	FirstSeen: &time.Time{}, LastSeen: &time.Time{},
+}
+
+// apply returns the alert as a JSON object with the transform applied:
+// renames first, then drops, then added fields
+func (t *AlertTransform) apply(alert ThreatAlert) (map[string]interface{}, error) {
+	native, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+	// Numbers stay json.Number so they are written back unchanged
+	dec := json.NewDecoder(bytes.NewReader(native))
+	dec.UseNumber()
+	var out map[string]interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+
+	// Take every renamed value out before placing any, so a rename can
+	// reuse a name another rename moves away
+	values := make([]interface{}, len(t.renames))
+	found := make([]bool, len(t.renames))
+	for i, r := range t.renames {
+		values[i], found[i] = takePath(out, r.from)
+	}
+	for i, r := range t.renames {
+		if !found[i] {
+			continue
+		}
+		if err := setPath(out, r.to, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range t.Drop {
+		takePath(out, strings.Split(p, "."))
+	}
+	for p, v := range t.Add {
+		if err := setPath(out, strings.Split(p, "."), v); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// encode serializes an alert for a sink. A nil transform leaves the native
+// ThreatAlert JSON.
+func (t *AlertTransform) encode(alert ThreatAlert) ([]byte, error) {
+	if t == nil {
+		return json.Marshal(alert)
+	}
+	out, err := t.apply(alert)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// takePath removes and returns the value at path
+func takePath(obj map[string]interface{}, path []string) (interface{}, bool) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	last := path[len(path)-1]
+	v, ok := obj[last]
+	delete(obj, last)
+	return v, ok
+}
+
+// setPath sets the value at path, creating objects along the way
+func setPath(obj map[string]interface{}, path []string, value interface{}) error {
+	for i, key := range path[:len(path)-1] {
+		switch next := obj[key].(type) {
+		case map[string]interface{}:
+			obj = next
+		case nil:
+			m := make(map[string]interface{})
+			obj[key] = m
+			obj = m
+		default:
+			return fmt.Errorf("transform: %s is not an object", strings.Join(path[:i+1], "."))
+		}
+	}
+	obj[path[len(path)-1]] = value
+	return nil
+}
+
+// validateTransforms compiles sinks.transforms
+func (c *SinksConfig) validateTransforms() error {
+	names := mapKeys(c.Transforms)
+	sort.Strings(names)
+	for _, name := range names {
+		if !containsString(transformSinks, name) {
+			return fmt.Errorf("sinks.transforms: unknown sink %q (want one of %s)", name, strings.Join(transformSinks, ", "))
+		}
+		if c.Transforms[name] == nil {
+			continue
+		}
+		if err := c.Transforms[name].compile(); err != nil {
+			return fmt.Errorf("sinks.transforms.%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transformAlert is the alert the round-trip tests reshape
+var transformAlert = ThreatAlert{
+	AlertID:    "BF-1704067200",
+	Timestamp:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	Severity:   "HIGH",
+	ThreatType: "BRUTE_FORCE",
+	SourceIP:   "203.0.113.7",
+	Details:    "Brute force attack detected from 203.0.113.7",
+	EventCount: 5,
+	User:       "alice",
+	Metadata:   map[string]string{"host": "web-1", "cves": "CVE-2024-0001"},
+}
+
+// encodeTransform compiles a spec from its JSON and encodes transformAlert,
+// returning the decoded output
+func encodeTransform(t *testing.T, spec string) map[string]interface{} {
+	t.Helper()
+	var tr AlertTransform
+	if err := json.Unmarshal([]byte(spec), &tr); err != nil {
+		t.Fatalf("spec: %v", err)
+	}
+	if err := tr.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	data, err := tr.encode(transformAlert)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("output is not a JSON object: %v", err)
+	}
+	return out
+}
+
+func TestAlertTransformRoundTrip(t *testing.T) {
+	out := encodeTransform(t, `{
+		"rename": {"source_ip": "src.ip", "threat_type": "rule.name", "metadata.host": "host.name", "severity": "level"},
+		"drop": ["raw_events", "metadata.cves"],
+		"add": {"vendor": "sbla", "event.kind": "alert", "schema_version": 2}
+	}`)
+
+	want := map[string]interface{}{
+		// renamed and nested
+		"src":   map[string]interface{}{"ip": "203.0.113.7"},
+		"rule":  map[string]interface{}{"name": "BRUTE_FORCE"},
+		"host":  map[string]interface{}{"name": "web-1"},
+		"level": "HIGH",
+		// injected, flat and nested
+		"vendor":         "sbla",
+		"event":          map[string]interface{}{"kind": "alert"},
+		"schema_version": float64(2),
+		// untouched fields pass through unchanged
+		"alert_id":    "BF-1704067200",
+		"timestamp":   "2024-01-01T00:00:00Z",
+		"details":     "Brute force attack detected from 203.0.113.7",
+		"event_count": float64(5),
+		"user":        "alice",
+		// what's left of metadata once host moved and cves was dropped
+		"metadata": map[string]interface{}{},
+	}
+	for k := range out {
+		if _, ok := want[k]; !ok {
+			t.Errorf("unexpected output field %q = %v", k, out[k])
+		}
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(out[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, out[k], v)
+		}
+	}
+}
+
+func TestAlertTransformSwapsNames(t *testing.T) {
+	out := encodeTransform(t, `{"rename": {"user": "source_ip", "source_ip": "user"}}`)
+	if out["user"] != "203.0.113.7" || out["source_ip"] != "alice" {
+		t.Errorf("user = %v, source_ip = %v; want the two swapped", out["user"], out["source_ip"])
+	}
+}
+
+func TestAlertTransformReusesFreedNames(t *testing.T) {
+	out := encodeTransform(t, `{"rename": {"severity": "level", "user": "severity"}, "drop": ["details"], "add": {"details": "redacted"}}`)
+	if out["severity"] != "alice" || out["details"] != "redacted" {
+		t.Errorf("severity = %v, details = %v; want the moved and dropped names reused", out["severity"], out["details"])
+	}
+}
+
+func TestAlertTransformNilIsNative(t *testing.T) {
+	var tr *AlertTransform
+	got, err := tr.encode(transformAlert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, _ := json.Marshal(transformAlert)
+	if string(got) != string(native) {
+		t.Errorf("nil transform = %s, want the native alert %s", got, native)
+	}
+
+	var back ThreatAlert
+	if err := json.Unmarshal(got, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, transformAlert) {
+		t.Errorf("native JSON doesn't read back as the same alert: %+v", back)
+	}
+}
+
+func TestAlertTransformRejectsBadSpecs(t *testing.T) {
+	tests := map[string]string{
+		`{"rename": {"nope": "x"}}`:                               "not an alert field",
+		`{"rename": {"user": "a..b"}}`:                            "bad path",
+		`{"drop": ["nope"]}`:                                      "not an alert field",
+		`{"rename": {"user": "who"}, "drop": ["who"]}`:            "not an alert field",
+		`{"rename": {"user": "actor.name"}, "add": {"actor": 1}}`: "overlapping",
+		`{"add": {"details.text": "x"}}`:                          "not an object",
+		`{"rename": {"user": "severity"}}`:                        "replace the alert's own",
+		`{"add": {"source_ip": "0.0.0.0"}}`:                       "replace the alert's own",
+		`{"rename": {"metadata.host": "metadata"}}`:               "replace the alert's own",
+	}
+	for spec, want := range tests {
+		var tr AlertTransform
+		if err := json.Unmarshal([]byte(spec), &tr); err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		err := tr.compile()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: compile = %v, want an error containing %q", spec, err, want)
+		}
+	}
+}
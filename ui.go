@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// uiFiles is the dashboard, built into the binary so it needs no deployment
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the dashboard under /ui/
+func uiHandler() http.Handler {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err) // the embedded tree is fixed at build time
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(root)))
+}
+
+// startUIServer serves the dashboard on its own address, for when the
+// operational API shouldn't be exposed to the people watching alerts
+func (td *ThreatDetector) startUIServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/ui/", uiHandler())
+	mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	mux.HandleFunc("/alerts", td.handleAlerts)
+	addr := td.cfg().UI.Addr
+	td.uiServer = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		log.Printf("Dashboard listening on %s/ui/", addr)
+		if err := td.uiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Dashboard server error: %v", err)
+		}
+	}()
+}
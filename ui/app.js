@@ -0,0 +1,135 @@
+// Dashboard for GET /alerts. Polls the alert store, filters in the browser
+// and redraws the summary panels and the alert table.
+"use strict";
+
+const POLL_MS = 5000;
+const PAGE_LIMIT = 1000;
+const MAX_PAGES = 10;
+const SEVERITIES = ["CRITICAL", "HIGH", "MEDIUM", "LOW"];
+
+const filters = document.getElementById("filters");
+const status = document.getElementById("status");
+let timer = null;
+
+function token() {
+  return sessionStorage.getItem("sbla-token") || "";
+}
+
+// fetchAlerts pages through the selected range, oldest first
+async function fetchAlerts(from) {
+  const alerts = [];
+  let cursor = "";
+  for (let page = 0; page < MAX_PAGES; page++) {
+    const params = new URLSearchParams({ from: from.toISOString(), limit: PAGE_LIMIT });
+    if (cursor) params.set("cursor", cursor);
+
+    const headers = token() ? { Authorization: "Bearer " + token() } : {};
+    const resp = await fetch("../alerts?" + params, { headers });
+    if (resp.status === 401) {
+      const t = prompt("Alert store token");
+      if (t === null) throw new Error("unauthorized");
+      sessionStorage.setItem("sbla-token", t);
+      page--;
+      continue;
+    }
+    if (!resp.ok) throw new Error(resp.status + " " + (await resp.text()).trim());
+
+    const body = await resp.json();
+    alerts.push(...body.alerts);
+    cursor = body.next_cursor || "";
+    if (!cursor) return { alerts, truncated: false };
+  }
+  return { alerts, truncated: true };
+}
+
+function matches(alert, f) {
+  return (!f.severity || alert.severity === f.severity) &&
+    (!f.threat || alert.threat_type.toUpperCase().includes(f.threat.toUpperCase())) &&
+    (!f.ip || (alert.source_ip || "").startsWith(f.ip)) &&
+    (!f.tenant || alert.tenant_id === f.tenant);
+}
+
+function countBy(alerts, key) {
+  const counts = new Map();
+  for (const a of alerts) {
+    const k = key(a) || "(none)";
+    counts.set(k, (counts.get(k) || 0) + 1);
+  }
+  return [...counts].sort((a, b) => b[1] - a[1]);
+}
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) td.className = className;
+  return td;
+}
+
+function sevBadge(severity) {
+  const span = document.createElement("span");
+  span.className = "sev sev-" + severity;
+  span.textContent = severity;
+  return span;
+}
+
+// fillCounts draws a two-column table; clicking a row sets that filter
+function fillCounts(table, rows, filterName) {
+  const body = table.tBodies[0];
+  body.replaceChildren(...rows.map(([name, n]) => {
+    const tr = document.createElement("tr");
+    tr.className = "clickable";
+    tr.append(cell(name), cell(n, "num"));
+    tr.onclick = () => {
+      filters.elements[filterName].value = name;
+      refresh();
+    };
+    return tr;
+  }));
+}
+
+function render(all, truncated) {
+  const f = Object.fromEntries(new FormData(filters));
+  const alerts = all.filter(a => matches(a, f)).reverse();
+
+  const bySeverity = new Map(countBy(alerts, a => a.severity));
+  document.getElementById("severities").replaceChildren(...SEVERITIES.map(s => {
+    const li = document.createElement("li");
+    li.className = "sev-" + s;
+    li.textContent = s + " " + (bySeverity.get(s) || 0);
+    return li;
+  }));
+  fillCounts(document.getElementById("rules"), countBy(alerts, a => a.threat_type), "threat");
+  fillCounts(document.getElementById("ips"), countBy(alerts, a => a.source_ip).slice(0, 10), "ip");
+
+  const shown = alerts.slice(0, 500);
+  document.getElementById("alerts").replaceChildren(...shown.map(a => {
+    const tr = document.createElement("tr");
+    const sev = document.createElement("td");
+    sev.append(sevBadge(a.severity));
+    tr.append(cell(new Date(a.timestamp).toLocaleString(), "time"), sev, cell(a.threat_type),
+      cell(a.source_ip), cell(a.user || ""), cell(a.details, "details"));
+    tr.title = a.alert_id;
+    return tr;
+  }));
+  document.getElementById("shown").textContent =
+    `(${shown.length} of ${alerts.length}${truncated ? ", range truncated" : ""})`;
+}
+
+async function refresh() {
+  clearTimeout(timer);
+  const minutes = Number(filters.elements.range.value);
+  try {
+    const { alerts, truncated } = await fetchAlerts(new Date(Date.now() - minutes * 60000));
+    render(alerts, truncated);
+    status.textContent = "updated " + new Date().toLocaleTimeString();
+    status.className = "";
+  } catch (err) {
+    status.textContent = "error: " + err.message;
+    status.className = "error";
+  }
+  timer = setTimeout(refresh, POLL_MS);
+}
+
+filters.addEventListener("change", refresh);
+filters.addEventListener("submit", e => { e.preventDefault(); refresh(); });
+refresh();
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// validateReport is what the "validate" subcommand found in a config file
+type validateReport struct {
+	Errors   []error
+	Warnings []string
+}
+
+// configProblems splits a LoadConfig error into the individual problems
+func configProblems(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// validateConfigFile loads and checks a config the way startup does, plus
+// the allowlist and asset files it names. Nothing connects to Kafka or Redis.
+func validateConfigFile(path string) validateReport {
+	var r validateReport
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		r.Errors = configProblems(err)
+		return r
+	}
+
+	if _, err := NewUserAllowlistManager(cfg.UserAllowlist); err != nil {
+		r.Errors = append(r.Errors, err)
+	}
+	if cfg.Assets.Enabled {
+		if _, err := NewAssetInventory(cfg.Assets); err != nil {
+			r.Errors = append(r.Errors, err)
+		}
+	}
+	if cfg.Vulns.Enabled {
+		if _, err := NewVulnDB(cfg.Vulns); err != nil {
+			r.Errors = append(r.Errors, err)
+		}
+	}
+	r.Warnings = cfg.unknownThreatTypes()
+	return r
+}
+
+// unknownThreatTypes lists settings keyed by a threat type no rule raises.
+// These are usually typos, and are otherwise silently ignored.
+func (c *DetectorConfig) unknownThreatTypes() []string {
+	known := map[string]bool{"TEST_ALERT": true, "RECON_ACTIVITY": true, "SUSPICIOUS_PROCESS": true}
+	for _, rule := range c.RuleSummaries() {
+		known[rule.ThreatType] = true
+	}
+
+	var warnings []string
+	check := func(setting, threatType string) {
+		if !known[threatType] {
+			warnings = append(warnings, fmt.Sprintf("%s: unknown threat type %q", setting, threatType))
+		}
+	}
+	checkKeys := func(setting string, keys []string) {
+		sort.Strings(keys)
+		for _, k := range keys {
+			check(setting, k)
+		}
+	}
+
+	checkKeys("window_modes", mapKeys(c.WindowModes))
+	for _, t := range c.DistinctRawLogs {
+		check("distinct_raw_logs", t)
+	}
+	checkKeys("state_retention.threat_types", mapKeys(c.StateRetention.ThreatTypes))
+	checkKeys("evidence", mapKeys(c.Evidence))
+	checkKeys("observations.threat_types", mapKeys(c.Observations.ThreatTypes))
+	checkKeys("alert_templates", mapKeys(c.AlertTemplates))
+	checkKeys("cooldown.rules", mapKeys(c.Cooldown.Rules))
+	checkKeys("lifecycle.rules", mapKeys(c.Lifecycle.Rules))
+	checkKeys("sinks.stix.techniques", mapKeys(c.Sinks.STIX.Techniques))
+	filtered := mapKeys(c.Sinks.Filters)
+	sort.Strings(filtered)
+	for _, name := range filtered {
+		for _, t := range c.Sinks.Filters[name].ThreatTypes {
+			check(fmt.Sprintf("sinks.filters.%s.threat_types", name), t)
+		}
+		for _, t := range c.Sinks.Filters[name].ExcludeThreatTypes {
+			check(fmt.Sprintf("sinks.filters.%s.exclude_threat_types", name), t)
+		}
+	}
+
+	var allowlisted []string
+	for k := range c.UserAllowlist.Rules {
+		if k != allowAllRules {
+			allowlisted = append(allowlisted, k)
+		}
+	}
+	checkKeys("user_allowlist.rules", allowlisted)
+
+	for i, chain := range c.Correlation.Chains {
+		for _, step := range chain.Sequence {
+			check(fmt.Sprintf("correlation.chains[%d].sequence", i), step)
+		}
+	}
+	for _, t := range c.CIDRAggregation.ThreatTypes {
+		check("cidr_aggregation.threat_types", t)
+	}
+	checkKeys("correlation.scoring.weights", mapKeys(c.Correlation.Scoring.Weights))
+	for i, o := range c.SeverityOverrides {
+		for _, t := range o.ThreatTypes {
+			check(fmt.Sprintf("severity_overrides[%d].threat_types", i), t)
+		}
+	}
+	return warnings
+}
+
+// mapKeys returns a map's keys in no particular order
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// print writes the report and says whether the config passes
+func (r validateReport) print(w io.Writer, path string, strict bool) bool {
+	for _, err := range r.Errors {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+	ok := len(r.Errors) == 0 && (!strict || len(r.Warnings) == 0)
+	if ok {
+		fmt.Fprintf(w, "%s: OK\n", path)
+	} else {
+		fmt.Fprintf(w, "%s: %d errors, %d warnings\n", path, len(r.Errors), len(r.Warnings))
+	}
+	return ok
+}
+
+// runValidate implements the "validate" subcommand: check a config file
+// without starting the detector. It returns the process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to JSON config file (or give it as the argument)")
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Parse(args)
+
+	path := *configPath
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "validate: a config file is required")
+		return 2
+	}
+
+	if !validateConfigFile(path).print(os.Stdout, path, *strict) {
+		return 1
+	}
+	return 0
+}
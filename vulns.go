@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VulnEntry ties a CVE to the events that show it in play: a service and
+// version, or a request signature
+type VulnEntry struct {
+	CVE            string   `json:"cve"`
+	CVSS           float64  `json:"cvss"`
+	KnownExploited bool     `json:"known_exploited"` // e.g. listed in CISA KEV
+	Service        string   `json:"service"`         // product name or glob (case-insensitive)
+	Versions       []string `json:"versions"`        // affected versions or globs (empty = all)
+	Signatures     []string `json:"signatures"`      // substrings of the path or raw log (case-insensitive)
+}
+
+// compile lowercases the patterns and checks the entry is usable
+func (e *VulnEntry) compile() error {
+	if e.CVE == "" {
+		return fmt.Errorf("cve is required")
+	}
+	if e.Service == "" && len(e.Signatures) == 0 {
+		return fmt.Errorf("%s: needs a service or signatures", e.CVE)
+	}
+	if e.CVSS < 0 || e.CVSS > 10 {
+		return fmt.Errorf("%s: cvss must be between 0 and 10", e.CVE)
+	}
+	e.Service = strings.ToLower(e.Service)
+	for i, v := range e.Versions {
+		e.Versions[i] = strings.ToLower(v)
+	}
+	for i, s := range e.Signatures {
+		e.Signatures[i] = strings.ToLower(s)
+	}
+	if err := validatePatterns(append([]string{e.Service}, e.Versions...)); err != nil {
+		return fmt.Errorf("%s: %w", e.CVE, err)
+	}
+	return nil
+}
+
+// matches reports whether an event shows the vulnerability: its service
+// and version are affected, or its path or raw log carries a signature
+func (e VulnEntry) matches(service, version, path, rawLog string) bool {
+	if e.Service != "" && service != "" && matchAny([]string{e.Service}, service) &&
+		(len(e.Versions) == 0 || version != "" && matchAny(e.Versions, version)) {
+		return true
+	}
+	for _, sig := range e.Signatures {
+		if path != "" && strings.Contains(path, sig) || strings.Contains(rawLog, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the vulnerability context settings
+func (c *VulnConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.File == "" {
+		return fmt.Errorf("vulns.file is required when enabled")
+	}
+	if c.ServiceField == "" || c.VersionField == "" || c.PathField == "" {
+		return fmt.Errorf("vulns.service_field, version_field and path_field are required")
+	}
+	if c.ReloadInterval.Duration < time.Second {
+		return fmt.Errorf("vulns.reload_interval must be at least 1s")
+	}
+	if c.KnownExploitedBoost < 0 || c.KnownExploitedBoost > 3 {
+		return fmt.Errorf("vulns.known_exploited_boost must be between 0 and 3")
+	}
+	return nil
+}
+
+// VulnDB is the CVE mapping loaded from vulns.file, re-read whenever the
+// file changes
+type VulnDB struct {
+	cfg     VulnConfig
+	entries atomic.Pointer[[]VulnEntry]
+
+	mu      sync.Mutex // guards modTime
+	modTime time.Time
+}
+
+// NewVulnDB loads the mapping; a bad file fails startup
+func NewVulnDB(cfg VulnConfig) (*VulnDB, error) {
+	db := &VulnDB{cfg: cfg}
+	entries, modTime, err := loadVulnFile(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	db.entries.Store(&entries)
+	db.modTime = modTime
+	log.Printf("Loaded %d vulnerability mappings from %s", len(entries), cfg.File)
+	return db, nil
+}
+
+// Lookup returns the entries an event matches, highest CVSS first
+func (db *VulnDB) Lookup(event SecurityEvent) []VulnEntry {
+	service := strings.ToLower(event.Metadata[db.cfg.ServiceField])
+	version := strings.ToLower(event.Metadata[db.cfg.VersionField])
+	path := strings.ToLower(event.Metadata[db.cfg.PathField])
+
+	var found []VulnEntry
+	for _, e := range *db.entries.Load() {
+		if e.matches(service, version, path, event.rawLogLower) {
+			found = append(found, e)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].CVSS > found[j].CVSS })
+	return found
+}
+
+// annotate tags an alert with the CVEs its event matches and raises its
+// severity when one of them is known to be exploited
+func (db *VulnDB) annotate(event SecurityEvent, alert *ThreatAlert) {
+	found := db.Lookup(event)
+	if len(found) == 0 {
+		return
+	}
+	if alert.Metadata == nil {
+		alert.Metadata = make(map[string]string)
+	}
+
+	cves := make([]string, len(found))
+	exploited := false
+	for i, e := range found {
+		cves[i] = e.CVE
+		exploited = exploited || e.KnownExploited
+	}
+	alert.Metadata["cves"] = strings.Join(cves, ",")
+	alert.Metadata["cvss"] = strconv.FormatFloat(found[0].CVSS, 'f', 1, 64)
+	if exploited {
+		alert.Metadata["known_exploited"] = "true"
+		alert.Severity = shiftSeverity(alert.Severity, db.cfg.KnownExploitedBoost)
+	}
+}
+
+// Run re-reads the file when it changes until stop is closed. A file that
+// fails to load is logged and the previous mapping stays active.
+func (db *VulnDB) Run(stop <-chan struct{}) {
+	if db.cfg.ReloadInterval.Duration <= 0 {
+		return
+	}
+	ticker := time.NewTicker(db.cfg.ReloadInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			db.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged re-reads the file if it was modified
+func (db *VulnDB) reloadIfChanged() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	info, err := os.Stat(db.cfg.File)
+	if err != nil || !info.ModTime().After(db.modTime) {
+		return
+	}
+	entries, modTime, err := loadVulnFile(db.cfg.File)
+	if err != nil {
+		log.Printf("Vulnerability mapping reload failed, keeping previous mapping: %v", err)
+		db.modTime = info.ModTime()
+		return
+	}
+	db.entries.Store(&entries)
+	db.modTime = modTime
+	log.Printf("Reloaded %d vulnerability mappings from %s", len(entries), db.cfg.File)
+}
+
+// loadVulnFile reads a JSON array of entries
+func loadVulnFile(file string) ([]VulnEntry, time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("vulnerability mapping: %w", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("vulnerability mapping: %w", err)
+	}
+
+	var entries []VulnEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, time.Time{}, fmt.Errorf("vulnerability mapping %s: %w", file, err)
+	}
+	for i := range entries {
+		if err := entries[i].compile(); err != nil {
+			return nil, time.Time{}, fmt.Errorf("vulnerability mapping %s: [%d] %w", file, i, err)
+		}
+	}
+	return entries, info.ModTime(), nil
+}
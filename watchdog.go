@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// workerSlot tracks one running worker goroutine for the watchdog
+type workerSlot struct {
+	id     int
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// busySince is when the current event started processing (unix nanos),
+	// or 0 while the worker is waiting for input
+	busySince atomic.Int64
+}
+
+// newWorkerSlot gives a worker its own cancellable context
+func (td *ThreatDetector) newWorkerSlot(id int) *workerSlot {
+	ctx, cancel := context.WithCancel(td.ctx)
+	return &workerSlot{id: id, ctx: ctx, cancel: cancel}
+}
+
+// startWorker launches a worker goroutine in the given slot
+func (td *ThreatDetector) startWorker(id int) {
+	slot := td.newWorkerSlot(id)
+
+	td.workersMu.Lock()
+	td.workers[id] = slot
+	td.workersMu.Unlock()
+
+	td.producers.Add(1)
+	go td.processEvents(slot)
+}
+
+// runWatchdog restarts workers stuck on a single event for longer than the
+// timeout. The stuck worker's context is cancelled so its blocking calls
+// return, and a fresh worker takes over its ID straight away.
+func (td *ThreatDetector) runWatchdog() {
+	defer td.producers.Done()
+
+	cfg := td.cfg().Watchdog
+	ticker := time.NewTicker(cfg.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-td.stop:
+			return
+		case <-ticker.C:
+		}
+
+		td.workersMu.Lock()
+		slots := append([]*workerSlot(nil), td.workers...)
+		td.workersMu.Unlock()
+
+		for _, slot := range slots {
+			since := slot.busySince.Load()
+			if since == 0 {
+				continue
+			}
+			stuck := time.Since(time.Unix(0, since))
+			if stuck < cfg.Timeout.Duration {
+				continue
+			}
+
+			log.Printf("Watchdog: worker %d stuck on one event for %s, restarting", slot.id, stuck.Round(time.Second))
+			workerRestarts.Inc()
+			slot.cancel()
+			td.startWorker(slot.id)
+		}
+	}
+}
+
+// acquireInFlight takes an in-flight slot, waiting while the cap is reached.
+// It returns false if the worker is cancelled or the detector stops first.
+func (td *ThreatDetector) acquireInFlight(ctx context.Context) bool {
+	if td.inFlight != nil {
+		select {
+		case td.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		case <-td.stop:
+			return false
+		}
+	}
+	eventsInFlight.Add(1)
+	return true
+}
+
+// releaseInFlight returns a slot taken by acquireInFlight
+func (td *ThreatDetector) releaseInFlight() {
+	eventsInFlight.Add(-1)
+	if td.inFlight != nil {
+		<-td.inFlight
+	}
+}
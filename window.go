@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Window modes for counter rules
+const (
+	windowSliding = "sliding" // TTL reset on every event: N events with gaps under the window
+	windowFixed   = "fixed"   // TTL set on the first event: N events within the window
+)
+
+// validateWindowModes checks window_mode and every window_modes entry
+func (c *DetectorConfig) validateWindowModes() error {
+	if c.WindowMode != windowSliding && c.WindowMode != windowFixed {
+		return fmt.Errorf("window_mode must be %q or %q, got %q", windowSliding, windowFixed, c.WindowMode)
+	}
+	for threatType, mode := range c.WindowModes {
+		if mode != windowSliding && mode != windowFixed {
+			return fmt.Errorf("window_modes.%s must be %q or %q, got %q", threatType, windowSliding, windowFixed, mode)
+		}
+	}
+	return nil
+}
+
+// WindowModeFor returns the window mode a threat type's counter uses
+func (c *DetectorConfig) WindowModeFor(threatType string) string {
+	if mode, ok := c.WindowModes[threatType]; ok {
+		return mode
+	}
+	return c.WindowMode
+}
+
+// countInWindow increments a rule's counter under the rule's window mode.
+// For rules in distinct_raw_logs, a raw log already counted in the window
+// leaves the counter alone and returns 0, so a repeat never fires the rule.
+func (td *ThreatDetector) countInWindow(ctx context.Context, event SecurityEvent, threatType, key string, window time.Duration) (int64, error) {
+	if event.RawLog != "" && containsString(td.cfg().DistinctRawLogs, threatType) {
+		sum := sha256.Sum256([]byte(event.RawLog))
+		seenKey := key + ":seen:" + hex.EncodeToString(sum[:8])
+		first, err := td.state.SetIfAbsent(ctx, seenKey, "1", window)
+		if err != nil {
+			return 0, &StateError{Op: "setnx", Key: seenKey, Err: err}
+		}
+		if !first {
+			duplicateRawLogs.WithLabelValues(threatType).Inc()
+			return 0, nil
+		}
+	}
+
+	var count int64
+	var err error
+	if td.cfg().WindowModeFor(threatType) == windowFixed {
+		count, err = td.state.IncrFixed(ctx, key, window)
+	} else {
+		count, err = td.state.Incr(ctx, key, window)
+	}
+	if err != nil {
+		return 0, &StateError{Op: "incr", Key: key, Err: err}
+	}
+	return count, nil
+}
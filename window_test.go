@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestWindowModes feeds brute-force failures at the window's edges. The
+// window is 5 minutes and the threshold 5.
+func TestWindowModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		gaps  []time.Duration // before each failure after the first
+		alert bool
+	}{
+		{"fixed: fifth failure just inside the first window", windowFixed,
+			[]time.Duration{time.Minute, time.Minute, time.Minute, 119 * time.Second}, true},
+		{"fixed: fifth failure exactly one window after the first", windowFixed,
+			[]time.Duration{time.Minute, time.Minute, time.Minute, 2 * time.Minute}, false},
+		{"fixed: steady failures don't stretch the window", windowFixed,
+			[]time.Duration{299 * time.Second, 299 * time.Second, 299 * time.Second, 299 * time.Second}, false},
+		{"sliding: each failure inside the previous one's window", windowSliding,
+			[]time.Duration{299 * time.Second, 299 * time.Second, 299 * time.Second, 299 * time.Second}, true},
+		{"sliding: a gap of exactly one window resets the count", windowSliding,
+			[]time.Duration{299 * time.Second, 299 * time.Second, 299 * time.Second, 5 * time.Minute}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.WindowModes = map[string]string{"BRUTE_FORCE": tt.mode}
+			td, clock := newClockedDetector(t, cfg)
+			ctx := context.Background()
+
+			event := SecurityEvent{EventType: "authentication", Action: "login", Result: "failed", SourceIP: "203.0.113.7", User: "alice"}
+			event.normalize()
+			if err := td.detectThreats(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+			for _, gap := range tt.gaps {
+				clock.advance(gap)
+				if err := td.detectThreats(ctx, event); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			raised := false
+			for _, alert := range drainAlerts(td) {
+				raised = raised || alert.ThreatType == "BRUTE_FORCE"
+			}
+			if raised != tt.alert {
+				t.Errorf("BRUTE_FORCE raised = %v, want %v", raised, tt.alert)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Input formats
+const (
+	formatJSON       = "json"
+	formatWindowsXML = "windows_xml"
+	formatCloudTrail = "cloudtrail"
+	formatAccessLog  = "access_log"
+	formatAuto       = "auto" // XML if the message starts with '<', CloudTrail if it has a userIdentity, an access log line if it has "[time] \"", else JSON
+)
+
+// windowsXMLEvent is a Windows event as rendered by Event Viewer, wevtutil
+// or Get-WinEvent ToXml(). Element names match in any namespace.
+type windowsXMLEvent struct {
+	XMLName xml.Name `xml:"Event"`
+	System  struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     string `xml:"EventID"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		Keywords string `xml:"Keywords"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+}
+
+// windowsEventKind maps a Security event ID onto the analyzer's fields.
+// subject means the acting account (SubjectUserName) is the event's user
+// and TargetUserName is recorded as target_user.
+type windowsEventKind struct {
+	eventType string
+	action    string
+	result    string // "" derives it from the Status field or keywords
+	subject   bool
+}
+
+// windowsEvents are the Security log events with a mapping. Others keep
+// event_type "windows" and action "event_<id>".
+var windowsEvents = map[string]windowsEventKind{
+	"4624": {"authentication", "logon", "success", false},
+	"4625": {"authentication", "logon", "failed", false},
+	"4648": {"authentication", "explicit_credentials", "success", true},
+	"4672": {"privilege", "special_privileges_assigned", "success", true},
+	"4720": {"account_management", "account_created", "success", true},
+	"4722": {"account_management", "account_enabled", "success", true},
+	"4723": {"password_change", "password_change", "", true},
+	"4724": {"password_reset", "password_reset", "", true},
+	"4725": {"account_management", "account_disabled", "success", true},
+	"4726": {"account_management", "account_deleted", "success", true},
+	"4728": {"account_management", "group_added", "success", true},
+	"4732": {"account_management", "group_added", "success", true},
+	"4738": {"account_management", "account_changed", "success", true},
+	"4740": {"account_management", "account_locked_out", "success", true},
+	"4756": {"account_management", "group_added", "success", true},
+	"4662": {"directory_access", "object_operation", "success", true},
+	"4768": {"authentication", "kerberos_tgt", "", false},
+	"4769": {"authentication", "kerberos_service_ticket", "", false},
+	"4771": {"authentication", "kerberos_preauth", "failed", false},
+	"4776": {"authentication", "ntlm_validation", "", false},
+}
+
+// windowsLogonTypes names the LogonType values of 4624/4625
+var windowsLogonTypes = map[string]string{
+	"2":  "interactive",
+	"3":  "network",
+	"4":  "batch",
+	"5":  "service",
+	"7":  "unlock",
+	"8":  "network_cleartext",
+	"9":  "new_credentials",
+	"10": "remote_interactive",
+	"11": "cached_interactive",
+}
+
+// windowsFailureReasons names common NTSTATUS codes of failed logons
+var windowsFailureReasons = map[string]string{
+	"0xc0000064": "unknown_user",
+	"0xc000006a": "bad_password",
+	"0xc000006d": "bad_credentials",
+	"0xc000006f": "outside_logon_hours",
+	"0xc0000070": "workstation_restricted",
+	"0xc0000071": "password_expired",
+	"0xc0000072": "account_disabled",
+	"0xc0000193": "account_expired",
+	"0xc0000234": "account_locked_out",
+	"0x12":       "account_disabled", // Kerberos KDC_ERR_CLIENT_REVOKED
+	"0x18":       "bad_password",     // Kerberos KDC_ERR_PREAUTH_FAILED
+	"0x6":        "unknown_user",     // Kerberos KDC_ERR_C_PRINCIPAL_UNKNOWN
+}
+
+// Audit keywords, for events without a mapping
+const (
+	keywordAuditFailure = 0x10000000000000
+	keywordAuditSuccess = 0x20000000000000
+)
+
+// windowsSystemAccount reports the built-in and machine accounts Windows
+// assigns special privileges to on every service logon
+func windowsSystemAccount(user, sid string) bool {
+	switch sid {
+	case "S-1-5-18", "S-1-5-19", "S-1-5-20":
+		return true
+	}
+	return strings.HasSuffix(user, "$")
+}
+
+// snakeCase turns an EventData name into a metadata key
+// ("TargetUserName" -> "target_user_name")
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word at a lower->upper step, or at the last capital
+			// of an acronym ("IPAddress" -> "ip_address")
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// windowsValue treats the "-" Windows writes for empty fields as empty
+func windowsValue(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "-" {
+		return ""
+	}
+	return v
+}
+
+// parseWindowsXML converts one Windows event in XML into a SecurityEvent.
+// Every EventData field is kept in metadata under its snake_case name, and
+// the fields the rules read are mapped onto the event.
+func parseWindowsXML(data []byte) (SecurityEvent, error) {
+	var x windowsXMLEvent
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return SecurityEvent{}, fmt.Errorf("windows event xml: %w", err)
+	}
+	id := strings.TrimSpace(x.System.EventID)
+	if id == "" {
+		return SecurityEvent{}, fmt.Errorf("windows event xml: no EventID")
+	}
+
+	meta := map[string]string{"event_id": id}
+	if x.System.Channel != "" {
+		meta["channel"] = x.System.Channel
+	}
+	if x.System.Provider.Name != "" {
+		meta["provider"] = x.System.Provider.Name
+	}
+	for _, d := range x.EventData.Data {
+		if v := windowsValue(d.Value); d.Name != "" && v != "" {
+			meta[snakeCase(d.Name)] = v
+		}
+	}
+
+	event := SecurityEvent{
+		Source:    x.System.Computer,
+		SourceIP:  meta["ip_address"],
+		RawLog:    string(bytes.TrimSpace(data)),
+		Metadata:  meta,
+		EventType: "windows",
+		Action:    "event_" + id,
+	}
+	if t, err := time.Parse(time.RFC3339Nano, x.System.TimeCreated.SystemTime); err == nil {
+		event.Timestamp = t
+	}
+
+	kind, known := windowsEvents[id]
+	if known {
+		event.EventType, event.Action, event.Result = kind.eventType, kind.action, kind.result
+	}
+	if kind.subject {
+		event.User = meta["subject_user_name"]
+		if target := meta["target_user_name"]; target != "" {
+			meta["target_user"] = target
+		}
+	} else {
+		event.User = meta["target_user_name"]
+	}
+
+	// Group membership names the group as the target; the account is the member
+	if kind.action == "group_added" {
+		meta["group"] = meta["target_user_name"]
+		meta["target_user"] = memberAccount(meta["member_name"])
+	}
+
+	// SYSTEM and machine accounts get special privileges on every service
+	// logon; only people being handed them is worth a privilege check
+	if id == "4672" && windowsSystemAccount(event.User, meta["subject_user_sid"]) {
+		event.EventType = "logon"
+	}
+
+	if event.Result == "" {
+		event.Result = windowsResult(meta["status"], x.System.Keywords)
+	}
+	if event.Result == "failed" {
+		code := strings.ToLower(meta["sub_status"])
+		if code == "" || code == "0x0" {
+			code = strings.ToLower(meta["status"])
+		}
+		if reason := windowsFailureReasons[code]; reason != "" {
+			meta["failure_reason"] = reason
+		}
+	}
+	if name := windowsLogonTypes[meta["logon_type"]]; name != "" {
+		meta["logon_type_name"] = name
+	}
+
+	// The names the protocol downgrade rule reads by default
+	if v := meta["lm_package_name"]; v != "" {
+		meta["lm_package"] = v
+	}
+	if v := meta["ticket_encryption_type"]; v != "" {
+		meta["encryption_type"] = v
+	}
+	return event, nil
+}
+
+// windowsResult derives success or failure from a Status field (0x0 is
+// success) or, without one, the audit keywords
+func windowsResult(status, keywords string) string {
+	if status != "" {
+		if strings.EqualFold(status, "0x0") {
+			return "success"
+		}
+		return "failed"
+	}
+	var kw uint64
+	fmt.Sscanf(strings.TrimPrefix(strings.ToLower(keywords), "0x"), "%x", &kw)
+	switch {
+	case kw&keywordAuditFailure != 0:
+		return "failed"
+	case kw&keywordAuditSuccess != 0:
+		return "success"
+	}
+	return ""
+}
+
+// memberAccount extracts the account from a group member's distinguished
+// name ("CN=bob,OU=Staff,DC=corp,DC=example" -> "bob")
+func memberAccount(dn string) string {
+	first, _, _ := strings.Cut(dn, ",")
+	if name, ok := strings.CutPrefix(first, "CN="); ok {
+		return name
+	}
+	return dn
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// failedLogon4625 is a real 4625 (an NTLM logon failure) as the Security
+// channel renders it, with the second of the minute and source port varied
+func failedLogon4625(second, port int) string {
+	return fmt.Sprintf(`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-Security-Auditing" Guid="{54849625-5478-4994-A5BA-3E3B0328C30D}" /><EventID>4625</EventID><Version>0</Version><Level>0</Level><Task>12544</Task><Opcode>0</Opcode><Keywords>0x8010000000000000</Keywords><TimeCreated SystemTime="2024-01-01T00:00:%02d.5218833Z" /><EventRecordID>91860</EventRecordID><Correlation /><Execution ProcessID="636" ThreadID="2112" /><Channel>Security</Channel><Computer>DC01.corp.example.com</Computer><Security /></System><EventData><Data Name="SubjectUserSid">S-1-0-0</Data><Data Name="SubjectUserName">-</Data><Data Name="SubjectDomainName">-</Data><Data Name="SubjectLogonId">0x0</Data><Data Name="TargetUserSid">S-1-0-0</Data><Data Name="TargetUserName">Administrator</Data><Data Name="TargetDomainName">CORP</Data><Data Name="Status">0xc000006d</Data><Data Name="FailureReason">%%%%2313</Data><Data Name="SubStatus">0xc000006a</Data><Data Name="LogonType">3</Data><Data Name="LogonProcessName">NtLmSsp </Data><Data Name="AuthenticationPackageName">NTLM</Data><Data Name="WorkstationName">KALI</Data><Data Name="TransmittedServices">-</Data><Data Name="LmPackageName">-</Data><Data Name="KeyLength">0</Data><Data Name="ProcessId">0x0</Data><Data Name="ProcessName">-</Data><Data Name="IpAddress">198.51.100.23</Data><Data Name="IpPort">%d</Data></EventData></Event>`, second, port)
+}
+
+// specialLogon4672 is a real 4672 (special privileges assigned) for an account
+func specialLogon4672(sid, user, privileges string) string {
+	return fmt.Sprintf(`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-Security-Auditing" Guid="{54849625-5478-4994-A5BA-3E3B0328C30D}" /><EventID>4672</EventID><Version>0</Version><Level>0</Level><Task>12548</Task><Opcode>0</Opcode><Keywords>0x8020000000000000</Keywords><TimeCreated SystemTime="2024-01-01T00:10:00.1234567Z" /><EventRecordID>91873</EventRecordID><Correlation ActivityID="{F3B5A7C2-1D2E-0001-6A1B-B5F32E1DDA01}" /><Execution ProcessID="636" ThreadID="700" /><Channel>Security</Channel><Computer>WS042.corp.example.com</Computer><Security /></System><EventData><Data Name="SubjectUserSid">%s</Data><Data Name="SubjectUserName">%s</Data><Data Name="SubjectDomainName">CORP</Data><Data Name="SubjectLogonId">0x3e7a12</Data><Data Name="PrivilegeList">%s</Data></EventData></Event>`, sid, user, privileges)
+}
+
+const dangerousPrivileges = "SeSecurityPrivilege\n\t\t\tSeBackupPrivilege\n\t\t\tSeRestorePrivilege\n\t\t\tSeTakeOwnershipPrivilege\n\t\t\tSeDebugPrivilege\n\t\t\tSeSystemEnvironmentPrivilege\n\t\t\tSeLoadDriverPrivilege\n\t\t\tSeImpersonatePrivilege"
+
+// detectXML parses each message as input.format would and runs detection
+func detectXML(t *testing.T, format string, messages ...string) []ThreatAlert {
+	t.Helper()
+	td := newTestDetector(t, nil)
+	input := InputConfig{Format: format}
+	for _, m := range messages {
+		event, err := parseEvent(kafka.Message{Value: []byte(m)}, &input)
+		if err != nil {
+			t.Fatalf("parseEvent: %v", err)
+		}
+		if err := td.detectThreats(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return drainAlerts(td)
+}
+
+func TestWindowsFailedLogons(t *testing.T) {
+	var logons []string
+	for i := 0; i < 5; i++ {
+		logons = append(logons, failedLogon4625(i*10, 51734+i))
+	}
+
+	event, err := parseEvent(kafka.Message{Value: []byte(logons[0])}, &InputConfig{Format: formatWindowsXML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "authentication" || event.Result != "failed" || event.User != "Administrator" || event.SourceIP != "198.51.100.23" {
+		t.Errorf("4625 parsed as %+v", event)
+	}
+
+	for _, format := range []string{formatWindowsXML, formatAuto} {
+		if alerts := detectXML(t, format, logons[:4]...); len(alerts) != 0 {
+			t.Errorf("%s: four 4625s raised %+v", format, alerts)
+		}
+		alerts := detectXML(t, format, logons...)
+		if len(alerts) != 1 || alerts[0].ThreatType != "BRUTE_FORCE" || alerts[0].SourceIP != "198.51.100.23" || alerts[0].User != "Administrator" {
+			t.Errorf("%s: five 4625s raised %+v, want one BRUTE_FORCE", format, alerts)
+		}
+	}
+}
+
+func TestWindowsSpecialPrivileges(t *testing.T) {
+	person := specialLogon4672("S-1-5-21-3623811015-3361044348-30300820-1013", "jsmith", dangerousPrivileges)
+	alerts := detectXML(t, formatWindowsXML, person)
+	if len(alerts) != 1 || alerts[0].ThreatType != "PRIVILEGE_ESCALATION" || alerts[0].User != "jsmith" {
+		t.Errorf("4672 with SeDebugPrivilege raised %+v, want one PRIVILEGE_ESCALATION", alerts)
+	}
+
+	system := specialLogon4672("S-1-5-18", "SYSTEM", dangerousPrivileges)
+	harmless := specialLogon4672("S-1-5-21-3623811015-3361044348-30300820-1013", "jsmith", "SeSecurityPrivilege\n\t\t\tSeBackupPrivilege")
+	if alerts := detectXML(t, formatWindowsXML, system, harmless); len(alerts) != 0 {
+		t.Errorf("4672 for SYSTEM or without a dangerous privilege raised %+v", alerts)
+	}
+}